@@ -7,9 +7,13 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -23,6 +27,81 @@ type ConfigElement struct {
 	Elems []*ConfigElement
 }
 
+func invalidAttrError(key string, value string) error {
+	return errors.New(fmt.Sprintf("invalid %s: %s", key, strconv.Quote(value)))
+}
+
+// Returns the value of the attribute, or defaultValue if it is not
+// specified.
+func (elem *ConfigElement) AttrString(key string, defaultValue string) string {
+	value, ok := elem.Attrs[key]
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+// Returns the value of the attribute as an integer, or defaultValue if it
+// is not specified.
+func (elem *ConfigElement) AttrInt(key string, defaultValue int) (int, error) {
+	value, ok := elem.Attrs[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	retval, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, invalidAttrError(key, value)
+	}
+	return retval, nil
+}
+
+// Returns the value of the attribute as a boolean, or defaultValue if it
+// is not specified.  It accepts what strconv.ParseBool does.
+func (elem *ConfigElement) AttrBool(key string, defaultValue bool) (bool, error) {
+	value, ok := elem.Attrs[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	retval, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, invalidAttrError(key, value)
+	}
+	return retval, nil
+}
+
+// Returns the value of the attribute as a duration, or defaultValue if it
+// is not specified.  It is either a number of seconds like "30" or a
+// duration like "1m30s".
+func (elem *ConfigElement) AttrDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := elem.Attrs[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	retval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, invalidAttrError(key, value)
+	}
+	return retval, nil
+}
+
+// Returns the value of the attribute as a size like "8m", or defaultValue
+// if it is not specified.
+func (elem *ConfigElement) AttrCapacity(key string, defaultValue int64) (int64, error) {
+	value, ok := elem.Attrs[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	retval, err := ParseCapacityString(value)
+	if err != nil {
+		return 0, invalidAttrError(key, value)
+	}
+	return retval, nil
+}
+
 type LineReader interface {
 	Next() (string, error)
 	Close() error
@@ -31,11 +110,14 @@ type LineReader interface {
 }
 
 type parserContext struct {
-	tag     string
-	tagArgs string
-	elems   []*ConfigElement
-	attrs   map[string]string
-	opener  Opener
+	tag       string
+	tagArgs   string
+	elems     []*ConfigElement
+	attrs     map[string]string
+	opener    Opener
+	expandEnv bool
+	// the files being parsed, from the outermost one
+	includeStack []string
 }
 
 type DefaultLineReader struct {
@@ -49,8 +131,11 @@ type DefaultOpener http.Dir
 
 var (
 	stripCommentRegexp = regexp.MustCompile("\\s*(?:#.*)?$")
-	startTagRegexp     = regexp.MustCompile("^<([a-zA-Z0-9_]+)\\s*(.+?)?>$")
-	attrRegExp         = regexp.MustCompile("^([a-zA-Z0-9_]+)\\s+(.*)$")
+	// "#{" doesn't start a comment when the environment variables are expanded
+	stripCommentExceptEnvRegexp = regexp.MustCompile("\\s*(?:#(?:[^{].*)?)?$")
+	startTagRegexp              = regexp.MustCompile("^<([a-zA-Z0-9_]+)\\s*(.+?)?>$")
+	attrRegExp                  = regexp.MustCompile("^(@?[a-zA-Z0-9_]+)\\s+(.*)$")
+	envVarRegExp                = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)(?::-([^}]*))?\}|#\{ENV\[(?:'([^']*)'|"([^"]*)")\]\}`)
 )
 
 func (reader *DefaultLineReader) Next() (string, error) {
@@ -120,16 +205,35 @@ func (opener DefaultOpener) Open(filename string) (http.File, error) {
 	return http.Dir(opener).Open(filename)
 }
 
-func makeParserContext(tag string, tagArgs string, opener Opener) *parserContext {
+func makeParserContext(tag string, tagArgs string, opener Opener, expandEnv bool, includeStack []string) *parserContext {
 	return &parserContext{
-		tag:     tag,
-		tagArgs: tagArgs,
-		elems:   make([]*ConfigElement, 0),
-		attrs:   make(map[string]string),
-		opener:  opener,
+		tag:          tag,
+		tagArgs:      tagArgs,
+		elems:        make([]*ConfigElement, 0),
+		attrs:        make(map[string]string),
+		opener:       opener,
+		expandEnv:    expandEnv,
+		includeStack: includeStack,
 	}
 }
 
+// replaces ${VAR}, ${VAR:-default} and #{ENV['VAR']} with the values of
+// the environment variables.  undefined variables expand to the empty
+// string unless the default is given.
+func expandEnvVars(value string) string {
+	return envVarRegExp.ReplaceAllStringFunc(value, func(placeholder string) string {
+		m := envVarRegExp.FindStringSubmatch(placeholder)
+		if m[1] == "" {
+			return os.Getenv(m[3] + m[4])
+		}
+		retval := os.Getenv(m[1])
+		if retval == "" {
+			retval = m[2]
+		}
+		return retval
+	})
+}
+
 func makeConfigElementFromContext(context *parserContext) *ConfigElement {
 	return &ConfigElement{
 		Name:  context.tag,
@@ -139,45 +243,79 @@ func makeConfigElementFromContext(context *parserContext) *ConfigElement {
 	}
 }
 
+// splits the pattern into the leading directory without wildcards and the rest.
+func splitGlobPattern(pattern string) (string, string) {
+	components := strings.Split(pattern, "/")
+	i := len(components) - 1
+	for j, component := range components[0:i] {
+		if strings.ContainsAny(component, "*?[\\") {
+			i = j
+			break
+		}
+	}
+	dir := strings.Join(components[0:i], "/")
+	if dir == "" && path.IsAbs(pattern) {
+		dir = "/"
+	}
+	return dir, strings.Join(components[i:], "/")
+}
+
+// parses the files matching the pattern into the current section.  a
+// relative pattern is resolved against the directory of the including file.
 func handleInclude(reader LineReader, context *parserContext, attrValue string) error {
 	url_, err := url.Parse(attrValue)
 	if err != nil {
 		return err
 	}
-	if url_.Scheme == "file" || url_.Path == attrValue {
-		var abspathPattern string
-		if path.IsAbs(url_.Path) {
-			abspathPattern = url_.Path
-		} else {
-			abspathPattern = path.Join(context.opener.BasePath(), url_.Path)
-		}
-		files, err := Glob(context.opener.FileSystem(), abspathPattern)
-		if err != nil {
-			return err
+	if url_.Scheme != "file" && url_.Path != attrValue {
+		return errors.New("Not implemented!")
+	}
+	dir, pattern := splitGlobPattern(url_.Path)
+	opener := context.opener.NewOpener(dir)
+	files, err := Glob(opener.FileSystem(), pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		includedPath := path.Join(opener.BasePath(), file)
+		for _, includingPath := range context.includeStack {
+			if includingPath == includedPath {
+				return errors.New(fmt.Sprintf("Include cycle detected: %s includes %s", reader.Filename(), includedPath))
+			}
 		}
-		for _, file := range files {
-			newReader, err := NewLineReader(context.opener, file)
+		err := func() error {
+			newReader, err := NewLineReader(opener, file)
 			if err != nil {
 				return err
 			}
 			defer newReader.Close()
-			parseConfig(newReader, &parserContext{
-				tag:     context.tag,
-				tagArgs: context.tagArgs,
-				elems:   context.elems,
-				attrs:   context.attrs,
-				opener:  context.opener.NewOpener(path.Dir(file)),
-			})
+			subcontext := &parserContext{
+				tag:          context.tag,
+				tagArgs:      context.tagArgs,
+				elems:        context.elems,
+				attrs:        context.attrs,
+				opener:       opener.NewOpener(path.Dir(file)),
+				expandEnv:    context.expandEnv,
+				includeStack: append(context.includeStack[0:len(context.includeStack):len(context.includeStack)], includedPath),
+			}
+			err = parseConfig(newReader, subcontext)
+			if err != nil {
+				return err
+			}
+			context.elems = subcontext.elems
+			return nil
+		}()
+		if err != nil {
+			return err
 		}
-		return nil
-	} else {
-		return errors.New("Not implemented!")
 	}
+	return nil
 }
 
 func handleSpecialAttrs(reader LineReader, context *parserContext, attrName string, attrValue string) (bool, error) {
-	if attrName == "include" {
-		return false, handleInclude(reader, context, attrValue)
+	if attrName == "@include" || attrName == "include" {
+		return true, handleInclude(reader, context, attrValue)
 	}
 	return false, nil
 }
@@ -195,14 +333,20 @@ func parseConfig(reader LineReader, context *parserContext) error {
 		}
 
 		line = strings.TrimLeft(line, " \t\r\n")
-		line = stripCommentRegexp.ReplaceAllLiteralString(line, "")
+		if context.expandEnv {
+			line = stripCommentExceptEnvRegexp.ReplaceAllLiteralString(line, "")
+		} else {
+			line = stripCommentRegexp.ReplaceAllLiteralString(line, "")
+		}
 		if len(line) == 0 {
 			continue
 		} else if submatch := startTagRegexp.FindStringSubmatch(line); submatch != nil {
 			subcontext := makeParserContext(
 				submatch[1],
 				submatch[2],
-				nil,
+				context.opener,
+				context.expandEnv,
+				context.includeStack,
 			)
 			err = parseConfig(reader, subcontext)
 			if err != nil {
@@ -217,7 +361,11 @@ func parseConfig(reader LineReader, context *parserContext) error {
 				return err
 			}
 			if !handled {
-				context.attrs[submatch[1]] = submatch[2]
+				value := submatch[2]
+				if context.expandEnv {
+					value = expandEnvVars(value)
+				}
+				context.attrs[submatch[1]] = value
 			}
 		} else {
 			return errors.New(fmt.Sprintf("Parse error in %s at line %s", reader.Filename(), reader.LineNumber()))
@@ -227,7 +375,17 @@ func parseConfig(reader LineReader, context *parserContext) error {
 }
 
 func ParseConfig(opener Opener, filename string) (*Config, error) {
-	context := makeParserContext("(root)", "", opener)
+	return parseConfigFile(opener, filename, false)
+}
+
+// Same as ParseConfig except that the environment variables referred to
+// in the attribute values are expanded.
+func ParseConfigExpandingEnv(opener Opener, filename string) (*Config, error) {
+	return parseConfigFile(opener, filename, true)
+}
+
+func parseConfigFile(opener Opener, filename string, expandEnv bool) (*Config, error) {
+	context := makeParserContext("(root)", "", opener, expandEnv, []string{path.Join(opener.BasePath(), filename)})
 	reader, err := NewLineReader(opener, filename)
 	if err != nil {
 		return nil, err
@@ -243,54 +401,462 @@ func ParseConfig(opener Opener, filename string) (*Config, error) {
 type FluentConfigurer struct {
 	logger                Logger
 	router                *FluentRouter
+	labels                map[string]*FluentRouter
+	inputs                map[string][]Input
+	outputs               map[string][]Output
+	filters               map[string][]Filter
 	inputFactoryRegistry  InputFactoryRegistry
 	outputFactoryRegistry OutputFactoryRegistry
+	filterFactoryRegistry FilterFactoryRegistry
+	workerPool            *WorkerPool
+	strictConfig          bool
+	// the engine's dead letter port, which takes the rules of the
+	// DeadLetterLabel
+	deadLetterRouter *FluentRouter
+	// the default port of the routers but the DeadLetterLabel's
+	orphans *orphanPort
 }
 
-func (configurer *FluentConfigurer) Configure(engine Engine, config *Config) error {
-	for _, v := range config.Root.Elems {
-		switch v.Name {
-		case "source":
-			type_ := v.Attrs["type"]
-			inputFactory := configurer.inputFactoryRegistry.LookupInputFactory(type_)
-			if inputFactory == nil {
-				return errors.New("Could not find input factory: " + type_)
+// an engine handed to the input plugins with the @label attribute so that
+// the records they emit go to the label's router instead of the default one.
+type labelledEngine struct {
+	Engine
+	port Port
+}
+
+func (engine *labelledEngine) DefaultPort() Port {
+	return engine.port
+}
+
+// the instances built while applying a configuration.  the ones carried
+// over from the previous configuration are moved out of the configurer's
+// maps so that whatever is left there afterwards is to be shut down.
+type fluentConfiguration struct {
+	labels  map[string]*FluentRouter
+	inputs  map[string][]Input
+	outputs map[string][]Output
+	filters map[string][]Filter
+	created map[interface{}]bool
+}
+
+// a string that identifies the section along with all of its contents.
+// two sections having the same fingerprint are considered to be identical.
+func (elem *ConfigElement) fingerprint() string {
+	retval := fmt.Sprintf("<%q %q", elem.Name, elem.Args)
+	keys := make([]string, 0, len(elem.Attrs))
+	for key, _ := range elem.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		retval += fmt.Sprintf(" %q=%q", key, elem.Attrs[key])
+	}
+	retval += ">"
+	for _, child := range elem.Elems {
+		retval += child.fingerprint()
+	}
+	return retval
+}
+
+// the attributes interpreted by the configurer rather than the plugins.
+var commonAttributes = []string{"type", "@label", "@id"}
+
+// checks that no two sections, including those in the labels, have the
+// same `@id'.
+func checkPluginIds(elems []*ConfigElement, ids map[string]bool) error {
+	for _, v := range elems {
+		if id, ok := v.Attrs["@id"]; ok {
+			if ids[id] {
+				return errors.New("Duplicate @id: " + id)
 			}
-			input, err := inputFactory.New(engine, v)
-			if err != nil {
-				return err
+			ids[id] = true
+		}
+		err := checkPluginIds(v.Elems, ids)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reports the attributes of the section that are not declared by the
+// factory, as an error in the strict mode and as a warning otherwise.
+// nothing is checked for the factories that don't declare them.
+func (configurer *FluentConfigurer) validateAttributes(factory Plugin, v *ConfigElement) error {
+	declarer, ok := factory.(AttributeDeclarer)
+	if !ok {
+		return nil
+	}
+	accepted := make(map[string]bool)
+	for _, name := range commonAttributes {
+		accepted[name] = true
+	}
+	for _, name := range declarer.AcceptedAttributes() {
+		accepted[name] = true
+	}
+	unknown := make([]string, 0)
+	for name, _ := range v.Attrs {
+		if !accepted[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	message := fmt.Sprintf("Unknown attribute(s) in <%s> of type %s: %s", v.Name, factory.Name(), strings.Join(unknown, ", "))
+	if configurer.strictConfig {
+		return errors.New(message)
+	}
+	configurer.logger.Warning("%s", message)
+	return nil
+}
+
+// builds the input for the source section without launching it.
+func (configurer *FluentConfigurer) newInput(engine Engine, configuration *fluentConfiguration, v *ConfigElement) (Input, error) {
+	type_ := v.Attrs["type"]
+	inputFactory := configurer.inputFactoryRegistry.LookupInputFactory(type_)
+	if inputFactory == nil {
+		return nil, errors.New("Could not find input factory: " + type_)
+	}
+	err := configurer.validateAttributes(inputFactory, v)
+	if err != nil {
+		return nil, err
+	}
+	label, ok := v.Attrs["@label"]
+	if ok {
+		var port Port = configuration.labels[label]
+		if configurer.workerPool != nil {
+			port = configurer.workerPool.Port(port)
+		}
+		engine = &labelledEngine{Engine: engine, port: port}
+	}
+	return inputFactory.New(engine, v)
+}
+
+// builds the inputs for the new source sections first, then starts them
+// and finally launches them, so that no address is bound unless all of
+// them are built.  the ones not launched yet are discarded if any of them
+// fails.
+func (configurer *FluentConfigurer) configureInputs(engine Engine, configuration *fluentConfiguration, sources []*ConfigElement) error {
+	inputs := make([]Input, 0, len(sources))
+	// the number of the inputs that have been started if startable
+	started := 0
+	discard := func(from int) {
+		for i := from; i < len(inputs); i += 1 {
+			if startable, ok := inputs[i].(Startable); ok && i < started {
+				startable.Stop()
 			}
-			err = engine.Launch(input)
+			inputs[i].Shutdown()
+		}
+	}
+	for _, v := range sources {
+		input, err := configurer.newInput(engine, configuration, v)
+		if err != nil {
+			discard(0)
+			return err
+		}
+		inputs = append(inputs, input)
+	}
+	for _, input := range inputs {
+		startable, ok := input.(Startable)
+		if ok {
+			err := startable.Start()
 			if err != nil {
+				discard(0)
 				return err
 			}
-			configurer.logger.Info("Input plugin loaded: %s", inputFactory.Name())
+		}
+		started += 1
+	}
+	for i, input := range inputs {
+		err := engine.Launch(input)
+		if err != nil {
+			// the ones launched already are kept running as part of the
+			// configuration
+			discard(i)
+			return err
+		}
+		key := sources[i].fingerprint()
+		configuration.inputs[key] = append(configuration.inputs[key], input)
+		configurer.logger.Info("Input plugin loaded: %s", sources[i].Attrs["type"])
+	}
+	return nil
+}
+
+func (configurer *FluentConfigurer) configureOutput(engine Engine, configuration *fluentConfiguration, router *FluentRouter, key string, v *ConfigElement) error {
+	if outputs := configurer.outputs[key]; len(outputs) > 0 {
+		configurer.outputs[key] = outputs[1:]
+		configuration.outputs[key] = append(configuration.outputs[key], outputs[0])
+		return router.AddRule(v.Args, outputs[0])
+	}
+	type_ := v.Attrs["type"]
+	outputFactory := configurer.outputFactoryRegistry.LookupOutputFactory(type_)
+	if outputFactory == nil {
+		return errors.New("Could not find output factory: " + type_)
+	}
+	err := configurer.validateAttributes(outputFactory, v)
+	if err != nil {
+		return err
+	}
+	output, err := outputFactory.New(engine, v)
+	if err != nil {
+		return err
+	}
+	err = engine.Launch(output)
+	if err != nil {
+		return err
+	}
+	configuration.created[output] = true
+	configuration.outputs[key] = append(configuration.outputs[key], output)
+	configurer.logger.Info("Output plugin loaded: %s, with Args '%s'", outputFactory.Name(), v.Args)
+	return router.AddRule(v.Args, output)
+}
+
+func (configurer *FluentConfigurer) configureFilter(engine Engine, configuration *fluentConfiguration, router *FluentRouter, key string, v *ConfigElement) error {
+	if filters := configurer.filters[key]; len(filters) > 0 {
+		configurer.filters[key] = filters[1:]
+		configuration.filters[key] = append(configuration.filters[key], filters[0])
+		return router.AddFilterRule(v.Args, filters[0])
+	}
+	type_ := v.Attrs["type"]
+	filterFactory := configurer.filterFactoryRegistry.LookupFilterFactory(type_)
+	if filterFactory == nil {
+		return errors.New("Could not find filter factory: " + type_)
+	}
+	err := configurer.validateAttributes(filterFactory, v)
+	if err != nil {
+		return err
+	}
+	filter, err := filterFactory.New(engine, v)
+	if err != nil {
+		return err
+	}
+	configuration.created[filter] = true
+	configuration.filters[key] = append(configuration.filters[key], filter)
+	configurer.logger.Info("Filter plugin loaded: %s, with Args '%s'", filterFactory.Name(), v.Args)
+	return router.AddFilterRule(v.Args, filter)
+}
+
+// builds the rules for the match and filter sections into the router.
+// the keys are prefixed with the label so that the same section in
+// different labels doesn't share the instance.
+func (configurer *FluentConfigurer) configureRoutes(engine Engine, configuration *fluentConfiguration, label string, router *FluentRouter, elems []*ConfigElement) error {
+	for _, v := range elems {
+		var err error
+		switch v.Name {
 		case "match":
-			type_ := v.Attrs["type"]
-			outputFactory := configurer.outputFactoryRegistry.LookupOutputFactory(type_)
-			if outputFactory == nil {
-				return errors.New("Could not find output factory: " + type_)
+			err = configurer.configureOutput(engine, configuration, router, label+v.fingerprint(), v)
+		case "filter":
+			err = configurer.configureFilter(engine, configuration, router, label+v.fingerprint(), v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (configurer *FluentConfigurer) shutdownInputs(engine Engine, inputs map[string][]Input) {
+	for _, inputs_ := range inputs {
+		for _, input := range inputs_ {
+			err := engine.Terminate(input)
+			if err != nil {
+				configurer.logger.Error("%s", err.Error())
 			}
-			output, err := outputFactory.New(engine, v)
+		}
+	}
+}
+
+func (configurer *FluentConfigurer) shutdownOutputs(engine Engine, outputs map[string][]Output) {
+	for _, outputs_ := range outputs {
+		for _, output := range outputs_ {
+			err := engine.Terminate(output)
 			if err != nil {
-				return err
+				configurer.logger.Error("%s", err.Error())
+			}
+		}
+	}
+}
+
+// Applies the configuration.  It can be called again with a new
+// configuration (e.g. on SIGHUP); the sections that are left unchanged
+// keep their plugin instances, so the listeners of such sources and the
+// connections to them survive.  Any change to a section, including the
+// attributes like `port' or `@label', recreates its instance.  Changes to
+// match, filter and label sections never affect the sources.
+func (configurer *FluentConfigurer) Configure(engine Engine, config *Config) error {
+	configuration := &fluentConfiguration{
+		labels:  make(map[string]*FluentRouter),
+		inputs:  make(map[string][]Input),
+		outputs: make(map[string][]Output),
+		filters: make(map[string][]Filter),
+		created: make(map[interface{}]bool),
+	}
+	topLevelRouter := NewFluentRouter()
+	err := func() error {
+		err := checkPluginIds(config.Root.Elems, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+		for _, v := range config.Root.Elems {
+			if v.Name != "label" {
+				continue
+			}
+			label := strings.TrimSpace(v.Args)
+			if !strings.HasPrefix(label, "@") {
+				return errors.New(fmt.Sprintf("Invalid label name: '%s'", label))
+			}
+			if _, ok := configuration.labels[label]; ok {
+				return errors.New("Duplicate label: " + label)
 			}
-			configurer.router.AddRule(v.Args, output)
-			err = engine.Launch(output)
+			router := NewFluentRouter()
+			configuration.labels[label] = router
+			err := configurer.configureRoutes(engine, configuration, label, router, v.Elems)
 			if err != nil {
 				return err
 			}
-			configurer.logger.Info("Output plugin loaded: %s, with Args '%s'", outputFactory.Name(), v.Args)
+		}
+		for _, v := range config.Root.Elems {
+			if v.Name == "source" {
+				if label, ok := v.Attrs["@label"]; ok {
+					if _, ok := configuration.labels[label]; !ok {
+						return errors.New("Could not find label: " + label)
+					}
+				}
+			}
+		}
+		return configurer.configureRoutes(engine, configuration, "", topLevelRouter, config.Root.Elems)
+	}()
+	if err != nil {
+		// put back the instances taken over and discard the new ones
+		for key, outputs := range configuration.outputs {
+			for _, output := range outputs {
+				if configuration.created[output] {
+					engine.Terminate(output)
+				} else {
+					configurer.outputs[key] = append(configurer.outputs[key], output)
+				}
+			}
+		}
+		for key, filters := range configuration.filters {
+			for _, filter := range filters {
+				if !configuration.created[filter] {
+					configurer.filters[key] = append(configurer.filters[key], filter)
+				}
+			}
+		}
+		return err
+	}
+
+	// the routers the sources emit to are kept so that the carried-over
+	// sources see the new rules.
+	configurer.router.replaceRules(topLevelRouter)
+	for label, router := range configuration.labels {
+		if router_, ok := configurer.labels[label]; ok {
+			router_.replaceRules(router)
+			configuration.labels[label] = router_
+		}
+	}
+	configurer.labels = configuration.labels
+	if configurer.deadLetterRouter != nil {
+		errorRouter, ok := configuration.labels[DeadLetterLabel]
+		if !ok {
+			errorRouter = NewFluentRouter()
+		}
+		configurer.deadLetterRouter.replaceRules(errorRouter)
+	}
+	for label, router := range configuration.labels {
+		if label != DeadLetterLabel {
+			router.SetDefaultPort(configurer.orphans)
+		}
+	}
+	configurer.warnAboutOrphanedSources(config.Root.Elems)
+
+	// the sources being removed are shut down first so that the new ones
+	// can listen on the same addresses.
+	newSources := make([]*ConfigElement, 0)
+	for _, v := range config.Root.Elems {
+		if v.Name == "source" {
+			key := v.fingerprint()
+			if inputs := configurer.inputs[key]; len(inputs) > 0 {
+				configurer.inputs[key] = inputs[1:]
+				configuration.inputs[key] = append(configuration.inputs[key], inputs[0])
+			} else {
+				newSources = append(newSources, v)
+			}
+		}
+	}
+	for key, inputs := range configurer.inputs {
+		if len(inputs) > 0 {
+			configurer.logger.Info("Shutting down the source removed or changed: %s", key)
+		}
+	}
+	configurer.shutdownInputs(engine, configurer.inputs)
+	configurer.shutdownOutputs(engine, configurer.outputs)
+	configurer.inputs = configuration.inputs
+	configurer.outputs = configuration.outputs
+	configurer.filters = configuration.filters
+
+	return configurer.configureInputs(engine, configuration, newSources)
+}
+
+// Makes the sources with the @label attribute emit to the label through
+// the workers, as the engine's default port does.
+func (configurer *FluentConfigurer) SetWorkerPool(pool *WorkerPool) {
+	configurer.workerPool = pool
+}
+
+// Makes the configuration with the attributes unknown to the plugins fail
+// to apply, instead of just warning about them.
+func (configurer *FluentConfigurer) SetStrictConfig(strict bool) {
+	configurer.strictConfig = strict
+}
+
+// Routes the records emitted to the dead letter port according to the
+// match sections in the DeadLetterLabel, and makes the routers emit the
+// records that match no rule to the port.
+func (configurer *FluentConfigurer) SetDeadLetterRouter(router *FluentRouter) {
+	configurer.deadLetterRouter = router
+	configurer.orphans.deadLetterPort = router
+}
+
+// Returns the number of the records that matched no match section so far,
+// whether they were dropped or emitted to the DeadLetterLabel.
+func (configurer *FluentConfigurer) OrphanedRecordCount() int64 {
+	return configurer.orphans.Count()
+}
+
+// Warns about the sources whose records are bound to match no match
+// section because there is none where they are emitted to.
+func (configurer *FluentConfigurer) warnAboutOrphanedSources(elems []*ConfigElement) {
+	for _, v := range elems {
+		if v.Name != "source" {
+			continue
+		}
+		router, where := configurer.router, "at the top level"
+		if label, ok := v.Attrs["@label"]; ok {
+			router, where = configurer.labels[label], "in the label "+label
+		}
+		if router.RuleCount() == 0 {
+			configurer.logger.Warning("No match section %s for the source of type %s; its records are orphaned", where, v.Attrs["type"])
 		}
 	}
-	return nil
 }
 
-func NewFluentConfigurer(logger Logger, inputFactoryRegistry InputFactoryRegistry, outputFactoryRegistry OutputFactoryRegistry, router *FluentRouter) *FluentConfigurer {
+func NewFluentConfigurer(logger Logger, inputFactoryRegistry InputFactoryRegistry, outputFactoryRegistry OutputFactoryRegistry, filterFactoryRegistry FilterFactoryRegistry, router *FluentRouter) *FluentConfigurer {
+	orphans := newOrphanPort(logger)
+	router.SetDefaultPort(orphans)
 	return &FluentConfigurer{
 		logger:                logger,
 		router:                router,
+		labels:                make(map[string]*FluentRouter),
+		inputs:                make(map[string][]Input),
+		outputs:               make(map[string][]Output),
+		filters:               make(map[string][]Filter),
 		inputFactoryRegistry:  inputFactoryRegistry,
 		outputFactoryRegistry: outputFactoryRegistry,
+		filterFactoryRegistry: filterFactoryRegistry,
+		orphans:               orphans,
 	}
 }
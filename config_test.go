@@ -2,10 +2,15 @@ package ik
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 type myOpener string
@@ -56,4 +61,645 @@ func TestParseConfig(t *testing.T) {
 	}
 }
 
+type testConfigLogger struct{}
+
+func (testConfigLogger) Critical(format string, args ...interface{}) {}
+func (testConfigLogger) Error(format string, args ...interface{})    {}
+func (testConfigLogger) Warning(format string, args ...interface{})  {}
+func (testConfigLogger) Notice(format string, args ...interface{})   {}
+func (testConfigLogger) Info(format string, args ...interface{})     {}
+func (testConfigLogger) Debug(format string, args ...interface{})    {}
+
+// keeps the warnings so that the tests can check them.
+type testWarningLogger struct {
+	testConfigLogger
+	warnings []string
+}
+
+func (logger *testWarningLogger) Warning(format string, args ...interface{}) {
+	logger.warnings = append(logger.warnings, fmt.Sprintf(format, args...))
+}
+
+type testConfigEngine struct {
+	Engine
+	router     *FluentRouter
+	terminated []PluginInstance
+	launched   []PluginInstance
+	// fails to launch any more than the number of the instances unless 0
+	launchLimit int
+}
+
+func (engine *testConfigEngine) Terminate(instance PluginInstance) error {
+	engine.terminated = append(engine.terminated, instance)
+	return nil
+}
+
+func (engine *testConfigEngine) DefaultPort() Port    { return engine.router }
+func (engine *testConfigEngine) DeadLetterPort() Port { return nil }
+
+func (engine *testConfigEngine) Launch(instance PluginInstance) error {
+	if engine.launchLimit > 0 && len(engine.launched) >= engine.launchLimit {
+		return errors.New("too many instances")
+	}
+	engine.launched = append(engine.launched, instance)
+	return nil
+}
+
+type testConfigInput struct {
+	port      Port
+	failStart bool
+	started   bool
+	shutdown  bool
+}
+
+func (input *testConfigInput) Run() error { return nil }
+
+func (input *testConfigInput) Shutdown() error {
+	input.shutdown = true
+	return nil
+}
+
+func (input *testConfigInput) Start() error {
+	if input.failStart {
+		return errors.New("failed to start")
+	}
+	input.started = true
+	return nil
+}
+
+func (input *testConfigInput) Stop() error {
+	input.started = false
+	return nil
+}
+
+func (input *testConfigInput) Factory() Plugin { return nil }
+func (input *testConfigInput) Port() Port      { return input.port }
+
+type testConfigOutput struct {
+	testPort
+}
+
+func (output *testConfigOutput) Run() error      { return nil }
+func (output *testConfigOutput) Shutdown() error { return nil }
+func (output *testConfigOutput) Factory() Plugin { return nil }
+
+// keeps the instances it creates keyed by their "id" attribute.
+type testConfigRegistry struct {
+	inputs  map[string]*testConfigInput
+	outputs map[string]*testConfigOutput
+	created int
+}
+
+func (registry *testConfigRegistry) Name() string                              { return "test" }
+func (registry *testConfigRegistry) BindScorekeeper(*Scorekeeper)              {}
+func (registry *testConfigRegistry) RegisterInputFactory(InputFactory) error   { return nil }
+func (registry *testConfigRegistry) RegisterOutputFactory(OutputFactory) error { return nil }
+func (registry *testConfigRegistry) RegisterFilterFactory(FilterFactory) error { return nil }
+func (registry *testConfigRegistry) LookupFilterFactory(string) FilterFactory  { return nil }
+
+func (registry *testConfigRegistry) LookupInputFactory(string) InputFactory {
+	return &testConfigInputFactory{registry}
+}
+
+func (registry *testConfigRegistry) LookupOutputFactory(string) OutputFactory {
+	return &testConfigOutputFactory{registry}
+}
+
+type testConfigInputFactory struct{ *testConfigRegistry }
+type testConfigOutputFactory struct{ *testConfigRegistry }
+
+func (factory *testConfigInputFactory) New(engine Engine, config *ConfigElement) (Input, error) {
+	input := &testConfigInput{port: engine.DefaultPort(), failStart: config.Attrs["fail_start"] != ""}
+	factory.inputs[config.Attrs["id"]] = input
+	factory.created += 1
+	return input, nil
+}
+
+func (factory *testConfigInputFactory) AcceptedAttributes() []string {
+	return []string{"id", "port", "fail_start"}
+}
+
+func (factory *testConfigOutputFactory) New(engine Engine, config *ConfigElement) (Output, error) {
+	output := &testConfigOutput{}
+	factory.outputs[config.Attrs["id"]] = output
+	factory.created += 1
+	return output, nil
+}
+
+func TestParseConfig_ExpandEnv(t *testing.T) {
+	os.Setenv("IK_TEST_PORT", "24225")
+	os.Setenv("IK_TEST_HOST", "example.com")
+	os.Setenv("IK_TEST_EMPTY", "")
+	os.Unsetenv("IK_TEST_MISSING")
+	const data = "<source>\n" +
+		"port ${IK_TEST_PORT}\n" +
+		"bind #{ENV['IK_TEST_HOST']}:#{ENV[\"IK_TEST_PORT\"]}\n" +
+		"missing [${IK_TEST_MISSING}]\n" +
+		"default ${IK_TEST_MISSING:-fallback}\n" +
+		"empty ${IK_TEST_EMPTY:-fallback}\n" +
+		"set ${IK_TEST_PORT:-fallback} # comment\n" +
+		"literal $IK_TEST_PORT$\n" +
+		"</source>\n"
+	config, err := ParseConfigExpandingEnv(myOpener(data), "test.cfg")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	attrs := config.Root.Elems[0].Attrs
+	expected := map[string]string{
+		"port":    "24225",
+		"bind":    "example.com:24225",
+		"missing": "[]",
+		"default": "fallback",
+		"empty":   "fallback",
+		"set":     "24225",
+		"literal": "$IK_TEST_PORT$",
+	}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Logf("%s: %s", key, attrs[key])
+			t.Fail()
+		}
+	}
+
+	// expansion is off by default
+	config, err = ParseConfig(myOpener("<source>\nport ${IK_TEST_PORT}\n</source>\n"), "test.cfg")
+	if err != nil {
+		t.FailNow()
+	}
+	if config.Root.Elems[0].Attrs["port"] != "${IK_TEST_PORT}" {
+		t.Fail()
+	}
+}
+
+func writeTestConfigFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "ik-config")
+	if err != nil {
+		t.FailNow()
+	}
+	for name, data := range files {
+		os.MkdirAll(path.Join(dir, path.Dir(name)), 0755)
+		err := ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	return dir
+}
+
+func TestParseConfig_Include(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"fluent.conf": "@include conf.d/*.conf\n" +
+			"<match **>\n" +
+			"type stdout\n" +
+			"</match>\n",
+		"conf.d/a.conf": "<source>\n" +
+			"type forward\n" +
+			"@include sub/port.conf\n" +
+			"</source>\n",
+		"conf.d/b.conf":        "<source>\ntype tail\n</source>\n",
+		"conf.d/sub/port.conf": "port 24225\n",
+		"absolute.conf":        "<filter **>\ntype grep\n</filter>\n",
+	})
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "conf.d/c.conf"), []byte("@include "+path.Join(dir, "absolute.conf")+"\n"), 0644)
+	config, err := ParseConfig(DefaultOpener(dir), "fluent.conf")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	elems := config.Root.Elems
+	if len(elems) != 4 {
+		t.Log(elems)
+		t.FailNow()
+	}
+	if elems[0].Name != "source" || elems[0].Attrs["type"] != "forward" || elems[0].Attrs["port"] != "24225" {
+		t.Log(elems[0])
+		t.Fail()
+	}
+	if elems[1].Name != "source" || elems[1].Attrs["type"] != "tail" {
+		t.Fail()
+	}
+	if elems[2].Name != "filter" || elems[3].Name != "match" {
+		t.Fail()
+	}
+	if _, ok := config.Root.Attrs["@include"]; ok {
+		t.Fail()
+	}
+}
+
+func TestParseConfig_IncludeCycle(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"fluent.conf": "@include a.conf\n",
+		"a.conf":      "<source>\n@include b/b.conf\n</source>\n",
+		"b/b.conf":    "@include ../a.conf\n",
+	})
+	defer os.RemoveAll(dir)
+	_, err := ParseConfig(DefaultOpener(dir), "fluent.conf")
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_Label(t *testing.T) {
+	const data = "<source>\n" +
+		"type test\n" +
+		"id plain\n" +
+		"</source>\n" +
+		"<source>\n" +
+		"type test\n" +
+		"id labelled\n" +
+		"@label @SPECIAL\n" +
+		"</source>\n" +
+		"<match **>\n" +
+		"type test\n" +
+		"id default\n" +
+		"</match>\n" +
+		"<label @SPECIAL>\n" +
+		"<match **>\n" +
+		"type test\n" +
+		"id special\n" +
+		"</match>\n" +
+		"</label>\n"
+	config, err := ParseConfig(myOpener(data), "test.cfg")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	err = configurer.Configure(&testConfigEngine{router: router}, config)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	record := TinyFluentRecord{Timestamp: 1, Data: map[string]interface{}{"a": "b"}}
+	registry.inputs["labelled"].Port().Emit([]FluentRecordSet{{"labelled", []TinyFluentRecord{record}}})
+	registry.inputs["plain"].Port().Emit([]FluentRecordSet{{"plain", []TinyFluentRecord{record}}})
+	special := registry.outputs["special"].recordSets
+	if len(special) != 1 || special[0].Tag != "labelled" {
+		t.Log(special)
+		t.Fail()
+	}
+	default_ := registry.outputs["default"].recordSets
+	if len(default_) != 1 || default_[0].Tag != "plain" {
+		t.Log(default_)
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_DeadLetterLabel(t *testing.T) {
+	const data = "<source>\n" +
+		"type test\n" +
+		"id plain\n" +
+		"</source>\n" +
+		"<match app.**>\n" +
+		"type test\n" +
+		"id app\n" +
+		"</match>\n" +
+		"<label @ERROR>\n" +
+		"<match **>\n" +
+		"type test\n" +
+		"id errors\n" +
+		"</match>\n" +
+		"</label>\n"
+	config, err := ParseConfig(myOpener(data), "test.cfg")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	deadLetterRouter := NewFluentRouter()
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	configurer.SetDeadLetterRouter(deadLetterRouter)
+	err = configurer.Configure(&testConfigEngine{router: router}, config)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	data_ := map[string]interface{}{"a": "b"}
+	record := TinyFluentRecord{Timestamp: 1, Data: data_}
+	registry.inputs["plain"].Port().Emit([]FluentRecordSet{{"app.x", []TinyFluentRecord{record}}, {"other", []TinyFluentRecord{record}}})
+	err = EmitDeadLetters(deadLetterRouter, []FluentRecordSet{{"app.y", []TinyFluentRecord{record}}}, "failed")
+	if err != nil {
+		t.FailNow()
+	}
+	app := registry.outputs["app"].recordSets
+	if len(app) != 1 || app[0].Tag != "app.x" || app[0].Records[0].Data[DeadLetterErrorKey] != nil {
+		t.Log(app)
+		t.Fail()
+	}
+	// the records matching no rule and those emitted to the dead letter
+	// port, with the reasons
+	errors_ := registry.outputs["errors"].recordSets
+	if len(errors_) != 2 || errors_[0].Tag != "other" || errors_[0].Records[0].Data[DeadLetterErrorKey] != noRouteReason || errors_[1].Tag != "app.y" || errors_[1].Records[0].Data[DeadLetterErrorKey] != "failed" {
+		t.Log(errors_)
+		t.Fail()
+	}
+	if len(data_) != 1 {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_OrphanedRecords(t *testing.T) {
+	const data = "<source>\n" +
+		"type test\n" +
+		"id plain\n" +
+		"</source>\n" +
+		"<source>\n" +
+		"type test\n" +
+		"id labelled\n" +
+		"@label @EMPTY\n" +
+		"</source>\n" +
+		"<match app.**>\n" +
+		"type test\n" +
+		"id app\n" +
+		"</match>\n" +
+		"<label @EMPTY>\n" +
+		"</label>\n"
+	config, err := ParseConfig(myOpener(data), "test.cfg")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	logger := &testWarningLogger{}
+	configurer := NewFluentConfigurer(logger, registry, registry, registry, router)
+	err = configurer.Configure(&testConfigEngine{router: router}, config)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], "@EMPTY") {
+		t.Log(logger.warnings)
+		t.Fail()
+	}
+	record := TinyFluentRecord{Timestamp: 1, Data: map[string]interface{}{"a": "b"}}
+	for i := 0; i < 2; i++ {
+		err = registry.inputs["plain"].Port().Emit([]FluentRecordSet{{"app.x", []TinyFluentRecord{record}}, {"other", []TinyFluentRecord{record, record}}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	err = registry.inputs["labelled"].Port().Emit([]FluentRecordSet{{"app.x", []TinyFluentRecord{record}}})
+	if err != nil {
+		t.FailNow()
+	}
+	if len(registry.outputs["app"].recordSets) != 2 {
+		t.Fail()
+	}
+	if configurer.OrphanedRecordCount() != 5 {
+		t.Log(configurer.OrphanedRecordCount())
+		t.Fail()
+	}
+	// warned once for each tag
+	if len(logger.warnings) != 3 || !strings.Contains(logger.warnings[1], "other") || !strings.Contains(logger.warnings[2], "app.x") {
+		t.Log(logger.warnings)
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_UnknownLabel(t *testing.T) {
+	config := &Config{Root: &ConfigElement{Elems: []*ConfigElement{
+		{Name: "source", Attrs: map[string]string{"type": "test", "@label": "@MISSING"}},
+	}}}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	if configurer.Configure(&testConfigEngine{router: router}, config) == nil {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_DuplicateId(t *testing.T) {
+	config := &Config{Root: &ConfigElement{Elems: []*ConfigElement{
+		{Name: "source", Attrs: map[string]string{"type": "test", "@id": "main"}},
+		{Name: "label", Args: "@SPECIAL", Elems: []*ConfigElement{
+			{Name: "match", Args: "**", Attrs: map[string]string{"type": "test", "@id": "main"}},
+		}},
+	}}}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	err := configurer.Configure(&testConfigEngine{router: router}, config)
+	if err == nil || err.Error() != "Duplicate @id: main" {
+		t.Log(err)
+		t.Fail()
+	}
+	if len(registry.inputs) != 0 || len(registry.outputs) != 0 {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_StartsInputsAfterBuildingAll(t *testing.T) {
+	config := &Config{Root: &ConfigElement{Elems: []*ConfigElement{
+		{Name: "source", Attrs: map[string]string{"type": "test", "id": "a"}},
+		{Name: "source", Attrs: map[string]string{"type": "test", "id": "b", "fail_start": "1"}},
+	}}}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	if configurer.Configure(&testConfigEngine{router: router}, config) == nil {
+		t.FailNow()
+	}
+	// the one started is stopped as the other fails to start
+	a := registry.inputs["a"]
+	if a.started || !a.shutdown || !registry.inputs["b"].shutdown {
+		t.Fail()
+	}
+	if len(configurer.inputs) != 0 {
+		t.Fail()
+	}
+
+	config.Root.Elems[1].Attrs["fail_start"] = ""
+	if configurer.Configure(&testConfigEngine{router: router}, config) != nil {
+		t.FailNow()
+	}
+	if !registry.inputs["a"].started || !registry.inputs["b"].started {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_DiscardsInputsNotLaunched(t *testing.T) {
+	config := &Config{Root: &ConfigElement{Elems: []*ConfigElement{
+		{Name: "source", Attrs: map[string]string{"type": "test", "id": "a"}},
+		{Name: "source", Attrs: map[string]string{"type": "test", "id": "b"}},
+	}}}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	if configurer.Configure(&testConfigEngine{router: router, launchLimit: 1}, config) == nil {
+		t.FailNow()
+	}
+	// the one launched is kept, while the other is stopped and shut down
+	a, b := registry.inputs["a"], registry.inputs["b"]
+	if !a.started || a.shutdown || b.started || !b.shutdown {
+		t.Fail()
+	}
+	if len(configurer.inputs) != 1 {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_UnknownAttributes(t *testing.T) {
+	config := &Config{Root: &ConfigElement{Elems: []*ConfigElement{
+		{Name: "source", Attrs: map[string]string{"type": "test", "id": "a", "prot": "24224"}},
+	}}}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	configurer.SetStrictConfig(true)
+	err := configurer.Configure(&testConfigEngine{router: router}, config)
+	if err == nil || !strings.Contains(err.Error(), "prot") || registry.created != 0 {
+		t.FailNow()
+	}
+	// just warned about unless strict
+	configurer.SetStrictConfig(false)
+	err = configurer.Configure(&testConfigEngine{router: router}, config)
+	if err != nil || registry.created != 1 {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_Reconfigure(t *testing.T) {
+	source := &ConfigElement{Name: "source", Attrs: map[string]string{"type": "test", "id": "in", "port": "1"}}
+	match := &ConfigElement{Name: "match", Args: "a.**", Attrs: map[string]string{"type": "test", "id": "a"}}
+	registry := &testConfigRegistry{
+		inputs:  make(map[string]*testConfigInput),
+		outputs: make(map[string]*testConfigOutput),
+	}
+	router := NewFluentRouter()
+	engine := &testConfigEngine{router: router}
+	configurer := NewFluentConfigurer(testConfigLogger{}, registry, registry, registry, router)
+	err := configurer.Configure(engine, &Config{Root: &ConfigElement{Elems: []*ConfigElement{source, match}}})
+	if err != nil {
+		t.FailNow()
+	}
+	input := registry.inputs["in"]
+	output := registry.outputs["a"]
+
+	// adding an output leaves the rest untouched
+	err = configurer.Configure(engine, &Config{Root: &ConfigElement{Elems: []*ConfigElement{
+		source,
+		match,
+		{Name: "match", Args: "b.**", Attrs: map[string]string{"type": "test", "id": "b"}},
+	}}})
+	if err != nil {
+		t.FailNow()
+	}
+	if registry.created != 3 || len(engine.terminated) != 0 {
+		t.Log(registry.created, engine.terminated)
+		t.FailNow()
+	}
+	record := TinyFluentRecord{Timestamp: 1, Data: map[string]interface{}{}}
+	input.Port().Emit([]FluentRecordSet{{"a.x", []TinyFluentRecord{record}}, {"b.x", []TinyFluentRecord{record}}})
+	if len(output.recordSets) != 1 || len(registry.outputs["b"].recordSets) != 1 {
+		t.Fail()
+	}
+
+	// changing the port of the source recreates it, and the dropped
+	// output gets shut down
+	err = configurer.Configure(engine, &Config{Root: &ConfigElement{Elems: []*ConfigElement{
+		{Name: "source", Attrs: map[string]string{"type": "test", "id": "in", "port": "2"}},
+		match,
+	}}})
+	if err != nil {
+		t.FailNow()
+	}
+	if registry.created != 4 || registry.inputs["in"] == input || len(engine.terminated) != 2 {
+		t.Log(registry.created, engine.terminated)
+		t.FailNow()
+	}
+	if engine.terminated[0] != input || engine.terminated[1] != registry.outputs["b"] {
+		t.Fail()
+	}
+	registry.inputs["in"].Port().Emit([]FluentRecordSet{{"a.x", []TinyFluentRecord{record}}, {"b.x", []TinyFluentRecord{record}}})
+	if len(output.recordSets) != 2 || len(registry.outputs["b"].recordSets) != 1 {
+		t.Fail()
+	}
+}
+
 // vim: sts=4 sw=4 ts=4 noet
+
+func TestConfigElement_TypedAttrs(t *testing.T) {
+	elem := &ConfigElement{Attrs: map[string]string{
+		"s":       "str",
+		"i":       "42",
+		"b":       "true",
+		"seconds": "30",
+		"d":       "1m30s",
+		"c":       "8kiB",
+		"bad":     "x",
+	}}
+	if elem.AttrString("s", "") != "str" || elem.AttrString("missing", "default") != "default" {
+		t.Fail()
+	}
+	i, err := elem.AttrInt("i", 0)
+	if err != nil || i != 42 {
+		t.Fail()
+	}
+	i, err = elem.AttrInt("missing", 7)
+	if err != nil || i != 7 {
+		t.Fail()
+	}
+	b, err := elem.AttrBool("b", false)
+	if err != nil || !b {
+		t.Fail()
+	}
+	d, err := elem.AttrDuration("seconds", 0)
+	if err != nil || d != 30*time.Second {
+		t.Fail()
+	}
+	d, err = elem.AttrDuration("d", 0)
+	if err != nil || d != 90*time.Second {
+		t.Fail()
+	}
+	c, err := elem.AttrCapacity("c", 0)
+	if err != nil || c != 8192 {
+		t.Fail()
+	}
+	// the errors name the attribute
+	_, err = elem.AttrInt("bad", 0)
+	if err == nil || err.Error() != `invalid bad: "x"` {
+		t.Fail()
+	}
+	_, err = elem.AttrBool("bad", false)
+	if err == nil {
+		t.Fail()
+	}
+	_, err = elem.AttrDuration("bad", 0)
+	if err == nil {
+		t.Fail()
+	}
+	_, err = elem.AttrCapacity("bad", 0)
+	if err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,53 @@
+package ik
+
+// The key under which the reason is attached to the records emitted to the
+// dead letter port.
+const DeadLetterErrorKey = "error"
+
+// The label whose match sections receive the records emitted to the dead
+// letter port.
+const DeadLetterLabel = "@ERROR"
+
+// the reason attached to the records that match no rule.
+const noRouteReason = "no match for the tag"
+
+// Emits the records that could not be processed as usual to the dead letter
+// port, with the reason attached to each of them.  The data of the records
+// are copied so that the records shared with the other ports are left
+// intact.  The records are dropped if port is nil.
+func EmitDeadLetters(port Port, recordSets []FluentRecordSet, reason string) error {
+	if port == nil {
+		return nil
+	}
+	deadLetters := make([]FluentRecordSet, len(recordSets))
+	for i, recordSet := range recordSets {
+		records := make([]TinyFluentRecord, len(recordSet.Records))
+		for j, record := range recordSet.Records {
+			data := make(map[string]interface{}, len(record.Data)+1)
+			for k, v := range record.Data {
+				data[k] = v
+			}
+			data[DeadLetterErrorKey] = reason
+			records[j] = TinyFluentRecord{Timestamp: record.Timestamp, Data: data, Nanoseconds: record.Nanoseconds}
+		}
+		deadLetters[i] = FluentRecordSet{Tag: recordSet.Tag, Records: records}
+	}
+	return port.Emit(deadLetters)
+}
+
+// a port that passes the records to the dead letter port with the reason.
+type deadLetterReasonPort struct {
+	port   Port
+	reason string
+}
+
+func (port *deadLetterReasonPort) Emit(recordSets []FluentRecordSet) error {
+	return EmitDeadLetters(port.port, recordSets, port.reason)
+}
+
+// Returns a port that emits the records to the dead letter port with the
+// reason, e.g. as the default port of a router for the records that match
+// no rule.
+func WithDeadLetterReason(port Port, reason string) Port {
+	return &deadLetterReasonPort{port: port, reason: reason}
+}
@@ -3,6 +3,8 @@ package ik
 import (
 	"github.com/moriyoshi/ik/task"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,10 +35,15 @@ type engineImpl struct {
 	randSource               rand.Source
 	scorekeeper              *Scorekeeper
 	defaultPort              Port
+	deadLetterPort           Port
+	orphanedRecordCount      func() int64
 	spawner                  *Spawner
 	pluginInstances          []PluginInstance
+	pluginInstancesMtx       sync.Mutex
 	taskRunner               task.TaskRunner
 	recurringTaskScheduler   *task.RecurringTaskScheduler
+	state                    int32
+	startedAt                time.Time
 }
 
 func (engine *engineImpl) Logger() Logger {
@@ -67,6 +74,22 @@ func (engine *engineImpl) DefaultPort() Port {
 	return engine.defaultPort
 }
 
+func (engine *engineImpl) DeadLetterPort() Port {
+	return engine.deadLetterPort
+}
+
+// SetDeadLetterPort sets the port the plugins emit the records that could
+// not be processed to.  Such records are dropped unless it is set.
+func (engine *engineImpl) SetDeadLetterPort(port Port) {
+	engine.deadLetterPort = port
+}
+
+// SetOrphanedRecordCount sets the function that returns the number of the
+// records that matched no match section, which the engine status reports.
+func (engine *engineImpl) SetOrphanedRecordCount(count func() int64) {
+	engine.orphanedRecordCount = count
+}
+
 func (engine *engineImpl) Dispose() error {
 	spawnees, err := engine.spawner.GetRunningSpawnees()
 	if err != nil {
@@ -92,11 +115,38 @@ func (engine *engineImpl) Launch(pluginInstance PluginInstance) error {
 			return err
 		}
 	}
+	engine.pluginInstancesMtx.Lock()
+	defer engine.pluginInstancesMtx.Unlock()
 	engine.pluginInstances = append(engine.pluginInstances, pluginInstance)
 	return nil
 }
 
+// Shuts down a plugin instance launched before and waits for it to stop.
+func (engine *engineImpl) Terminate(pluginInstance PluginInstance) error {
+	func() {
+		engine.pluginInstancesMtx.Lock()
+		defer engine.pluginInstancesMtx.Unlock()
+		for i, pluginInstance_ := range engine.pluginInstances {
+			if pluginInstance_ == pluginInstance {
+				engine.pluginInstances = append(engine.pluginInstances[0:i], engine.pluginInstances[i+1:]...)
+				break
+			}
+		}
+	}()
+	spawnee, ok := pluginInstance.(Spawnee)
+	if ok {
+		_, err := engine.spawner.Kill(spawnee)
+		if err != nil {
+			return err
+		}
+		return engine.spawner.Poll(spawnee)
+	}
+	return nil
+}
+
 func (engine *engineImpl) PluginInstances() []PluginInstance {
+	engine.pluginInstancesMtx.Lock()
+	defer engine.pluginInstancesMtx.Unlock()
 	retval := make([]PluginInstance, len(engine.pluginInstances))
 	copy(retval, engine.pluginInstances)
 	return retval
@@ -106,6 +156,54 @@ func (engine *engineImpl) RecurringTaskScheduler() *task.RecurringTaskScheduler
 	return engine.recurringTaskScheduler
 }
 
+func (engine *engineImpl) State() EngineState {
+	return EngineState(atomic.LoadInt32(&engine.state))
+}
+
+// SetState tells the engine has got running once the configuration is
+// applied, or is shutting down.
+func (engine *engineImpl) SetState(state EngineState) {
+	atomic.StoreInt32(&engine.state, int32(state))
+}
+
+// Drain makes the inputs stop accepting new connections while finishing
+// the ones being handled.  The readiness check fails from then on.
+func (engine *engineImpl) Drain() error {
+	engine.SetState(EngineDraining)
+	var retval error
+	for _, pluginInstance := range engine.PluginInstances() {
+		drainable, ok := pluginInstance.(Drainable)
+		if !ok {
+			continue
+		}
+		err := drainable.Drain()
+		if err != nil {
+			engine.logger.Error("%s", err.Error())
+			retval = err
+		}
+	}
+	return retval
+}
+
+// Stop shuts down all the plugin instances, which lets the outputs flush
+// the records they hold, and makes Start return once they have stopped.
+func (engine *engineImpl) Stop() error {
+	engine.SetState(EngineShuttingDown)
+	spawnees, err := engine.spawner.GetRunningSpawnees()
+	if err != nil {
+		return err
+	}
+	var retval error
+	for _, spawnee := range spawnees {
+		_, err := engine.spawner.Kill(spawnee)
+		if err != nil {
+			engine.logger.Error("%s", err.Error())
+			retval = err
+		}
+	}
+	return retval
+}
+
 func (engine *engineImpl) Start() error {
 	spawnees, err := engine.spawner.GetRunningSpawnees()
 	if err != nil {
@@ -131,7 +229,12 @@ func NewEngine(logger Logger, opener Opener, lineParserPluginRegistry LineParser
 		pluginInstances:          make([]PluginInstance, 0),
 		taskRunner:               taskRunner,
 		recurringTaskScheduler:   recurringTaskScheduler,
+		startedAt:                time.Now(),
 	}
 	engine.Spawn(&recurringTaskDaemon{engine, false})
+	if scorekeeper != nil {
+		engineStatusPlugin.BindScorekeeper(scorekeeper)
+	}
+	engine.Launch(&engineStatus{engine: engine, shutdownChan: make(chan struct{})})
 	return engine
 }
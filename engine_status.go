@@ -0,0 +1,117 @@
+package ik
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// engineStatus is launched by the engine so that the status of the engine
+// itself shows up along with the plugin instances.
+type engineStatus struct {
+	engine       *engineImpl
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
+}
+
+type EngineStatusPlugin struct{}
+
+type EngineUptimeTopic struct{}
+
+type EngineStateTopic struct{}
+
+type EngineOrphanedRecordsTopic struct{}
+
+var engineStatusPlugin = &EngineStatusPlugin{}
+
+func (status *engineStatus) Factory() Plugin {
+	return engineStatusPlugin
+}
+
+func (status *engineStatus) Run() error {
+	<-status.shutdownChan
+	return nil
+}
+
+func (status *engineStatus) Shutdown() error {
+	status.shutdownOnce.Do(func() {
+		close(status.shutdownChan)
+	})
+	return nil
+}
+
+func (*EngineStatusPlugin) Name() string {
+	return "engine"
+}
+
+func (plugin *EngineStatusPlugin) BindScorekeeper(scorekeeper *Scorekeeper) {
+	scorekeeper.AddTopic(ScorekeeperTopic{
+		Plugin:      plugin,
+		Name:        "uptime",
+		DisplayName: "Uptime",
+		Description: "Seconds since the engine started",
+		Fetcher:     &EngineUptimeTopic{},
+	})
+	scorekeeper.AddTopic(ScorekeeperTopic{
+		Plugin:      plugin,
+		Name:        "state",
+		DisplayName: "State",
+		Description: "Whether the engine is starting, running, draining or shutting down",
+		Fetcher:     &EngineStateTopic{},
+	})
+	scorekeeper.AddTopic(ScorekeeperTopic{
+		Plugin:      plugin,
+		Name:        "orphaned_records",
+		DisplayName: "Orphaned records",
+		Description: "Number of records that matched no match section",
+		Fetcher:     &EngineOrphanedRecordsTopic{},
+	})
+}
+
+func (topic *EngineUptimeTopic) Markup(status_ PluginInstance) (Markup, error) {
+	status := status_.(*engineStatus)
+	uptime := time.Since(status.engine.startedAt) / time.Second * time.Second
+	return Markup{[]MarkupChunk{{Text: uptime.String()}}}, nil
+}
+
+func (topic *EngineUptimeTopic) PlainText(status_ PluginInstance) (string, error) {
+	status := status_.(*engineStatus)
+	return strconv.FormatInt(int64(time.Since(status.engine.startedAt)/time.Second), 10), nil
+}
+
+func (topic *EngineStateTopic) Markup(status_ PluginInstance) (Markup, error) {
+	text, err := topic.PlainText(status_)
+	if err != nil {
+		return Markup{}, err
+	}
+	return Markup{[]MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *EngineStateTopic) PlainText(status_ PluginInstance) (string, error) {
+	status := status_.(*engineStatus)
+	return status.engine.State().String(), nil
+}
+
+func (topic *EngineOrphanedRecordsTopic) Value(status_ PluginInstance) (interface{}, error) {
+	status := status_.(*engineStatus)
+	if status.engine.orphanedRecordCount == nil {
+		return int64(0), nil
+	}
+	return status.engine.orphanedRecordCount(), nil
+}
+
+func (topic *EngineOrphanedRecordsTopic) Markup(status_ PluginInstance) (Markup, error) {
+	text, err := topic.PlainText(status_)
+	if err != nil {
+		return Markup{}, err
+	}
+	return Markup{[]MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *EngineOrphanedRecordsTopic) PlainText(status_ PluginInstance) (string, error) {
+	value, err := topic.Value(status_)
+	if err != nil {
+		return "", err
+	}
+	return FormatScoreValue(value), nil
+}
@@ -0,0 +1,25 @@
+package ik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEngineStatusTopics(t *testing.T) {
+	engine := &engineImpl{startedAt: time.Now().Add(-90 * time.Second)}
+	engine.SetState(EngineRunning)
+	status := &engineStatus{engine: engine, shutdownChan: make(chan struct{})}
+	uptime, _ := (&EngineUptimeTopic{}).PlainText(status)
+	if uptime != "90" {
+		t.Log(uptime)
+		t.Fail()
+	}
+	markup, _ := (&EngineUptimeTopic{}).Markup(status)
+	if markup.Chunks[0].Text != "1m30s" {
+		t.Fail()
+	}
+	state, _ := (&EngineStateTopic{}).PlainText(status)
+	if state != "running" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,31 @@
+package ik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEngine_Stop(t *testing.T) {
+	engine := NewEngine(testConfigLogger{}, nil, nil, nil, nil)
+	foo := &Foo{"", make(chan string)}
+	engine.Spawn(foo)
+	done := make(chan error)
+	go func() {
+		done <- engine.Start()
+	}()
+	err := engine.Stop()
+	if err != nil || engine.State() != EngineShuttingDown {
+		t.FailNow()
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.FailNow()
+	}
+	if foo.state != "stopped" {
+		t.Fail()
+	}
+}
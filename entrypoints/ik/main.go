@@ -9,7 +9,11 @@ import (
 	"github.com/moriyoshi/ik/plugins"
 	"github.com/op/go-logging"
 	"os"
+	"os/signal"
 	"path"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 func usage() {
@@ -41,12 +45,106 @@ func configureScoreboards(logger ik.Logger, registry *MultiFactoryRegistry, engi
 	return nil
 }
 
+// creates the worker pool if `workers' is specified in the <system>
+// section.  it is not affected by reloading.
+func newWorkerPool(logger ik.Logger, scorekeeper *ik.Scorekeeper, config *ik.Config) (*ik.WorkerPool, error) {
+	for _, v := range config.Root.Elems {
+		if v.Name != "system" {
+			continue
+		}
+		workersStr, ok := v.Attrs["workers"]
+		if !ok {
+			return nil, nil
+		}
+		workers, err := strconv.Atoi(workersStr)
+		if err != nil || workers < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid workers: %s", strconv.Quote(workersStr)))
+		}
+		if workers == 0 {
+			return nil, nil
+		}
+		queueLimit := int64(ik.DefaultWorkerQueueLimit)
+		queueLimitStr, ok := v.Attrs["queue_limit"]
+		if ok {
+			queueLimit, err = strconv.ParseInt(queueLimitStr, 10, 64)
+			if err != nil || queueLimit < 0 {
+				return nil, errors.New(fmt.Sprintf("invalid queue_limit: %s", strconv.Quote(queueLimitStr)))
+			}
+		}
+		overflowAction := ik.OverflowActionBlock
+		overflowActionStr, ok := v.Attrs["overflow_action"]
+		if ok {
+			overflowAction, err = ik.ParseOverflowAction(overflowActionStr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		logger.Info("Starting %d workers", workers)
+		return ik.NewWorkerPool(logger, scorekeeper, workers, queueLimit, overflowAction), nil
+	}
+	return nil, nil
+}
+
+// tells if `strict_config' is enabled in the <system> section, which makes
+// the unknown attributes in the plugin sections an error.
+func isStrictConfig(config *ik.Config) (bool, error) {
+	for _, v := range config.Root.Elems {
+		if v.Name != "system" {
+			continue
+		}
+		strictConfigStr, ok := v.Attrs["strict_config"]
+		if !ok {
+			return false, nil
+		}
+		strictConfig, err := strconv.ParseBool(strictConfigStr)
+		if err != nil {
+			return false, errors.New(fmt.Sprintf("invalid strict_config: %s", strconv.Quote(strictConfigStr)))
+		}
+		return strictConfig, nil
+	}
+	return false, nil
+}
+
+// starts the health check server if `health_check_bind' is specified in
+// the <system> section.  returns whether it is started and how long it
+// keeps failing the probes before exiting on SIGTERM or SIGINT, which is
+// `health_check_drain_wait'.
+func startHealthCheck(logger ik.Logger, engine ik.Engine, config *ik.Config) (bool, time.Duration, error) {
+	for _, v := range config.Root.Elems {
+		if v.Name != "system" {
+			continue
+		}
+		bind, ok := v.Attrs["health_check_bind"]
+		if !ok {
+			return false, 0, nil
+		}
+		drainWait, err := v.AttrDuration("health_check_drain_wait", 0)
+		if err != nil {
+			return false, 0, err
+		}
+		server, err := ik.NewHealthCheckServer(engine, bind)
+		if err != nil {
+			return false, 0, err
+		}
+		err = engine.Spawn(server)
+		if err != nil {
+			server.Shutdown()
+			return false, 0, err
+		}
+		logger.Info("Health check listening on %s", server.Addr().String())
+		return true, drainWait, nil
+	}
+	return false, 0, nil
+}
+
 func main() {
 	logger := logging.MustGetLogger("ik")
 
 	var config_file string
+	var expandEnv bool
 	var help bool
 	flag.StringVar(&config_file, "c", "/etc/fluent/fluent.conf", "config file path (default: /etc/fluent/fluent.conf)")
+	flag.BoolVar(&expandEnv, "E", false, "expand environment variables like ${VAR} in the config file")
 	flag.BoolVar(&help, "h", false, "show help")
 	flag.Parse()
 
@@ -56,7 +154,11 @@ func main() {
 
 	dir, file := path.Split(config_file)
 	opener := ik.DefaultOpener(dir)
-	config, err := ik.ParseConfig(opener, file)
+	parseConfig := ik.ParseConfig
+	if expandEnv {
+		parseConfig = ik.ParseConfigExpandingEnv
+	}
+	config, err := parseConfig(opener, file)
 	if err != nil {
 		println(err.Error())
 		return
@@ -72,6 +174,8 @@ func main() {
 			registry.RegisterInputFactory(plugin)
 		case ik.OutputFactory:
 			registry.RegisterOutputFactory(plugin)
+		case ik.FilterFactory:
+			registry.RegisterFilterFactory(plugin)
 		}
 	}
 
@@ -82,7 +186,16 @@ func main() {
 	registry.RegisterScoreboardFactory(&HTMLHTTPScoreboardFactory{})
 
 	router := ik.NewFluentRouter()
-	engine := ik.NewEngine(logger, opener, registry, scorekeeper, router)
+	workerPool, err := newWorkerPool(logger, scorekeeper, config)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	var defaultPort ik.Port = router
+	if workerPool != nil {
+		defaultPort = workerPool.Port(router)
+	}
+	engine := ik.NewEngine(logger, opener, registry, scorekeeper, defaultPort)
 	defer func() {
 		err := engine.Dispose()
 		if err != nil {
@@ -90,7 +203,32 @@ func main() {
 		}
 	}()
 
-	err = ik.NewFluentConfigurer(logger, registry, registry, router).Configure(engine, config)
+	healthCheck, drainWait, err := startHealthCheck(logger, engine, config)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	configurer := ik.NewFluentConfigurer(logger, registry, registry, registry, router)
+	strictConfig, err := isStrictConfig(config)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	configurer.SetStrictConfig(strictConfig)
+	deadLetterRouter := ik.NewFluentRouter()
+	engine.SetDeadLetterPort(deadLetterRouter)
+	configurer.SetDeadLetterRouter(deadLetterRouter)
+	engine.SetOrphanedRecordCount(configurer.OrphanedRecordCount)
+	if workerPool != nil {
+		err = engine.Launch(workerPool)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		configurer.SetWorkerPool(workerPool)
+	}
+	err = configurer.Configure(engine, config)
 	if err != nil {
 		println(err.Error())
 		return
@@ -100,6 +238,58 @@ func main() {
 		println(err.Error())
 		return
 	}
+	engine.SetState(ik.EngineRunning)
+
+	// stop accepting new connections on SIGUSR1, while finishing the ones
+	// being handled.
+	drains := make(chan os.Signal, 1)
+	signal.Notify(drains, syscall.SIGUSR1)
+	go func() {
+		for _ = range drains {
+			logger.Info("Draining")
+			engine.Drain()
+		}
+	}()
+
+	// with the health check, keep failing the probes for a while on
+	// SIGTERM or SIGINT so that the load balancers stop routing to this
+	// instance, then stop the engine so that the outputs flush what they
+	// hold before it exits.
+	if healthCheck {
+		terminations := make(chan os.Signal, 1)
+		signal.Notify(terminations, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			<-terminations
+			engine.Drain()
+			engine.SetState(ik.EngineShuttingDown)
+			logger.Info("Shutting down in %s", drainWait.String())
+			time.Sleep(drainWait)
+			err := engine.Stop()
+			if err != nil {
+				logger.Error("%s", err.Error())
+			}
+		}()
+	}
+
+	// reload the configuration on SIGHUP.  the scoreboards are not
+	// affected by reloading.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for _ = range signals {
+			logger.Info("Reloading %s", config_file)
+			config, err := parseConfig(opener, file)
+			if err != nil {
+				logger.Error("%s", err.Error())
+				continue
+			}
+			err = configurer.Configure(engine, config)
+			if err != nil {
+				logger.Error("%s", err.Error())
+			}
+		}
+	}()
+
 	engine.Start()
 }
 
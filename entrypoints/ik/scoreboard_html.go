@@ -131,7 +131,7 @@ h1 {
 {{range $plugin, $pluginInstanceStatuses := .PluginInstanceStatusesPerPlugin}}
 <h3>{{renderPluginName $plugin}}</h3>
 {{range $_, $pluginInstanceStatus := $pluginInstanceStatuses}}
-<h4>Instance #{{$pluginInstanceStatus.Id}}</h4>
+<h4>Instance #{{$pluginInstanceStatus.Id}}{{with $pluginInstanceStatus.Name}} ({{.}}){{end}}</h4>
 {{with .SpawneeStatus}}
 <table class="table">
   <tbody>
@@ -199,6 +199,7 @@ type pluginInstanceStatusTopic struct {
 
 type pluginInstanceStatus struct {
 	Id             int
+	Name           string
 	PluginInstance ik.PluginInstance
 	SpawneeStatus  *ik.SpawneeStatus
 	Topics         []pluginInstanceStatusTopic
@@ -347,6 +348,7 @@ func (scoreboard *HTMLHTTPScoreboard) ServeHTTP(resp http.ResponseWriter, req *h
 		}
 		pluginInstanceStatus_ := pluginInstanceStatus{
 			Id:             i + 1,
+			Name:           ik.PluginInstanceId(pluginInstance),
 			PluginInstance: pluginInstance,
 			Topics:         topics,
 		}
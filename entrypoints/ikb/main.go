@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	termutil "github.com/andrew-d/go-termutil"
@@ -10,28 +15,103 @@ import (
 	"github.com/moriyoshi/ik/markup"
 	"github.com/op/go-logging"
 	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
 	"math"
+	"math/rand"
 	"net"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 type Record struct {
-	Timestamp uint64
+	Timestamp interface{}
 	Data      map[string]interface{}
 }
 
+const (
+	TimeFormatInteger   = "integer"
+	TimeFormatFloat     = "float"
+	TimeFormatEventTime = "eventtime"
+)
+
+// returns the timestamp of a record in the requested encoding.  the
+// nanosecond part of an EventTime is randomized so that the decoder
+// doesn't see the same value over and over.
+func makeTimestamp(timeFormat string, now time.Time, rand_ *rand.Rand) (interface{}, error) {
+	switch timeFormat {
+	case TimeFormatInteger:
+		return uint64(now.Unix()), nil
+	case TimeFormatFloat:
+		return float64(now.UnixNano()) / 1e9, nil
+	case TimeFormatEventTime:
+		return ik.EventTime{Seconds: uint32(now.Unix()), Nanoseconds: uint32(rand_.Int31n(1e9))}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown time format: %s", timeFormat))
+}
+
+const (
+	TimestampNow       = "now"
+	TimestampFixed     = "fixed"
+	TimestampIncrement = "increment"
+)
+
+// gives the time of each record: the current time, a fixed time, or the
+// time the run started advanced by a second for every record.
+type TimestampSource struct {
+	Mode  string
+	Fixed time.Time
+	start time.Time
+	seq   int64
+}
+
+func (source *TimestampSource) Next(now time.Time) time.Time {
+	switch source.Mode {
+	case TimestampFixed:
+		return source.Fixed
+	case TimestampIncrement:
+		return source.start.Add(time.Duration(atomic.AddInt64(&source.seq, 1)-1) * time.Second)
+	}
+	return now
+}
+
+// parses the value of -timestamp, which is "now", "fixed=<unix time>" or
+// "increment".
+func NewTimestampSource(s string, start time.Time) (*TimestampSource, error) {
+	switch {
+	case s == TimestampNow || s == TimestampIncrement:
+		return &TimestampSource{Mode: s, start: start}, nil
+	case strings.HasPrefix(s, TimestampFixed+"="):
+		seconds, err := strconv.ParseInt(s[len(TimestampFixed)+1:], 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid fixed timestamp: %s", s))
+		}
+		return &TimestampSource{Mode: TimestampFixed, Fixed: time.Unix(seconds, 0), start: start}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown timestamp source: %s", s))
+}
+
+const ackResponseTimeout = 60 * time.Second
+
 type IkBench struct {
 	codec codec.MsgpackHandle
 }
 
 type IkBenchReportData struct {
 	NumberOfRecordsSent    int64
+	NumberOfBytesSent      int64
 	LongestSubmissionTime  time.Duration
 	ShortestSubmissionTime time.Duration
+	P50SubmissionTime      time.Duration
+	P95SubmissionTime      time.Duration
+	P99SubmissionTime      time.Duration
+	SubmissionTimes        []time.Duration // sorted
 	Now                    time.Time
 	Start                  time.Time
 }
@@ -43,148 +123,636 @@ type IkBenchReporter interface {
 
 type IkBenchParams struct {
 	Host                      string
+	Unix                      string
+	TLSConfig                 *tls.Config
 	Simple                    bool
 	NumberOfRecordsToSubmit   int
 	NumberOfRecordsSentAtOnce int
 	Concurrency               int
+	Pipeline                  int
+	Warmup                    time.Duration
+	Rampup                    time.Duration
 	Tag                       string
+	TimeFormat                string
+	Timestamp                 *TimestampSource
 	Data                      map[string]interface{}
+	RandomFields              int
+	PayloadSize               int
+	FieldTemplate             map[string]string
+	Seed                      int64
 	MaxRetryCount             int
 	ReportingFrequency        int
 	Reporter                  IkBenchReporter
+	CSV                       io.Writer
+	// relays the newline-delimited JSON objects read from it instead of
+	// generating the records, if not nil
+	Input io.Reader
+	// sends the records to a forward input started in the process instead
+	// of the host, and checks what it emits
+	Verify bool
+}
+
+var fieldTemplatePlaceholderRegExp = regexp.MustCompile(`\$\{(seq|rand)\}`)
+
+const randomStringLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// generates the data of every record so that each of them differs.
+// every goroutine has its own generator, seeded from IkBenchParams.Seed.
+type IkBenchPayloadGenerator struct {
+	params *IkBenchParams
+	codec  *codec.MsgpackHandle
+	rand   *rand.Rand
+	seq    *int64
+}
+
+func (generator *IkBenchPayloadGenerator) randomString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = randomStringLetters[generator.rand.Intn(len(randomStringLetters))]
+	}
+	return string(b)
+}
+
+// every record gets its own copy of the data, even if it is not altered.
+func (generator *IkBenchPayloadGenerator) Generate() (map[string]interface{}, error) {
+	params := generator.params
+	retval := make(map[string]interface{}, len(params.Data)+params.RandomFields+len(params.FieldTemplate)+1)
+	for k, v := range params.Data {
+		retval[k] = v
+	}
+	if len(params.FieldTemplate) > 0 {
+		seq := atomic.AddInt64(generator.seq, 1)
+		for k, v := range params.FieldTemplate {
+			retval[k] = fieldTemplatePlaceholderRegExp.ReplaceAllStringFunc(v, func(placeholder string) string {
+				if placeholder == "${seq}" {
+					return strconv.FormatInt(seq, 10)
+				}
+				return strconv.FormatInt(generator.rand.Int63(), 10)
+			})
+		}
+	}
+	for i := 0; i < params.RandomFields; i += 1 {
+		retval[generator.randomString(8)] = generator.randomString(16)
+	}
+	if params.PayloadSize > 0 {
+		buf := bytes.Buffer{}
+		err := codec.NewEncoder(&buf, generator.codec).Encode(retval)
+		if err != nil {
+			return nil, err
+		}
+		// the key and the header of the padding take roughly 11 bytes
+		n := params.PayloadSize - buf.Len() - 11
+		if n > 0 {
+			retval["padding"] = strings.Repeat("x", n)
+		}
+	}
+	return retval, nil
+}
+
+func NewIkBenchPayloadGenerator(ikb *IkBench, params *IkBenchParams, seed int64, seq *int64) *IkBenchPayloadGenerator {
+	return &IkBenchPayloadGenerator{
+		params: params,
+		codec:  &ikb.codec,
+		rand:   rand.New(rand.NewSource(seed)),
+		seq:    seq,
+	}
 }
 
-func (ikb *IkBench) encodeEntrySingle(buf *bytes.Buffer, tag string, record Record) error {
+func (ikb *IkBench) encodeEntrySingle(buf *bytes.Buffer, tag string, record Record, option map[string]interface{}) error {
 	enc := codec.NewEncoder(buf, &ikb.codec)
-	return enc.Encode([]interface{}{tag, record.Timestamp, record.Data})
+	v := []interface{}{tag, record.Timestamp, record.Data}
+	if option != nil {
+		v = append(v, option)
+	}
+	return enc.Encode(v)
 }
 
-func (ikb *IkBench) encodeEntryBulk(buf *bytes.Buffer, tag string, records []Record) error {
+func (ikb *IkBench) encodeEntryBulk(buf *bytes.Buffer, tag string, records []Record, option map[string]interface{}) error {
 	enc := codec.NewEncoder(buf, &ikb.codec)
-	return enc.Encode([]interface{}{tag, records})
+	v := []interface{}{tag, records}
+	if option != nil {
+		v = append(v, option)
+	}
+	return enc.Encode(v)
 }
 
-func (ikb *IkBench) Submit(conn net.Conn, params *IkBenchParams) error {
-	time_ := time.Now().Unix()
+// sends a batch of records.  when chunk is not empty, it is attached as
+// the chunk option so that the server acknowledges the batch.
+func (ikb *IkBench) Submit(conn net.Conn, params *IkBenchParams, generator *IkBenchPayloadGenerator, chunk string) (int64, error) {
+	records, err := makeRecords(params, generator)
+	if err != nil {
+		return 0, err
+	}
+	buf := bytes.Buffer{}
+	err = ikb.encodeBatch(&buf, params, records, chunk)
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(conn)
+}
+
+// generates a batch of records.
+func makeRecords(params *IkBenchParams, generator *IkBenchPayloadGenerator) ([]Record, error) {
 	records := make([]Record, params.NumberOfRecordsSentAtOnce)
 	for i := 0; i < params.NumberOfRecordsSentAtOnce; i += 1 {
-		records[i] = Record{Timestamp: uint64(time_), Data: params.Data}
+		timestamp, err := makeTimestamp(params.TimeFormat, params.Timestamp.Next(time.Now()), generator.rand)
+		if err != nil {
+			return nil, err
+		}
+		data, err := generator.Generate()
+		if err != nil {
+			return nil, err
+		}
+		records[i] = Record{Timestamp: timestamp, Data: data}
+	}
+	return records, nil
+}
+
+// encodes a batch of records in the selected mode.  when chunk is not
+// empty, the batch asks for the ack.
+func (ikb *IkBench) encodeBatch(buf *bytes.Buffer, params *IkBenchParams, records []Record, chunk string) error {
+	var option map[string]interface{}
+	if chunk != "" {
+		option = map[string]interface{}{"chunk": chunk}
 	}
-	buf := bytes.Buffer{}
 	if params.Simple {
-		for _, record := range records {
-			err := ikb.encodeEntrySingle(&buf, params.Tag, record)
+		for i, record := range records {
+			// only the last entry asks for the ack
+			var option_ map[string]interface{}
+			if i == len(records)-1 {
+				option_ = option
+			}
+			err := ikb.encodeEntrySingle(buf, params.Tag, record, option_)
 			if err != nil {
 				return err
 			}
 		}
-	} else {
-		err := ikb.encodeEntryBulk(&buf, params.Tag, records)
+		return nil
+	}
+	return ikb.encodeEntryBulk(buf, params.Tag, records, option)
+}
+
+// connects to the server over the selected transport.  tls.Dial
+// completes the handshake before returning.
+func (ikb *IkBench) dial(params *IkBenchParams) (net.Conn, error) {
+	if params.Unix != "" {
+		return net.Dial("unix", params.Unix)
+	}
+	if params.TLSConfig != nil {
+		conn, err := tls.Dial("tcp", params.Host, params.TLSConfig)
 		if err != nil {
-			return err
+			// don't let a nil *tls.Conn through as a non-nil net.Conn
+			return nil, err
 		}
+		return conn, nil
 	}
-	_, err := buf.WriteTo(conn)
+	return net.Dial("tcp", params.Host)
+}
+
+func (ikb *IkBench) waitForAck(conn net.Conn, dec *codec.Decoder, chunk string) error {
+	err := conn.SetReadDeadline(time.Now().Add(ackResponseTimeout))
+	if err != nil {
+		return err
+	}
+	response := map[string]interface{}{}
+	err = dec.Decode(&response)
+	if err != nil {
+		return err
+	}
+	ack := ""
+	switch v := response["ack"].(type) {
+	case []byte:
+		ack = string(v)
+	case string:
+		ack = v
+	}
+	if ack != chunk {
+		return errors.New(fmt.Sprintf("unexpected ack response: %v", response))
+	}
+	return nil
+}
+
+type ikBenchBatch struct {
+	seq             int
+	warmup          bool
+	chunk           string
+	submissionStart time.Time
+	submissionTime  time.Duration
+	bytes           int64
+}
+
+type ikBenchResult struct {
+	id                  int
+	numberOfRecordsSent int64
+	numberOfBytesSent   int64
+	batches             []ikBenchBatch
+	err                 error
+}
+
+type ikBenchResults []ikBenchResult
+
+func (results ikBenchResults) Len() int {
+	return len(results)
+}
+
+func (results ikBenchResults) Less(i, j int) bool {
+	return results[i].id < results[j].id
+}
+
+func (results ikBenchResults) Swap(i, j int) {
+	results[i], results[j] = results[j], results[i]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int {
+	return len(d)
+}
+
+func (d durations) Less(i, j int) bool {
+	return d[i] < d[j]
+}
+
+func (d durations) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
+}
+
+// returns the nearest-rank percentile of the sorted durations.
+func (d durations) percentile(p float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(d)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return d[i]
+}
+
+// fills the statistics of the submission times, which get sorted.
+func (data *IkBenchReportData) setSubmissionTimes(submissionTimes durations) {
+	sort.Sort(submissionTimes)
+	data.SubmissionTimes = submissionTimes
+	if len(submissionTimes) > 0 {
+		data.ShortestSubmissionTime = submissionTimes[0]
+		data.LongestSubmissionTime = submissionTimes[len(submissionTimes)-1]
+	}
+	data.P50SubmissionTime = submissionTimes.percentile(50)
+	data.P95SubmissionTime = submissionTimes.percentile(95)
+	data.P99SubmissionTime = submissionTimes.percentile(99)
+}
+
+// combines the errors the goroutines gave up with into one.
+func aggregateErrors(results ikBenchResults) error {
+	messages := make([]string, 0)
+	var retval error
+	for _, result := range results {
+		if result.err != nil {
+			messages = append(messages, fmt.Sprintf("goroutine %d: %s", result.id, result.err.Error()))
+			retval = result.err
+		}
+	}
+	if len(messages) > 1 {
+		retval = errors.New(fmt.Sprintf("%d of %d goroutines failed: %s", len(messages), len(results), strings.Join(messages, "; ")))
+	}
+	return retval
+}
+
+// writes a row for every batch delivered.  this is done after the run
+// so that it doesn't slow down the sending.
+func writeCSV(writer io.Writer, results ikBenchResults) error {
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{"goroutine", "seq", "send_start_unixnano", "latency_ns", "bytes"})
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		for _, batch := range result.batches {
+			err = csvWriter.Write([]string{
+				strconv.Itoa(result.id),
+				strconv.Itoa(batch.seq),
+				strconv.FormatInt(batch.submissionStart.UnixNano(), 10),
+				strconv.FormatInt(int64(batch.submissionTime), 10),
+				strconv.FormatInt(batch.bytes, 10),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// reads up to NumberOfRecordsSentAtOnce records from the input.  the lines
+// that are not JSON objects are skipped.  the returned bool tells whether
+// the input has ended.
+func readRelayRecords(logger ik.Logger, params *IkBenchParams, reader *bufio.Reader, rand_ *rand.Rand) ([]Record, bool, error) {
+	records := make([]Record, 0, params.NumberOfRecordsSentAtOnce)
+	for len(records) < params.NumberOfRecordsSentAtOnce {
+		line, err := reader.ReadBytes('\n')
+		eof := err == io.EOF
+		if err != nil && !eof {
+			return nil, false, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			data := make(map[string]interface{})
+			err = json.Unmarshal(line, &data)
+			if err != nil {
+				logger.Warning("Skipped a line: %s", err.Error())
+			} else {
+				timestamp, err := makeTimestamp(params.TimeFormat, params.Timestamp.Next(time.Now()), rand_)
+				if err != nil {
+					return nil, false, err
+				}
+				records = append(records, Record{Timestamp: timestamp, Data: data})
+			}
+		}
+		if eof {
+			return records, true, nil
+		}
+	}
+	return records, false, nil
+}
+
+// forwards the records read from params.Input over a connection until the
+// input ends.  a batch is sent again over a new connection if it fails,
+// or is not acknowledged when the pipeline is enabled.
+func (ikb *IkBench) Relay(logger ik.Logger, params *IkBenchParams) error {
+	reader := bufio.NewReader(params.Input)
+	rand_ := rand.New(rand.NewSource(params.Seed))
+	retry := ik.NewRetryManager(100*time.Millisecond, 10*time.Second, 2, params.MaxRetryCount, rand.NewSource(time.Now().UnixNano()))
+	final := IkBenchReportData{Start: time.Now()}
+	result := ikBenchResult{batches: make([]ikBenchBatch, 0)}
+	submissionTimes := make(durations, 0)
+	var conn net.Conn
+	var dec *codec.Decoder
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	var err error
+	for eof := false; !eof; {
+		var records []Record
+		records, eof, err = readRelayRecords(logger, params, reader, rand_)
+		if err != nil {
+			break
+		}
+		if len(records) == 0 {
+			continue
+		}
+		batch := ikBenchBatch{seq: len(result.batches) + 1}
+		if params.Pipeline > 0 {
+			batch.chunk = fmt.Sprintf("relay-%d", batch.seq)
+		}
+		buf := bytes.Buffer{}
+		err = ikb.encodeBatch(&buf, params, records, batch.chunk)
+		if err != nil {
+			break
+		}
+		batch.bytes = int64(buf.Len())
+		batch.submissionStart = time.Now()
+		for {
+			if conn == nil {
+				conn, err = ikb.dial(params)
+				if err == nil {
+					dec = codec.NewDecoder(conn, &ikb.codec)
+				}
+			}
+			if err == nil {
+				_, err = conn.Write(buf.Bytes())
+			}
+			if err == nil && batch.chunk != "" {
+				err = ikb.waitForAck(conn, dec, batch.chunk)
+			}
+			if err == nil {
+				retry.Reset()
+				break
+			}
+			logger.Warning(err.Error())
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			wait, giveUp := retry.NextWait()
+			if giveUp {
+				err = errors.New(fmt.Sprintf("retry count exceeded: %s", err.Error()))
+				break
+			}
+			time.Sleep(wait)
+		}
+		if err != nil {
+			break
+		}
+		now := time.Now()
+		batch.submissionTime = now.Sub(batch.submissionStart)
+		result.batches = append(result.batches, batch)
+		submissionTimes = append(submissionTimes, batch.submissionTime)
+		previous := final.NumberOfRecordsSent
+		final.NumberOfRecordsSent += int64(len(records))
+		final.NumberOfBytesSent += batch.bytes
+		if previous/int64(params.ReportingFrequency) != final.NumberOfRecordsSent/int64(params.ReportingFrequency) {
+			params.Reporter.ReportRecordsSent(IkBenchReportData{
+				NumberOfRecordsSent: final.NumberOfRecordsSent,
+				NumberOfBytesSent:   final.NumberOfBytesSent,
+				Now:                 now,
+				Start:               final.Start,
+			})
+		}
+	}
+	final.Now = time.Now()
+	if params.CSV != nil {
+		err_ := writeCSV(params.CSV, ikBenchResults{result})
+		if err_ != nil && err == nil {
+			err = err_
+		}
+	}
+	final.setSubmissionTimes(submissionTimes)
+	params.Reporter.ReportFinal(final)
 	return err
 }
 
-func (ikb *IkBench) Run(logger ik.Logger, params *IkBenchParams) {
+func (ikb *IkBench) Run(logger ik.Logger, params *IkBenchParams) error {
+	if params.Input != nil {
+		return ikb.Relay(logger, params)
+	}
+	if params.Verify {
+		return ikb.Verify(logger, params)
+	}
 	numberOfRecordsSentAtOnce := params.NumberOfRecordsSentAtOnce
 	numberOfAttempts := params.NumberOfRecordsToSubmit / numberOfRecordsSentAtOnce
 	numberOfAttemptsPerProc := numberOfAttempts / params.Concurrency
 	remainder := numberOfAttempts % params.Concurrency
 	reportingFrequency := params.ReportingFrequency
 	numberOfRecordsSent := int64(0)
-	sync := make(chan int)
+	numberOfBytesSent := int64(0)
+	seq := int64(0)
+	results := make(chan ikBenchResult)
 	start := time.Now()
-	shortestSubmissionTime := time.Duration(-1)
-	longestSubmissionTime := time.Duration(-1)
-	var submissionStart time.Time
+	// the batches sent during the warm-up are left out of the statistics
+	warmupEnd := start.Add(params.Warmup)
 	for i := 0; i < params.Concurrency; i += 1 {
 		r := 0
 		if i < remainder {
 			r = 1
 		}
 		go func(id int, attempts int) {
-			retryCount := params.MaxRetryCount
+			// the goroutines are started one after another over the ramp-up
+			time.Sleep(params.Rampup * time.Duration(id) / time.Duration(params.Concurrency))
+			retry := ik.NewRetryManager(100*time.Millisecond, 10*time.Second, 2, params.MaxRetryCount, rand.NewSource(time.Now().UnixNano()+int64(id)))
+			generator := NewIkBenchPayloadGenerator(ikb, params, params.Seed+int64(id), &seq)
+			result := ikBenchResult{id: id, batches: make([]ikBenchBatch, 0, attempts)}
 			var conn net.Conn
+			var dec *codec.Decoder
 			var err error
+			// the batches sent but not acknowledged yet, the oldest first
+			inflight := make([]ikBenchBatch, 0, params.Pipeline)
+			sent := 0
+			batchSeq := 0
 			defer func() {
 				if conn != nil {
 					conn.Close()
 				}
 			}()
+			complete := func(batch ikBenchBatch) {
+				if batch.warmup {
+					return
+				}
+				now := time.Now()
+				batch.submissionTime = now.Sub(batch.submissionStart)
+				result.batches = append(result.batches, batch)
+				result.numberOfRecordsSent += int64(numberOfRecordsSentAtOnce)
+				if atomic.AddInt64(&numberOfRecordsSent, int64(numberOfRecordsSentAtOnce))%int64(reportingFrequency) == 0 {
+					params.Reporter.ReportRecordsSent(IkBenchReportData{
+						NumberOfRecordsSent: atomic.LoadInt64(&numberOfRecordsSent),
+						NumberOfBytesSent:   atomic.LoadInt64(&numberOfBytesSent),
+						Now:                 now,
+						Start:               warmupEnd,
+					})
+				}
+			}
+			// drops the connection after a failure.  the batches that have
+			// not been acknowledged are sent again over the new one.
+			reconnect := func(err error) bool {
+				logger.Warning(err.Error())
+				closeErr := conn.Close()
+				if closeErr != nil {
+					logger.Warning(closeErr.Error())
+				}
+				conn = nil
+				for _, batch := range inflight {
+					if !batch.warmup {
+						sent -= 1
+					}
+				}
+				inflight = inflight[0:0]
+				wait, giveUp := retry.NextWait()
+				if giveUp {
+					result.err = errors.New(fmt.Sprintf("retry count exceeded: %s", err.Error()))
+					return false
+				}
+				time.Sleep(wait)
+				return true
+			}
 		outer:
-			for i := 0; i < attempts; i += 1 {
-				for {
-					if conn == nil {
-						for {
-							conn, err = net.Dial("tcp", params.Host)
-							if err != nil {
-								logger.Error(err.Error())
-								retryCount -= 1
-								if retryCount < 0 {
-									logger.Critical("retry count exceeded") // FIXME
-									break outer
-								}
-								continue
+			for sent < attempts || len(inflight) > 0 {
+				if conn == nil {
+					for {
+						conn, err = ikb.dial(params)
+						if err != nil {
+							logger.Error(err.Error())
+							wait, giveUp := retry.NextWait()
+							if giveUp {
+								result.err = errors.New(fmt.Sprintf("retry count exceeded: %s", err.Error()))
+								break outer
 							}
-							break
+							time.Sleep(wait)
+							continue
 						}
+						break
 					}
-					if submissionStart.IsZero() {
-						submissionStart = time.Now()
+					dec = codec.NewDecoder(conn, &ikb.codec)
+				}
+				if sent < attempts && (params.Pipeline == 0 || len(inflight) < params.Pipeline) {
+					batchSeq += 1
+					batch := ikBenchBatch{seq: batchSeq}
+					if params.Pipeline > 0 {
+						batch.chunk = fmt.Sprintf("%d-%d", id, batchSeq)
+					}
+					batch.submissionStart = time.Now()
+					batch.warmup = batch.submissionStart.Before(warmupEnd)
+					n, err := ikb.Submit(conn, params, generator, batch.chunk)
+					batch.bytes = n
+					if !batch.warmup {
+						result.numberOfBytesSent += n
+						atomic.AddInt64(&numberOfBytesSent, n)
 					}
-					err = ikb.Submit(conn, params)
 					if err != nil {
 						err_, ok := err.(net.Error)
-						if ok {
-							if err_.Temporary() {
-								continue
-							}
-							closeErr := conn.Close()
-							if closeErr != nil {
-								logger.Warning(closeErr.Error())
-							}
-							conn = nil
+						if !ok {
+							result.err = err
+							break outer
 						}
-						logger.Error(err.Error()) // FIXME
-						break outer
+						if err_.Temporary() {
+							continue
+						}
+						if !reconnect(err) {
+							break outer
+						}
+						continue
 					}
-					now := time.Now()
-					submissionTime := now.Sub(submissionStart)
-					submissionStart = now
-					if shortestSubmissionTime < 0 || shortestSubmissionTime > submissionTime {
-						shortestSubmissionTime = submissionTime
+					if !batch.warmup {
+						sent += 1
 					}
-					if longestSubmissionTime < submissionTime {
-						longestSubmissionTime = submissionTime
+					if params.Pipeline == 0 {
+						retry.Reset()
+						complete(batch)
+					} else {
+						inflight = append(inflight, batch)
 					}
-					if atomic.AddInt64(&numberOfRecordsSent, int64(numberOfRecordsSentAtOnce))%int64(reportingFrequency) == 0 {
-						params.Reporter.ReportRecordsSent(IkBenchReportData{
-							NumberOfRecordsSent:    numberOfRecordsSent,
-							ShortestSubmissionTime: shortestSubmissionTime,
-							LongestSubmissionTime:  longestSubmissionTime,
-							Now:   now,
-							Start: start,
-						})
+					continue
+				}
+				// the pipeline is full, or everything has been sent
+				err = ikb.waitForAck(conn, dec, inflight[0].chunk)
+				if err != nil {
+					if !reconnect(err) {
+						break outer
 					}
-					break
+					continue
 				}
+				retry.Reset()
+				complete(inflight[0])
+				inflight = inflight[1:]
 			}
-			sync <- id
+			results <- result
 		}(i, numberOfAttemptsPerProc+r)
 	}
+	final := IkBenchReportData{Start: warmupEnd}
+	results_ := make(ikBenchResults, 0, params.Concurrency)
+	submissionTimes := make(durations, 0, numberOfAttempts)
 	for i := 0; i < params.Concurrency; i += 1 {
-		<-sync
+		result := <-results
+		final.NumberOfRecordsSent += result.numberOfRecordsSent
+		final.NumberOfBytesSent += result.numberOfBytesSent
+		for _, batch := range result.batches {
+			submissionTimes = append(submissionTimes, batch.submissionTime)
+		}
+		results_ = append(results_, result)
+	}
+	final.Now = time.Now()
+	sort.Sort(results_)
+	err := aggregateErrors(results_)
+	if params.CSV != nil {
+		err_ := writeCSV(params.CSV, results_)
+		if err_ != nil && err == nil {
+			err = err_
+		}
 	}
-	params.Reporter.ReportFinal(IkBenchReportData{
-		NumberOfRecordsSent:    numberOfRecordsSent,
-		ShortestSubmissionTime: shortestSubmissionTime,
-		LongestSubmissionTime:  longestSubmissionTime,
-		Now:   time.Now(),
-		Start: start,
-	})
+	final.setSubmissionTimes(submissionTimes)
+	params.Reporter.ReportFinal(final)
+	return err
 }
 
 func NewIkBench() *IkBench {
@@ -192,11 +760,26 @@ func NewIkBench() *IkBench {
 	codec_.MapType = reflect.TypeOf(map[string]interface{}(nil))
 	codec_.RawToString = false
 	codec_.StructToArray = true
+	codec_.AddExt(
+		reflect.TypeOf(ik.EventTime{}),
+		ik.EventTimeExtType,
+		func(rv reflect.Value) ([]byte, error) {
+			return rv.Interface().(ik.EventTime).Bytes(), nil
+		},
+		func(rv reflect.Value, b []byte) error {
+			eventTime, err := ik.DecodeEventTime(b)
+			if err != nil {
+				return err
+			}
+			rv.Set(reflect.ValueOf(eventTime))
+			return nil
+		},
+	)
 	return &IkBench{codec: codec_}
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: %s [-concurrent N] [-multi N] [-no-packed] [-host HOST] [-data JSON] tag count\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s [-concurrent N] [-multi N] [-pipeline N] [-warmup DURATION] [-rampup DURATION] [-no-packed] [-host HOST] [-tls [-ca PATH] [-insecure]] [-unix PATH] [-data JSON] [-random-fields N] [-payload-size BYTES] [-field-template JSON] [-seed N] [-time-format FORMAT] [-timestamp SOURCE] [-csv PATH] [-histogram] [-quiet] [-verify] tag count\n       %s -stdin [-multi N] [-pipeline N] [-no-packed] [-host HOST] [-tls [-ca PATH] [-insecure]] [-unix PATH] [-time-format FORMAT] [-timestamp SOURCE] [-csv PATH] [-histogram] [-quiet] tag\n", os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 	os.Exit(255)
 }
@@ -211,10 +794,68 @@ func exitWithError(err error, exitStatus int) {
 }
 
 type defaultReporter struct {
-	renderer markup.MarkupRenderer
+	renderer  markup.MarkupRenderer
+	quiet     bool
+	histogram bool
+}
+
+const histogramWidth = 50
+
+// renders the submission times in buckets whose bounds are powers of two
+// microseconds.
+func renderHistogram(submissionTimes []time.Duration) []ik.MarkupChunk {
+	if len(submissionTimes) == 0 {
+		return []ik.MarkupChunk{}
+	}
+	bucketOf := func(d time.Duration) int {
+		us := int64(d / time.Microsecond)
+		retval := 0
+		for us > 1 {
+			us >>= 1
+			retval += 1
+		}
+		return retval
+	}
+	first := bucketOf(submissionTimes[0])
+	counts := make([]int, bucketOf(submissionTimes[len(submissionTimes)-1])-first+1)
+	maxCount := 0
+	for _, d := range submissionTimes {
+		i := bucketOf(d) - first
+		counts[i] += 1
+		if maxCount < counts[i] {
+			maxCount = counts[i]
+		}
+	}
+	retval := []ik.MarkupChunk{
+		ik.MarkupChunk{
+			Attrs: ik.Embolden | ik.Yellow,
+			Text:  "Submission Time Histogram:\n",
+		},
+	}
+	for i, count := range counts {
+		lower := time.Duration(int64(1)<<uint(first+i)) * time.Microsecond
+		if first+i == 0 {
+			lower = 0
+		}
+		upper := time.Duration(int64(1)<<uint(first+i+1)) * time.Microsecond
+		retval = append(
+			retval,
+			ik.MarkupChunk{
+				Attrs: ik.Embolden,
+				Text:  fmt.Sprintf("    %10s - %-10s ", lower, upper),
+			},
+			ik.MarkupChunk{
+				Text: fmt.Sprintf("%-*s %d\n", histogramWidth, strings.Repeat("#", count*histogramWidth/maxCount), count),
+			},
+		)
+	}
+	return retval
 }
 
 func (reporter *defaultReporter) ReportRecordsSent(data IkBenchReportData) {
+	if reporter.quiet {
+		return
+	}
 	elapsed := float64(data.Now.Sub(data.Start)) / 1e9
 	reporter.renderer.Render(&ik.Markup{[]ik.MarkupChunk{
 		ik.MarkupChunk{
@@ -251,6 +892,22 @@ func (reporter *defaultReporter) ReportFinal(data IkBenchReportData) {
 			Attrs: ik.Embolden,
 			Text:  fmt.Sprintf("%.3f\n", float64(data.NumberOfRecordsSent)/elapsed),
 		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden | ik.Yellow,
+			Text:  "Number of Bytes Submitted: ",
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  fmt.Sprintf("%d\n", data.NumberOfBytesSent),
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden | ik.Yellow,
+			Text:  "Bytes per Second: ",
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  fmt.Sprintf("%.3f\n", float64(data.NumberOfBytesSent)/elapsed),
+		},
 		ik.MarkupChunk{
 			Attrs: ik.Embolden | ik.Yellow,
 			Text:  "Average Submission Time: ",
@@ -275,63 +932,217 @@ func (reporter *defaultReporter) ReportFinal(data IkBenchReportData) {
 			Attrs: ik.Embolden,
 			Text:  fmt.Sprintf("%.10f seconds\n", float64(data.LongestSubmissionTime)/1e9),
 		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  "    50th Percentile: ",
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  fmt.Sprintf("%.10f seconds\n", float64(data.P50SubmissionTime)/1e9),
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  "    95th Percentile: ",
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  fmt.Sprintf("%.10f seconds\n", float64(data.P95SubmissionTime)/1e9),
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  "    99th Percentile: ",
+		},
+		ik.MarkupChunk{
+			Attrs: ik.Embolden,
+			Text:  fmt.Sprintf("%.10f seconds\n", float64(data.P99SubmissionTime)/1e9),
+		},
 	}})
+	if reporter.histogram {
+		reporter.renderer.Render(&ik.Markup{renderHistogram(data.SubmissionTimes)})
+	}
 }
 
 func main() {
 	var host string
+	var unixPath string
+	var useTLS bool
+	var caPath string
+	var insecure bool
 	var simple bool
+	var quiet bool
 	var numberOfRecordsToSubmit int
 	var numberOfRecordsSentAtOnce int
 	var concurrency int
+	var pipeline int
 	var tag string
 	var jsonString string
+	var timeFormat string
+	var randomFields int
+	var payloadSize int
+	var fieldTemplateString string
+	var seed int64
+	var csvPath string
+	var warmup time.Duration
+	var rampup time.Duration
+	var histogram bool
+	var stdin bool
+	var timestampString string
+	var verify bool
 	flag.IntVar(&concurrency, "concurrent", 1, "number of goroutines")
 	flag.IntVar(&numberOfRecordsSentAtOnce, "multi", 1, "send multiple records at once")
+	flag.IntVar(&pipeline, "pipeline", 0, "number of batches sent ahead of the acks (0 to not request acks)")
 	flag.BoolVar(&simple, "no-packed", false, "don't use lazy deserialization optimize")
 	flag.StringVar(&host, "host", "localhost:24224", "fluent host")
+	flag.StringVar(&unixPath, "unix", "", "connect to the Unix domain socket at the given path instead of the host")
+	flag.BoolVar(&useTLS, "tls", false, "connect to the host over TLS")
+	flag.StringVar(&caPath, "ca", "", "CA certificates (in PEM) to verify the server with")
+	flag.BoolVar(&insecure, "insecure", false, "don't verify the server certificate")
 	flag.StringVar(&jsonString, "data", `{ "message": "test" }`, "data to send (in JSON)")
+	flag.IntVar(&randomFields, "random-fields", 0, "number of random key/value pairs added to each record")
+	flag.IntVar(&payloadSize, "payload-size", 0, "pad each record to approximately the given number of bytes")
+	flag.StringVar(&fieldTemplateString, "field-template", "", "fields added to each record (in JSON), where ${seq} and ${rand} in the values are expanded")
+	flag.Int64Var(&seed, "seed", 0, "seed of the random payloads (default: the current time)")
+	flag.StringVar(&timeFormat, "time-format", TimeFormatInteger, "encoding of the timestamps (integer, float or eventtime)")
+	flag.StringVar(&timestampString, "timestamp", TimestampNow, "time of the records (now, fixed=<unix time> or increment, which advances a second for every record from the start)")
+	flag.DurationVar(&warmup, "warmup", 0, "keep sending for the given duration before collecting the statistics")
+	flag.DurationVar(&rampup, "rampup", 0, "start the goroutines one after another over the given duration")
+	flag.StringVar(&csvPath, "csv", "", "write the submission time of every batch to the given file in CSV")
+	flag.BoolVar(&histogram, "histogram", false, "print the histogram of the submission times")
+	flag.BoolVar(&quiet, "quiet", false, "only print the final summary")
+	flag.BoolVar(&stdin, "stdin", false, "forward the newline-delimited JSON objects read from the standard input until its end, instead of generating the records")
+	flag.BoolVar(&verify, "verify", false, "send the records to a forward input started in the process, and check that they are received as sent")
 	flag.Parse()
 	args := flag.Args()
-	if len(args) < 2 {
+	if len(args) < 1 || (!stdin && len(args) < 2) {
 		usage()
 	}
 	tag = args[0]
-	numberOfRecordsToSubmit, err := strconv.Atoi(args[1])
-	if err != nil {
-		exitWithError(err, 255)
+	var err error
+	if verify && (stdin || concurrency != 1 || warmup != 0 || rampup != 0) {
+		exitWithMessage("'verify' cannot be specified with 'stdin', 'concurrent', 'warmup' or 'rampup'", 255)
+	}
+	if stdin {
+		if concurrency != 1 || warmup != 0 || rampup != 0 {
+			exitWithMessage("'stdin' cannot be specified with 'concurrent', 'warmup' or 'rampup'", 255)
+		}
+		if numberOfRecordsSentAtOnce < 1 {
+			exitWithMessage("the value of 'multi' must be positive", 255)
+		}
+		// not used other than by the checks below
+		numberOfRecordsToSubmit = numberOfRecordsSentAtOnce
+	} else {
+		numberOfRecordsToSubmit, err = strconv.Atoi(args[1])
+		if err != nil {
+			exitWithError(err, 255)
+		}
 	}
 	data := make(map[string]interface{})
 	err = json.Unmarshal([]byte(jsonString), &data)
 	if err != nil {
 		exitWithError(err, 255)
 	}
+	fieldTemplate := make(map[string]string)
+	if fieldTemplateString != "" {
+		err = json.Unmarshal([]byte(fieldTemplateString), &fieldTemplate)
+		if err != nil {
+			exitWithError(err, 255)
+		}
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
 	if numberOfRecordsToSubmit%numberOfRecordsSentAtOnce != 0 {
 		exitWithMessage("the value of 'count' must be a multiple of 'multi'", 255)
 	}
 	if numberOfRecordsToSubmit/numberOfRecordsSentAtOnce < concurrency {
 		exitWithMessage("the value of 'concurrency' must be equal to or greater than the division of 'count' by 'multi'", 255)
 	}
+	if timeFormat != TimeFormatInteger && timeFormat != TimeFormatFloat && timeFormat != TimeFormatEventTime {
+		exitWithMessage("the value of 'time-format' must be one of 'integer', 'float' and 'eventtime'", 255)
+	}
+	timestampSource, err := NewTimestampSource(timestampString, time.Now())
+	if err != nil {
+		exitWithError(err, 255)
+	}
+	if randomFields < 0 || payloadSize < 0 {
+		exitWithMessage("the values of 'random-fields' and 'payload-size' must not be negative", 255)
+	}
+	if useTLS && unixPath != "" {
+		exitWithMessage("'tls' and 'unix' cannot be specified at the same time", 255)
+	}
+	var tlsConfig *tls.Config
+	if useTLS {
+		tlsConfig = &tls.Config{InsecureSkipVerify: insecure}
+		if caPath != "" {
+			pem, err := ioutil.ReadFile(caPath)
+			if err != nil {
+				exitWithError(err, 255)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				exitWithMessage(fmt.Sprintf("no valid certificates found in %s", caPath), 255)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if warmup < 0 || rampup < 0 {
+		exitWithMessage("the values of 'warmup' and 'rampup' must not be negative", 255)
+	}
+	if pipeline < 0 {
+		exitWithMessage("the value of 'pipeline' must not be negative", 255)
+	}
 	var renderer markup.MarkupRenderer
 	if termutil.Isatty(os.Stdout.Fd()) {
 		renderer = &markup.TerminalEscapeRenderer{os.Stdout}
 	} else {
 		renderer = &markup.PlainRenderer{os.Stdout}
 	}
+	var csvWriter io.Writer
+	if csvPath != "" {
+		csvFile, err := os.Create(csvPath)
+		if err != nil {
+			exitWithError(err, 255)
+		}
+		defer csvFile.Close()
+		csvWriter = csvFile
+	}
+	var input io.Reader
+	reportingFrequency := int(math.Max(math.Pow(10, math.Ceil(math.Log10(float64(numberOfRecordsToSubmit)))-1), 100))
+	if stdin {
+		input = os.Stdin
+		reportingFrequency = 1000
+	}
 	ikb := NewIkBench()
-	ikb.Run(
+	err = ikb.Run(
 		logging.MustGetLogger("ikb"),
 		&IkBenchParams{
 			Host:                      host,
+			Unix:                      unixPath,
+			TLSConfig:                 tlsConfig,
 			Simple:                    simple,
 			NumberOfRecordsToSubmit:   numberOfRecordsToSubmit,
 			NumberOfRecordsSentAtOnce: numberOfRecordsSentAtOnce,
 			Concurrency:               concurrency,
+			Pipeline:                  pipeline,
+			Warmup:                    warmup,
+			Rampup:                    rampup,
 			Tag:                       tag,
+			TimeFormat:                timeFormat,
+			Timestamp:                 timestampSource,
 			Data:                      data,
+			RandomFields:              randomFields,
+			PayloadSize:               payloadSize,
+			FieldTemplate:             fieldTemplate,
+			Seed:                      seed,
 			MaxRetryCount:             5,
-			ReportingFrequency:        int(math.Max(math.Pow(10, math.Ceil(math.Log10(float64(numberOfRecordsToSubmit)))-1), 100)),
-			Reporter:                  &defaultReporter{renderer: renderer},
+			ReportingFrequency:        reportingFrequency,
+			Reporter:                  &defaultReporter{renderer: renderer, quiet: quiet, histogram: histogram},
+			CSV:                       csvWriter,
+			Input:                     input,
+			Verify:                    verify,
 		},
 	)
+	if err != nil {
+		exitWithError(err, 1)
+	}
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/plugins"
+	"github.com/ugorji/go/codec"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long to wait for the loopback input to emit all the records
+const verifyTimeout = 30 * time.Second
+
+// the number of the mismatches described in the error
+const maxReportedMismatches = 5
+
+// collects the record sets emitted by the loopback input.
+type verifyingPort struct {
+	mtx        sync.Mutex
+	recordSets []ik.FluentRecordSet
+	count      int
+}
+
+func (port *verifyingPort) Emit(recordSets []ik.FluentRecordSet) error {
+	port.mtx.Lock()
+	defer port.mtx.Unlock()
+	for _, recordSet := range recordSets {
+		port.recordSets = append(port.recordSets, recordSet)
+		port.count += len(recordSet.Records)
+	}
+	return nil
+}
+
+// waits until n records have been emitted or the timeout expires, and
+// returns the record sets emitted so far.
+func (port *verifyingPort) wait(n int, timeout time.Duration) []ik.FluentRecordSet {
+	deadline := time.Now().Add(timeout)
+	for {
+		port.mtx.Lock()
+		count := port.count
+		port.mtx.Unlock()
+		if count >= n || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	port.mtx.Lock()
+	defer port.mtx.Unlock()
+	return append([]ik.FluentRecordSet(nil), port.recordSets...)
+}
+
+// returns the seconds and the nanoseconds the input is expected to decode
+// the timestamp into.
+func expectedTimestamp(timestamp interface{}) (uint64, uint32) {
+	switch v := timestamp.(type) {
+	case uint64:
+		return v, 0
+	case float64:
+		seconds := math.Floor(v)
+		return uint64(seconds), uint32((v - seconds) * 1e9)
+	case ik.EventTime:
+		return v.Timestamp(), v.Nanoseconds
+	}
+	return 0, 0
+}
+
+// tells how the record differs from the one sent, or returns an empty
+// string if it doesn't.  the data are compared in JSON, so that the types
+// the decoder picks for the numbers don't matter, while the byte strings
+// left undecoded do.
+func compareRecord(tag string, sent Record, receivedTag string, received ik.TinyFluentRecord) (string, error) {
+	if receivedTag != tag {
+		return fmt.Sprintf("tag %q != %q", receivedTag, tag), nil
+	}
+	seconds, nanoseconds := expectedTimestamp(sent.Timestamp)
+	if received.Timestamp != seconds || received.Nanoseconds != nanoseconds {
+		return fmt.Sprintf("timestamp %d.%09d != %d.%09d", received.Timestamp, received.Nanoseconds, seconds, nanoseconds), nil
+	}
+	expected, err := json.Marshal(sent.Data)
+	if err != nil {
+		return "", err
+	}
+	actual, err := json.Marshal(received.Data)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(expected, actual) {
+		return fmt.Sprintf("data %s != %s", string(actual), string(expected)), nil
+	}
+	return "", nil
+}
+
+// checks that the records are emitted in the order they were sent, as
+// they were sent.
+func verifyRecords(tag string, sent []Record, received []ik.FluentRecordSet) error {
+	mismatches := make([]string, 0)
+	numberOfMismatches := 0
+	i := 0
+	for _, recordSet := range received {
+		for _, record := range recordSet.Records {
+			if i >= len(sent) {
+				break
+			}
+			mismatch, err := compareRecord(tag, sent[i], recordSet.Tag, record)
+			if err != nil {
+				return err
+			}
+			if mismatch != "" {
+				numberOfMismatches += 1
+				if len(mismatches) < maxReportedMismatches {
+					mismatches = append(mismatches, fmt.Sprintf("record %d: %s", i, mismatch))
+				}
+			}
+			i += 1
+		}
+	}
+	if numberOfMismatches > 0 {
+		return errors.New(fmt.Sprintf("%d of %d records differ: %s", numberOfMismatches, len(sent), strings.Join(mismatches, "; ")))
+	}
+	numberOfReceived := 0
+	for _, recordSet := range received {
+		numberOfReceived += len(recordSet.Records)
+	}
+	if numberOfReceived != len(sent) {
+		return errors.New(fmt.Sprintf("%d records were sent while %d were received", len(sent), numberOfReceived))
+	}
+	return nil
+}
+
+// sends the records to a forward input started in the process, and checks
+// that it emits them as they were sent.  the records are sent over a
+// connection in batches, each waiting for the ack when the pipeline is
+// enabled.
+func (ikb *IkBench) Verify(logger ik.Logger, params *IkBenchParams) error {
+	port := &verifyingPort{}
+	engine := ik.NewEngine(logger, nil, nil, nil, port)
+	defer engine.Dispose()
+	input, err := (&plugins.ForwardInputFactory{}).New(engine, &ik.ConfigElement{
+		Name:  "source",
+		Attrs: map[string]string{"listen": "127.0.0.1", "port": "0"},
+	})
+	if err != nil {
+		return err
+	}
+	err = input.(ik.Startable).Start()
+	if err != nil {
+		return err
+	}
+	err = engine.Launch(input)
+	if err != nil {
+		return err
+	}
+	loopback := *params
+	loopback.Host = input.(ik.Listening).Addrs()[0].String()
+	loopback.Unix = ""
+	loopback.TLSConfig = nil
+	conn, err := ikb.dial(&loopback)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dec := codec.NewDecoder(conn, &ikb.codec)
+	seq := int64(0)
+	generator := NewIkBenchPayloadGenerator(ikb, params, params.Seed, &seq)
+	final := IkBenchReportData{Start: time.Now()}
+	submissionTimes := make(durations, 0)
+	sent := make([]Record, 0, params.NumberOfRecordsToSubmit)
+	for i := 0; i < params.NumberOfRecordsToSubmit/params.NumberOfRecordsSentAtOnce; i += 1 {
+		records, err := makeRecords(params, generator)
+		if err != nil {
+			return err
+		}
+		chunk := ""
+		if params.Pipeline > 0 {
+			chunk = fmt.Sprintf("verify-%d", i+1)
+		}
+		buf := bytes.Buffer{}
+		err = ikb.encodeBatch(&buf, params, records, chunk)
+		if err != nil {
+			return err
+		}
+		submissionStart := time.Now()
+		n, err := buf.WriteTo(conn)
+		if err != nil {
+			return err
+		}
+		if chunk != "" {
+			err = ikb.waitForAck(conn, dec, chunk)
+			if err != nil {
+				return err
+			}
+		}
+		submissionTimes = append(submissionTimes, time.Now().Sub(submissionStart))
+		final.NumberOfRecordsSent += int64(len(records))
+		final.NumberOfBytesSent += n
+		sent = append(sent, records...)
+	}
+	received := port.wait(len(sent), verifyTimeout)
+	final.Now = time.Now()
+	final.setSubmissionTimes(submissionTimes)
+	params.Reporter.ReportFinal(final)
+	return verifyRecords(params.Tag, sent, received)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (logger *testLogger) Critical(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testLogger) Error(format string, args ...interface{})   { logger.t.Logf(format, args...) }
+func (logger *testLogger) Warning(format string, args ...interface{}) { logger.t.Logf(format, args...) }
+func (logger *testLogger) Notice(format string, args ...interface{})  { logger.t.Logf(format, args...) }
+func (logger *testLogger) Info(format string, args ...interface{})    { logger.t.Logf(format, args...) }
+func (logger *testLogger) Debug(format string, args ...interface{})   { logger.t.Logf(format, args...) }
+
+type nullReporter struct{}
+
+func (reporter *nullReporter) ReportRecordsSent(data IkBenchReportData) {}
+func (reporter *nullReporter) ReportFinal(data IkBenchReportData)       {}
+
+func newTestVerifyParams(t *testing.T) *IkBenchParams {
+	timestampSource, err := NewTimestampSource(TimestampIncrement, time.Unix(1409286145, 0))
+	if err != nil {
+		t.FailNow()
+	}
+	return &IkBenchParams{
+		NumberOfRecordsToSubmit:   20,
+		NumberOfRecordsSentAtOnce: 5,
+		Concurrency:               1,
+		Tag:                       "verify",
+		TimeFormat:                TimeFormatInteger,
+		Timestamp:                 timestampSource,
+		Data:                      map[string]interface{}{"message": "test", "nested": map[string]interface{}{"n": 1.5, "a": []interface{}{"x", true}}},
+		RandomFields:              2,
+		FieldTemplate:             map[string]string{"seq": "${seq}"},
+		Seed:                      1,
+		ReportingFrequency:        100,
+		Reporter:                  &nullReporter{},
+	}
+}
+
+func TestIkBench_Verify(t *testing.T) {
+	for _, timeFormat := range []string{TimeFormatInteger, TimeFormatFloat, TimeFormatEventTime} {
+		for _, simple := range []bool{false, true} {
+			for _, pipeline := range []int{0, 1} {
+				params := newTestVerifyParams(t)
+				params.TimeFormat = timeFormat
+				params.Simple = simple
+				params.Pipeline = pipeline
+				err := NewIkBench().Verify(&testLogger{t}, params)
+				if err != nil {
+					t.Logf("%s %v %d: %s", timeFormat, simple, pipeline, err.Error())
+					t.Fail()
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyRecords_Mismatch(t *testing.T) {
+	sent := []Record{
+		{Timestamp: uint64(1), Data: map[string]interface{}{"a": "b"}},
+		{Timestamp: uint64(2), Data: map[string]interface{}{"a": "c"}},
+	}
+	received := []ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{
+		{Timestamp: 1, Data: map[string]interface{}{"a": "b"}},
+		{Timestamp: 2, Data: map[string]interface{}{"a": []byte("c")}},
+	}}}
+	if verifyRecords("tag", sent, received) == nil {
+		t.Fail()
+	}
+	received[0].Records[1].Data["a"] = "c"
+	if verifyRecords("tag", sent, received) != nil {
+		t.Fail()
+	}
+	if verifyRecords("other", sent, received) == nil {
+		t.Fail()
+	}
+	// missing records
+	if verifyRecords("tag", append(sent, sent[0]), received) == nil {
+		t.Fail()
+	}
+}
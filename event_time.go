@@ -0,0 +1,48 @@
+package ik
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// the extension type code assigned to EventTime by the forward protocol
+const EventTimeExtType = 0
+
+type EventTime struct {
+	Seconds     uint32
+	Nanoseconds uint32
+}
+
+func (eventTime EventTime) Timestamp() uint64 {
+	return uint64(eventTime.Seconds)
+}
+
+func (eventTime EventTime) Time() time.Time {
+	return time.Unix(int64(eventTime.Seconds), int64(eventTime.Nanoseconds))
+}
+
+func (eventTime EventTime) Bytes() []byte {
+	retval := make([]byte, 8)
+	binary.BigEndian.PutUint32(retval[0:4], eventTime.Seconds)
+	binary.BigEndian.PutUint32(retval[4:8], eventTime.Nanoseconds)
+	return retval
+}
+
+func DecodeEventTime(b []byte) (EventTime, error) {
+	if len(b) != 8 {
+		return EventTime{}, errors.New(fmt.Sprintf("EventTime must be 8 bytes long (got %d bytes)", len(b)))
+	}
+	return EventTime{
+		Seconds:     binary.BigEndian.Uint32(b[0:4]),
+		Nanoseconds: binary.BigEndian.Uint32(b[4:8]),
+	}, nil
+}
+
+func NewEventTime(t time.Time) EventTime {
+	return EventTime{
+		Seconds:     uint32(t.Unix()),
+		Nanoseconds: uint32(t.Nanosecond()),
+	}
+}
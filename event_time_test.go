@@ -0,0 +1,34 @@
+package ik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventTime_RoundTrip(t *testing.T) {
+	eventTime := NewEventTime(time.Unix(1409286145, 123456789))
+	b := eventTime.Bytes()
+	if len(b) != 8 {
+		t.FailNow()
+	}
+	decoded, err := DecodeEventTime(b)
+	if err != nil {
+		t.FailNow()
+	}
+	if decoded.Seconds != 1409286145 {
+		t.Fail()
+	}
+	if decoded.Nanoseconds != 123456789 {
+		t.Fail()
+	}
+	if decoded.Timestamp() != 1409286145 {
+		t.Fail()
+	}
+}
+
+func TestDecodeEventTime_InvalidLength(t *testing.T) {
+	_, err := DecodeEventTime([]byte{0, 0, 0, 0})
+	if err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,301 @@
+package ik
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OverflowActionDrop  = 0
+	OverflowActionBlock = 1
+)
+
+const fileBufferChunkSuffix = ".chunk"
+
+// appended to the name of the chunk that can't be decoded, which is then
+// left aside for the inspection.
+const fileBufferCorruptSuffix = ".corrupt"
+
+var ErrBufferOverflow = errors.New("buffer overflow")
+
+type fileBufferChunk struct {
+	path string
+	size int64
+}
+
+// FileBuffer persists the records into the chunk files under the buffer
+// directory so that they survive restarts.  A chunk file is removed only
+// after the flush callback succeeds, so the delivery is at-least-once.
+type FileBuffer struct {
+	bufferPath     string
+	chunkLimitSize int64
+	totalLimitSize int64
+	overflowAction int
+	flush          func([]FluentRecord) error
+	codec          *codec.MsgpackHandle
+	head           *os.File
+	headSize       int64
+	queue          []fileBufferChunk
+	totalSize      int64
+	seq            int64
+	mtx            sync.Mutex
+	cond           *sync.Cond
+	flushMtx       sync.Mutex
+	ticker         *time.Ticker
+	cancel         chan bool
+	closed         bool
+}
+
+func (buffer *FileBuffer) nextChunkPath() string {
+	buffer.seq += 1
+	return path.Join(buffer.bufferPath, fmt.Sprintf("%016x.%08x%s", time.Now().UnixNano(), buffer.seq, fileBufferChunkSuffix))
+}
+
+// moves the chunk being written to the queue.  the lock must be held by
+// the caller.
+func (buffer *FileBuffer) enqueueHead() error {
+	if buffer.head == nil {
+		return nil
+	}
+	err := buffer.head.Close()
+	buffer.queue = append(buffer.queue, fileBufferChunk{buffer.head.Name(), buffer.headSize})
+	buffer.head = nil
+	buffer.headSize = 0
+	return err
+}
+
+func (buffer *FileBuffer) Append(record FluentRecord) error {
+	data := []byte{}
+	err := codec.NewEncoderBytes(&data, buffer.codec).Encode([]interface{}{record.Tag, record.Timestamp, record.Data, uint64(record.Nanoseconds)})
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	buffer.mtx.Lock()
+	defer buffer.mtx.Unlock()
+	for buffer.totalLimitSize > 0 && buffer.totalSize+size > buffer.totalLimitSize {
+		if buffer.overflowAction != OverflowActionBlock || buffer.closed {
+			return ErrBufferOverflow
+		}
+		buffer.cond.Wait()
+	}
+	if buffer.head != nil && buffer.chunkLimitSize > 0 && buffer.headSize+size > buffer.chunkLimitSize {
+		err = buffer.enqueueHead()
+		if err != nil {
+			return err
+		}
+	}
+	if buffer.head == nil {
+		buffer.head, err = os.OpenFile(buffer.nextChunkPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = buffer.head.Write(data)
+	if err != nil {
+		return err
+	}
+	buffer.headSize += size
+	buffer.totalSize += size
+	return nil
+}
+
+func (buffer *FileBuffer) readChunk(chunk fileBufferChunk) ([]FluentRecord, error) {
+	f, err := os.Open(chunk.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := codec.NewDecoder(bufio.NewReader(f), buffer.codec)
+	retval := []FluentRecord{}
+	for {
+		v := []interface{}{}
+		err := dec.Decode(&v)
+		// the record being written when the process crashed is lost, but
+		// the ones before it are kept.
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		// the nanoseconds are missing in the chunks written by older versions
+		if len(v) != 3 && len(v) != 4 {
+			return nil, errors.New(fmt.Sprintf("malformed record in %s", chunk.path))
+		}
+		tag, ok := v[0].(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("malformed tag in %s", chunk.path))
+		}
+		timestamp, ok := v[1].(uint64)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("malformed timestamp in %s", chunk.path))
+		}
+		data, ok := v[2].(map[string]interface{})
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("malformed data in %s", chunk.path))
+		}
+		var nanoseconds uint64
+		if len(v) == 4 {
+			nanoseconds, ok = v[3].(uint64)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("malformed timestamp in %s", chunk.path))
+			}
+		}
+		retval = append(retval, FluentRecord{tag, timestamp, data, uint32(nanoseconds)})
+	}
+	return retval, nil
+}
+
+// removes the first chunk in the queue.
+func (buffer *FileBuffer) dequeue(chunk fileBufferChunk) {
+	buffer.mtx.Lock()
+	defer buffer.mtx.Unlock()
+	buffer.queue = buffer.queue[1:]
+	buffer.totalSize -= chunk.size
+	buffer.cond.Broadcast()
+}
+
+// Hands the queued chunks to the flush callback in order.  The chunk that
+// failed to be flushed and the ones after it are kept for the next attempt.
+// The chunk that can't be decoded is renamed with the ".corrupt" suffix so
+// that it doesn't hold back the others, and the error is returned after
+// the rest are flushed.
+func (buffer *FileBuffer) Flush() error {
+	buffer.flushMtx.Lock()
+	defer buffer.flushMtx.Unlock()
+	buffer.mtx.Lock()
+	err := buffer.enqueueHead()
+	queue := buffer.queue
+	buffer.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	var corruptErr error
+	for _, chunk := range queue {
+		records, err := buffer.readChunk(chunk)
+		if err != nil {
+			err_ := os.Rename(chunk.path, chunk.path+fileBufferCorruptSuffix)
+			if err_ != nil {
+				return err_
+			}
+			buffer.dequeue(chunk)
+			if corruptErr == nil {
+				corruptErr = errors.New(fmt.Sprintf("Set aside the chunk that could not be decoded: %s", err.Error()))
+			}
+			continue
+		}
+		if len(records) > 0 {
+			err = buffer.flush(records)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.Remove(chunk.path)
+		if err != nil {
+			return err
+		}
+		buffer.dequeue(chunk)
+	}
+	return corruptErr
+}
+
+func (buffer *FileBuffer) Close() error {
+	buffer.mtx.Lock()
+	closed := buffer.closed
+	buffer.closed = true
+	buffer.cond.Broadcast()
+	buffer.mtx.Unlock()
+	if closed {
+		return nil
+	}
+	if buffer.ticker != nil {
+		buffer.ticker.Stop()
+		close(buffer.cancel)
+	}
+	return buffer.Flush()
+}
+
+func (buffer *FileBuffer) run() {
+	for {
+		select {
+		case <-buffer.cancel:
+			return
+		case <-buffer.ticker.C:
+			buffer.Flush()
+		}
+	}
+}
+
+// collects the chunk files left by the previous run.
+func (buffer *FileBuffer) recover() error {
+	entries, err := ioutil.ReadDir(buffer.bufferPath)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	sizes := map[string]int64{}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.HasSuffix(entry.Name(), fileBufferChunkSuffix) {
+			names = append(names, entry.Name())
+			sizes[entry.Name()] = entry.Size()
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buffer.queue = append(buffer.queue, fileBufferChunk{path.Join(buffer.bufferPath, name), sizes[name]})
+		buffer.totalSize += sizes[name]
+	}
+	return nil
+}
+
+func NewFileBuffer(bufferPath string, chunkLimitSize int64, totalLimitSize int64, overflowAction int, flushInterval time.Duration, flush func([]FluentRecord) error) (*FileBuffer, error) {
+	err := os.MkdirAll(bufferPath, 0700)
+	if err != nil {
+		return nil, err
+	}
+	_codec := &codec.MsgpackHandle{}
+	_codec.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	_codec.RawToString = true
+	retval := &FileBuffer{
+		bufferPath:     bufferPath,
+		chunkLimitSize: chunkLimitSize,
+		totalLimitSize: totalLimitSize,
+		overflowAction: overflowAction,
+		flush:          flush,
+		codec:          _codec,
+		queue:          []fileBufferChunk{},
+		cancel:         make(chan bool),
+	}
+	retval.cond = sync.NewCond(&retval.mtx)
+	err = retval.recover()
+	if err != nil {
+		return nil, err
+	}
+	if flushInterval > 0 {
+		retval.ticker = time.NewTicker(flushInterval)
+		go retval.run()
+	}
+	return retval, nil
+}
+
+func ParseOverflowAction(s string) (int, error) {
+	switch s {
+	case "drop":
+		return OverflowActionDrop, nil
+	case "block":
+		return OverflowActionBlock, nil
+	default:
+		return -1, errors.New("unknown overflow_action: " + s)
+	}
+}
@@ -0,0 +1,148 @@
+package ik
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFileBuffer_RecoverChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_buffer")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	failing := func(records []FluentRecord) error {
+		return errors.New("unavailable")
+	}
+	buffer, err := NewFileBuffer(dir, 32, 0, OverflowActionDrop, 0, failing)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	for i := 0; i < 3; i += 1 {
+		err = buffer.Append(FluentRecord{Tag: "tag", Timestamp: uint64(1409286145 + i), Data: map[string]interface{}{"k": "v"}, Nanoseconds: uint32(i * 1000)})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	if buffer.Close() == nil {
+		t.Fail()
+	}
+	flushed := []FluentRecord{}
+	buffer, err = NewFileBuffer(dir, 32, 0, OverflowActionDrop, 0, func(records []FluentRecord) error {
+		flushed = append(flushed, records...)
+		return nil
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	if len(buffer.queue) < 2 {
+		t.Fail()
+	}
+	err = buffer.Close()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(flushed) != 3 {
+		t.FailNow()
+	}
+	for i, record := range flushed {
+		if record.Tag != "tag" || record.Timestamp != uint64(1409286145+i) || record.Nanoseconds != uint32(i*1000) || record.Data["k"] != "v" {
+			t.Fail()
+		}
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fail()
+	}
+}
+
+func TestFileBuffer_RecoverTruncatedChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_buffer")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	buffer, err := NewFileBuffer(dir, 0, 0, OverflowActionDrop, 0, func(records []FluentRecord) error {
+		return errors.New("unavailable")
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	for i := 0; i < 3; i += 1 {
+		err = buffer.Append(FluentRecord{Tag: "tag", Timestamp: uint64(i), Data: map[string]interface{}{"k": "v"}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	buffer.Close()
+	if len(buffer.queue) != 1 {
+		t.FailNow()
+	}
+	// the process crashed while writing the last record
+	truncated := buffer.queue[0]
+	err = os.Truncate(truncated.path, truncated.size-3)
+	if err != nil {
+		t.FailNow()
+	}
+	// and a chunk that is not even msgpack
+	err = ioutil.WriteFile(path.Join(dir, "0"+fileBufferChunkSuffix), []byte{0xc1}, 0600)
+	if err != nil {
+		t.FailNow()
+	}
+	flushed := []FluentRecord{}
+	buffer, err = NewFileBuffer(dir, 0, 0, OverflowActionDrop, 0, func(records []FluentRecord) error {
+		flushed = append(flushed, records...)
+		return nil
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	if buffer.Close() == nil {
+		t.Fail()
+	}
+	if len(flushed) != 2 || flushed[1].Timestamp != 1 || len(buffer.queue) != 0 || buffer.totalSize != 0 {
+		t.Log(flushed)
+		t.Fail()
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), fileBufferCorruptSuffix) {
+		t.Fail()
+	}
+}
+
+func TestFileBuffer_OverflowDrop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_buffer")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	buffer, err := NewFileBuffer(dir, 0, 32, OverflowActionDrop, 0, func(records []FluentRecord) error {
+		return nil
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	defer buffer.Close()
+	err = buffer.Append(FluentRecord{Tag: "tag", Timestamp: 1, Data: map[string]interface{}{"k": "v"}})
+	if err != nil {
+		t.Fail()
+	}
+	err = buffer.Append(FluentRecord{Tag: "tag", Timestamp: 1, Data: map[string]interface{}{"k": "this will not fit in the buffer"}})
+	if err != ErrBufferOverflow {
+		t.Fail()
+	}
+	err = buffer.Flush()
+	if err != nil {
+		t.Fail()
+	}
+	err = buffer.Append(FluentRecord{Tag: "tag", Timestamp: 1, Data: map[string]interface{}{"k": "v"}})
+	if err != nil {
+		t.Fail()
+	}
+}
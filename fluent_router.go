@@ -2,6 +2,7 @@ package ik
 
 import (
 	"regexp"
+	"sync"
 )
 
 type fluentRouterRule struct {
@@ -9,8 +10,16 @@ type fluentRouterRule struct {
 	port Port
 }
 
+type fluentRouterFilterRule struct {
+	re     *regexp.Regexp
+	filter Filter
+}
+
 type FluentRouter struct {
-	rules []*fluentRouterRule
+	rules       []*fluentRouterRule
+	filterRules []*fluentRouterFilterRule
+	defaultPort Port
+	mtx         sync.RWMutex
 }
 
 type PatternError struct {
@@ -117,16 +126,96 @@ func (router *FluentRouter) AddRule(pattern string, port Port) error {
 		return err
 	}
 	newRule := &fluentRouterRule{re, port}
+	router.mtx.Lock()
+	defer router.mtx.Unlock()
 	router.rules = append(router.rules, newRule)
 	return nil
 }
 
+// Sets the port that receives the records matching no rule.  Such records
+// are dropped if it is nil.
+func (router *FluentRouter) SetDefaultPort(port Port) {
+	router.mtx.Lock()
+	defer router.mtx.Unlock()
+	router.defaultPort = port
+}
+
+// Returns the number of the match rules.
+func (router *FluentRouter) RuleCount() int {
+	router.mtx.RLock()
+	defer router.mtx.RUnlock()
+	return len(router.rules)
+}
+
+func (router *FluentRouter) AddFilterRule(pattern string, filter Filter) error {
+	chunk, err := BuildRegexpFromGlobPattern(pattern)
+	if err != nil {
+		return err
+	}
+	re, err := regexp.Compile(chunk)
+	if err != nil {
+		return err
+	}
+	router.mtx.Lock()
+	defer router.mtx.Unlock()
+	router.filterRules = append(router.filterRules, &fluentRouterFilterRule{re, filter})
+	return nil
+}
+
+// takes over the rules of another router while keeping this one as the
+// port the inputs emit to.  used on reloading the configuration.
+func (router *FluentRouter) replaceRules(other *FluentRouter) {
+	other.mtx.RLock()
+	rules, filterRules := other.rules, other.filterRules
+	other.mtx.RUnlock()
+	router.mtx.Lock()
+	defer router.mtx.Unlock()
+	router.rules = rules
+	router.filterRules = filterRules
+}
+
+// applies the filters whose pattern matches the tag in the order of
+// registration.  the record sets that become empty are dropped.
+func applyFilters(filterRules []*fluentRouterFilterRule, recordSets []FluentRecordSet) ([]FluentRecordSet, error) {
+	if len(filterRules) == 0 {
+		return recordSets, nil
+	}
+	retval := make([]FluentRecordSet, 0, len(recordSets))
+	for _, recordSet := range recordSets {
+		for _, rule := range filterRules {
+			if len(recordSet.Records) == 0 {
+				break
+			}
+			if rule.re.MatchString(recordSet.Tag) {
+				var err error
+				recordSet, err = rule.filter.Filter(recordSet)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(recordSet.Records) > 0 {
+			retval = append(retval, recordSet)
+		}
+	}
+	return retval, nil
+}
+
 func (router *FluentRouter) Emit(recordSets []FluentRecordSet) error {
+	router.mtx.RLock()
+	rules, filterRules, defaultPort := router.rules, router.filterRules, router.defaultPort
+	router.mtx.RUnlock()
+	recordSets, err := applyFilters(filterRules, recordSets)
+	if err != nil {
+		return err
+	}
 	recordSetsMap := make(map[Port][]FluentRecordSet)
 	for i := range recordSets {
 		recordSet := &recordSets[i]
-		for _, rule := range router.rules {
+		matched := false
+		for _, rule := range rules {
 			if rule.re.MatchString(recordSet.Tag) {
+				matched = true
 				recordSetsForPort, ok := recordSetsMap[rule.port]
 				if !ok {
 					recordSetsForPort = make([]FluentRecordSet, 0)
@@ -139,16 +228,36 @@ func (router *FluentRouter) Emit(recordSets []FluentRecordSet) error {
 				recordSetsMap[rule.port] = append(recordSetsForPort, *recordSet)
 			}
 		}
+		if !matched && defaultPort != nil {
+			recordSetsMap[defaultPort] = append(recordSetsMap[defaultPort], *recordSet)
+		}
 	}
+	// the ports under backpressure don't keep the others from receiving
+	// the records; the refused ones are collected into a single error.
+	var backpressureErr *BackpressureError
 	for port, recordSets := range recordSetsMap {
 		err := port.Emit(recordSets)
 		if err != nil {
-			return err
+			err_, ok := err.(*BackpressureError)
+			if !ok {
+				return err
+			}
+			if backpressureErr == nil {
+				backpressureErr = &BackpressureError{Pending: make(map[Port][]FluentRecordSet)}
+			}
+			backpressureErr.Merge(err_)
 		}
 	}
+	if backpressureErr != nil {
+		return backpressureErr
+	}
 	return nil
 }
 
 func NewFluentRouter() *FluentRouter {
-	return &FluentRouter{make([]*fluentRouterRule, 0)}
+	return &FluentRouter{
+		rules:       make([]*fluentRouterRule, 0),
+		filterRules: make([]*fluentRouterFilterRule, 0),
+		defaultPort: nil,
+	}
 }
@@ -54,3 +54,123 @@ func TestBuildRegexpFromGlobPattern_4(t *testing.T) {
 		t.Fail()
 	}
 }
+
+type testFilter struct {
+	key string
+}
+
+func (filter *testFilter) Factory() Plugin {
+	return nil
+}
+
+func (filter *testFilter) Filter(recordSet FluentRecordSet) (FluentRecordSet, error) {
+	records := []TinyFluentRecord{}
+	for _, record := range recordSet.Records {
+		if _, ok := record.Data[filter.key]; ok {
+			records = append(records, record)
+		}
+	}
+	return FluentRecordSet{recordSet.Tag, records}, nil
+}
+
+type testPort struct {
+	recordSets []FluentRecordSet
+}
+
+func (port *testPort) Emit(recordSets []FluentRecordSet) error {
+	port.recordSets = append(port.recordSets, recordSets...)
+	return nil
+}
+
+func TestFluentRouter_Filter(t *testing.T) {
+	router := NewFluentRouter()
+	port := &testPort{}
+	router.AddRule("**", port)
+	router.AddFilterRule("a.*", &testFilter{"x"})
+	err := router.Emit([]FluentRecordSet{
+		{"a.b", []TinyFluentRecord{{Timestamp: 1, Data: map[string]interface{}{"x": 1}}, {Timestamp: 2, Data: map[string]interface{}{}}}},
+		{"a.c", []TinyFluentRecord{{Timestamp: 3, Data: map[string]interface{}{}}}},
+		{"b.c", []TinyFluentRecord{{Timestamp: 4, Data: map[string]interface{}{}}}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	if len(port.recordSets) != 2 {
+		t.FailNow()
+	}
+	if port.recordSets[0].Tag != "a.b" || len(port.recordSets[0].Records) != 1 || port.recordSets[0].Records[0].Timestamp != 1 {
+		t.Fail()
+	}
+	if port.recordSets[1].Tag != "b.c" {
+		t.Fail()
+	}
+}
+
+func TestFluentRouter_DefaultPort(t *testing.T) {
+	router := NewFluentRouter()
+	port := &testPort{}
+	router.AddRule("a.**", port)
+	recordSets := []FluentRecordSet{
+		{"a.b.c", []TinyFluentRecord{{Timestamp: 1, Data: map[string]interface{}{}}}},
+		{"b.c", []TinyFluentRecord{{Timestamp: 2, Data: map[string]interface{}{}}, {Timestamp: 3, Data: map[string]interface{}{}}}},
+	}
+	router.Emit(recordSets)
+	if len(port.recordSets) != 1 {
+		t.FailNow()
+	}
+	defaultPort := &testPort{}
+	router.SetDefaultPort(defaultPort)
+	router.Emit(recordSets)
+	if len(port.recordSets) != 2 || len(defaultPort.recordSets) != 1 {
+		t.Fail()
+	}
+	if defaultPort.recordSets[0].Tag != "b.c" {
+		t.Fail()
+	}
+}
+
+// refuses the last record set for the given number of times
+type congestedPort struct {
+	testPort
+	refusals int
+}
+
+func (port *congestedPort) Emit(recordSets []FluentRecordSet) error {
+	if port.refusals > 0 {
+		port.refusals -= 1
+		port.testPort.Emit(recordSets[0 : len(recordSets)-1])
+		return &BackpressureError{Pending: map[Port][]FluentRecordSet{port: recordSets[len(recordSets)-1:]}}
+	}
+	return port.testPort.Emit(recordSets)
+}
+
+func TestFluentRouter_Backpressure(t *testing.T) {
+	router := NewFluentRouter()
+	port := &testPort{}
+	congested := &congestedPort{refusals: 2}
+	router.AddRule("**", port)
+	router.AddRule("a.**", congested)
+	err := router.Emit([]FluentRecordSet{
+		{"a.b", []TinyFluentRecord{{Timestamp: 1, Data: map[string]interface{}{}}}},
+		{"a.c", []TinyFluentRecord{{Timestamp: 2, Data: map[string]interface{}{}}}},
+	})
+	backpressureErr, ok := err.(*BackpressureError)
+	if !ok {
+		t.FailNow()
+	}
+	if len(port.recordSets) != 2 || len(congested.recordSets) != 1 || congested.recordSets[0].Tag != "a.b" {
+		t.FailNow()
+	}
+	err = backpressureErr.Retry()
+	if _, ok := err.(*BackpressureError); !ok {
+		t.FailNow()
+	}
+	err = err.(*BackpressureError).Retry()
+	if err != nil {
+		t.FailNow()
+	}
+	// the port that accepted everything at first doesn't see them again
+	if len(port.recordSets) != 2 || len(congested.recordSets) != 2 || congested.recordSets[1].Tag != "a.c" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,58 @@
+package formatters
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVFormatter writes the values of the fields of a record as a line of
+// CSV, in the order of the comma-separated `fields'.
+type CSVFormatter struct {
+	fields    []string
+	delimiter rune
+}
+
+func (formatter *CSVFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	values := make([]string, len(formatter.fields))
+	for i, field := range formatter.fields {
+		value, ok := record.Data[field]
+		if ok {
+			values[i] = fmt.Sprintf("%v", value)
+		}
+	}
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	writer.Comma = formatter.delimiter
+	writer.Write(values)
+	writer.Flush()
+	err := writer.Error()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var _ = AddFormatter("csv", func(config *ik.ConfigElement) (ik.Formatter, error) {
+	fieldsStr, ok := config.Attrs["fields"]
+	if !ok {
+		return nil, errors.New("Required attribute `fields' not found")
+	}
+	fields := strings.Split(fieldsStr, ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+	}
+	delimiterStr := config.AttrString("delimiter", ",")
+	delimiter, size := utf8.DecodeRuneInString(delimiterStr)
+	if size == 0 || size != len(delimiterStr) {
+		return nil, errors.New(fmt.Sprintf("invalid delimiter: %q", delimiterStr))
+	}
+	return &CSVFormatter{
+		fields:    fields,
+		delimiter: delimiter,
+	}, nil
+})
@@ -0,0 +1,87 @@
+package formatters
+
+import (
+	"github.com/moriyoshi/ik"
+	"github.com/ugorji/go/codec"
+	"testing"
+)
+
+var testRecord = ik.FluentRecord{
+	Tag:       "test.tag",
+	Timestamp: 1409286145,
+	Data:      map[string]interface{}{"message": "hello, world", "level": 3},
+}
+
+func newFormatter(t *testing.T, attrs map[string]string) ik.Formatter {
+	formatter, err := New(&ik.ConfigElement{Name: "match", Attrs: attrs}, "out_file")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	return formatter
+}
+
+func testFormat(t *testing.T, attrs map[string]string, expected string) {
+	b, err := newFormatter(t, attrs).Format(testRecord)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if string(b) != expected {
+		t.Logf("expected %q, got %q", expected, string(b))
+		t.Fail()
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&ik.ConfigElement{Name: "match", Attrs: map[string]string{"format": "unknown"}}, "out_file")
+	if err == nil {
+		t.Fail()
+	}
+	_, err = New(&ik.ConfigElement{Name: "match", Attrs: map[string]string{"format": "csv"}}, "out_file")
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	testFormat(t, map[string]string{"format": "json"}, "{\"level\":3,\"message\":\"hello, world\"}\n")
+	testFormat(t, map[string]string{"format": "ltsv"}, "level:3\tmessage:hello, world\n")
+	testFormat(t, map[string]string{"format": "csv", "fields": "message,missing,level"}, "\"hello, world\",,3\n")
+	testFormat(t, map[string]string{"format": "single_value"}, "hello, world\n")
+	testFormat(t, map[string]string{"format": "single_value", "message_key": "level", "add_newline": "false"}, "3")
+	testFormat(t, map[string]string{"time_format": "%Y"}, "2014\ttest.tag\t{\"level\":3,\"message\":\"hello, world\"}\n")
+	testFormat(t, map[string]string{"output_time": "false", "delimiter": " "}, "test.tag {\"level\":3,\"message\":\"hello, world\"}\n")
+}
+
+func TestNewOutFileFormatter(t *testing.T) {
+	config := &ik.ConfigElement{Name: "match", Attrs: map[string]string{"format": "ltsv", "time_format": "%Y"}}
+	formatter, err := NewOutFileFormatter(config, newFormatter(t, config.Attrs), "\t")
+	if err != nil {
+		t.FailNow()
+	}
+	b, err := formatter.Format(testRecord)
+	if err != nil || string(b) != "2014\ttest.tag\tlevel:3\tmessage:hello, world\n" {
+		t.Logf("%q", string(b))
+		t.Fail()
+	}
+}
+
+func TestFormatters_IncludeKeys(t *testing.T) {
+	testFormat(t, map[string]string{"format": "ltsv", "include_tag_key": "true", "include_time_key": "true", "time_key": "t", "time_format": "%Y"}, "level:3\tmessage:hello, world\tt:2014\ttag:test.tag\n")
+	if len(testRecord.Data) != 2 {
+		t.Fail()
+	}
+}
+
+func TestMsgpackFormatter(t *testing.T) {
+	b, err := newFormatter(t, map[string]string{"format": "msgpack"}).Format(testRecord)
+	if err != nil {
+		t.FailNow()
+	}
+	data := make(map[string]interface{})
+	err = codec.NewDecoderBytes(b, &codec.MsgpackHandle{}).Decode(&data)
+	if err != nil || len(data) != 2 {
+		t.Fail()
+	}
+}
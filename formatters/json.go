@@ -0,0 +1,21 @@
+package formatters
+
+import (
+	"encoding/json"
+	"github.com/moriyoshi/ik"
+)
+
+// JSONFormatter writes the data of a record as a JSON object per line.
+type JSONFormatter struct{}
+
+func (formatter *JSONFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	b, err := json.Marshal(record.Data)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+var _ = AddFormatter("json", func(config *ik.ConfigElement) (ik.Formatter, error) {
+	return &JSONFormatter{}, nil
+})
@@ -0,0 +1,39 @@
+package formatters
+
+import (
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"sort"
+)
+
+// LTSVFormatter writes the data of a record as labeled tab-separated
+// values, sorted by the labels.
+type LTSVFormatter struct {
+	delimiter      string
+	labelDelimiter string
+}
+
+func (formatter *LTSVFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	keys := make([]string, 0, len(record.Data))
+	for key, _ := range record.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	retval := make([]byte, 0, 64)
+	for i, key := range keys {
+		if i > 0 {
+			retval = append(retval, formatter.delimiter...)
+		}
+		retval = append(retval, key...)
+		retval = append(retval, formatter.labelDelimiter...)
+		retval = append(retval, fmt.Sprintf("%v", record.Data[key])...)
+	}
+	return append(retval, '\n'), nil
+}
+
+var _ = AddFormatter("ltsv", func(config *ik.ConfigElement) (ik.Formatter, error) {
+	return &LTSVFormatter{
+		delimiter:      config.AttrString("delimiter", "\t"),
+		labelDelimiter: config.AttrString("label_delimiter", ":"),
+	}, nil
+})
@@ -0,0 +1,24 @@
+package formatters
+
+import (
+	"github.com/moriyoshi/ik"
+	"github.com/ugorji/go/codec"
+)
+
+// MsgpackFormatter writes the data of a record as a MessagePack map.
+type MsgpackFormatter struct {
+	codec *codec.MsgpackHandle
+}
+
+func (formatter *MsgpackFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	retval := make([]byte, 0, 64)
+	err := codec.NewEncoderBytes(&retval, formatter.codec).Encode(record.Data)
+	if err != nil {
+		return nil, err
+	}
+	return retval, nil
+}
+
+var _ = AddFormatter("msgpack", func(config *ik.ConfigElement) (ik.Formatter, error) {
+	return &MsgpackFormatter{codec: &codec.MsgpackHandle{}}, nil
+})
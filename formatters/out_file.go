@@ -0,0 +1,59 @@
+package formatters
+
+import (
+	"github.com/moriyoshi/ik"
+)
+
+// OutFileFormatter writes the time, the tag and the data of a record as
+// the formatter writes it, separated by delimiter, which are what the file
+// and stdout outputs have always written.  output_time and output_tag
+// leave out the time and the tag.
+type OutFileFormatter struct {
+	formatter     ik.Formatter
+	timeFormatter *timeFormatter
+	delimiter     string
+	outputTime    bool
+	outputTag     bool
+}
+
+func (formatter *OutFileFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	b, err := formatter.formatter.Format(record)
+	if err != nil {
+		return nil, err
+	}
+	retval := make([]byte, 0, len(b)+64)
+	if formatter.outputTime {
+		retval = append(retval, formatter.timeFormatter.format(record.Timestamp)...)
+		retval = append(retval, formatter.delimiter...)
+	}
+	if formatter.outputTag {
+		retval = append(retval, record.Tag...)
+		retval = append(retval, formatter.delimiter...)
+	}
+	return append(retval, b...), nil
+}
+
+// NewOutFileFormatter puts the time and the tag in front of what the
+// formatter writes, separated by delimiter, unless output_time or
+// output_tag is false.
+func NewOutFileFormatter(config *ik.ConfigElement, formatter ik.Formatter, delimiter string) (ik.Formatter, error) {
+	outputTime, err := config.AttrBool("output_time", true)
+	if err != nil {
+		return nil, err
+	}
+	outputTag, err := config.AttrBool("output_tag", true)
+	if err != nil {
+		return nil, err
+	}
+	return &OutFileFormatter{
+		formatter:     formatter,
+		timeFormatter: newTimeFormatter(config),
+		delimiter:     delimiter,
+		outputTime:    outputTime,
+		outputTag:     outputTag,
+	}, nil
+}
+
+var _ = AddFormatter("out_file", func(config *ik.ConfigElement) (ik.Formatter, error) {
+	return NewOutFileFormatter(config, &JSONFormatter{}, config.AttrString("delimiter", "\t"))
+})
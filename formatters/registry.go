@@ -0,0 +1,74 @@
+package formatters
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+)
+
+type FormatterFactory func(config *ik.ConfigElement) (ik.Formatter, error)
+
+var _factories map[string]FormatterFactory = make(map[string]FormatterFactory)
+
+func AddFormatter(name string, factory FormatterFactory) bool {
+	_factories[name] = factory
+	return false
+}
+
+// New builds the formatter named by the `format' attribute, or by
+// defaultFormat if it is not given.  With include_time_key or
+// include_tag_key, the time and the tag are put in the records under
+// time_key and tag_key before they are formatted.
+func New(config *ik.ConfigElement, defaultFormat string) (ik.Formatter, error) {
+	format := config.AttrString("format", defaultFormat)
+	factory, ok := _factories[format]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported format: %s", format))
+	}
+	formatter, err := factory(config)
+	if err != nil {
+		return nil, err
+	}
+	includeTimeKey, err := config.AttrBool("include_time_key", false)
+	if err != nil {
+		return nil, err
+	}
+	includeTagKey, err := config.AttrBool("include_tag_key", false)
+	if err != nil {
+		return nil, err
+	}
+	if !includeTimeKey && !includeTagKey {
+		return formatter, nil
+	}
+	retval := &injectingFormatter{formatter: formatter}
+	if includeTimeKey {
+		retval.timeKey = config.AttrString("time_key", "time")
+		retval.timeFormatter = newTimeFormatter(config)
+	}
+	if includeTagKey {
+		retval.tagKey = config.AttrString("tag_key", "tag")
+	}
+	return retval, nil
+}
+
+type injectingFormatter struct {
+	formatter     ik.Formatter
+	timeKey       string
+	timeFormatter *timeFormatter
+	tagKey        string
+}
+
+func (formatter *injectingFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	data := make(map[string]interface{}, len(record.Data)+2)
+	for key, value := range record.Data {
+		data[key] = value
+	}
+	if formatter.timeKey != "" {
+		data[formatter.timeKey] = formatter.timeFormatter.format(record.Timestamp)
+	}
+	if formatter.tagKey != "" {
+		data[formatter.tagKey] = record.Tag
+	}
+	record.Data = data
+	return formatter.formatter.Format(record)
+}
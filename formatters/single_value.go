@@ -0,0 +1,45 @@
+package formatters
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+)
+
+// SingleValueFormatter writes only the value of message_key of a record,
+// followed by a newline unless add_newline is false.
+type SingleValueFormatter struct {
+	messageKey string
+	addNewline bool
+}
+
+func (formatter *SingleValueFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	value, ok := record.Data[formatter.messageKey]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("record has no %s", formatter.messageKey))
+	}
+	var retval []byte
+	switch value := value.(type) {
+	case string:
+		retval = []byte(value)
+	case []byte:
+		retval = append([]byte(nil), value...)
+	default:
+		retval = []byte(fmt.Sprintf("%v", value))
+	}
+	if formatter.addNewline {
+		retval = append(retval, '\n')
+	}
+	return retval, nil
+}
+
+var _ = AddFormatter("single_value", func(config *ik.ConfigElement) (ik.Formatter, error) {
+	addNewline, err := config.AttrBool("add_newline", true)
+	if err != nil {
+		return nil, err
+	}
+	return &SingleValueFormatter{
+		messageKey: config.AttrString("message_key", "message"),
+		addNewline: addNewline,
+	}, nil
+})
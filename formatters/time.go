@@ -0,0 +1,26 @@
+package formatters
+
+import (
+	strftime "github.com/jehiah/go-strftime"
+	"github.com/moriyoshi/ik"
+	"time"
+)
+
+// timeFormatter formats the timestamps according to time_format, or as
+// RFC3339 if it is not given.
+type timeFormatter struct {
+	timeFormat string
+}
+
+func newTimeFormatter(config *ik.ConfigElement) *timeFormatter {
+	return &timeFormatter{timeFormat: config.AttrString("time_format", "")}
+}
+
+func (formatter *timeFormatter) format(timestamp uint64) string {
+	timestamp_ := time.Unix(int64(timestamp), 0)
+	if formatter.timeFormat == "" {
+		return timestamp_.Format(time.RFC3339)
+	} else {
+		return strftime.Format(formatter.timeFormat, timestamp_)
+	}
+}
@@ -0,0 +1,103 @@
+package ik
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+type EngineState int32
+
+const (
+	// the configuration is being applied and the inputs are starting
+	EngineStarting EngineState = iota
+	// all the inputs are started
+	EngineRunning
+	// the inputs no longer accept new connections, but are finishing the
+	// ones being handled
+	EngineDraining
+	// about to exit; the load balancers are to stop routing to it
+	EngineShuttingDown
+)
+
+func (state EngineState) String() string {
+	switch state {
+	case EngineStarting:
+		return "starting"
+	case EngineRunning:
+		return "running"
+	case EngineDraining:
+		return "draining"
+	case EngineShuttingDown:
+		return "shutting down"
+	}
+	return "unknown"
+}
+
+// HealthCheckServer answers the probes of the load balancers over HTTP.
+// GET /healthz (liveness) succeeds unless the engine is shutting down, and
+// GET /readyz (readiness) succeeds only while the engine is running, that
+// is, once all the inputs have bound their addresses and until it starts
+// draining.
+type HealthCheckServer struct {
+	engine   Engine
+	listener net.Listener
+	server   *http.Server
+	closed   int32
+}
+
+func (server *HealthCheckServer) respond(w http.ResponseWriter, ok bool) {
+	w.Header().Set("Content-Type", "text/plain")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(server.engine.State().String() + "\n"))
+}
+
+func (server *HealthCheckServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := server.engine.State()
+	switch r.URL.Path {
+	case "/healthz":
+		server.respond(w, state != EngineShuttingDown)
+	case "/readyz":
+		server.respond(w, state == EngineRunning)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (server *HealthCheckServer) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+func (server *HealthCheckServer) Run() error {
+	err := server.server.Serve(server.listener)
+	if atomic.LoadInt32(&server.closed) != 0 {
+		return nil
+	}
+	return err
+}
+
+func (server *HealthCheckServer) Shutdown() error {
+	atomic.StoreInt32(&server.closed, 1)
+	return server.listener.Close()
+}
+
+// NewHealthCheckServer binds the address, which is to be served by
+// spawning the server on the engine.
+func NewHealthCheckServer(engine Engine, bind string) (*HealthCheckServer, error) {
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		return nil, err
+	}
+	server := &HealthCheckServer{
+		engine:   engine,
+		listener: listener,
+	}
+	server.server = &http.Server{Handler: server}
+	return server, nil
+}
@@ -0,0 +1,59 @@
+package ik
+
+import (
+	"net/http"
+	"testing"
+)
+
+type testHealthEngine struct {
+	Engine
+	state EngineState
+}
+
+func (engine *testHealthEngine) State() EngineState {
+	return engine.state
+}
+
+func TestHealthCheckServer(t *testing.T) {
+	engine := &testHealthEngine{state: EngineStarting}
+	server, err := NewHealthCheckServer(engine, "127.0.0.1:0")
+	if err != nil {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		done <- server.Run()
+	}()
+	status := func(path string) int {
+		resp, err := http.Get("http://" + server.Addr().String() + path)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// alive but not ready until all the inputs are started
+	if status("/healthz") != 200 || status("/readyz") != 503 {
+		t.Fail()
+	}
+	engine.state = EngineRunning
+	if status("/healthz") != 200 || status("/readyz") != 200 {
+		t.Fail()
+	}
+	// taken out of service while draining
+	engine.state = EngineDraining
+	if status("/healthz") != 200 || status("/readyz") != 503 {
+		t.Fail()
+	}
+	engine.state = EngineShuttingDown
+	if status("/healthz") != 503 || status("/readyz") != 503 {
+		t.Fail()
+	}
+	if status("/") != 404 {
+		t.Fail()
+	}
+	if server.Shutdown() != nil || <-done != nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,147 @@
+package ik
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The upper bounds of the buckets of the latencies in seconds, from 100us
+// to 10s.
+var DefaultHistogramBounds = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts the observations into the buckets by their upper
+// bounds.  It is safe to observe from several goroutines.
+type Histogram struct {
+	bounds []float64
+	// the last bucket is for the observations above all the bounds
+	counts []int64
+	// the sum of the observations as the bits of a float64
+	sum uint64
+}
+
+// HistogramSnapshot is the state of a histogram at a time, which is the
+// typed value of the topics of the ScorekeeperHistogram fetchers.
+type HistogramSnapshot struct {
+	Bounds []float64 `json:"bounds"`
+	// the number of the observations in each bucket, which is not
+	// cumulative.  the last one is for those above all the bounds.
+	Counts []int64 `json:"counts"`
+	Count  int64   `json:"count"`
+	Sum    float64 `json:"sum"`
+}
+
+// NewHistogram returns a histogram with the ascending upper bounds of the
+// buckets.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// Observe counts the value.  A nil histogram discards it.
+func (histogram *Histogram) Observe(value float64) {
+	if histogram == nil {
+		return
+	}
+	// a value on a bound falls in the bucket of the bound
+	i := sort.SearchFloat64s(histogram.bounds, value)
+	atomic.AddInt64(&histogram.counts[i], 1)
+	for {
+		old := atomic.LoadUint64(&histogram.sum)
+		if atomic.CompareAndSwapUint64(&histogram.sum, old, math.Float64bits(math.Float64frombits(old)+value)) {
+			break
+		}
+	}
+}
+
+// ObserveDuration observes the duration in seconds.
+func (histogram *Histogram) ObserveDuration(duration time.Duration) {
+	histogram.Observe(duration.Seconds())
+}
+
+// Snapshot returns the counts so far.  The counts are taken one by one
+// while being observed, so Sum may be off by the observations in flight.
+func (histogram *Histogram) Snapshot() HistogramSnapshot {
+	retval := HistogramSnapshot{
+		Bounds: histogram.bounds,
+		Counts: make([]int64, len(histogram.counts)),
+		Sum:    math.Float64frombits(atomic.LoadUint64(&histogram.sum)),
+	}
+	for i := range histogram.counts {
+		retval.Counts[i] = atomic.LoadInt64(&histogram.counts[i])
+		retval.Count += retval.Counts[i]
+	}
+	return retval
+}
+
+// Quantile estimates the q-quantile (e.g. 0.99 for the 99th percentile)
+// by interpolating linearly within the bucket it falls in, assuming the
+// observations are not negative.  The quantiles above all the bounds are
+// estimated as the last bound.  Returns 0 if nothing has been observed.
+func (snapshot HistogramSnapshot) Quantile(q float64) float64 {
+	if snapshot.Count == 0 {
+		return 0
+	}
+	rank := q * float64(snapshot.Count)
+	cumulative := int64(0)
+	for i, count := range snapshot.Counts {
+		if count == 0 || float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+		if i == len(snapshot.Bounds) {
+			break
+		}
+		lower := 0.
+		if i > 0 {
+			lower = snapshot.Bounds[i-1]
+		}
+		upper := snapshot.Bounds[i]
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(count)
+	}
+	if len(snapshot.Bounds) == 0 {
+		return 0
+	}
+	return snapshot.Bounds[len(snapshot.Bounds)-1]
+}
+
+// ScorekeeperHistogram is the fetcher of the topics whose values are the
+// distributions of the durations observed by the plugin instances, e.g.
+// the latencies.  The median, the 90th and the 99th percentiles are shown.
+type ScorekeeperHistogram struct {
+	// returns the histogram of the plugin instance
+	Histogram func(PluginInstance) *Histogram
+}
+
+func (fetcher *ScorekeeperHistogram) Value(pluginInstance PluginInstance) (interface{}, error) {
+	return fetcher.Histogram(pluginInstance).Snapshot(), nil
+}
+
+func (fetcher *ScorekeeperHistogram) PlainText(pluginInstance PluginInstance) (string, error) {
+	snapshot := fetcher.Histogram(pluginInstance).Snapshot()
+	if snapshot.Count == 0 {
+		return "no observations", nil
+	}
+	percentiles := []struct {
+		name string
+		q    float64
+	}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}}
+	texts := make([]string, len(percentiles))
+	for i, percentile := range percentiles {
+		duration := time.Duration(snapshot.Quantile(percentile.q) * float64(time.Second))
+		texts[i] = percentile.name + " " + duration.String()
+	}
+	return strings.Join(texts, ", ") + " of " + FormatScoreValue(snapshot.Count), nil
+}
+
+func (fetcher *ScorekeeperHistogram) Markup(pluginInstance PluginInstance) (Markup, error) {
+	text, err := fetcher.PlainText(pluginInstance)
+	if err != nil {
+		return Markup{}, err
+	}
+	return Markup{[]MarkupChunk{{Text: text}}}, nil
+}
@@ -0,0 +1,62 @@
+package ik
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	histogram := NewHistogram([]float64{1, 2, 4})
+	for _, value := range []float64{0.5, 1, 1.5, 1.5, 3, 8} {
+		histogram.Observe(value)
+	}
+	snapshot := histogram.Snapshot()
+	// a value on a bound falls in the bucket of the bound
+	if !reflect.DeepEqual(snapshot.Counts, []int64{2, 2, 1, 1}) || snapshot.Count != 6 || snapshot.Sum != 15.5 {
+		t.Log(snapshot)
+		t.Fail()
+	}
+	// interpolated within the bucket
+	if snapshot.Quantile(0.5) != 1.5 {
+		t.Log(snapshot.Quantile(0.5))
+		t.Fail()
+	}
+	if math.Abs(snapshot.Quantile(0.25)-0.75) > 1e-9 {
+		t.Log(snapshot.Quantile(0.25))
+		t.Fail()
+	}
+	// the last bound above all the bounds
+	if snapshot.Quantile(0.99) != 4 {
+		t.Fail()
+	}
+	if NewHistogram([]float64{1}).Snapshot().Quantile(0.5) != 0 {
+		t.Fail()
+	}
+	// a nil histogram discards the observations
+	var nilHistogram *Histogram
+	nilHistogram.Observe(1)
+}
+
+func TestScorekeeperHistogram_PlainText(t *testing.T) {
+	histogram := NewHistogram(DefaultHistogramBounds)
+	fetcher := &ScorekeeperHistogram{Histogram: func(PluginInstance) *Histogram { return histogram }}
+	text, err := fetcher.PlainText(nil)
+	if err != nil || text != "no observations" {
+		t.Fail()
+	}
+	// interpolated between 1ms and 2.5ms
+	for i := 0; i < 100; i += 1 {
+		histogram.ObserveDuration(2 * time.Millisecond)
+	}
+	text, err = fetcher.PlainText(nil)
+	if err != nil || text != "p50 1.75ms, p90 2.35ms, p99 2.485ms of 100" {
+		t.Log(text)
+		t.Fail()
+	}
+	value, err := fetcher.Value(nil)
+	if snapshot, ok := value.(HistogramSnapshot); err != nil || !ok || snapshot.Count != 100 {
+		t.Fail()
+	}
+}
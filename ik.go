@@ -1,21 +1,36 @@
 package ik
 
 import (
+	"fmt"
 	"github.com/moriyoshi/ik/task"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
+	"time"
 )
 
+// Timestamp holds the seconds since the epoch, and Nanoseconds the
+// fractional part of it for the sources that provide one.
 type FluentRecord struct {
-	Tag       string
-	Timestamp uint64
-	Data      map[string]interface{}
+	Tag         string
+	Timestamp   uint64
+	Data        map[string]interface{}
+	Nanoseconds uint32
 }
 
 type TinyFluentRecord struct {
-	Timestamp uint64
-	Data      map[string]interface{}
+	Timestamp   uint64
+	Data        map[string]interface{}
+	Nanoseconds uint32
+}
+
+func (record *FluentRecord) Time() time.Time {
+	return time.Unix(int64(record.Timestamp), int64(record.Nanoseconds))
+}
+
+func (record *TinyFluentRecord) Time() time.Time {
+	return time.Unix(int64(record.Timestamp), int64(record.Nanoseconds))
 }
 
 type FluentRecordSet struct {
@@ -27,6 +42,50 @@ type Port interface {
 	Emit(recordSets []FluentRecordSet) error
 }
 
+// BackpressureError is returned by Port.Emit when some of the records
+// could not be accepted because the downstream is congested.  The records
+// not in Pending have been accepted, so the caller should retry only the
+// pending ones after a while rather than emitting everything again.
+type BackpressureError struct {
+	Pending map[Port][]FluentRecordSet
+}
+
+func (err *BackpressureError) Error() string {
+	n := 0
+	for _, recordSets := range err.Pending {
+		for _, recordSet := range recordSets {
+			n += len(recordSet.Records)
+		}
+	}
+	return fmt.Sprintf("downstream is congested (%d records pending)", n)
+}
+
+func (err *BackpressureError) Merge(other *BackpressureError) {
+	for port, recordSets := range other.Pending {
+		err.Pending[port] = append(err.Pending[port], recordSets...)
+	}
+}
+
+// Emits the pending records again to the ports that refused them.  Returns
+// another BackpressureError if some of them are still refused.
+func (err *BackpressureError) Retry() error {
+	retval := &BackpressureError{Pending: make(map[Port][]FluentRecordSet)}
+	for port, recordSets := range err.Pending {
+		err_ := port.Emit(recordSets)
+		if err_ != nil {
+			backpressureErr, ok := err_.(*BackpressureError)
+			if !ok {
+				return err_
+			}
+			retval.Merge(backpressureErr)
+		}
+	}
+	if len(retval.Pending) > 0 {
+		return retval
+	}
+	return nil
+}
+
 type Spawnee interface {
 	Run() error
 	Shutdown() error
@@ -37,6 +96,15 @@ type PluginInstance interface {
 	Factory() Plugin
 }
 
+// Implemented by the plugin instances that can be given an id with `@id',
+// which tells them apart from the other instances of the same plugin in
+// the topics.
+type IdentifiedPluginInstance interface {
+	PluginInstance
+	// the id, or the empty string if not given
+	Id() string
+}
+
 type Input interface {
 	PluginInstance
 	Port() Port
@@ -47,6 +115,29 @@ type Output interface {
 	Port
 }
 
+// Implemented by the inputs that acquire the resources like listening
+// sockets apart from the construction, so that all the inputs can be built
+// before any of them binds its address.  Start is called before the input
+// is launched, and Stop releases what Start acquired.
+type Startable interface {
+	Start() error
+	Stop() error
+}
+
+// Implemented by the inputs that can stop accepting new connections while
+// finishing the ones being handled, so that the instance can be taken out
+// of service without losing the records in flight.
+type Drainable interface {
+	Drain() error
+}
+
+// Implemented by the inputs that listen on the network, which tell the
+// addresses actually bound once started, e.g. the port assigned by the OS
+// for port 0.
+type Listening interface {
+	Addrs() []net.Addr
+}
+
 type MarkupAttributes int
 
 const (
@@ -75,6 +166,15 @@ type ScoreValueFetcher interface {
 	Markup(PluginInstance) (Markup, error)
 }
 
+// Implemented by the fetchers whose values are numbers or flags, so that
+// the exporters get them as they are rather than parsing the text meant
+// for display.  Value returns an int64, a float64, a bool, a string or a
+// HistogramSnapshot.
+type TypedScoreValueFetcher interface {
+	ScoreValueFetcher
+	Value(PluginInstance) (interface{}, error)
+}
+
 type Disposable interface {
 	Dispose() error
 }
@@ -106,11 +206,16 @@ type Engine interface {
 	RandSource() rand.Source
 	Scorekeeper() *Scorekeeper
 	DefaultPort() Port
+	// the port for the records that could not be processed as usual, e.g.
+	// failed to be decoded or delivered, which may be nil
+	DeadLetterPort() Port
 	Spawn(Spawnee) error
 	Launch(PluginInstance) error
+	Terminate(PluginInstance) error
 	SpawneeStatuses() ([]SpawneeStatus, error)
 	PluginInstances() []PluginInstance
 	RecurringTaskScheduler() *task.RecurringTaskScheduler
+	State() EngineState
 }
 
 type InputFactory interface {
@@ -118,6 +223,12 @@ type InputFactory interface {
 	New(engine Engine, config *ConfigElement) (Input, error)
 }
 
+// Implemented by the factories that declare the attributes they accept,
+// so that the unknown ones, which are most likely typos, get reported.
+type AttributeDeclarer interface {
+	AcceptedAttributes() []string
+}
+
 type InputFactoryRegistry interface {
 	RegisterInputFactory(factory InputFactory) error
 	LookupInputFactory(name string) InputFactory
@@ -133,6 +244,23 @@ type OutputFactoryRegistry interface {
 	LookupOutputFactory(name string) OutputFactory
 }
 
+// Filter modifies or drops the records before they are routed to the
+// outputs.  Returning a record set with no records drops the whole set.
+type Filter interface {
+	Factory() Plugin
+	Filter(recordSet FluentRecordSet) (FluentRecordSet, error)
+}
+
+type FilterFactory interface {
+	Plugin
+	New(engine Engine, config *ConfigElement) (Filter, error)
+}
+
+type FilterFactoryRegistry interface {
+	RegisterFilterFactory(factory FilterFactory) error
+	LookupFilterFactory(name string) FilterFactory
+}
+
 type PluginRegistry interface {
 	Plugins() []Plugin
 }
@@ -175,10 +303,21 @@ type JournalGroupFactory interface {
 	GetJournalGroup() JournalGroup
 }
 
+type RecordBuffer interface {
+	Append(record FluentRecord) error
+	Flush() error
+	Close() error
+}
+
 type RecordPacker interface {
 	Pack(record FluentRecord) ([]byte, error)
 }
 
+// Formatter turns a record into the bytes written by an output.
+type Formatter interface {
+	Format(record FluentRecord) ([]byte, error)
+}
+
 type LineParser interface {
 	Feed(line string) error
 }
@@ -0,0 +1,191 @@
+// Package iktest provides the test doubles of the engine and the ports, so
+// that the plugins can be tested without standing up the whole process.
+package iktest
+
+import (
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/task"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NopLogger discards everything logged.
+type NopLogger struct{}
+
+func (NopLogger) Critical(format string, args ...interface{}) {}
+func (NopLogger) Error(format string, args ...interface{})    {}
+func (NopLogger) Warning(format string, args ...interface{})  {}
+func (NopLogger) Notice(format string, args ...interface{})   {}
+func (NopLogger) Info(format string, args ...interface{})     {}
+func (NopLogger) Debug(format string, args ...interface{})    {}
+
+// FakePort captures the record sets emitted to it.  It is safe to emit to
+// from several goroutines.
+type FakePort struct {
+	mtx        sync.Mutex
+	recordSets []ik.FluentRecordSet
+}
+
+func (port *FakePort) Emit(recordSets []ik.FluentRecordSet) error {
+	port.mtx.Lock()
+	defer port.mtx.Unlock()
+	port.recordSets = append(port.recordSets, recordSets...)
+	return nil
+}
+
+// RecordSets returns the record sets captured so far in the order emitted.
+func (port *FakePort) RecordSets() []ik.FluentRecordSet {
+	port.mtx.Lock()
+	defer port.mtx.Unlock()
+	retval := make([]ik.FluentRecordSet, len(port.recordSets))
+	copy(retval, port.recordSets)
+	return retval
+}
+
+// Records returns the records captured so far with their tags.
+func (port *FakePort) Records() []ik.FluentRecord {
+	retval := make([]ik.FluentRecord, 0)
+	for _, recordSet := range port.RecordSets() {
+		for _, record := range recordSet.Records {
+			retval = append(retval, ik.FluentRecord{
+				Tag:         recordSet.Tag,
+				Timestamp:   record.Timestamp,
+				Data:        record.Data,
+				Nanoseconds: record.Nanoseconds,
+			})
+		}
+	}
+	return retval
+}
+
+// WaitForRecords waits until n records or more are captured, and returns
+// false if they are not within the timeout.
+func (port *FakePort) WaitForRecords(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for len(port.Records()) < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Reset forgets the record sets captured so far.
+func (port *FakePort) Reset() {
+	port.mtx.Lock()
+	defer port.mtx.Unlock()
+	port.recordSets = nil
+}
+
+// FakeEngine is an engine whose default and dead letter ports capture the
+// records.  The plugin instances launched on it are recorded but not run,
+// so that the tests run them as they see fit.
+type FakeEngine struct {
+	// the port the inputs emit to by default
+	Port *FakePort
+	// the port the records that could not be processed are emitted to
+	DeadLetters *FakePort
+	// the plugin instances launched and not terminated yet
+	Instances []ik.PluginInstance
+
+	logger                 ik.Logger
+	scorekeeper            *ik.Scorekeeper
+	randSource             rand.Source
+	recurringTaskScheduler *task.RecurringTaskScheduler
+	state                  ik.EngineState
+	mtx                    sync.Mutex
+}
+
+func (engine *FakeEngine) Logger() ik.Logger                                     { return engine.logger }
+func (engine *FakeEngine) Opener() ik.Opener                                     { return nil }
+func (engine *FakeEngine) LineParserPluginRegistry() ik.LineParserPluginRegistry { return nil }
+func (engine *FakeEngine) RandSource() rand.Source                               { return engine.randSource }
+func (engine *FakeEngine) Scorekeeper() *ik.Scorekeeper                          { return engine.scorekeeper }
+func (engine *FakeEngine) DefaultPort() ik.Port                                  { return engine.Port }
+func (engine *FakeEngine) DeadLetterPort() ik.Port                               { return engine.DeadLetters }
+func (engine *FakeEngine) Dispose() error                                        { return nil }
+func (engine *FakeEngine) Spawn(spawnee ik.Spawnee) error                        { return nil }
+
+func (engine *FakeEngine) SpawneeStatuses() ([]ik.SpawneeStatus, error) {
+	return []ik.SpawneeStatus{}, nil
+}
+
+func (engine *FakeEngine) RecurringTaskScheduler() *task.RecurringTaskScheduler {
+	return engine.recurringTaskScheduler
+}
+
+func (engine *FakeEngine) Launch(pluginInstance ik.PluginInstance) error {
+	engine.mtx.Lock()
+	defer engine.mtx.Unlock()
+	engine.Instances = append(engine.Instances, pluginInstance)
+	return nil
+}
+
+func (engine *FakeEngine) Terminate(pluginInstance ik.PluginInstance) error {
+	engine.mtx.Lock()
+	defer engine.mtx.Unlock()
+	for i, pluginInstance_ := range engine.Instances {
+		if pluginInstance_ == pluginInstance {
+			engine.Instances = append(engine.Instances[0:i], engine.Instances[i+1:]...)
+			break
+		}
+	}
+	return pluginInstance.Shutdown()
+}
+
+func (engine *FakeEngine) PluginInstances() []ik.PluginInstance {
+	engine.mtx.Lock()
+	defer engine.mtx.Unlock()
+	retval := make([]ik.PluginInstance, len(engine.Instances))
+	copy(retval, engine.Instances)
+	return retval
+}
+
+func (engine *FakeEngine) State() ik.EngineState {
+	engine.mtx.Lock()
+	defer engine.mtx.Unlock()
+	return engine.state
+}
+
+// SetState changes the state the plugins see, which is EngineRunning
+// initially.
+func (engine *FakeEngine) SetState(state ik.EngineState) {
+	engine.mtx.Lock()
+	defer engine.mtx.Unlock()
+	engine.state = state
+}
+
+// TopicNames returns the names of the topics registered to the scorekeeper
+// for the plugin in alphabetical order.
+func (engine *FakeEngine) TopicNames(plugin ik.Plugin) []string {
+	topics := engine.scorekeeper.GetTopics(plugin)
+	retval := make([]string, len(topics))
+	for i, topic := range topics {
+		retval[i] = topic.Name
+	}
+	sort.Strings(retval)
+	return retval
+}
+
+// NewFakeEngine returns an engine that logs to the logger, or discards the
+// logs if it is nil.
+func NewFakeEngine(logger ik.Logger) *FakeEngine {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	return &FakeEngine{
+		Port:        &FakePort{},
+		DeadLetters: &FakePort{},
+		logger:      logger,
+		scorekeeper: ik.NewScorekeeper(logger),
+		randSource:  rand.NewSource(0),
+		recurringTaskScheduler: task.NewRecurringTaskScheduler(
+			func() time.Time { return time.Now() },
+			&task.SimpleTaskRunner{},
+		),
+		state: ik.EngineRunning,
+	}
+}
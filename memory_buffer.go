@@ -0,0 +1,138 @@
+package ik
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryBuffer accumulates records on memory and hands them to the flush
+// callback either when the total size reaches chunkLimitSize or every
+// flushInterval, whichever comes first.
+type MemoryBuffer struct {
+	chunkLimitSize int64
+	flushInterval  time.Duration
+	flush          func([]FluentRecord) error
+	records        []FluentRecord
+	size           int64
+	mtx            sync.Mutex
+	flushMtx       sync.Mutex
+	ticker         *time.Ticker
+	cancel         chan bool
+	closed         bool
+}
+
+// estimates the size of a value as it would be serialized.
+func estimateSize(v interface{}) int64 {
+	switch v_ := v.(type) {
+	case string:
+		return int64(len(v_))
+	case []byte:
+		return int64(len(v_))
+	case map[string]interface{}:
+		retval := int64(0)
+		for key, value := range v_ {
+			retval += int64(len(key)) + estimateSize(value)
+		}
+		return retval
+	case []interface{}:
+		retval := int64(0)
+		for _, value := range v_ {
+			retval += estimateSize(value)
+		}
+		return retval
+	default:
+		return 8
+	}
+}
+
+func estimateRecordSize(record FluentRecord) int64 {
+	return int64(len(record.Tag)) + 8 + estimateSize(record.Data)
+}
+
+func (buffer *MemoryBuffer) takeRecords() []FluentRecord {
+	buffer.mtx.Lock()
+	defer buffer.mtx.Unlock()
+	retval := buffer.records
+	buffer.records = nil
+	buffer.size = 0
+	return retval
+}
+
+// Appends a record to the buffer.  If the record makes the buffer exceed
+// chunkLimitSize, the records buffered so far are flushed first.
+func (buffer *MemoryBuffer) Append(record FluentRecord) error {
+	size := estimateRecordSize(record)
+	overflow := []FluentRecord(nil)
+	func() {
+		buffer.mtx.Lock()
+		defer buffer.mtx.Unlock()
+		if buffer.chunkLimitSize > 0 && len(buffer.records) > 0 && buffer.size+size > buffer.chunkLimitSize {
+			overflow = buffer.records
+			buffer.records = nil
+			buffer.size = 0
+		}
+		buffer.records = append(buffer.records, record)
+		buffer.size += size
+	}()
+	if overflow != nil {
+		return buffer.doFlush(overflow)
+	}
+	return nil
+}
+
+func (buffer *MemoryBuffer) doFlush(records []FluentRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	buffer.flushMtx.Lock()
+	defer buffer.flushMtx.Unlock()
+	return buffer.flush(records)
+}
+
+// Flushes the records buffered so far.
+func (buffer *MemoryBuffer) Flush() error {
+	return buffer.doFlush(buffer.takeRecords())
+}
+
+// Stops the periodic flush and flushes the rest of the records.
+func (buffer *MemoryBuffer) Close() error {
+	buffer.mtx.Lock()
+	closed := buffer.closed
+	buffer.closed = true
+	buffer.mtx.Unlock()
+	if closed {
+		return nil
+	}
+	if buffer.ticker != nil {
+		buffer.ticker.Stop()
+		close(buffer.cancel)
+	}
+	return buffer.Flush()
+}
+
+func (buffer *MemoryBuffer) run() {
+	for {
+		select {
+		case <-buffer.cancel:
+			return
+		case <-buffer.ticker.C:
+			buffer.Flush()
+		}
+	}
+}
+
+func NewMemoryBuffer(chunkLimitSize int64, flushInterval time.Duration, flush func([]FluentRecord) error) *MemoryBuffer {
+	retval := &MemoryBuffer{
+		chunkLimitSize: chunkLimitSize,
+		flushInterval:  flushInterval,
+		flush:          flush,
+		records:        nil,
+		size:           0,
+		cancel:         make(chan bool),
+	}
+	if flushInterval > 0 {
+		retval.ticker = time.NewTicker(flushInterval)
+		go retval.run()
+	}
+	return retval
+}
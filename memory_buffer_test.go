@@ -0,0 +1,64 @@
+package ik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBuffer_SizeTriggeredFlush(t *testing.T) {
+	flushed := [][]FluentRecord{}
+	buffer := NewMemoryBuffer(40, 0, func(records []FluentRecord) error {
+		flushed = append(flushed, records)
+		return nil
+	})
+	// each record is estimated as 3 (tag) + 8 (timestamp) + 1 + 4 = 16 bytes
+	for i := 0; i < 5; i += 1 {
+		err := buffer.Append(FluentRecord{Tag: "tag", Timestamp: uint64(i), Data: map[string]interface{}{"k": "vvvv"}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	if len(flushed) != 2 || len(flushed[0]) != 2 || len(flushed[1]) != 2 {
+		t.Log(flushed)
+		t.FailNow()
+	}
+	if flushed[1][0].Timestamp != 2 {
+		t.Fail()
+	}
+	buffer.Close()
+	if len(flushed) != 3 || len(flushed[2]) != 1 || flushed[2][0].Timestamp != 4 {
+		t.Fail()
+	}
+}
+
+func TestMemoryBuffer_TimeTriggeredFlush(t *testing.T) {
+	c := make(chan []FluentRecord, 1)
+	buffer := NewMemoryBuffer(0, 10*time.Millisecond, func(records []FluentRecord) error {
+		c <- records
+		return nil
+	})
+	defer buffer.Close()
+	buffer.Append(FluentRecord{Tag: "tag", Timestamp: 1, Data: map[string]interface{}{}})
+	select {
+	case records := <-c:
+		if len(records) != 1 || records[0].Tag != "tag" {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+}
+
+func TestMemoryBuffer_CloseIsIdempotent(t *testing.T) {
+	count := 0
+	buffer := NewMemoryBuffer(0, time.Second, func(records []FluentRecord) error {
+		count += len(records)
+		return nil
+	})
+	buffer.Append(FluentRecord{Tag: "tag", Timestamp: 1, Data: map[string]interface{}{}})
+	buffer.Close()
+	buffer.Close()
+	if count != 1 {
+		t.Fail()
+	}
+}
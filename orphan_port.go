@@ -0,0 +1,58 @@
+package ik
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// the number of the tags the orphan port logs about, beyond which the new
+// tags are not logged so as not to flood the log.
+const maxOrphanedTagsLogged = 100
+
+// the default port of the routers, which takes the records that match no
+// match section.  they are counted, and emitted to the dead letter port if
+// any, or dropped otherwise.  the first records of each tag are logged so
+// that a missing match section doesn't go unnoticed.
+type orphanPort struct {
+	logger Logger
+	// nil unless the dead letters are routed
+	deadLetterPort Port
+	count          int64
+	loggedTags     map[string]bool
+	mtx            sync.Mutex
+}
+
+func newOrphanPort(logger Logger) *orphanPort {
+	return &orphanPort{
+		logger:     logger,
+		loggedTags: make(map[string]bool),
+	}
+}
+
+// logs about the tag unless it has been logged already.
+func (port *orphanPort) logTag(tag string) {
+	port.mtx.Lock()
+	defer port.mtx.Unlock()
+	if port.loggedTags[tag] || len(port.loggedTags) >= maxOrphanedTagsLogged {
+		return
+	}
+	port.loggedTags[tag] = true
+	if port.deadLetterPort != nil {
+		port.logger.Warning("No match section for the tag %s; the records are emitted to the %s label", tag, DeadLetterLabel)
+	} else {
+		port.logger.Warning("No match section for the tag %s; the records are dropped", tag)
+	}
+}
+
+func (port *orphanPort) Emit(recordSets []FluentRecordSet) error {
+	for _, recordSet := range recordSets {
+		atomic.AddInt64(&port.count, int64(len(recordSet.Records)))
+		port.logTag(recordSet.Tag)
+	}
+	return EmitDeadLetters(port.deadLetterPort, recordSets, noRouteReason)
+}
+
+// returns the number of the records that matched no match section so far.
+func (port *orphanPort) Count() int64 {
+	return atomic.LoadInt64(&port.count)
+}
@@ -0,0 +1,77 @@
+package ik
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Parser turns a line into the data of a record.  The timestamp is zero
+// when the line doesn't tell the time, leaving it to the caller.
+type Parser interface {
+	Parse(line []byte) (map[string]interface{}, uint64, error)
+}
+
+// ParserLineParserFactory adapts a Parser to LineParserFactory so that the
+// parsers can be registered along with the other line parsers.
+type ParserLineParserFactory struct {
+	parser Parser
+	logger Logger
+}
+
+type parserLineParser struct {
+	factory  *ParserLineParserFactory
+	receiver func(FluentRecord) error
+}
+
+func (lineParser *parserLineParser) Feed(line string) error {
+	data, timestamp, err := lineParser.factory.parser.Parse([]byte(line))
+	if err != nil {
+		lineParser.factory.logger.Error("Unparsed line: " + line)
+		return nil
+	}
+	return lineParser.receiver(FluentRecord{
+		Tag:       "",
+		Timestamp: timestamp,
+		Data:      data,
+	})
+}
+
+func (factory *ParserLineParserFactory) Parser() Parser {
+	return factory.parser
+}
+
+func (factory *ParserLineParserFactory) New(receiver func(FluentRecord) error) (LineParser, error) {
+	return &parserLineParser{
+		factory:  factory,
+		receiver: receiver,
+	}, nil
+}
+
+func NewParserLineParserFactory(parser Parser, logger Logger) *ParserLineParserFactory {
+	return &ParserLineParserFactory{
+		parser: parser,
+		logger: logger,
+	}
+}
+
+// NewParser builds the parser named by the `format' attribute of the
+// configuration.
+func NewParser(engine Engine, config *ConfigElement) (Parser, error) {
+	format, ok := config.Attrs["format"]
+	if !ok {
+		return nil, errors.New("requires attribute `format' is not specified")
+	}
+	lineParserFactoryFactory := engine.LineParserPluginRegistry().LookupLineParserFactoryFactory(format)
+	if lineParserFactoryFactory == nil {
+		return nil, errors.New(fmt.Sprintf("Format `%s' is not supported", format))
+	}
+	lineParserFactory, err := lineParserFactoryFactory(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	parserFactory, ok := lineParserFactory.(*ParserLineParserFactory)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Format `%s' does not provide a parser", format))
+	}
+	return parserFactory.Parser(), nil
+}
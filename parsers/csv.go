@@ -0,0 +1,75 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"unicode/utf8"
+)
+
+type CSVLineParserPlugin struct{}
+
+// CSVParser reads a line as a CSV record, whose fields are named by keys.
+type CSVParser struct {
+	keys      []string
+	delimiter rune
+	timeField *timeField
+}
+
+func (parser *CSVParser) Parse(line []byte) (map[string]interface{}, uint64, error) {
+	reader := csv.NewReader(bytes.NewReader(line))
+	reader.Comma = parser.delimiter
+	reader.FieldsPerRecord = len(parser.keys)
+	values, err := reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	data := make(map[string]interface{})
+	for i, key := range parser.keys {
+		data[key] = values[i]
+	}
+	timestamp, err := parser.timeField.extract(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, timestamp, nil
+}
+
+func (*CSVLineParserPlugin) Name() string {
+	return "csv"
+}
+
+func (plugin *CSVLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("csv", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *CSVLineParserPlugin) NewParser(config *ik.ConfigElement) (*CSVParser, error) {
+	keys, err := requiredKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	delimiterStr := config.AttrString("delimiter", ",")
+	delimiter, size := utf8.DecodeRuneInString(delimiterStr)
+	if size == 0 || size != len(delimiterStr) {
+		return nil, errors.New(fmt.Sprintf("invalid delimiter: %q", delimiterStr))
+	}
+	return &CSVParser{
+		keys:      keys,
+		delimiter: delimiter,
+		timeField: newTimeField(config, ""),
+	}, nil
+}
+
+func (plugin *CSVLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	parser, err := plugin.NewParser(config)
+	if err != nil {
+		return nil, err
+	}
+	return ik.NewParserLineParserFactory(parser, engine.Logger()), nil
+}
+
+var _ = AddPlugin(&CSVLineParserPlugin{})
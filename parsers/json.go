@@ -0,0 +1,51 @@
+package parsers
+
+import (
+	"encoding/json"
+	"github.com/moriyoshi/ik"
+)
+
+type JSONLineParserPlugin struct{}
+
+type JSONParser struct {
+	timeField *timeField
+}
+
+func (parser *JSONParser) Parse(line []byte) (map[string]interface{}, uint64, error) {
+	data := make(map[string]interface{})
+	err := json.Unmarshal(line, &data)
+	if err != nil {
+		return nil, 0, err
+	}
+	timestamp, err := parser.timeField.extract(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, timestamp, nil
+}
+
+func (*JSONLineParserPlugin) Name() string {
+	return "json"
+}
+
+func (plugin *JSONLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("json", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *JSONLineParserPlugin) NewParser(config *ik.ConfigElement) (*JSONParser, error) {
+	return &JSONParser{
+		timeField: newTimeField(config, ""),
+	}, nil
+}
+
+func (plugin *JSONLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	parser, err := plugin.NewParser(config)
+	if err != nil {
+		return nil, err
+	}
+	return ik.NewParserLineParserFactory(parser, engine.Logger()), nil
+}
+
+var _ = AddPlugin(&JSONLineParserPlugin{})
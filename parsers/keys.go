@@ -0,0 +1,20 @@
+package parsers
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+	"strings"
+)
+
+// reads the comma-separated field names from the `keys' attribute.
+func requiredKeys(config *ik.ConfigElement) ([]string, error) {
+	keysStr, ok := config.Attrs["keys"]
+	if !ok {
+		return nil, errors.New("Required attribute `keys' not found")
+	}
+	keys := strings.Split(keysStr, ",")
+	for i, key := range keys {
+		keys[i] = strings.TrimSpace(key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,65 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strings"
+)
+
+type LTSVLineParserPlugin struct{}
+
+// LTSVParser reads labeled tab-separated values, each field of which is
+// the label and the value joined by label_delimiter.
+type LTSVParser struct {
+	delimiter      string
+	labelDelimiter string
+	timeField      *timeField
+}
+
+func (parser *LTSVParser) Parse(line []byte) (map[string]interface{}, uint64, error) {
+	data := make(map[string]interface{})
+	for _, field := range strings.Split(string(line), parser.delimiter) {
+		if field == "" {
+			continue
+		}
+		pair := strings.SplitN(field, parser.labelDelimiter, 2)
+		if len(pair) != 2 {
+			return nil, 0, errors.New(fmt.Sprintf("field without a label: %q", field))
+		}
+		data[pair[0]] = pair[1]
+	}
+	timestamp, err := parser.timeField.extract(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, timestamp, nil
+}
+
+func (*LTSVLineParserPlugin) Name() string {
+	return "ltsv"
+}
+
+func (plugin *LTSVLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("ltsv", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *LTSVLineParserPlugin) NewParser(config *ik.ConfigElement) (*LTSVParser, error) {
+	return &LTSVParser{
+		delimiter:      config.AttrString("delimiter", "\t"),
+		labelDelimiter: config.AttrString("label_delimiter", ":"),
+		timeField:      newTimeField(config, ""),
+	}, nil
+}
+
+func (plugin *LTSVLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	parser, err := plugin.NewParser(config)
+	if err != nil {
+		return nil, err
+	}
+	return ik.NewParserLineParserFactory(parser, engine.Logger()), nil
+}
+
+var _ = AddPlugin(&LTSVLineParserPlugin{})
@@ -0,0 +1,41 @@
+package parsers
+
+import (
+	"github.com/moriyoshi/ik"
+)
+
+type NoneLineParserPlugin struct{}
+
+type NoneParser struct {
+	messageKey string
+}
+
+func (parser *NoneParser) Parse(line []byte) (map[string]interface{}, uint64, error) {
+	return map[string]interface{}{parser.messageKey: string(line)}, 0, nil
+}
+
+func (*NoneLineParserPlugin) Name() string {
+	return "none"
+}
+
+func (plugin *NoneLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("none", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *NoneLineParserPlugin) NewParser(config *ik.ConfigElement) (*NoneParser, error) {
+	return &NoneParser{
+		messageKey: config.AttrString("message_key", "message"),
+	}, nil
+}
+
+func (plugin *NoneLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	parser, err := plugin.NewParser(config)
+	if err != nil {
+		return nil, err
+	}
+	return ik.NewParserLineParserFactory(parser, engine.Logger()), nil
+}
+
+var _ = AddPlugin(&NoneLineParserPlugin{})
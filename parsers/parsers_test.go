@@ -0,0 +1,163 @@
+package parsers
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+)
+
+type testLogger struct{}
+
+func (testLogger) Critical(format string, args ...interface{}) {}
+func (testLogger) Error(format string, args ...interface{})    {}
+func (testLogger) Warning(format string, args ...interface{})  {}
+func (testLogger) Notice(format string, args ...interface{})   {}
+func (testLogger) Info(format string, args ...interface{})     {}
+func (testLogger) Debug(format string, args ...interface{})    {}
+
+type testRegistry struct {
+	factoryFactories map[string]ik.LineParserFactoryFactory
+}
+
+func (registry *testRegistry) RegisterLineParserPlugin(plugin ik.LineParserPlugin) error {
+	return plugin.OnRegistering(func(name string, factoryFactory ik.LineParserFactoryFactory) error {
+		registry.factoryFactories[name] = factoryFactory
+		return nil
+	})
+}
+
+func (registry *testRegistry) LookupLineParserFactoryFactory(name string) ik.LineParserFactoryFactory {
+	return registry.factoryFactories[name]
+}
+
+type testEngine struct {
+	ik.Engine
+	registry *testRegistry
+}
+
+func (engine *testEngine) Logger() ik.Logger {
+	return testLogger{}
+}
+
+func (engine *testEngine) LineParserPluginRegistry() ik.LineParserPluginRegistry {
+	return engine.registry
+}
+
+func newTestEngine() *testEngine {
+	registry := &testRegistry{factoryFactories: make(map[string]ik.LineParserFactoryFactory)}
+	for _, plugin := range GetPlugins() {
+		registry.RegisterLineParserPlugin(plugin)
+	}
+	return &testEngine{registry: registry}
+}
+
+func newParser(t *testing.T, attrs map[string]string) ik.Parser {
+	parser, err := ik.NewParser(newTestEngine(), &ik.ConfigElement{Name: "source", Attrs: attrs})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	return parser
+}
+
+func TestNewParser(t *testing.T) {
+	engine := newTestEngine()
+	_, err := ik.NewParser(engine, &ik.ConfigElement{Name: "source", Attrs: map[string]string{}})
+	if err == nil {
+		t.Fail()
+	}
+	_, err = ik.NewParser(engine, &ik.ConfigElement{Name: "source", Attrs: map[string]string{"format": "unknown"}})
+	if err == nil {
+		t.Fail()
+	}
+	for _, format := range []string{"json", "ltsv", "none"} {
+		_, err = ik.NewParser(engine, &ik.ConfigElement{Name: "source", Attrs: map[string]string{"format": format}})
+		if err != nil {
+			t.Log(err.Error())
+			t.Fail()
+		}
+	}
+}
+
+func TestJSONParser_Parse(t *testing.T) {
+	parser := newParser(t, map[string]string{"format": "json", "time_key": "t"})
+	data, timestamp, err := parser.Parse([]byte(`{"a": "b", "t": 1409286145}`))
+	if err != nil || timestamp != 1409286145 || len(data) != 1 || data["a"] != "b" {
+		t.Fail()
+	}
+	// the record lacking the field is left for the caller to timestamp
+	data, timestamp, err = parser.Parse([]byte(`{"a": "b"}`))
+	if err != nil || timestamp != 0 || data["a"] != "b" {
+		t.Fail()
+	}
+	_, _, err = parser.Parse([]byte(`{"a": `))
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestRegexpParser_Parse(t *testing.T) {
+	parser := newParser(t, map[string]string{
+		"format":      "regexp",
+		"regexp":      `^(?P<time>\S+) (?P<host>\S+) (?P<message>.*)$`,
+		"time_format": "%Y-%m-%dT%H:%M:%S%z",
+	})
+	data, timestamp, err := parser.Parse([]byte("2014-08-29T04:22:25+0000 example.com hello world"))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if timestamp != 1409286145 || len(data) != 2 || data["host"] != "example.com" || data["message"] != "hello world" {
+		t.Fail()
+	}
+	_, _, err = parser.Parse([]byte("garbage"))
+	if err == nil {
+		t.Fail()
+	}
+	_, _, err = parser.Parse([]byte("yesterday example.com hello"))
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestLTSVParser_Parse(t *testing.T) {
+	parser := newParser(t, map[string]string{"format": "ltsv", "time_key": "time"})
+	data, timestamp, err := parser.Parse([]byte("time:2014-08-29T04:22:25Z\thost:example.com\treq:GET /a:b HTTP/1.1"))
+	if err != nil || timestamp != 1409286145 || len(data) != 2 || data["host"] != "example.com" || data["req"] != "GET /a:b HTTP/1.1" {
+		t.Fail()
+	}
+	_, _, err = parser.Parse([]byte("host:example.com\tunlabeled"))
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestCSVParser_Parse(t *testing.T) {
+	parser := newParser(t, map[string]string{"format": "csv", "keys": "host, message"})
+	data, timestamp, err := parser.Parse([]byte(`example.com,"hello, world"`))
+	if err != nil || timestamp != 0 || data["host"] != "example.com" || data["message"] != "hello, world" {
+		t.Fail()
+	}
+	_, _, err = parser.Parse([]byte("example.com"))
+	if err == nil {
+		t.Fail()
+	}
+	_, err = ik.NewParser(newTestEngine(), &ik.ConfigElement{Name: "source", Attrs: map[string]string{"format": "csv"}})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestParserLineParserFactory(t *testing.T) {
+	parser := newParser(t, map[string]string{"format": "json"})
+	records := make([]ik.FluentRecord, 0)
+	lineParser, _ := ik.NewParserLineParserFactory(parser, testLogger{}).New(func(record ik.FluentRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	// the unparsed lines are skipped
+	lineParser.Feed("{")
+	lineParser.Feed(`{"a": "b"}`)
+	if len(records) != 1 || records[0].Data["a"] != "b" {
+		t.Fail()
+	}
+}
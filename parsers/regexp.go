@@ -2,84 +2,51 @@ package parsers
 
 import (
 	"errors"
+	"fmt"
 	"github.com/moriyoshi/ik"
-	"github.com/pbnjay/strptime"
 	"regexp"
-	"time"
 )
 
 type RegexpLineParserPlugin struct{}
 
-type RegexpLineParserFactory struct {
-	plugin     *RegexpLineParserPlugin
-	logger     ik.Logger
-	timeParser func(value string) (time.Time, error)
-	regex      *regexp.Regexp
+// RegexpParser takes the named groups of the expression as the fields,
+// the one named by time_key ("time" by default) giving the timestamp.
+type RegexpParser struct {
+	regex     *regexp.Regexp
+	timeField *timeField
 }
 
-type RegexpLineParser struct {
-	factory  *RegexpLineParserFactory
-	receiver func(ik.FluentRecord) error
-}
-
-func (parser *RegexpLineParser) Feed(line string) error {
-	regex := parser.factory.regex
-	g := regex.FindStringSubmatch(line)
-	data := make(map[string]interface{})
+func (parser *RegexpParser) Parse(line []byte) (map[string]interface{}, uint64, error) {
+	regex := parser.regex
+	g := regex.FindSubmatch(line)
 	if g == nil {
-		parser.factory.logger.Error("Unparsed line: " + line)
-		return nil
+		return nil, 0, errors.New(fmt.Sprintf("line does not match %s", regex.String()))
 	}
+	data := make(map[string]interface{})
 	for i, name := range regex.SubexpNames() {
-		data[name] = g[i]
+		if name == "" {
+			continue
+		}
+		data[name] = string(g[i])
 	}
-	parser.receiver(ik.FluentRecord{
-		Tag:       "",
-		Timestamp: 0,
-		Data:      data,
-	})
-	return nil
+	timestamp, err := parser.timeField.extract(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, timestamp, nil
 }
 
 func (*RegexpLineParserPlugin) Name() string {
 	return "regexp"
 }
 
-func (factory *RegexpLineParserFactory) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
-	return &RegexpLineParser{
-		factory:  factory,
-		receiver: receiver,
-	}, nil
-}
-
 func (plugin *RegexpLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
 	return visitor("regexp", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
 		return plugin.New(engine, config)
 	})
 }
 
-func (plugin *RegexpLineParserPlugin) newRegexpLineParserFactory(logger ik.Logger, timeParser func(value string) (time.Time, error), regex *regexp.Regexp) (*RegexpLineParserFactory, error) {
-	return &RegexpLineParserFactory{
-		plugin:     plugin,
-		logger:     logger,
-		timeParser: timeParser,
-		regex:      regex,
-	}, nil
-}
-
-func (plugin *RegexpLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
-	timeFormatStr, ok := config.Attrs["time_format"]
-	var timeParser func(value string) (time.Time, error)
-	if ok {
-		timeParser = func(value string) (time.Time, error) {
-			return strptime.Parse(value, timeFormatStr)
-		}
-	} else {
-		timeParser = func(value string) (time.Time, error) {
-			// FIXME
-			return time.Parse(time.RFC3339, value)
-		}
-	}
+func (plugin *RegexpLineParserPlugin) NewParser(config *ik.ConfigElement) (*RegexpParser, error) {
 	regexStr, ok := config.Attrs["regexp"]
 	if !ok {
 		return nil, errors.New("Required attribute `regexp' not found")
@@ -88,7 +55,18 @@ func (plugin *RegexpLineParserPlugin) New(engine ik.Engine, config *ik.ConfigEle
 	if err != nil {
 		return nil, err
 	}
-	return plugin.newRegexpLineParserFactory(engine.Logger(), timeParser, regex)
+	return &RegexpParser{
+		regex:     regex,
+		timeField: newTimeField(config, "time"),
+	}, nil
+}
+
+func (plugin *RegexpLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	parser, err := plugin.NewParser(config)
+	if err != nil {
+		return nil, err
+	}
+	return ik.NewParserLineParserFactory(parser, engine.Logger()), nil
 }
 
 var _ = AddPlugin(&RegexpLineParserPlugin{})
@@ -0,0 +1,67 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"github.com/pbnjay/strptime"
+	"time"
+)
+
+// timeField takes the timestamp of a record out of the field named by
+// time_key, which is parsed according to time_format, or as RFC3339 if
+// it is not given.  Numbers are taken as the seconds since the epoch.
+type timeField struct {
+	key        string
+	timeParser func(value string) (time.Time, error)
+}
+
+// returns nil if the configuration doesn't name the field.
+func newTimeField(config *ik.ConfigElement, defaultKey string) *timeField {
+	key := config.AttrString("time_key", defaultKey)
+	if key == "" {
+		return nil
+	}
+	var timeParser func(value string) (time.Time, error)
+	timeFormatStr, ok := config.Attrs["time_format"]
+	if ok {
+		timeParser = func(value string) (time.Time, error) {
+			return strptime.Parse(value, timeFormatStr)
+		}
+	} else {
+		timeParser = func(value string) (time.Time, error) {
+			return time.Parse(time.RFC3339, value)
+		}
+	}
+	return &timeField{
+		key:        key,
+		timeParser: timeParser,
+	}
+}
+
+// removes the field from the data and returns the timestamp it holds, or
+// zero if the data lacks the field.
+func (field *timeField) extract(data map[string]interface{}) (uint64, error) {
+	if field == nil {
+		return 0, nil
+	}
+	value, ok := data[field.key]
+	if !ok {
+		return 0, nil
+	}
+	var timestamp uint64
+	switch value := value.(type) {
+	case string:
+		t, err := field.timeParser(value)
+		if err != nil {
+			return 0, err
+		}
+		timestamp = uint64(t.Unix())
+	case float64:
+		timestamp = uint64(value)
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid %s: %v", field.key, value))
+	}
+	delete(data, field.key)
+	return timestamp, nil
+}
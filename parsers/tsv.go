@@ -0,0 +1,65 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strings"
+)
+
+type TSVLineParserPlugin struct{}
+
+type TSVParser struct {
+	keys      []string
+	delimiter string
+	timeField *timeField
+}
+
+func (parser *TSVParser) Parse(line []byte) (map[string]interface{}, uint64, error) {
+	keys := parser.keys
+	values := strings.Split(string(line), parser.delimiter)
+	if len(values) != len(keys) {
+		return nil, 0, errors.New(fmt.Sprintf("expected %d fields, got %d", len(keys), len(values)))
+	}
+	data := make(map[string]interface{})
+	for i, key := range keys {
+		data[key] = values[i]
+	}
+	timestamp, err := parser.timeField.extract(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, timestamp, nil
+}
+
+func (*TSVLineParserPlugin) Name() string {
+	return "tsv"
+}
+
+func (plugin *TSVLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("tsv", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *TSVLineParserPlugin) NewParser(config *ik.ConfigElement) (*TSVParser, error) {
+	keys, err := requiredKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	return &TSVParser{
+		keys:      keys,
+		delimiter: config.AttrString("delimiter", "\t"),
+		timeField: newTimeField(config, ""),
+	}, nil
+}
+
+func (plugin *TSVLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	parser, err := plugin.NewParser(config)
+	if err != nil {
+		return nil, err
+	}
+	return ik.NewParserLineParserFactory(parser, engine.Logger()), nil
+}
+
+var _ = AddPlugin(&TSVLineParserPlugin{})
@@ -0,0 +1,340 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	mrand "math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the buffer parameters shared by the outputs that send the records in
+// batches.  they are named the same as those of out_forward.
+type bufferOptions struct {
+	chunkLimitSize int64
+	flushInterval  time.Duration
+	bufferPath     string
+	totalLimitSize int64
+	overflowAction int
+}
+
+func parseBufferOptions(config *ik.ConfigElement) (bufferOptions, error) {
+	var err error
+	retval := bufferOptions{}
+	retval.flushInterval, err = config.AttrDuration("flush_interval", 60*time.Second)
+	if err != nil {
+		return retval, err
+	}
+	retval.chunkLimitSize, err = config.AttrCapacity("chunk_limit_size", 8*1024*1024) // 8MB
+	if err != nil {
+		return retval, err
+	}
+	bufferType, ok := config.Attrs["buffer_type"]
+	if ok && bufferType == "file" {
+		retval.bufferPath, ok = config.Attrs["buffer_path"]
+		if !ok {
+			return retval, errors.New("'buffer_path' parameter is required for file buffer")
+		}
+	} else if ok && bufferType != "memory" {
+		return retval, errors.New("unknown buffer_type: " + bufferType)
+	}
+	retval.totalLimitSize, err = config.AttrCapacity("total_limit_size", 512*1024*1024) // 512MB
+	if err != nil {
+		return retval, err
+	}
+	retval.overflowAction = ik.OverflowActionBlock
+	overflowActionStr, ok := config.Attrs["overflow_action"]
+	if ok {
+		retval.overflowAction, err = ik.ParseOverflowAction(overflowActionStr)
+		if err != nil {
+			return retval, err
+		}
+	}
+	return retval, nil
+}
+
+// creates the buffer, and tells whether it is durable.
+func (options bufferOptions) newBuffer(flush func([]ik.FluentRecord) error) (ik.RecordBuffer, bool, error) {
+	if options.bufferPath != "" {
+		buffer, err := ik.NewFileBuffer(options.bufferPath, options.chunkLimitSize, options.totalLimitSize, options.overflowAction, options.flushInterval, flush)
+		if err != nil {
+			return nil, false, err
+		}
+		return buffer, true, nil
+	}
+	return ik.NewMemoryBuffer(options.chunkLimitSize, options.flushInterval, flush), false, nil
+}
+
+func parseRetryManager(engine ik.Engine, config *ik.ConfigElement) (*ik.RetryManager, error) {
+	retryWait, err := config.AttrDuration("retry_wait", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	maxRetryWait, err := config.AttrDuration("max_retry_wait", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	retryExponentialBackoffBase := 2.
+	retryExponentialBackoffBaseStr, ok := config.Attrs["retry_exponential_backoff_base"]
+	if ok {
+		retryExponentialBackoffBase, err = strconv.ParseFloat(retryExponentialBackoffBaseStr, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	retryLimit := 17
+	retryLimitStr, ok := config.Attrs["retry_limit"]
+	if ok {
+		retryLimit, err = strconv.Atoi(retryLimitStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ik.NewRetryManager(retryWait, maxRetryWait, retryExponentialBackoffBase, retryLimit, mrand.NewSource(mrand.New(engine.RandSource()).Int63())), nil
+}
+
+// returned by the send callback when sending the payload again would not
+// help, e.g. the server rejected it as malformed.
+type nonRetryableError struct {
+	err error
+}
+
+func (err *nonRetryableError) Error() string {
+	return err.err.Error()
+}
+
+// returned by the send callback when only a part of the payload has been
+// sent.  the rest replaces the payload, so that only that part is retried.
+type partialSendError struct {
+	err  error
+	rest []byte
+}
+
+func (err *partialSendError) Error() string {
+	return err.err.Error()
+}
+
+// a batch of records, encoded for the destination.
+type encodedChunk struct {
+	records []ik.FluentRecord
+	payload []byte
+	// the id the destination acks the chunk with, if any
+	id string
+	// the number of the attempts that failed to send the chunk
+	failures int
+}
+
+// groups the records of the chunks into record sets by the tag.
+func recordSetsOfChunks(chunks []encodedChunk) []ik.FluentRecordSet {
+	recordSets := make([]ik.FluentRecordSet, 0)
+	for _, chunk := range chunks {
+		for _, record := range chunk.records {
+			tinyRecord := ik.TinyFluentRecord{Timestamp: record.Timestamp, Data: record.Data, Nanoseconds: record.Nanoseconds}
+			if len(recordSets) > 0 && recordSets[len(recordSets)-1].Tag == record.Tag {
+				recordSet := &recordSets[len(recordSets)-1]
+				recordSet.Records = append(recordSet.Records, tinyRecord)
+			} else {
+				recordSets = append(recordSets, ik.FluentRecordSet{Tag: record.Tag, Records: []ik.TinyFluentRecord{tinyRecord}})
+			}
+		}
+	}
+	return recordSets
+}
+
+// sends the encoded chunks in order, and keeps the ones that failed so
+// that they are retried on the next flush, backing off in between.  a chunk
+// is given up once it has failed more than retry_limit times, and handed to
+// giveUp if set, or emitted to the dead letter port otherwise.
+type retryingSender struct {
+	logger ik.Logger
+	retry  *ik.RetryManager
+	send   func(payload []byte) error
+	// sends as many of the chunks as it can at once, e.g. over a single
+	// connection, and returns how many of them were sent.  it is used in
+	// place of send if set.
+	sendBatch      func(chunks []encodedChunk) (int, error)
+	giveUp         func(chunks []encodedChunk, reason string)
+	deadLetterPort ik.Port
+	// the total size of the payloads kept for retrying, beyond which
+	// isFull() reports true; zero means unlimited
+	pendingLimit int64
+	pending      []encodedChunk
+	nextRetry    time.Time
+	mtx          sync.Mutex
+	flushMtx     sync.Mutex
+}
+
+func (sender *retryingSender) drop(chunks []encodedChunk, reason string) {
+	if sender.giveUp != nil {
+		sender.giveUp(chunks, reason)
+		return
+	}
+	sender.emitDeadLetters(chunks, reason)
+}
+
+func (sender *retryingSender) emitDeadLetters(chunks []encodedChunk, reason string) {
+	err := ik.EmitDeadLetters(sender.deadLetterPort, recordSetsOfChunks(chunks), reason)
+	if err != nil {
+		sender.logger.Error("Failed to emit to the dead letter port: %s", err.Error())
+	}
+}
+
+func (sender *retryingSender) sendChunks(chunks []encodedChunk) ([]encodedChunk, error) {
+	if sender.sendBatch != nil {
+		n, err := sender.sendBatch(chunks)
+		return chunks[n:], err
+	}
+	for len(chunks) > 0 {
+		err := sender.send(chunks[0].payload)
+		if err != nil {
+			partial, ok := err.(*partialSendError)
+			if ok {
+				chunks[0].payload = partial.rest
+				return chunks, partial.err
+			}
+			_, ok = err.(*nonRetryableError)
+			if !ok {
+				return chunks, err
+			}
+			sender.logger.Error("Dropped %d records: %s", len(chunks[0].records), err.Error())
+			sender.drop(chunks[0:1], err.Error())
+		}
+		chunks = chunks[1:]
+	}
+	return nil, nil
+}
+
+// sends the chunks unless it is still backing off from the last failure.
+// flushMtx must be held by the caller.
+func (sender *retryingSender) trySend(chunks []encodedChunk) ([]encodedChunk, error) {
+	now := time.Now()
+	if now.Before(sender.nextRetry) {
+		return chunks, errors.New(fmt.Sprintf("retry is postponed until %s", sender.nextRetry.String()))
+	}
+	chunks, err := sender.sendChunks(chunks)
+	if err == nil {
+		sender.retry.Reset()
+		sender.nextRetry = time.Time{}
+		return nil, nil
+	}
+	for i := range chunks {
+		chunks[i].failures += 1
+	}
+	wait, giveUp := sender.retry.NextWait()
+	if giveUp {
+		// only the chunks that have used up their retries are given up;
+		// the ones queued after them may have been tried just once.
+		n := 0
+		for n < len(chunks) && chunks[n].failures > sender.retry.RetryLimit {
+			n += 1
+		}
+		sender.logger.Error("Gave up sending %d chunks after %d retries: %s", n, sender.retry.Steps(), err.Error())
+		sender.drop(chunks[0:n], fmt.Sprintf("gave up after %d retries: %s", sender.retry.Steps(), err.Error()))
+		chunks = chunks[n:]
+		sender.retry.Reset()
+		sender.nextRetry = time.Time{}
+		if len(chunks) == 0 {
+			return nil, nil
+		}
+		// back off as much as the oldest of the rest has been retried
+		for i := 0; i < chunks[0].failures; i++ {
+			wait, _ = sender.retry.NextWait()
+		}
+	}
+	sender.nextRetry = now.Add(wait)
+	return chunks, err
+}
+
+func (sender *retryingSender) flush() error {
+	sender.flushMtx.Lock()
+	defer sender.flushMtx.Unlock()
+	sender.mtx.Lock()
+	chunks := sender.pending
+	sender.pending = nil
+	sender.mtx.Unlock()
+	if len(chunks) == 0 {
+		return nil
+	}
+	chunks, err := sender.trySend(chunks)
+	if len(chunks) > 0 {
+		// put the rest back so that they are retried on the next flush
+		sender.mtx.Lock()
+		sender.pending = append(chunks, sender.pending...)
+		sender.mtx.Unlock()
+	}
+	return err
+}
+
+// if the buffer is durable, the chunks that failed to be sent are left
+// to the buffer so that they are retried from there.
+func (sender *retryingSender) enqueue(chunks []encodedChunk, durable bool) error {
+	if durable {
+		sender.flushMtx.Lock()
+		defer sender.flushMtx.Unlock()
+		// the buffer hands over the same chunks on every flush, which
+		// have failed as many times as retried since the last success.
+		for i := range chunks {
+			chunks[i].failures = sender.retry.Steps()
+		}
+		_, err := sender.trySend(chunks)
+		return err
+	}
+	sender.mtx.Lock()
+	sender.pending = append(sender.pending, chunks...)
+	sender.mtx.Unlock()
+	return sender.flush()
+}
+
+// tells whether the chunks kept for retrying have reached pendingLimit.
+func (sender *retryingSender) isFull() bool {
+	if sender.pendingLimit <= 0 {
+		return false
+	}
+	sender.mtx.Lock()
+	defer sender.mtx.Unlock()
+	size := int64(0)
+	for _, chunk := range sender.pending {
+		size += int64(len(chunk.payload))
+	}
+	return size >= sender.pendingLimit
+}
+
+// retries the chunks which could not be sent on the previous attempts.
+func (sender *retryingSender) run(interval time.Duration, cancel chan bool) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cancel:
+				return
+			case <-ticker.C:
+				sender.flush()
+			}
+		}
+	}()
+}
+
+// splits the records handed to Emit into the buffer.  the ones that don't
+// fit are handed back to the caller as a BackpressureError.
+func appendToBuffer(logger ik.Logger, buffer ik.RecordBuffer, port ik.Port, recordSets []ik.FluentRecordSet) error {
+	for i, recordSet := range recordSets {
+		for j, record := range recordSet.Records {
+			err := buffer.Append(ik.FluentRecord{
+				Tag:         recordSet.Tag,
+				Timestamp:   record.Timestamp,
+				Data:        record.Data,
+				Nanoseconds: record.Nanoseconds,
+			})
+			if err == ik.ErrBufferOverflow {
+				pending := append([]ik.FluentRecordSet{{Tag: recordSet.Tag, Records: recordSet.Records[j:]}}, recordSets[i+1:]...)
+				return &ik.BackpressureError{Pending: map[ik.Port][]ik.FluentRecordSet{port: pending}}
+			} else if err != nil {
+				logger.Error("%s", err.Error())
+			}
+		}
+	}
+	return nil
+}
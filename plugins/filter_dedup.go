@@ -0,0 +1,146 @@
+package plugins
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"github.com/moriyoshi/ik"
+	"strings"
+	"sync"
+	"time"
+)
+
+type dedupEntry struct {
+	hash   [sha1.Size]byte
+	seenAt time.Time
+}
+
+// DedupFilter drops the records identical to those seen within the window.
+// The records are identified by the hash of the tag and the data, or the
+// selected fields of it, in the JSON form, whose keys are sorted.  Up to
+// cache_size hashes are kept, the least recently seen ones being evicted
+// first.
+type DedupFilter struct {
+	factory   *DedupFilterFactory
+	logger    ik.Logger
+	keys      []string
+	window    time.Duration
+	cacheSize int
+	entries   map[[sha1.Size]byte]*list.Element
+	lru       *list.List
+	mtx       sync.Mutex
+	now       func() time.Time
+}
+
+type DedupFilterFactory struct {
+}
+
+func (filter *DedupFilter) Factory() ik.Plugin {
+	return filter.factory
+}
+
+func (filter *DedupFilter) hash(tag string, data map[string]interface{}) ([sha1.Size]byte, error) {
+	if filter.keys != nil {
+		selected := make(map[string]interface{}, len(filter.keys))
+		for _, key := range filter.keys {
+			selected[key] = data[key]
+		}
+		data = selected
+	}
+	b, err := json.Marshal([]interface{}{tag, data})
+	if err != nil {
+		return [sha1.Size]byte{}, err
+	}
+	return sha1.Sum(b), nil
+}
+
+// tells if the hash has been seen within the window, remembering it
+// otherwise.  the lock must be held by the caller.
+func (filter *DedupFilter) seen(hash [sha1.Size]byte, now time.Time) bool {
+	elem, ok := filter.entries[hash]
+	if ok {
+		filter.lru.MoveToFront(elem)
+		entry := elem.Value.(*dedupEntry)
+		if now.Sub(entry.seenAt) <= filter.window {
+			return true
+		}
+		entry.seenAt = now
+		return false
+	}
+	filter.entries[hash] = filter.lru.PushFront(&dedupEntry{hash: hash, seenAt: now})
+	for filter.lru.Len() > filter.cacheSize {
+		oldest := filter.lru.Back()
+		filter.lru.Remove(oldest)
+		delete(filter.entries, oldest.Value.(*dedupEntry).hash)
+	}
+	return false
+}
+
+func (filter *DedupFilter) Filter(recordSet ik.FluentRecordSet) (ik.FluentRecordSet, error) {
+	filter.mtx.Lock()
+	defer filter.mtx.Unlock()
+	now := filter.now()
+	records := make([]ik.TinyFluentRecord, 0, len(recordSet.Records))
+	for _, record := range recordSet.Records {
+		hash, err := filter.hash(recordSet.Tag, record.Data)
+		if err != nil {
+			// let through what can't be told apart
+			filter.logger.Warning("Failed to hash the record: %s", err.Error())
+			records = append(records, record)
+			continue
+		}
+		if !filter.seen(hash, now) {
+			records = append(records, record)
+		}
+	}
+	return ik.FluentRecordSet{Tag: recordSet.Tag, Records: records}, nil
+}
+
+func (factory *DedupFilterFactory) Name() string {
+	return "dedup"
+}
+
+func newDedupFilter(factory *DedupFilterFactory, logger ik.Logger, keys []string, window time.Duration, cacheSize int) *DedupFilter {
+	return &DedupFilter{
+		factory:   factory,
+		logger:    logger,
+		keys:      keys,
+		window:    window,
+		cacheSize: cacheSize,
+		entries:   make(map[[sha1.Size]byte]*list.Element),
+		lru:       list.New(),
+		now:       time.Now,
+	}
+}
+
+func (factory *DedupFilterFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Filter, error) {
+	window, err := config.AttrDuration("window", 60*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	cacheSize, err := config.AttrInt("cache_size", 10000)
+	if err != nil {
+		return nil, err
+	}
+	if cacheSize <= 0 {
+		return nil, errors.New("cache_size must be positive")
+	}
+	var keys []string
+	keysStr, ok := config.Attrs["keys"]
+	if ok {
+		for _, key := range strings.Split(keysStr, ",") {
+			keys = append(keys, strings.TrimSpace(key))
+		}
+	}
+	return newDedupFilter(factory, engine.Logger(), keys, window, cacheSize), nil
+}
+
+func (factory *DedupFilterFactory) AcceptedAttributes() []string {
+	return []string{"window", "cache_size", "keys"}
+}
+
+func (factory *DedupFilterFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&DedupFilterFactory{})
@@ -0,0 +1,65 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func TestDedupFilter_Filter(t *testing.T) {
+	filter := newDedupFilter(&DedupFilterFactory{}, &testLogger{t}, nil, time.Minute, 2)
+	now := time.Unix(1409286145, 0)
+	filter.now = func() time.Time { return now }
+	record := func(data map[string]interface{}) ik.TinyFluentRecord {
+		return ik.TinyFluentRecord{Timestamp: 1, Data: data}
+	}
+	recordSet, _ := filter.Filter(ik.FluentRecordSet{Tag: "tag", Records: []ik.TinyFluentRecord{
+		record(map[string]interface{}{"a": "b", "c": "d"}),
+		record(map[string]interface{}{"c": "d", "a": "b"}),
+		record(map[string]interface{}{"a": "x"}),
+	}})
+	// the second is the same record
+	if len(recordSet.Records) != 2 || recordSet.Records[1].Data["a"] != "x" {
+		t.FailNow()
+	}
+	recordSet, _ = filter.Filter(ik.FluentRecordSet{Tag: "tag", Records: []ik.TinyFluentRecord{
+		record(map[string]interface{}{"a": "b", "c": "d"}),
+	}})
+	if len(recordSet.Records) != 0 {
+		t.Fail()
+	}
+	// the same record with another tag is not a duplicate
+	recordSet, _ = filter.Filter(ik.FluentRecordSet{Tag: "other", Records: []ik.TinyFluentRecord{
+		record(map[string]interface{}{"a": "b", "c": "d"}),
+	}})
+	if len(recordSet.Records) != 1 {
+		t.Fail()
+	}
+	// evicted as the least recently seen one, ...
+	recordSet, _ = filter.Filter(ik.FluentRecordSet{Tag: "tag", Records: []ik.TinyFluentRecord{
+		record(map[string]interface{}{"a": "x"}),
+	}})
+	if len(recordSet.Records) != 1 {
+		t.Fail()
+	}
+	// ... or out of the window
+	now = now.Add(2 * time.Minute)
+	recordSet, _ = filter.Filter(ik.FluentRecordSet{Tag: "other", Records: []ik.TinyFluentRecord{
+		record(map[string]interface{}{"a": "b", "c": "d"}),
+	}})
+	if len(recordSet.Records) != 1 {
+		t.Fail()
+	}
+}
+
+func TestDedupFilter_Keys(t *testing.T) {
+	filter := newDedupFilter(&DedupFilterFactory{}, &testLogger{t}, []string{"id"}, time.Minute, 100)
+	recordSet, _ := filter.Filter(ik.FluentRecordSet{Tag: "tag", Records: []ik.TinyFluentRecord{
+		{Timestamp: 1, Data: map[string]interface{}{"id": 1, "received_at": "1"}},
+		{Timestamp: 2, Data: map[string]interface{}{"id": 1, "received_at": "2"}},
+		{Timestamp: 3, Data: map[string]interface{}{"id": 2, "received_at": "3"}},
+	}})
+	if len(recordSet.Records) != 2 || recordSet.Records[1].Timestamp != 3 {
+		t.Fail()
+	}
+}
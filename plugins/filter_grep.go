@@ -0,0 +1,126 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type grepCondition struct {
+	key string
+	re  *regexp.Regexp
+}
+
+type GrepFilter struct {
+	factory  *GrepFilterFactory
+	regexps  []grepCondition
+	excludes []grepCondition
+}
+
+type GrepFilterFactory struct {
+}
+
+func stringifyValue(v interface{}) (string, bool) {
+	switch v_ := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v_, true
+	case []byte:
+		return string(v_), true
+	default:
+		return fmt.Sprint(v_), true
+	}
+}
+
+func (condition *grepCondition) matches(data map[string]interface{}) bool {
+	value, ok := stringifyValue(data[condition.key])
+	if !ok {
+		return false
+	}
+	return condition.re.MatchString(value)
+}
+
+// a record passes if it satisfies all the regexp conditions and none of
+// the exclude conditions.
+func (filter *GrepFilter) passes(data map[string]interface{}) bool {
+	for i := range filter.regexps {
+		if !filter.regexps[i].matches(data) {
+			return false
+		}
+	}
+	for i := range filter.excludes {
+		if filter.excludes[i].matches(data) {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter *GrepFilter) Factory() ik.Plugin {
+	return filter.factory
+}
+
+func (filter *GrepFilter) Filter(recordSet ik.FluentRecordSet) (ik.FluentRecordSet, error) {
+	records := make([]ik.TinyFluentRecord, 0, len(recordSet.Records))
+	for _, record := range recordSet.Records {
+		if filter.passes(record.Data) {
+			records = append(records, record)
+		}
+	}
+	return ik.FluentRecordSet{Tag: recordSet.Tag, Records: records}, nil
+}
+
+func (factory *GrepFilterFactory) Name() string {
+	return "grep"
+}
+
+// collects the attributes like "regexp1 field pattern" in the order of the
+// number following the prefix.
+func parseGrepConditions(attrs map[string]string, prefix string) ([]grepCondition, error) {
+	names := []string{}
+	for name, _ := range attrs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	retval := make([]grepCondition, 0, len(names))
+	for _, name := range names {
+		value := strings.TrimSpace(attrs[name])
+		pos := strings.IndexAny(value, " \t")
+		if pos < 0 {
+			return nil, errors.New(fmt.Sprintf("%s must be in the form of `field pattern'", name))
+		}
+		re, err := regexp.Compile(strings.TrimSpace(value[pos+1:]))
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, grepCondition{key: value[0:pos], re: re})
+	}
+	return retval, nil
+}
+
+func (factory *GrepFilterFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Filter, error) {
+	regexps, err := parseGrepConditions(config.Attrs, "regexp")
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := parseGrepConditions(config.Attrs, "exclude")
+	if err != nil {
+		return nil, err
+	}
+	return &GrepFilter{
+		factory:  factory,
+		regexps:  regexps,
+		excludes: excludes,
+	}, nil
+}
+
+func (factory *GrepFilterFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&GrepFilterFactory{})
@@ -0,0 +1,54 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+)
+
+func TestGrepFilter_Filter(t *testing.T) {
+	factory := &GrepFilterFactory{}
+	filter, err := factory.New(nil, &ik.ConfigElement{
+		Name: "filter",
+		Args: "**",
+		Attrs: map[string]string{
+			"type":     "grep",
+			"regexp1":  "message cool",
+			"regexp2":  "hostname ^web\\d+$",
+			"exclude1": "message uncool",
+		},
+	})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	recordSet, err := filter.Filter(ik.FluentRecordSet{
+		Tag: "tag",
+		Records: []ik.TinyFluentRecord{
+			{Timestamp: 1, Data: map[string]interface{}{"message": "cool", "hostname": "web01"}},
+			{Timestamp: 2, Data: map[string]interface{}{"message": "cool", "hostname": "db01"}},
+			{Timestamp: 3, Data: map[string]interface{}{"message": "uncool", "hostname": "web02"}},
+			{Timestamp: 4, Data: map[string]interface{}{"hostname": "web03"}},
+			{Timestamp: 5, Data: map[string]interface{}{"message": []byte("so cool"), "hostname": "web04"}},
+		},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	if recordSet.Tag != "tag" || len(recordSet.Records) != 2 {
+		t.Log(recordSet)
+		t.FailNow()
+	}
+	if recordSet.Records[0].Timestamp != 1 || recordSet.Records[1].Timestamp != 5 {
+		t.Fail()
+	}
+}
+
+func TestGrepFilter_MalformedCondition(t *testing.T) {
+	factory := &GrepFilterFactory{}
+	_, err := factory.New(nil, &ik.ConfigElement{
+		Attrs: map[string]string{"regexp1": "message"},
+	})
+	if err == nil {
+		t.Fail()
+	}
+}
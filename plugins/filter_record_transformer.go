@@ -0,0 +1,111 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+var placeholderRegExp = regexp.MustCompile(`\$\{(?:(tag|hostname)|record\["([^"]*)"\])\}`)
+
+type RecordTransformerFilter struct {
+	factory     *RecordTransformerFactory
+	hostname    string
+	record      map[string]string
+	removeKeys  []string
+	renewRecord bool
+}
+
+type RecordTransformerFactory struct {
+}
+
+func (filter *RecordTransformerFilter) expand(value string, tag string, data map[string]interface{}) string {
+	return placeholderRegExp.ReplaceAllStringFunc(value, func(placeholder string) string {
+		m := placeholderRegExp.FindStringSubmatch(placeholder)
+		switch m[1] {
+		case "tag":
+			return tag
+		case "hostname":
+			return filter.hostname
+		}
+		retval, _ := stringifyValue(data[m[2]])
+		return retval
+	})
+}
+
+func (filter *RecordTransformerFilter) transform(tag string, data map[string]interface{}) map[string]interface{} {
+	retval := make(map[string]interface{})
+	if !filter.renewRecord {
+		for key, value := range data {
+			retval[key] = value
+		}
+	}
+	for key, value := range filter.record {
+		retval[key] = filter.expand(value, tag, data)
+	}
+	for _, key := range filter.removeKeys {
+		delete(retval, key)
+	}
+	return retval
+}
+
+func (filter *RecordTransformerFilter) Factory() ik.Plugin {
+	return filter.factory
+}
+
+func (filter *RecordTransformerFilter) Filter(recordSet ik.FluentRecordSet) (ik.FluentRecordSet, error) {
+	records := make([]ik.TinyFluentRecord, len(recordSet.Records))
+	for i, record := range recordSet.Records {
+		records[i] = ik.TinyFluentRecord{
+			Timestamp:   record.Timestamp,
+			Data:        filter.transform(recordSet.Tag, record.Data),
+			Nanoseconds: record.Nanoseconds,
+		}
+	}
+	return ik.FluentRecordSet{Tag: recordSet.Tag, Records: records}, nil
+}
+
+func (factory *RecordTransformerFactory) Name() string {
+	return "record_transformer"
+}
+
+func (factory *RecordTransformerFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Filter, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	record := map[string]string{}
+	for _, elem := range config.Elems {
+		if elem.Name == "record" {
+			for key, value := range elem.Attrs {
+				record[key] = value
+			}
+		}
+	}
+	removeKeys := []string{}
+	removeKeysStr, ok := config.Attrs["remove_keys"]
+	if ok {
+		removeKeys = splitAndStrip(removeKeysStr)
+	}
+	renewRecord := false
+	renewRecordStr, ok := config.Attrs["renew_record"]
+	if ok {
+		renewRecord, err = strconv.ParseBool(renewRecordStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &RecordTransformerFilter{
+		factory:     factory,
+		hostname:    hostname,
+		record:      record,
+		removeKeys:  removeKeys,
+		renewRecord: renewRecord,
+	}, nil
+}
+
+func (factory *RecordTransformerFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&RecordTransformerFactory{})
@@ -0,0 +1,81 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"os"
+	"testing"
+)
+
+func TestRecordTransformerFilter_Placeholders(t *testing.T) {
+	factory := &RecordTransformerFactory{}
+	filter, err := factory.New(nil, &ik.ConfigElement{
+		Attrs: map[string]string{"remove_keys": "secret, password"},
+		Elems: []*ik.ConfigElement{
+			{
+				Name: "record",
+				Attrs: map[string]string{
+					"origin":  "${tag}@${hostname}",
+					"summary": `${record["level"]}: ${record["message"]}`,
+					"message": "overwritten",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	original := map[string]interface{}{"level": "warn", "message": "disk full", "secret": "x", "password": "y"}
+	recordSet, err := filter.Filter(ik.FluentRecordSet{
+		Tag:     "app.log",
+		Records: []ik.TinyFluentRecord{{Timestamp: 1, Data: original}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	hostname, _ := os.Hostname()
+	data := recordSet.Records[0].Data
+	if data["origin"] != "app.log@"+hostname {
+		t.Log(data["origin"])
+		t.Fail()
+	}
+	if data["summary"] != "warn: disk full" {
+		t.Log(data["summary"])
+		t.Fail()
+	}
+	if data["message"] != "overwritten" || data["level"] != "warn" {
+		t.Fail()
+	}
+	if _, ok := data["secret"]; ok {
+		t.Fail()
+	}
+	if _, ok := data["password"]; ok {
+		t.Fail()
+	}
+	// the original record must be left intact
+	if original["message"] != "disk full" || original["secret"] != "x" {
+		t.Fail()
+	}
+}
+
+func TestRecordTransformerFilter_RenewRecord(t *testing.T) {
+	factory := &RecordTransformerFactory{}
+	filter, err := factory.New(nil, &ik.ConfigElement{
+		Attrs: map[string]string{"renew_record": "true"},
+		Elems: []*ik.ConfigElement{
+			{Name: "record", Attrs: map[string]string{"copied": `${record["a"]}`}},
+		},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	recordSet, _ := filter.Filter(ik.FluentRecordSet{
+		Tag:     "tag",
+		Records: []ik.TinyFluentRecord{{Timestamp: 1, Data: map[string]interface{}{"a": uint64(1), "b": "c"}}},
+	})
+	data := recordSet.Records[0].Data
+	if len(data) != 1 || data["copied"] != "1" {
+		t.Log(data)
+		t.Fail()
+	}
+}
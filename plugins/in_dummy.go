@@ -0,0 +1,169 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DummyInput generates the configured records at a fixed rate, which is
+// handy for testing the pipeline without any clients.
+type DummyInput struct {
+	factory          *DummyInputFactory
+	port             ik.Port
+	logger           ik.Logger
+	tag              string
+	dummy            []map[string]interface{}
+	rate             int
+	autoIncrementKey string
+	next             int
+	counter          int64
+	nextEmission     time.Time
+	shutdownChan     chan struct{}
+	shutdownOnce     sync.Once
+}
+
+type DummyInputFactory struct {
+}
+
+func (input *DummyInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *DummyInput) Port() ik.Port {
+	return input.port
+}
+
+// generates the records for a second, cycling through the dummy records.
+func (input *DummyInput) generate(now time.Time) ik.FluentRecordSet {
+	records := make([]ik.TinyFluentRecord, input.rate)
+	for i := range records {
+		data := deepCopyValue(input.dummy[input.next]).(map[string]interface{})
+		input.next = (input.next + 1) % len(input.dummy)
+		if input.autoIncrementKey != "" {
+			data[input.autoIncrementKey] = input.counter
+			input.counter += 1
+		}
+		records[i] = ik.TinyFluentRecord{
+			Timestamp:   uint64(now.Unix()),
+			Nanoseconds: uint32(now.Nanosecond()),
+			Data:        data,
+		}
+	}
+	return ik.FluentRecordSet{Tag: input.tag, Records: records}
+}
+
+func (input *DummyInput) Run() error {
+	if input.nextEmission.IsZero() {
+		input.nextEmission = time.Now()
+	}
+	timer := time.NewTimer(input.nextEmission.Sub(time.Now()))
+	defer timer.Stop()
+	select {
+	case <-input.shutdownChan:
+		return nil
+	case now := <-timer.C:
+		input.nextEmission = input.nextEmission.Add(time.Second)
+		if input.nextEmission.Before(now) {
+			// don't try to catch up after falling behind
+			input.nextEmission = now.Add(time.Second)
+		}
+		err := input.port.Emit([]ik.FluentRecordSet{input.generate(now)})
+		if err != nil {
+			input.logger.Warning("Dropped %d records: %s", input.rate, err.Error())
+		}
+	}
+	return ik.Continue
+}
+
+func (input *DummyInput) Shutdown() error {
+	input.shutdownOnce.Do(func() {
+		close(input.shutdownChan)
+	})
+	return nil
+}
+
+func (input *DummyInput) Dispose() {
+	input.Shutdown()
+}
+
+func newDummyInput(factory *DummyInputFactory, logger ik.Logger, tag string, dummy []map[string]interface{}, rate int, autoIncrementKey string, port ik.Port) *DummyInput {
+	return &DummyInput{
+		factory:          factory,
+		port:             port,
+		logger:           logger,
+		tag:              tag,
+		dummy:            dummy,
+		rate:             rate,
+		autoIncrementKey: autoIncrementKey,
+		shutdownChan:     make(chan struct{}),
+	}
+}
+
+// accepts either a JSON object or a list of them.
+func parseDummyRecords(s string) ([]map[string]interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal([]byte(s), &v)
+	if err != nil {
+		return nil, err
+	}
+	switch v_ := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v_}, nil
+	case []interface{}:
+		retval := make([]map[string]interface{}, 0, len(v_))
+		for _, elem := range v_ {
+			record, ok := elem.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("dummy must be a JSON object or an array of objects")
+			}
+			retval = append(retval, record)
+		}
+		if len(retval) == 0 {
+			return nil, errors.New("dummy must not be empty")
+		}
+		return retval, nil
+	}
+	return nil, errors.New("dummy must be a JSON object or an array of objects")
+}
+
+func (factory *DummyInputFactory) Name() string {
+	return "dummy"
+}
+
+func (factory *DummyInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	tag, ok := config.Attrs["tag"]
+	if !ok {
+		return nil, errors.New("required attribute `tag' is not specified")
+	}
+	dummyStr, ok := config.Attrs["dummy"]
+	if !ok {
+		dummyStr = `{"message":"dummy"}`
+	}
+	dummy, err := parseDummyRecords(dummyStr)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid dummy: %s", err.Error()))
+	}
+	rate := 1
+	rateStr, ok := config.Attrs["rate"]
+	if ok {
+		rate, err = strconv.Atoi(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		if rate < 1 {
+			return nil, errors.New(fmt.Sprintf("invalid rate: %s", strconv.Quote(rateStr)))
+		}
+	}
+	autoIncrementKey, _ := config.Attrs["auto_increment_key"]
+	return newDummyInput(factory, engine.Logger(), tag, dummy, rate, autoIncrementKey, engine.DefaultPort()), nil
+}
+
+func (factory *DummyInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&DummyInputFactory{})
@@ -0,0 +1,86 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func TestDummyInput_Run(t *testing.T) {
+	dummy, err := parseDummyRecords(`[{"message":"a"},{"message":"b"}]`)
+	if err != nil {
+		t.FailNow()
+	}
+	port := &testPort{}
+	input := newDummyInput(&DummyInputFactory{}, &testLogger{t}, "dummy.test", dummy, 3, "id", port)
+	start := time.Now()
+	for i := 0; i < 2; i += 1 {
+		if input.Run() != ik.Continue {
+			t.FailNow()
+		}
+	}
+	// the second batch is emitted a second after the first one
+	if time.Now().Sub(start) < 900*time.Millisecond {
+		t.Fail()
+	}
+	if len(port.recordSets) != 2 {
+		t.FailNow()
+	}
+	messages := ""
+	for i, recordSet := range port.recordSets {
+		if recordSet.Tag != "dummy.test" || len(recordSet.Records) != 3 {
+			t.FailNow()
+		}
+		for j, record := range recordSet.Records {
+			messages += record.Data["message"].(string)
+			if record.Data["id"] != int64(i*3+j) || record.Timestamp == 0 {
+				t.Fail()
+			}
+		}
+	}
+	if messages != "ababab" {
+		t.Log(messages)
+		t.Fail()
+	}
+	// the records must not share the maps
+	port.recordSets[0].Records[0].Data["message"] = "mutated"
+	if dummy[0]["message"] != "a" {
+		t.Fail()
+	}
+}
+
+func TestDummyInput_Shutdown(t *testing.T) {
+	port := &testPort{}
+	input := newDummyInput(&DummyInputFactory{}, &testLogger{t}, "dummy.test", []map[string]interface{}{{}}, 1, "", port)
+	if input.Run() != ik.Continue {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		done <- input.Run()
+	}()
+	input.Shutdown()
+	input.Dispose()
+	select {
+	case err := <-done:
+		if err != nil || len(port.recordSets) != 1 {
+			t.Fail()
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fail()
+	}
+}
+
+func TestParseDummyRecords(t *testing.T) {
+	records, err := parseDummyRecords(`{"a":1}`)
+	if err != nil || len(records) != 1 || records[0]["a"] != 1. {
+		t.Fail()
+	}
+	for _, s := range []string{`[]`, `"a"`, `[{"a":1},2]`, `{`} {
+		_, err = parseDummyRecords(s)
+		if err == nil {
+			t.Log(s)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package plugins
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ExecInput runs a command, either every run_interval or continuously,
+// and emits the lines it writes to the stdout as records.
+type ExecInput struct {
+	factory      *ExecInputFactory
+	port         ik.Port
+	logger       ik.Logger
+	command      string
+	tag          string
+	runInterval  time.Duration
+	lineParser   ik.LineParser
+	pump         *ik.RecordPump
+	cmd          *exec.Cmd
+	mtx          sync.Mutex
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
+}
+
+type ExecInputFactory struct {
+}
+
+func (input *ExecInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *ExecInput) Port() ik.Port {
+	return input.port
+}
+
+func (input *ExecInput) isShuttingDown() bool {
+	select {
+	case <-input.shutdownChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// runs the command once, feeding its stdout to the line parser until it
+// exits.
+func (input *ExecInput) runCommand() error {
+	cmd := newShellCommand(input.command)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	input.mtx.Lock()
+	if input.isShuttingDown() {
+		input.mtx.Unlock()
+		return nil
+	}
+	err = cmd.Start()
+	if err != nil {
+		input.mtx.Unlock()
+		return err
+	}
+	input.cmd = cmd
+	input.mtx.Unlock()
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			input.logger.Warning("%s[%d]: %s", input.command, cmd.Process.Pid, scanner.Text())
+		}
+	}()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for scanner.Scan() {
+		err := input.lineParser.Feed(scanner.Text())
+		if err != nil {
+			input.logger.Error("Failed to parse the output of %s: %s", input.command, err.Error())
+		}
+	}
+	if scanner.Err() != nil {
+		input.logger.Error("%s", scanner.Err().Error())
+	}
+	<-stderrDone
+	err = cmd.Wait()
+	input.mtx.Lock()
+	input.cmd = nil
+	input.mtx.Unlock()
+	if err != nil && !input.isShuttingDown() {
+		return errors.New(fmt.Sprintf("%s[%d] exited: %s", input.command, cmd.Process.Pid, err.Error()))
+	}
+	return nil
+}
+
+func (input *ExecInput) Run() error {
+	err := input.runCommand()
+	if err != nil {
+		input.logger.Error("%s", err.Error())
+	}
+	wait := input.runInterval
+	if wait == 0 {
+		// the command is expected to keep running
+		wait = execRestartInterval
+	}
+	select {
+	case <-input.shutdownChan:
+		return nil
+	case <-time.After(wait):
+	}
+	return ik.Continue
+}
+
+func (input *ExecInput) Shutdown() error {
+	input.shutdownOnce.Do(func() {
+		input.mtx.Lock()
+		close(input.shutdownChan)
+		if input.cmd != nil {
+			killProcessGroup(input.cmd)
+		}
+		input.mtx.Unlock()
+		input.pump.Shutdown()
+	})
+	return nil
+}
+
+func (input *ExecInput) Dispose() {
+	input.Shutdown()
+}
+
+func newExecInput(factory *ExecInputFactory, logger ik.Logger, command string, tag string, runInterval time.Duration, lineParserFactory ik.LineParserFactory, port ik.Port) (*ExecInput, error) {
+	input := &ExecInput{
+		factory:      factory,
+		port:         port,
+		logger:       logger,
+		command:      command,
+		tag:          tag,
+		runInterval:  runInterval,
+		pump:         ik.NewRecordPump(port, DefaultBacklogSize),
+		shutdownChan: make(chan struct{}),
+	}
+	var err error
+	input.lineParser, err = lineParserFactory.New(func(record ik.FluentRecord) error {
+		record.Tag = input.tag
+		if record.Timestamp == 0 {
+			record.Timestamp = uint64(time.Now().Unix())
+		}
+		input.pump.EmitOne(record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+func (factory *ExecInputFactory) Name() string {
+	return "exec"
+}
+
+func (factory *ExecInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	command, ok := config.Attrs["command"]
+	if !ok {
+		return nil, errors.New("required attribute `command' is not specified")
+	}
+	tag, ok := config.Attrs["tag"]
+	if !ok {
+		return nil, errors.New("required attribute `tag' is not specified")
+	}
+	runInterval, err := config.AttrDuration("run_interval", 0)
+	if err != nil {
+		return nil, err
+	}
+	format, ok := config.Attrs["format"]
+	if !ok {
+		format = "json"
+	}
+	lineParserFactoryFactory := engine.LineParserPluginRegistry().LookupLineParserFactoryFactory(format)
+	if lineParserFactoryFactory == nil {
+		return nil, errors.New(fmt.Sprintf("Format `%s' is not supported", format))
+	}
+	lineParserFactory, err := lineParserFactoryFactory(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	input, err := newExecInput(factory, engine.Logger(), command, tag, runInterval, lineParserFactory, engine.DefaultPort())
+	if err != nil {
+		return nil, err
+	}
+	err = engine.Spawn(input.pump)
+	if err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+func (factory *ExecInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&ExecInputFactory{})
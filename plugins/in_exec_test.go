@@ -0,0 +1,82 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+// puts each line into the "message" field
+type testLineParserFactory struct{}
+
+type testLineParser struct {
+	receiver func(ik.FluentRecord) error
+}
+
+func (factory *testLineParserFactory) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return &testLineParser{receiver}, nil
+}
+
+func (parser *testLineParser) Feed(line string) error {
+	return parser.receiver(ik.FluentRecord{Data: map[string]interface{}{"message": line}})
+}
+
+func TestExecInput_Run(t *testing.T) {
+	port := &testPort{}
+	input, err := newExecInput(&ExecInputFactory{}, &testLogger{t}, "echo a; echo b; exit 3", "exec.test", 10*time.Millisecond, &testLineParserFactory{}, port)
+	if err != nil {
+		t.FailNow()
+	}
+	pumpDone := make(chan error)
+	go func() {
+		pumpDone <- input.pump.Run()
+	}()
+	for i := 0; i < 2; i += 1 {
+		// a non-zero exit status doesn't stop the input
+		if input.Run() != ik.Continue {
+			t.FailNow()
+		}
+	}
+	input.Shutdown()
+	input.Dispose()
+	<-pumpDone
+	messages := ""
+	for _, recordSet := range port.recordSets {
+		if recordSet.Tag != "exec.test" {
+			t.Fail()
+		}
+		for _, record := range recordSet.Records {
+			messages += record.Data["message"].(string)
+			if record.Timestamp == 0 {
+				t.Fail()
+			}
+		}
+	}
+	if messages != "abab" {
+		t.Log(messages)
+		t.Fail()
+	}
+}
+
+func TestExecInput_Shutdown(t *testing.T) {
+	port := &testPort{}
+	input, err := newExecInput(&ExecInputFactory{}, &testLogger{t}, "echo a; sleep 10", "exec.test", 0, &testLineParserFactory{}, port)
+	if err != nil {
+		t.FailNow()
+	}
+	go input.pump.Run()
+	done := make(chan error)
+	go func() {
+		done <- input.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	input.Shutdown()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+}
@@ -1,74 +1,356 @@
 package plugins
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/moriyoshi/ik"
 	"github.com/ugorji/go/codec"
 	"io"
+	"io/ioutil"
+	"math"
 	"net"
+	"os"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+type forwardInputOptions struct {
+	// given with @id to tell the input apart from the other forward inputs
+	// in the topics
+	id              string
+	tlsConfig       *tls.Config
+	sharedKey       string
+	selfHostname    string
+	readTimeout     time.Duration
+	maxConnections  int
+	shutdownTimeout time.Duration
+	maxMessageSize  int64
+	keepRawBytes    bool
+	// enables TCP keepalive on the accepted connections, with the period
+	// if non-zero
+	keepAlive       bool
+	keepAlivePeriod time.Duration
+	// coalesces the messages from a connection into batches of up to the
+	// size in records, waiting for up to the duration, if the size is
+	// non-zero
+	emitBatchSize int
+	emitBatchWait time.Duration
+	// limits the records read per second from a connection, or for each
+	// tag if throttleByTag is set, if non-zero
+	recordsPerSecond float64
+	throttleByTag    bool
+	// the wire format; "msgpack" or "json"
+	format string
+	// binds the address with SO_REUSEADDR and SO_REUSEPORT
+	reusePort bool
+	// the keys under which the address and the hostname of the peer are
+	// added to the records, if not empty
+	sourceAddressKey    string
+	sourceHostnameKey   string
+	overwriteSourceKeys bool
+	// the number of the acked chunk ids remembered to tell the resent
+	// chunks
+	chunkCacheSize int
+	// reads the connections through a buffer of the size, if non-zero
+	readBufferSize int64
+	// disconnects the clients sending a message slower than the bytes per
+	// second over the window, if non-zero
+	minBytesPerSec   int64
+	slowClientWindow time.Duration
+	// the networks the clients may connect from, which are all unless
+	// given, and those they may not, which take precedence
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	// replaces the tags of the records if not empty, then removes and adds
+	// the prefixes followed by a dot
+	tag             string
+	removeTagPrefix string
+	addTagPrefix    string
+	// rejects the PackedForward messages whose number of entries differs
+	// from the size option
+	strictSizeCheck bool
+	// what to do with a message that is read whole but can't be decoded;
+	// "disconnect", "skip" or "dead_letter", in which case it is emitted
+	// to the dead letter port under deadLetterTag
+	errorPolicy   string
+	deadLetterTag string
+	// closes the least recently active connection to accept a new one
+	// when max_connections is reached, instead of rejecting the new one
+	evictIdle bool
+	// accepts the connections upgraded to WebSocket on the path over HTTP
+	// if not empty
+	websocketPath string
+	// the upper bounds in seconds of the buckets of the decode time, or
+	// the defaults if empty
+	decodeTimeBuckets []float64
+	// requires the clients to give one of the usernames and its password
+	// on top of shared_key
+	userAuth bool
+	users    map[string]string
+	// the time a client has to complete the TLS handshake and the
+	// authentication, if non-zero
+	handshakeTimeout time.Duration
+}
+
+// rewrites the tag according to tag, remove_tag_prefix and add_tag_prefix.
+func (options *forwardInputOptions) rewriteTag(tag string) string {
+	if options.tag != "" {
+		tag = options.tag
+	}
+	if options.removeTagPrefix != "" {
+		tag = strings.TrimPrefix(tag, options.removeTagPrefix+".")
+	}
+	if options.addTagPrefix != "" {
+		tag = options.addTagPrefix + "." + tag
+	}
+	return tag
+}
+
+// tells if the malformed messages are dropped rather than the connections
+// they came through.
+func (options *forwardInputOptions) dropsMalformedMessages() bool {
+	return options.errorPolicy == "skip" || options.errorPolicy == "dead_letter"
+}
+
 type forwardClient struct {
 	input  *ForwardInput
 	logger ik.Logger
-	conn   net.Conn
-	codec  *codec.MsgpackHandle
-	enc    *codec.Encoder
-	dec    *codec.Decoder
+	// the connection, which is keyed by id in the input, and the address
+	// of its peer
+	id         uint64
+	conn       io.ReadWriteCloser
+	remoteAddr string
+	codec      *codec.MsgpackHandle
+	stream     forwardStream
+	bytes      int64
+	// the address and the hostname of the peer, looked up on the first
+	// records received
+	sourceAddress  string
+	sourceHostname string
+	sourceResolved bool
+	// the wait before reading again after a temporary failure, which is
+	// doubled while the failures persist
+	temporaryFailureWait time.Duration
+	// limits the records read off the connection unless throttled by tag
+	bucket *tokenBucket
+	// when the first byte of the message being received was read, in
+	// nanoseconds since the epoch, or 0 between messages
+	pendingSince int64
+	// the bytes received, sampled by the slow client check while a
+	// message is pending
+	byteSamples []int64
+	// set once disconnected by the input
+	disconnected int32
+	// when the last message was received, or the connection was accepted
+	// if none has been, in nanoseconds since the epoch
+	lastActivity int64
+}
+
+// counts the bytes read from a connection both for the client and the
+// input it belongs to.
+type countingReader struct {
+	reader io.Reader
+	client *forwardClient
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	if n > 0 {
+		atomic.CompareAndSwapInt64(&reader.client.pendingSince, 0, time.Now().UnixNano())
+	}
+	atomic.AddInt64(&reader.client.bytes, int64(n))
+	atomic.AddInt64(&reader.client.input.bytes, int64(n))
+	return n, err
 }
 
 type ForwardInput struct {
-	factory  *ForwardInputFactory
-	port     ik.Port
-	logger   ik.Logger
-	bind     string
-	listener net.Listener
-	codec    *codec.MsgpackHandle
-	clients  map[net.Conn]*forwardClient
-	entries  int64
+	factory      *ForwardInputFactory
+	port         ik.Port
+	logger       ik.Logger
+	binds        []string
+	listeners    []net.Listener
+	accepted     chan acceptedConn
+	acceptOnce   sync.Once
+	stopChan     chan struct{}
+	stopOnce     sync.Once
+	codec        *codec.MsgpackHandle
+	options      forwardInputOptions
+	clients      map[uint64]*forwardClient
+	clientsMtx   sync.Mutex
+	clientsWg    sync.WaitGroup
+	shuttingDown int32
+	entries      int64
+	entriesMeter *ik.RateMeter
+	entriesByTag map[string]int64
+	entriesMtx   sync.Mutex
+	bytes        int64
+	connections  int64
+	rejected     int64
+	denied       int64
+	// the listeners are closed by either of Drain and Stop
+	closeOnce sync.Once
+	draining  int32
+	// warns that keepalive is ineffective just once
+	keepAliveWarningOnce sync.Once
+	// the buckets shared by the connections when throttled by tag
+	tagBuckets    map[string]*tokenBucket
+	tagBucketsMtx sync.Mutex
+	throttled     int64
+	slowClients   int64
+	evicted       int64
+	// the last id given to a client
+	lastClientId uint64
+	// the malformed messages skipped or sent to the dead letter tag
+	droppedFrames int64
+	// the time taken to decode the records out of each message
+	decodeTime *ik.Histogram
+	// the chunks acked recently, which are shared by the connections as
+	// the client resends a chunk on a new connection
+	ackedChunks *chunkCache
+	// the port the malformed messages are emitted to with error_policy
+	// dead_letter, which may be nil
+	deadLetterPort ik.Port
+}
+
+// a connection accepted on one of the listeners, or the error that stopped
+// the listener.
+type acceptedConn struct {
+	conn net.Conn
+	err  error
+}
+
+var errMessageTooLarge = errors.New("message exceeds max_message_size")
+
+// a message that has been read whole off the connection but can't be
+// decoded, which can be dropped without losing track of where the next
+// one starts.
+type malformedMessageError struct {
+	err error
+}
+
+func (err *malformedMessageError) Error() string {
+	return err.err.Error()
+}
+
+// options carried in the trailing element of forward protocol messages
+type forwardOptions struct {
+	chunk      string
+	compressed string
+	// the number of the entries in a PackedForward payload, if hasSize is
+	// set
+	size    uint64
+	hasSize bool
+	// set if the chunk has already been acked, in which case the records
+	// are skipped
+	acked bool
 }
 
 type EntryCountTopic struct{}
 
+type EntryRateTopic struct{}
+
+type EntryCountByTagTopic struct{}
+
+type ReceivedBytesTopic struct{}
+
 type ConnectionCountTopic struct{}
 
+type RejectedConnectionCountTopic struct{}
+
+type ThrottledCountTopic struct{}
+
+type DrainingTopic struct{}
+
+type DeniedConnectionCountTopic struct{}
+
+type SlowClientCountTopic struct{}
+
+type DroppedFrameCountTopic struct{}
+
+type EvictedConnectionCountTopic struct{}
+
 type ForwardInputFactory struct {
 }
 
 func coerceInPlace(data map[string]interface{}) {
 	for k, v := range data {
-		switch v_ := v.(type) {
-		case []byte:
-			data[k] = string(v_) // XXX: byte => rune
-		case map[string]interface{}:
-			coerceInPlace(v_)
+		data[k] = coerceValue(v)
+	}
+}
+
+func coerceValue(v interface{}) interface{} {
+	switch v_ := v.(type) {
+	case []byte:
+		return string(v_) // XXX: byte => rune
+	case map[string]interface{}:
+		coerceInPlace(v_)
+	case []interface{}:
+		for i, elem := range v_ {
+			v_[i] = coerceValue(elem)
 		}
 	}
+	return v
+}
+
+// splits a floating-point timestamp into the seconds and the nanoseconds.
+func splitFloatTimestamp(timestamp float64) (uint64, uint32) {
+	seconds := math.Floor(timestamp)
+	return uint64(seconds), uint32((timestamp - seconds) * 1e9)
 }
 
-func decodeRecordSet(tag []byte, entries []interface{}) (ik.FluentRecordSet, error) {
+func decodeRecordSet(tag []byte, entries []interface{}, keepRawBytes bool) (ik.FluentRecordSet, error) {
 	records := make([]ik.TinyFluentRecord, len(entries))
 	for i, _entry := range entries {
 		entry, ok := _entry.([]interface{})
 		if !ok {
 			return ik.FluentRecordSet{}, errors.New("Failed to decode recordSet")
 		}
-		timestamp, ok := entry[0].(uint64)
-		if !ok {
+		if len(entry) != 2 {
+			return ik.FluentRecordSet{}, errors.New(fmt.Sprintf("Failed to decode entry with %d fields", len(entry)))
+		}
+		var timestamp uint64
+		var nanoseconds uint32
+		switch timestamp_ := entry[0].(type) {
+		case uint64:
+			timestamp = timestamp_
+		case float64:
+			timestamp, nanoseconds = splitFloatTimestamp(timestamp_)
+		case ik.EventTime:
+			timestamp = timestamp_.Timestamp()
+			nanoseconds = timestamp_.Nanoseconds
+		default:
 			return ik.FluentRecordSet{}, errors.New("Failed to decode timestamp field")
 		}
 		data, ok := entry[1].(map[string]interface{})
 		if !ok {
 			return ik.FluentRecordSet{}, errors.New(fmt.Sprintf("Failed to decode data field", entry[1]))
 		}
-		coerceInPlace(data)
+		if !keepRawBytes {
+			coerceInPlace(data)
+		}
 		records[i] = ik.TinyFluentRecord{
-			Timestamp: timestamp,
-			Data:      data,
+			Timestamp:   timestamp,
+			Data:        data,
+			Nanoseconds: nanoseconds,
 		}
 	}
 	return ik.FluentRecordSet{
@@ -77,26 +359,408 @@ func decodeRecordSet(tag []byte, entries []interface{}) (ik.FluentRecordSet, err
 	}, nil
 }
 
-func (c *forwardClient) decodeEntries() ([]ik.FluentRecordSet, error) {
-	v := []interface{}{nil, nil, nil}
-	err := c.dec.Decode(&v)
+func decodeOptions(v []interface{}, i int) (forwardOptions, error) {
+	retval := forwardOptions{}
+	if i >= len(v) || v[i] == nil {
+		return retval, nil
+	}
+	options, ok := v[i].(map[string]interface{})
+	if !ok {
+		return retval, errors.New("Failed to decode option field")
+	}
+	chunk, ok := options["chunk"]
+	if ok {
+		chunk_, ok := toBytes(chunk)
+		if !ok {
+			return retval, errors.New("Failed to decode chunk option")
+		}
+		retval.chunk = string(chunk_)
+	}
+	compressed, ok := options["compressed"]
+	if ok {
+		compressed_, ok := toBytes(compressed)
+		if !ok {
+			return retval, errors.New("Failed to decode compressed option")
+		}
+		retval.compressed = string(compressed_)
+	}
+	size, ok := options["size"]
+	if ok {
+		switch size_ := size.(type) {
+		case uint64:
+			retval.size = size_
+		case int64:
+			if size_ < 0 {
+				return retval, errors.New("Failed to decode size option")
+			}
+			retval.size = uint64(size_)
+		case float64:
+			// the numbers come in as float64 with format json
+			if size_ < 0 || size_ >= math.MaxUint64 || size_ != math.Trunc(size_) {
+				return retval, errors.New("Failed to decode size option")
+			}
+			retval.size = uint64(size_)
+		default:
+			return retval, errors.New("Failed to decode size option")
+		}
+		retval.hasSize = true
+	}
+	return retval, nil
+}
+
+// decodes the concatenated entries in a PackedForward payload
+func decodePackedEntries(reader io.Reader, _codec *codec.MsgpackHandle) ([]interface{}, error) {
+	dec := codec.NewDecoder(reader, _codec)
+	entries := make([]interface{}, 0)
+	for {
+		var entry interface{}
+		err := dec.Decode(&entry)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// fails with errMessageTooLarge when more than the remaining bytes are
+// read, unlike io.LimitReader which just ends there.
+type sizeLimitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// the bytes beyond the limit are an error, but not the end
+		b := []byte{0}
+		n, err := io.ReadFull(r.reader, b)
+		if n > 0 {
+			return 0, errMessageTooLarge
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func (c *forwardClient) decodePackedForwardPayload(payload []byte, options forwardOptions) ([]interface{}, error) {
+	switch options.compressed {
+	case "":
+		return decodePackedEntries(bytes.NewReader(payload), c.codec)
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to decompress entries: %s", err.Error()))
+		}
+		defer reader.Close()
+		// the entries may inflate far beyond the compressed payload
+		var entriesReader io.Reader = reader
+		if c.input.options.maxMessageSize > 0 {
+			entriesReader = &sizeLimitedReader{reader: reader, remaining: c.input.options.maxMessageSize}
+		}
+		entries, err := decodePackedEntries(entriesReader, c.codec)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to decode compressed entries: %s", err.Error()))
+		}
+		return entries, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported compression format: %s", options.compressed))
+	}
+}
+
+type msgpackFrameReader struct {
+	reader io.Reader
+	limit  int64
+	buf    []byte
+}
+
+// the most bytes appendFull allocates ahead of those read
+const readGrowthSize = 64 * 1024
+
+// reads n bytes onto buf.  the buffer grows as the bytes arrive, so that a
+// bogus length in a header costs no more memory than the bytes sent.
+func appendFull(reader io.Reader, buf []byte, n int64) ([]byte, error) {
+	for n > 0 {
+		m := n
+		if m > readGrowthSize {
+			m = readGrowthSize
+		}
+		o := len(buf)
+		buf = append(buf, make([]byte, m)...)
+		_, err := io.ReadFull(reader, buf[o:])
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf, err
+		}
+		n -= m
+	}
+	return buf, nil
+}
+
+func (r *msgpackFrameReader) read(n int64, pending int64) ([]byte, error) {
+	if int64(len(r.buf))+n+pending > r.limit {
+		return nil, errMessageTooLarge
+	}
+	o := len(r.buf)
+	var err error
+	r.buf, err = appendFull(r.reader, r.buf, n)
 	if err != nil {
 		return nil, err
 	}
-	tag, ok := v[0].([]byte)
+	return r.buf[o:], nil
+}
+
+func (r *msgpackFrameReader) readUint(n int64, pending int64) (int64, error) {
+	b, err := r.read(n, pending)
+	if err != nil {
+		return 0, err
+	}
+	retval := int64(0)
+	for _, c := range b {
+		retval = retval<<8 | int64(c)
+	}
+	return retval, nil
+}
+
+// Reads a single msgpack object without decoding it, so that the lengths
+// declared in the headers can be checked against the limit before anything
+// is allocated for them.  Every pending element takes at least one byte,
+// which lets a bogus header be rejected right away.
+func (r *msgpackFrameReader) readFrame() ([]byte, error) {
+	r.buf = r.buf[:0]
+	pending := int64(1)
+	for pending > 0 {
+		pending -= 1
+		first := len(r.buf) == 0
+		b, err := r.read(1, pending)
+		if err != nil {
+			if first && err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, err
+		}
+		c := b[0]
+		var n int64
+		switch {
+		case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		case c&0xf0 == 0x80:
+			pending += int64(c&0x0f) * 2
+		case c&0xf0 == 0x90:
+			pending += int64(c & 0x0f)
+		case c&0xe0 == 0xa0:
+			_, err = r.read(int64(c&0x1f), pending)
+		case c == 0xc4 || c == 0xc5 || c == 0xc6: // bin 8, 16, 32
+			n, err = r.readUint(1<<(c-0xc4), pending)
+			if err == nil {
+				_, err = r.read(n, pending)
+			}
+		case c == 0xc7 || c == 0xc8 || c == 0xc9: // ext 8, 16, 32
+			n, err = r.readUint(1<<(c-0xc7), pending)
+			if err == nil {
+				_, err = r.read(n+1, pending)
+			}
+		case c == 0xca || c == 0xcb: // float 32, 64
+			_, err = r.read(4<<(c-0xca), pending)
+		case c >= 0xcc && c <= 0xcf: // uint 8, 16, 32, 64
+			_, err = r.read(1<<(c-0xcc), pending)
+		case c >= 0xd0 && c <= 0xd3: // int 8, 16, 32, 64
+			_, err = r.read(1<<(c-0xd0), pending)
+		case c >= 0xd4 && c <= 0xd8: // fixext 1, 2, 4, 8, 16
+			_, err = r.read(1+(1<<(c-0xd4)), pending)
+		case c >= 0xd9 && c <= 0xdb: // str 8, 16, 32
+			n, err = r.readUint(1<<(c-0xd9), pending)
+			if err == nil {
+				_, err = r.read(n, pending)
+			}
+		case c == 0xdc || c == 0xdd: // array 16, 32
+			n, err = r.readUint(2<<(c-0xdc), pending)
+			pending += n
+		case c == 0xde || c == 0xdf: // map 16, 32
+			n, err = r.readUint(2<<(c-0xde), pending)
+			pending += n * 2
+		default:
+			err = errors.New(fmt.Sprintf("Invalid msgpack descriptor: 0x%02x", c))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(r.buf))+pending > r.limit {
+			return nil, errMessageTooLarge
+		}
+	}
+	return r.buf, nil
+}
+
+// decodes the messages of the forward protocol off a connection and
+// encodes the responses to it, in the wire format specified by `format'.
+type forwardStream interface {
+	Decode(v *[]interface{}) error
+	Encode(v interface{}) error
+	// returns the bytes of the message last read, or nil if they are not
+	// kept
+	Frame() []byte
+}
+
+// the msgpack stream, which is what fluentd speaks.
+type msgpackForwardStream struct {
+	codec       *codec.MsgpackHandle
+	enc         *codec.Encoder
+	dec         *codec.Decoder
+	frameReader *msgpackFrameReader
+	// the buffer the encoder writes to, which is flushed per message
+	writer *bufio.Writer
+}
+
+func (stream *msgpackForwardStream) Decode(v *[]interface{}) error {
+	if stream.frameReader == nil {
+		return stream.dec.Decode(v)
+	}
+	frame, err := stream.frameReader.readFrame()
+	if err != nil {
+		return err
+	}
+	err = codec.NewDecoderBytes(frame, stream.codec).Decode(v)
+	if err != nil {
+		// the frame reader knows where the message ends
+		return &malformedMessageError{err}
+	}
+	return nil
+}
+
+func (stream *msgpackForwardStream) Frame() []byte {
+	if stream.frameReader == nil {
+		return nil
+	}
+	return stream.frameReader.buf
+}
+
+func (stream *msgpackForwardStream) Encode(v interface{}) error {
+	err := stream.enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	return stream.writer.Flush()
+}
+
+// the stream of JSON texts, each of which is preceded by its length in
+// 4-byte big endian.  the strings come in as they are, and the numbers as
+// float64.
+type jsonForwardStream struct {
+	reader io.Reader
+	writer io.Writer
+	limit  int64
+	// the message last read
+	payload []byte
+}
+
+func (stream *jsonForwardStream) Decode(v *[]interface{}) error {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(stream.reader, header)
+	if err != nil {
+		return err
+	}
+	n := int64(binary.BigEndian.Uint32(header))
+	if stream.limit > 0 && n > stream.limit {
+		return errMessageTooLarge
+	}
+	payload, err := appendFull(stream.reader, nil, n)
+	if err != nil {
+		return err
+	}
+	stream.payload = payload
+	err = json.Unmarshal(payload, v)
+	if err != nil {
+		return &malformedMessageError{err}
+	}
+	return nil
+}
+
+func (stream *jsonForwardStream) Frame() []byte {
+	return stream.payload
+}
+
+func (stream *jsonForwardStream) Encode(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	_, err = stream.writer.Write(append(b, payload...))
+	return err
+}
+
+func (c *forwardClient) decodeEntries() ([]ik.FluentRecordSet, forwardOptions, error) {
+	v := []interface{}{nil, nil, nil}
+	err := c.stream.Decode(&v)
+	atomic.StoreInt64(&c.pendingSince, 0)
+	if err != nil {
+		return nil, forwardOptions{}, err
+	}
+	decodeStart := time.Now()
+	atomic.StoreInt64(&c.lastActivity, decodeStart.UnixNano())
+	retval, options, err := c.decodeMessage(v)
+	if err != nil {
+		return nil, options, &malformedMessageError{err}
+	}
+	c.input.decodeTime.ObserveDuration(time.Since(decodeStart))
+	if c.input.ackedChunks.contains(options.chunk) {
+		// resent by the client that missed the ack
+		options.acked = true
+		return nil, options, nil
+	}
+	for i := range retval {
+		retval[i].Tag = c.input.options.rewriteTag(retval[i].Tag)
+	}
+	c.injectSource(retval)
+	c.input.countEntries(retval)
+	return retval, options, nil
+}
+
+// decodes the records and the options out of a message.
+func (c *forwardClient) decodeMessage(v []interface{}) ([]ik.FluentRecordSet, forwardOptions, error) {
+	if len(v) < 2 {
+		return nil, forwardOptions{}, errors.New("Unexpected payload format")
+	}
+	tag, ok := toBytes(v[0])
 	if !ok {
-		return nil, errors.New("Failed to decode tag field")
+		return nil, forwardOptions{}, errors.New("Failed to decode tag field")
+	}
+	if len(v) < 3 {
+		v = append(v, nil)
 	}
 
+	var options forwardOptions
+	var err error
+
 	var retval []ik.FluentRecordSet
 	switch timestamp_or_entries := v[1].(type) {
 	case uint64:
 		timestamp := timestamp_or_entries
+		options, err = decodeOptions(v, 3)
+		if err != nil {
+			return nil, options, err
+		}
 		data, ok := v[2].(map[string]interface{})
 		if !ok {
-			return nil, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
+			return nil, options, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
+		}
+		if !c.input.options.keepRawBytes {
+			coerceInPlace(data)
 		}
-		coerceInPlace(data)
 		retval = []ik.FluentRecordSet{
 			{
 				Tag: string(tag), // XXX: byte => rune
@@ -109,177 +773,1645 @@ func (c *forwardClient) decodeEntries() ([]ik.FluentRecordSet, error) {
 			},
 		}
 	case float64:
-		timestamp := uint64(timestamp_or_entries)
+		timestamp, nanoseconds := splitFloatTimestamp(timestamp_or_entries)
+		options, err = decodeOptions(v, 3)
+		if err != nil {
+			return nil, options, err
+		}
 		data, ok := v[2].(map[string]interface{})
 		if !ok {
-			return nil, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
+			return nil, options, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
+		}
+		if !c.input.options.keepRawBytes {
+			coerceInPlace(data)
 		}
 		retval = []ik.FluentRecordSet{
 			{
 				Tag: string(tag), // XXX: byte => rune
 				Records: []ik.TinyFluentRecord{
 					{
-						Timestamp: timestamp,
-						Data:      data,
+						Timestamp:   timestamp,
+						Data:        data,
+						Nanoseconds: nanoseconds,
 					},
 				},
 			},
 		}
-	case []interface{}:
+	case ik.EventTime:
+		timestamp := timestamp_or_entries.Timestamp()
+		nanoseconds := timestamp_or_entries.Nanoseconds
+		options, err = decodeOptions(v, 3)
+		if err != nil {
+			return nil, options, err
+		}
+		data, ok := v[2].(map[string]interface{})
 		if !ok {
-			return nil, errors.New("Unexpected payload format")
+			return nil, options, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
+		}
+		if !c.input.options.keepRawBytes {
+			coerceInPlace(data)
+		}
+		retval = []ik.FluentRecordSet{
+			{
+				Tag: string(tag), // XXX: byte => rune
+				Records: []ik.TinyFluentRecord{
+					{
+						Timestamp:   timestamp,
+						Data:        data,
+						Nanoseconds: nanoseconds,
+					},
+				},
+			},
+		}
+	case []interface{}:
+		options, err = decodeOptions(v, 2)
+		if err != nil {
+			return nil, options, err
 		}
-		recordSet, err := decodeRecordSet(tag, timestamp_or_entries)
+		recordSet, err := decodeRecordSet(tag, timestamp_or_entries, c.input.options.keepRawBytes)
 		if err != nil {
-			return nil, err
+			return nil, options, err
 		}
 		retval = []ik.FluentRecordSet{recordSet}
 	case []byte:
-		entries := make([]interface{}, 0)
-		err := codec.NewDecoderBytes(timestamp_or_entries, c.codec).Decode(&entries)
+		options, err = decodeOptions(v, 2)
 		if err != nil {
-			return nil, err
+			return nil, options, err
 		}
-		recordSet, err := decodeRecordSet(tag, entries)
+		entries, err := c.decodePackedForwardPayload(timestamp_or_entries, options)
 		if err != nil {
-			return nil, err
+			return nil, options, err
+		}
+		if c.input.options.strictSizeCheck && options.hasSize && options.size != uint64(len(entries)) {
+			// truncated or corrupted in transit
+			return nil, options, errors.New(fmt.Sprintf("PackedForward payload has %d entries while the size option says %d", len(entries), options.size))
+		}
+		recordSet, err := decodeRecordSet(tag, entries, c.input.options.keepRawBytes)
+		if err != nil {
+			return nil, options, err
 		}
 		retval = []ik.FluentRecordSet{recordSet}
 	default:
-		return nil, errors.New(fmt.Sprintf("Unknown type: %t", timestamp_or_entries))
+		return nil, options, errors.New(fmt.Sprintf("Unknown type: %t", timestamp_or_entries))
 	}
-	atomic.AddInt64(&c.input.entries, int64(len(retval)))
-	return retval, nil
+	return retval, options, nil
 }
 
-func handleInner(c *forwardClient) bool {
-	recordSets, err := c.decodeEntries()
-	defer func() {
-		if len(recordSets) > 0 {
-			err_ := c.input.Port().Emit(recordSets)
-			if err_ != nil {
-				c.logger.Error("%s", err_.Error())
-			}
-		}
-	}()
+// looks up the address and, if source_hostname_key is specified, the
+// hostname of the peer.  the hostname falls back to the address if the
+// reverse lookup fails.
+func (c *forwardClient) resolveSource() {
+	if c.sourceResolved {
+		return
+	}
+	c.sourceResolved = true
+	c.sourceAddress = c.RemoteAddr()
+	host, _, err := net.SplitHostPort(c.sourceAddress)
 	if err == nil {
-		return true
+		c.sourceAddress = host
 	}
-
-	err_, ok := err.(net.Error)
-	if ok {
-		if err_.Temporary() {
-			c.logger.Warning("Temporary failure: %s", err_.Error())
-			return true
-		}
+	if c.input.options.sourceHostnameKey == "" {
+		return
 	}
-	if err == io.EOF {
-		c.logger.Info("Client %s closed the connection", c.conn.RemoteAddr().String())
-	} else {
-		c.logger.Error("%s", err.Error())
+	c.sourceHostname = c.sourceAddress
+	names, err := net.LookupAddr(c.sourceAddress)
+	if err != nil {
+		c.logger.Info("Failed to resolve the hostname of %s: %s", c.sourceAddress, err.Error())
+		return
+	}
+	if len(names) > 0 {
+		c.sourceHostname = strings.TrimSuffix(names[0], ".")
 	}
-	return false
 }
 
-func (c *forwardClient) handle() {
-	for handleInner(c) {
+// adds the address and the hostname of the peer to the records.  the
+// values already in the records are left intact unless
+// overwrite_source_keys is set.
+func (c *forwardClient) injectSource(recordSets []ik.FluentRecordSet) {
+	options := &c.input.options
+	if options.sourceAddressKey == "" && options.sourceHostnameKey == "" {
+		return
 	}
-	err := c.conn.Close()
-	if err != nil {
-		c.logger.Warning("%s", err.Error())
+	c.resolveSource()
+	inject := func(data map[string]interface{}, key string, value string) {
+		if key == "" {
+			return
+		}
+		if _, ok := data[key]; ok && !options.overwriteSourceKeys {
+			return
+		}
+		data[key] = value
+	}
+	for _, recordSet := range recordSets {
+		for _, record := range recordSet.Records {
+			inject(record.Data, options.sourceAddressKey, c.sourceAddress)
+			inject(record.Data, options.sourceHostnameKey, c.sourceHostname)
+		}
 	}
-	c.input.markDischarged(c)
 }
 
-func newForwardClient(input *ForwardInput, logger ik.Logger, conn net.Conn, _codec *codec.MsgpackHandle) *forwardClient {
-	c := &forwardClient{
-		input:  input,
-		logger: logger,
-		conn:   conn,
-		codec:  _codec,
-		enc:    codec.NewEncoder(conn, _codec),
-		dec:    codec.NewDecoder(conn, _codec),
+func (input *ForwardInput) countEntries(recordSets []ik.FluentRecordSet) {
+	input.entriesMtx.Lock()
+	defer input.entriesMtx.Unlock()
+	for _, recordSet := range recordSets {
+		atomic.AddInt64(&input.entries, int64(len(recordSet.Records)))
+		input.entriesByTag[recordSet.Tag] += int64(len(recordSet.Records))
 	}
-	input.markCharged(c)
-	return c
+	input.entriesMeter.Record(atomic.LoadInt64(&input.entries), time.Now())
 }
 
-func (input *ForwardInput) Factory() ik.Plugin {
-	return input.factory
+type tagEntryCounts struct {
+	tags   []string
+	counts []int64
 }
 
-func (input *ForwardInput) Port() ik.Port {
-	return input.port
+func (counts *tagEntryCounts) Len() int { return len(counts.tags) }
+
+func (counts *tagEntryCounts) Less(i, j int) bool {
+	return counts.counts[i] > counts.counts[j] || (counts.counts[i] == counts.counts[j] && counts.tags[i] < counts.tags[j])
 }
 
-func (input *ForwardInput) Run() error {
-	conn, err := input.listener.Accept()
-	if err != nil {
-		input.logger.Warning("%s", err.Error())
-		return err
-	}
-	go newForwardClient(input, input.logger, conn, input.codec).handle()
-	return ik.Continue
+func (counts *tagEntryCounts) Swap(i, j int) {
+	counts.tags[i], counts.tags[j] = counts.tags[j], counts.tags[i]
+	counts.counts[i], counts.counts[j] = counts.counts[j], counts.counts[i]
 }
 
-func (input *ForwardInput) Shutdown() error {
-	for conn, _ := range input.clients {
-		err := conn.Close()
-		if err != nil {
-			input.logger.Warning("Error during closing connection: %s", err.Error())
-		}
-	}
-	return input.listener.Close()
+// EntryRate returns the number of the entries received per second over
+// the last minute.
+func (input *ForwardInput) EntryRate() float64 {
+	return input.entriesMeter.Rate(atomic.LoadInt64(&input.entries), time.Now())
 }
 
-func (input *ForwardInput) Dispose() {
-	input.Shutdown()
+// returns the tags and the number of the entries received for each of
+// them, the most frequent first.
+func (input *ForwardInput) entryCountsByTag() ([]string, []int64) {
+	input.entriesMtx.Lock()
+	retval := &tagEntryCounts{
+		tags:   make([]string, 0, len(input.entriesByTag)),
+		counts: make([]int64, 0, len(input.entriesByTag)),
+	}
+	for tag, count := range input.entriesByTag {
+		retval.tags = append(retval.tags, tag)
+		retval.counts = append(retval.counts, count)
+	}
+	input.entriesMtx.Unlock()
+	sort.Sort(retval)
+	return retval.tags, retval.counts
+}
+
+// When the client asks for an acknowledgement by specifying the chunk option,
+// it is sent back once the records have been handed off to the port without
+// an error.  If the connection gets lost before the client receives the ack,
+// the client will send the same chunk again, which is acked again without
+// emitting the records as long as the chunk is remembered.
+func (c *forwardClient) ack(chunk string) {
+	c.input.ackedChunks.add(chunk)
+	err := c.stream.Encode(map[string]interface{}{"ack": chunk})
+	if err != nil {
+		c.logger.Warning("Failed to send ack to %s: %s", c.RemoteAddr(), err.Error())
+	}
+}
+
+const (
+	backpressureInitialWait     = 10 * time.Millisecond
+	backpressureMaxWait         = time.Second
+	temporaryFailureInitialWait = 5 * time.Millisecond
+	temporaryFailureMaxWait     = time.Second
+)
+
+// emits the records to the input's port.
+func (c *forwardClient) emit(recordSets []ik.FluentRecordSet) bool {
+	return c.emitTo(c.input.Port(), recordSets)
+}
+
+// emits the records to the port.  while the downstream is under
+// backpressure, it keeps retrying the refused records without reading
+// further from the connection so that the client gets slowed down by TCP
+// flow control; the same goes for the worker pool with the "block"
+// overflow action, whose Emit simply blocks.  returns true if all the
+// records are accepted.
+func (c *forwardClient) emitTo(port ik.Port, recordSets []ik.FluentRecordSet) bool {
+	err := port.Emit(recordSets)
+	wait := backpressureInitialWait
+	for err != nil {
+		if err == ik.ErrBufferOverflow {
+			// dropped by the worker pool, which counts them.  the chunk is
+			// not acked so that the client sends it again.
+			return false
+		}
+		backpressureErr, ok := err.(*ik.BackpressureError)
+		if !ok {
+			c.logger.Error("%s", err.Error())
+			return false
+		}
+		if atomic.LoadInt32(&c.input.shuttingDown) != 0 {
+			c.logger.Error("Shutting down; %s", err.Error())
+			return false
+		}
+		time.Sleep(wait)
+		wait *= 2
+		if wait > backpressureMaxWait {
+			wait = backpressureMaxWait
+		}
+		err = backpressureErr.Retry()
+	}
+	return true
+}
+
+// a token bucket that holds up to a second worth of tokens.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mtx    sync.Mutex
+}
+
+// takes n tokens and returns how long to wait for them.  the tokens may
+// go negative for a batch larger than the bucket, which makes the
+// following ones wait longer.
+func (bucket *tokenBucket) take(n int, now time.Time) time.Duration {
+	bucket.mtx.Lock()
+	defer bucket.mtx.Unlock()
+	if now.After(bucket.last) {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+		if bucket.tokens > bucket.rate {
+			bucket.tokens = bucket.rate
+		}
+		bucket.last = now
+	}
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// remembers up to size chunk ids, the least recently acked ones being
+// forgotten first.
+type chunkCache struct {
+	size int
+	ids  map[string]*list.Element
+	lru  *list.List
+	mtx  sync.Mutex
+}
+
+func newChunkCache(size int) *chunkCache {
+	if size <= 0 {
+		return nil
+	}
+	return &chunkCache{
+		size: size,
+		ids:  make(map[string]*list.Element),
+		lru:  list.New(),
+	}
+}
+
+func (cache *chunkCache) add(chunk string) {
+	if cache == nil {
+		return
+	}
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	elem, ok := cache.ids[chunk]
+	if ok {
+		cache.lru.MoveToFront(elem)
+		return
+	}
+	cache.ids[chunk] = cache.lru.PushFront(chunk)
+	for cache.lru.Len() > cache.size {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.ids, oldest.Value.(string))
+	}
+}
+
+func (cache *chunkCache) contains(chunk string) bool {
+	if cache == nil || chunk == "" {
+		return false
+	}
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	_, ok := cache.ids[chunk]
+	return ok
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (input *ForwardInput) tagBucket(tag string) *tokenBucket {
+	input.tagBucketsMtx.Lock()
+	defer input.tagBucketsMtx.Unlock()
+	bucket, ok := input.tagBuckets[tag]
+	if !ok {
+		if input.tagBuckets == nil {
+			input.tagBuckets = make(map[string]*tokenBucket)
+		}
+		bucket = newTokenBucket(input.options.recordsPerSecond)
+		input.tagBuckets[tag] = bucket
+	}
+	return bucket
+}
+
+// holds off reading further from the connection while the records exceed
+// records_per_second, so that the client gets slowed down by TCP flow
+// control.
+func (c *forwardClient) throttle(recordSets []ik.FluentRecordSet) {
+	options := &c.input.options
+	if options.recordsPerSecond <= 0 {
+		return
+	}
+	now := time.Now()
+	wait := time.Duration(0)
+	if options.throttleByTag {
+		for _, recordSet := range recordSets {
+			wait_ := c.input.tagBucket(recordSet.Tag).take(len(recordSet.Records), now)
+			if wait_ > wait {
+				wait = wait_
+			}
+		}
+	} else {
+		if c.bucket == nil {
+			c.bucket = newTokenBucket(options.recordsPerSecond)
+		}
+		n := 0
+		for _, recordSet := range recordSets {
+			n += len(recordSet.Records)
+		}
+		wait = c.bucket.take(n, now)
+	}
+	if wait == 0 {
+		return
+	}
+	atomic.AddInt64(&c.input.throttled, 1)
+	select {
+	case <-time.After(wait):
+	case <-c.input.stopChan:
+	}
+}
+
+func handleInner(c *forwardClient) bool {
+	recordSets, options, ok := c.readEntries()
+	if options.acked {
+		c.ack(options.chunk)
+	} else if len(recordSets) > 0 && c.emit(recordSets) && options.chunk != "" {
+		c.ack(options.chunk)
+	}
+	return ok
+}
+
+// reads the next message off the connection.  returns false if the
+// connection is no longer to be read.
+func (c *forwardClient) readEntries() ([]ik.FluentRecordSet, forwardOptions, bool) {
+	readTimeout := c.input.options.readTimeout
+	if readTimeout > 0 {
+		err := c.setReadDeadline(time.Now().Add(readTimeout))
+		if err != nil {
+			c.logger.Error("%s", err.Error())
+			return nil, forwardOptions{}, false
+		}
+	}
+	recordSets, options, err := c.decodeEntries()
+	if err == nil {
+		c.temporaryFailureWait = 0
+		c.throttle(recordSets)
+		return recordSets, options, true
+	}
+	if atomic.LoadInt32(&c.disconnected) != 0 {
+		// the reason has been logged
+		return nil, options, false
+	}
+
+	malformed, ok := err.(*malformedMessageError)
+	if ok && c.input.options.dropsMalformedMessages() {
+		return c.dropMalformedMessage(malformed, options)
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) {
+		// reported as temporary, but the connection is gone for good
+		c.logger.Info("Client %s reset the connection", c.RemoteAddr())
+		return nil, options, false
+	}
+	err_, ok := err.(net.Error)
+	if ok {
+		if err_.Timeout() {
+			c.logger.Info("Client %s timed out", c.RemoteAddr())
+			return nil, options, false
+		}
+		if err_.Temporary() {
+			c.logger.Warning("Temporary failure: %s", err_.Error())
+			// back off so as not to spin while the failure persists
+			if c.temporaryFailureWait == 0 {
+				c.temporaryFailureWait = temporaryFailureInitialWait
+			} else {
+				c.temporaryFailureWait *= 2
+				if c.temporaryFailureWait > temporaryFailureMaxWait {
+					c.temporaryFailureWait = temporaryFailureMaxWait
+				}
+			}
+			time.Sleep(c.temporaryFailureWait)
+			return nil, options, true
+		}
+	}
+	if err == errMessageTooLarge {
+		c.logger.Error("Message from %s exceeds max_message_size (%d bytes)", c.RemoteAddr(), c.input.options.maxMessageSize)
+	} else if errors.Is(err, io.EOF) {
+		c.logger.Info("Client %s closed the connection", c.RemoteAddr())
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		// the client went away while sending a message, which is dropped
+		// unacknowledged so that it gets resent
+		c.logger.Info("Client %s closed the connection in the middle of a message", c.RemoteAddr())
+	} else if errors.Is(err, net.ErrClosed) || atomic.LoadInt32(&c.input.shuttingDown) != 0 {
+		c.logger.Debug("Connection from %s closed: %s", c.RemoteAddr(), err.Error())
+	} else {
+		c.logger.Error("%s", err.Error())
+	}
+	return nil, options, false
+}
+
+// drops a malformed message according to error_policy, and goes on to the
+// next one.  the chunk is acked if the options could be decoded, so that
+// the client doesn't keep resending the message that would never be
+// accepted.  with "dead_letter", the message is turned into a record
+// carrying the bytes of the message in base64, which is emitted to the
+// dead letter port with the reason before the chunk is acked.
+func (c *forwardClient) dropMalformedMessage(err *malformedMessageError, options forwardOptions) ([]ik.FluentRecordSet, forwardOptions, bool) {
+	atomic.AddInt64(&c.input.droppedFrames, 1)
+	c.logger.Warning("Dropped a malformed message from %s: %s", c.RemoteAddr(), err.Error())
+	if c.input.options.errorPolicy == "dead_letter" && c.input.deadLetterPort != nil {
+		if !c.emitTo(ik.WithDeadLetterReason(c.input.deadLetterPort, err.Error()), c.deadLetters()) {
+			return nil, options, true
+		}
+	}
+	options.acked = options.chunk != ""
+	return nil, options, true
+}
+
+// the record in place of the malformed message last read.
+func (c *forwardClient) deadLetters() []ik.FluentRecordSet {
+	data := map[string]interface{}{}
+	frame := c.stream.Frame()
+	if frame != nil {
+		data["message"] = base64.StdEncoding.EncodeToString(frame)
+	}
+	recordSets := []ik.FluentRecordSet{
+		{
+			Tag: c.input.options.deadLetterTag,
+			Records: []ik.TinyFluentRecord{
+				{
+					Timestamp: uint64(time.Now().Unix()),
+					Data:      data,
+				},
+			},
+		},
+	}
+	c.injectSource(recordSets)
+	return recordSets
+}
+
+// a message read off the connection, waiting to be emitted in a batch.
+type forwardMessage struct {
+	recordSets []ik.FluentRecordSet
+	chunk      string
+	acked      bool
+}
+
+// the messages coalesced into a batch.  the record sets with the same tag
+// in a row are merged.
+type forwardBatch struct {
+	recordSets []ik.FluentRecordSet
+	chunks     []string
+	size       int
+}
+
+func (batch *forwardBatch) add(message forwardMessage) {
+	for _, recordSet := range message.recordSets {
+		n := len(batch.recordSets)
+		if n > 0 && batch.recordSets[n-1].Tag == recordSet.Tag {
+			batch.recordSets[n-1].Records = append(batch.recordSets[n-1].Records, recordSet.Records...)
+		} else {
+			batch.recordSets = append(batch.recordSets, recordSet)
+		}
+		batch.size += len(recordSet.Records)
+	}
+	if message.chunk != "" {
+		batch.chunks = append(batch.chunks, message.chunk)
+	}
+}
+
+// emits the batch and acks the chunks in it once all of them are accepted.
+func (c *forwardClient) flushBatch(batch *forwardBatch) {
+	if len(batch.recordSets) > 0 && c.emit(batch.recordSets) {
+		for _, chunk := range batch.chunks {
+			c.ack(chunk)
+		}
+	}
+	*batch = forwardBatch{}
+}
+
+// emits the messages in batches of emit_batch_size records, or whatever
+// has been read within emit_batch_wait.  the messages are read on another
+// goroutine, which is blocked while a batch is being emitted, so the
+// client still gets slowed down under backpressure.
+func (c *forwardClient) handleInBatches() {
+	messages := make(chan forwardMessage)
+	go func() {
+		defer close(messages)
+		for {
+			recordSets, options, ok := c.readEntries()
+			if len(recordSets) > 0 || options.acked {
+				messages <- forwardMessage{recordSets: recordSets, chunk: options.chunk, acked: options.acked}
+			}
+			if !ok || atomic.LoadInt32(&c.input.shuttingDown) != 0 {
+				return
+			}
+		}
+	}()
+	batch := forwardBatch{}
+	var timeout <-chan time.Time
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				// flush what is left on close
+				c.flushBatch(&batch)
+				return
+			}
+			if message.acked {
+				c.ack(message.chunk)
+				continue
+			}
+			batch.add(message)
+			if batch.size >= c.input.options.emitBatchSize {
+				c.flushBatch(&batch)
+				timeout = nil
+			} else if timeout == nil {
+				timeout = time.After(c.input.options.emitBatchWait)
+			}
+		case <-timeout:
+			c.flushBatch(&batch)
+			timeout = nil
+		}
+	}
+}
+
+// the address of the peer, as given when the client was created.
+func (c *forwardClient) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// sets the deadline of the next read if the connection supports one, as
+// the net.Conn ones do.
+func (c *forwardClient) setReadDeadline(deadline time.Time) error {
+	conn, ok := c.conn.(interface {
+		SetReadDeadline(time.Time) error
+	})
+	if !ok {
+		return nil
+	}
+	return conn.SetReadDeadline(deadline)
+}
+
+// sets the deadline of both the reads and the writes, as the handshake
+// blocks on either when the peer stalls.
+func (c *forwardClient) setDeadline(deadline time.Time) error {
+	conn, ok := c.conn.(interface {
+		SetDeadline(time.Time) error
+	})
+	if !ok {
+		return nil
+	}
+	return conn.SetDeadline(deadline)
+}
+
+func (c *forwardClient) handshake() bool {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	err := tlsConn.Handshake()
+	if err != nil {
+		c.logger.Error("TLS handshake with %s failed: %s", c.RemoteAddr(), err.Error())
+		return false
+	}
+	return true
+}
+
+func toBytes(v interface{}) ([]byte, bool) {
+	switch v_ := v.(type) {
+	case []byte:
+		return v_, true
+	case string:
+		return []byte(v_), true
+	}
+	return nil, false
+}
+
+// computes the digest exchanged in PING / PONG messages:
+// hex(sha512(salt + hostname + nonce + shared_key))
+func computeSharedKeyDigest(salt []byte, hostname []byte, nonce []byte, sharedKey string) []byte {
+	h := sha512.New()
+	h.Write(salt)
+	h.Write(hostname)
+	h.Write(nonce)
+	h.Write([]byte(sharedKey))
+	sum := h.Sum(nil)
+	retval := make([]byte, hex.EncodedLen(len(sum)))
+	hex.Encode(retval, sum)
+	return retval
+}
+
+// computes the digest of the password in PING messages:
+// hex(sha512(auth_salt + username + password))
+func computePasswordDigest(authSalt []byte, username []byte, password string) []byte {
+	h := sha512.New()
+	h.Write(authSalt)
+	h.Write(username)
+	h.Write([]byte(password))
+	sum := h.Sum(nil)
+	retval := make([]byte, hex.EncodedLen(len(sum)))
+	hex.Encode(retval, sum)
+	return retval
+}
+
+func (c *forwardClient) authenticate() error {
+	options := &c.input.options
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	// an empty auth salt tells the client that no username is required
+	var authSalt []byte
+	var auth interface{} = ""
+	if options.userAuth {
+		authSalt = make([]byte, 16)
+		_, err = rand.Read(authSalt)
+		if err != nil {
+			return err
+		}
+		auth = authSalt
+	}
+	err = c.stream.Encode([]interface{}{
+		"HELO",
+		map[string]interface{}{
+			"nonce":     nonce,
+			"auth":      auth,
+			"keepalive": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ping := []interface{}{}
+	err = c.stream.Decode(&ping)
+	atomic.StoreInt64(&c.pendingSince, 0)
+	if err != nil {
+		return err
+	}
+	if len(ping) < 4 {
+		return errors.New("Malformed PING message")
+	}
+	type_, ok := toBytes(ping[0])
+	if !ok || string(type_) != "PING" {
+		return errors.New("Expected PING message")
+	}
+	hostname, ok := toBytes(ping[1])
+	if !ok {
+		return errors.New("Failed to decode hostname field of PING message")
+	}
+	salt, ok := toBytes(ping[2])
+	if !ok {
+		return errors.New("Failed to decode salt field of PING message")
+	}
+	digest, ok := toBytes(ping[3])
+	if !ok {
+		return errors.New("Failed to decode digest field of PING message")
+	}
+	if subtle.ConstantTimeCompare(digest, computeSharedKeyDigest(salt, hostname, nonce, options.sharedKey)) != 1 {
+		c.stream.Encode([]interface{}{"PONG", false, "shared_key mismatch", options.selfHostname, ""})
+		return errors.New(fmt.Sprintf("shared_key mismatch (client hostname: %s)", string(hostname)))
+	}
+	if options.userAuth {
+		var username, passwordDigest []byte
+		if len(ping) >= 6 {
+			username, _ = toBytes(ping[4])
+			passwordDigest, _ = toBytes(ping[5])
+		}
+		password, ok := options.users[string(username)]
+		if !ok || subtle.ConstantTimeCompare(passwordDigest, computePasswordDigest(authSalt, username, password)) != 1 {
+			c.stream.Encode([]interface{}{"PONG", false, "username/password mismatch", options.selfHostname, ""})
+			return errors.New(fmt.Sprintf("username/password mismatch (client hostname: %s, username: %s)", string(hostname), string(username)))
+		}
+	}
+	return c.stream.Encode([]interface{}{
+		"PONG",
+		true,
+		"",
+		options.selfHostname,
+		computeSharedKeyDigest(salt, []byte(options.selfHostname), nonce, options.sharedKey),
+	})
+}
+
+func (c *forwardClient) handle() {
+	handshakeTimeout := c.input.options.handshakeTimeout
+	if handshakeTimeout > 0 {
+		c.setDeadline(time.Now().Add(handshakeTimeout))
+	}
+	authenticated := c.handshake()
+	if authenticated && c.input.options.sharedKey != "" {
+		err := c.authenticate()
+		if err != nil {
+			c.logger.Error("Authentication of %s failed: %s", c.RemoteAddr(), err.Error())
+			authenticated = false
+		}
+	}
+	if authenticated && handshakeTimeout > 0 {
+		err := c.setDeadline(time.Time{})
+		if err != nil {
+			c.logger.Error("%s", err.Error())
+			authenticated = false
+		}
+	}
+	if authenticated && c.input.options.emitBatchSize > 0 {
+		c.handleInBatches()
+	} else if authenticated {
+		for handleInner(c) {
+			if atomic.LoadInt32(&c.input.shuttingDown) != 0 {
+				break
+			}
+		}
+	}
+	err := c.conn.Close()
+	if err != nil && atomic.LoadInt32(&c.disconnected) == 0 {
+		c.logger.Warning("%s", err.Error())
+	}
+	c.input.markDischarged(c)
+	c.input.clientsWg.Done()
+}
+
+// enables TCP keepalive on the connection.  it has no effect on the
+// connections other than plain TCP ones, such as those over TLS, which is
+// logged only once per input.
+func (c *forwardClient) setKeepAlive() {
+	conn := c.conn
+	if upgraded, ok := conn.(*websocketConn); ok {
+		conn = upgraded.Conn
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		c.input.keepAliveWarningOnce.Do(func() {
+			c.logger.Warning("keepalive has no effect on %T connections", conn)
+		})
+		return
+	}
+	err := tcpConn.SetKeepAlive(true)
+	if err == nil && c.input.options.keepAlivePeriod > 0 {
+		err = tcpConn.SetKeepAlivePeriod(c.input.options.keepAlivePeriod)
+	}
+	if err != nil {
+		c.logger.Warning("Failed to enable keepalive for %s: %s", c.RemoteAddr(), err.Error())
+	}
+}
+
+func newForwardClient(input *ForwardInput, logger ik.Logger, conn io.ReadWriteCloser, remoteAddr string, _codec *codec.MsgpackHandle) *forwardClient {
+	c := &forwardClient{
+		input:      input,
+		logger:     logger,
+		id:         atomic.AddUint64(&input.lastClientId, 1),
+		conn:       conn,
+		remoteAddr: remoteAddr,
+		codec:      _codec,
+		bytes:      0,
+		// idle since accepted
+		lastActivity: time.Now().UnixNano(),
+	}
+	if input.options.keepAlive {
+		c.setKeepAlive()
+	}
+	// the buffer is owned by the client so that the bytes read ahead are
+	// left for the next message
+	var reader io.Reader = &countingReader{reader: conn, client: c}
+	if input.options.readBufferSize > 0 {
+		reader = bufio.NewReaderSize(reader, int(input.options.readBufferSize))
+	}
+	switch input.options.format {
+	case "json":
+		c.stream = &jsonForwardStream{
+			reader: reader,
+			writer: conn,
+			limit:  input.options.maxMessageSize,
+		}
+	default:
+		writer := bufio.NewWriter(conn)
+		stream := &msgpackForwardStream{
+			codec:  _codec,
+			enc:    codec.NewEncoder(writer, _codec),
+			dec:    codec.NewDecoder(reader, _codec),
+			writer: writer,
+		}
+		if input.options.maxMessageSize > 0 {
+			stream.frameReader = &msgpackFrameReader{
+				reader: reader,
+				limit:  input.options.maxMessageSize,
+				buf:    make([]byte, 0, 4096),
+			}
+		} else if input.options.dropsMalformedMessages() {
+			// msgpack has no framing of its own, so the messages are read
+			// off the connection whole before being decoded, which is the
+			// only way to tell where a malformed message ends
+			stream.frameReader = &msgpackFrameReader{
+				reader: reader,
+				limit:  math.MaxInt32,
+				buf:    make([]byte, 0, 4096),
+			}
+		}
+		c.stream = stream
+	}
+	input.clientsWg.Add(1)
+	input.markCharged(c)
+	return c
+}
+
+func (input *ForwardInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+// Id returns the id given with @id, or the empty string.
+func (input *ForwardInput) Id() string {
+	return input.options.id
+}
+
+func (input *ForwardInput) Port() ik.Port {
+	return input.port
+}
+
+// accepts the connections on the listener and hands them to Run until the
+// listener is closed.
+func (input *ForwardInput) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		select {
+		case input.accepted <- acceptedConn{conn: conn, err: err}:
+		case <-input.stopChan:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (input *ForwardInput) Run() error {
+	if len(input.listeners) == 0 {
+		return errors.New(fmt.Sprintf("%s is not bound", strings.Join(input.binds, ", ")))
+	}
+	input.acceptOnce.Do(func() {
+		for _, listener := range input.listeners {
+			go input.accept(listener)
+		}
+		if input.options.minBytesPerSec > 0 {
+			go input.checkSlowClients()
+		}
+	})
+	var accepted acceptedConn
+	select {
+	case accepted = <-input.accepted:
+	case <-input.stopChan:
+		return nil
+	}
+	conn, err := accepted.conn, accepted.err
+	if err != nil {
+		if atomic.LoadInt32(&input.draining) != 0 {
+			// the listener has been closed by Drain; keep running until
+			// Stop
+			return ik.Continue
+		}
+		input.logger.Warning("%s", err.Error())
+		// nothing runs the input any longer, so the other listeners are
+		// closed as well rather than left accepting the connections
+		input.Stop()
+		return err
+	}
+	if !input.isAllowed(conn.RemoteAddr()) {
+		input.logger.Warning("Denied connection from %s", conn.RemoteAddr().String())
+		atomic.AddInt64(&input.denied, 1)
+		err := conn.Close()
+		if err != nil {
+			input.logger.Warning("Error during closing connection: %s", err.Error())
+		}
+		return ik.Continue
+	}
+	maxConnections := input.options.maxConnections
+	if maxConnections > 0 && atomic.LoadInt64(&input.connections) >= int64(maxConnections) && !(input.options.evictIdle && input.evictIdleClient()) {
+		input.logger.Warning("Rejected connection from %s (max_connections %d reached)", conn.RemoteAddr().String(), maxConnections)
+		atomic.AddInt64(&input.rejected, 1)
+		err := conn.Close()
+		if err != nil {
+			input.logger.Warning("Error during closing connection: %s", err.Error())
+		}
+		return ik.Continue
+	}
+	go newForwardClient(input, input.logger, conn, conn.RemoteAddr().String(), input.codec).handle()
+	return ik.Continue
+}
+
+// the number of the samples of the bytes received within
+// slow_client_window, which are taken at the interval of a tenth of it.
+const slowClientSamples = 10
+
+// samples the bytes received by the client and disconnects it if a message
+// has been pending for the whole window with fewer bytes received than
+// min_bytes_per_sec.  the clients idling between messages are left to
+// read_timeout.
+func (c *forwardClient) checkSlow(now time.Time) bool {
+	options := &c.input.options
+	pendingSince := atomic.LoadInt64(&c.pendingSince)
+	if pendingSince == 0 {
+		c.byteSamples = c.byteSamples[:0]
+		return false
+	}
+	c.byteSamples = append(c.byteSamples, atomic.LoadInt64(&c.bytes))
+	if len(c.byteSamples) > slowClientSamples+1 {
+		c.byteSamples = c.byteSamples[1:]
+	}
+	if len(c.byteSamples) <= slowClientSamples || now.Sub(time.Unix(0, pendingSince)) < options.slowClientWindow {
+		return false
+	}
+	received := c.byteSamples[slowClientSamples] - c.byteSamples[0]
+	if float64(received) >= float64(options.minBytesPerSec)*options.slowClientWindow.Seconds() {
+		return false
+	}
+	atomic.StoreInt32(&c.disconnected, 1)
+	c.logger.Warning("Disconnecting slow client %s (%d bytes in %s, below min_bytes_per_sec %d)", c.RemoteAddr(), received, options.slowClientWindow.String(), options.minBytesPerSec)
+	err := c.conn.Close()
+	if err != nil {
+		c.logger.Warning("Error during closing connection: %s", err.Error())
+	}
+	return true
+}
+
+// closes the connection whose client has been idle the longest to make
+// room for a new one.  a client in the middle of a message counts as active.
+// the connection is counted until its goroutine notices it closed, so
+// max_connections may be exceeded for a moment.  returns false if there is
+// no connection to close.
+func (input *ForwardInput) evictIdleClient() bool {
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	var idlest *forwardClient
+	idlestActivity := int64(0)
+	for _, c := range input.clients {
+		if atomic.LoadInt32(&c.disconnected) != 0 {
+			continue
+		}
+		activity := atomic.LoadInt64(&c.lastActivity)
+		if pendingSince := atomic.LoadInt64(&c.pendingSince); pendingSince > activity {
+			activity = pendingSince
+		}
+		if idlest == nil || activity < idlestActivity {
+			idlest, idlestActivity = c, activity
+		}
+	}
+	if idlest == nil {
+		return false
+	}
+	atomic.StoreInt32(&idlest.disconnected, 1)
+	atomic.AddInt64(&input.evicted, 1)
+	input.logger.Info("Evicting client %s idle for %s (max_connections %d reached)", idlest.RemoteAddr(), time.Since(time.Unix(0, idlestActivity)).String(), input.options.maxConnections)
+	err := idlest.conn.Close()
+	if err != nil {
+		input.logger.Warning("Error during closing connection: %s", err.Error())
+	}
+	return true
+}
+
+// checks the clients for the slow ones until stopped.
+func (input *ForwardInput) checkSlowClients() {
+	ticker := time.NewTicker(input.options.slowClientWindow / slowClientSamples)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			input.clientsMtx.Lock()
+			for _, c := range input.clients {
+				if atomic.LoadInt32(&c.disconnected) == 0 && c.checkSlow(now) {
+					atomic.AddInt64(&input.slowClients, 1)
+				}
+			}
+			input.clientsMtx.Unlock()
+		case <-input.stopChan:
+			return
+		}
+	}
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// tells if the address is allowed to connect by the allow and deny lists.
+func (input *ForwardInput) isAllowed(addr net.Addr) bool {
+	options := &input.options
+	if len(options.allow) == 0 && len(options.deny) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if containsIP(options.deny, tcpAddr.IP) {
+		return false
+	}
+	return len(options.allow) == 0 || containsIP(options.allow, tcpAddr.IP)
+}
+
+func (input *ForwardInput) waitForClients(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		input.clientsWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (input *ForwardInput) closeClients() {
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	for _, c := range input.clients {
+		err := c.conn.Close()
+		if err != nil {
+			input.logger.Warning("Error during closing connection: %s", err.Error())
+		}
+	}
+}
+
+// Binds the addresses.  It does nothing if the input is built with a
+// listener, and can be called again if it fails.
+func (input *ForwardInput) Start() error {
+	if len(input.listeners) > 0 {
+		return nil
+	}
+	listeners := make([]net.Listener, 0, len(input.binds))
+	for _, bind := range input.binds {
+		var listener net.Listener
+		var err error
+		if input.options.reusePort {
+			listener, err = listenReusingPort("tcp", bind)
+		} else {
+			listener, err = net.Listen("tcp", bind)
+		}
+		if err != nil {
+			input.logger.Warning("%s", err.Error())
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return err
+		}
+		if input.options.tlsConfig != nil {
+			listener = tls.NewListener(listener, input.options.tlsConfig)
+		}
+		if input.options.websocketPath != "" {
+			listener = newWebSocketListener(listener, input.options.websocketPath, input.logger)
+		}
+		input.logger.Info("Listening on %s", listener.Addr().String())
+		listeners = append(listeners, listener)
+	}
+	input.listeners = listeners
+	return nil
+}
+
+// Addrs returns the addresses the input is listening on, which are known
+// once started.
+func (input *ForwardInput) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(input.listeners))
+	for _, listener := range input.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// Addr returns the first of the addresses the input is listening on, or
+// nil if not started.
+func (input *ForwardInput) Addr() net.Addr {
+	if len(input.listeners) == 0 {
+		return nil
+	}
+	return input.listeners[0].Addr()
+}
+
+// Unbinds the addresses so that no more connections are accepted.  The
+// connections already accepted are left intact.
+func (input *ForwardInput) closeListeners() error {
+	var retval error
+	input.closeOnce.Do(func() {
+		for _, listener := range input.listeners {
+			err := listener.Close()
+			if err != nil {
+				retval = err
+			}
+		}
+	})
+	return retval
+}
+
+func (input *ForwardInput) Stop() error {
+	var retval error
+	input.stopOnce.Do(func() {
+		close(input.stopChan)
+		retval = input.closeListeners()
+	})
+	return retval
+}
+
+// Drain stops accepting connections, leaving the clients being handled
+// until they close the connections or the input is shut down.
+func (input *ForwardInput) Drain() error {
+	if !atomic.CompareAndSwapInt32(&input.draining, 0, 1) {
+		return nil
+	}
+	input.logger.Info("Draining %s", strings.Join(input.binds, ", "))
+	return input.closeListeners()
+}
+
+// Stops accepting connections first, and then gives the clients being
+// handled a chance to finish the current cycle for up to shutdown_timeout
+// before closing the connections forcibly.
+func (input *ForwardInput) Shutdown() error {
+	err := input.Stop()
+	atomic.StoreInt32(&input.shuttingDown, 1)
+	shutdownTimeout := input.options.shutdownTimeout
+	if shutdownTimeout > 0 && !input.waitForClients(shutdownTimeout) {
+		input.logger.Warning("Some clients did not finish within %s; closing the connections", shutdownTimeout.String())
+	}
+	input.closeClients()
+	return err
+}
+
+func (input *ForwardInput) Dispose() {
+	input.Shutdown()
 }
 
 func (input *ForwardInput) markCharged(c *forwardClient) {
-	input.clients[c.conn] = c
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	input.clients[c.id] = c
+	atomic.AddInt64(&input.connections, 1)
 }
 
 func (input *ForwardInput) markDischarged(c *forwardClient) {
-	delete(input.clients, c.conn)
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	delete(input.clients, c.id)
+	atomic.AddInt64(&input.connections, -1)
 }
 
-func newForwardInput(factory *ForwardInputFactory, logger ik.Logger, engine ik.Engine, bind string, port ik.Port) (*ForwardInput, error) {
+func newForwardCodec() *codec.MsgpackHandle {
 	_codec := codec.MsgpackHandle{}
 	_codec.MapType = reflect.TypeOf(map[string]interface{}(nil))
 	_codec.RawToString = false
-	listener, err := net.Listen("tcp", bind)
+	_codec.AddExt(
+		reflect.TypeOf(ik.EventTime{}),
+		ik.EventTimeExtType,
+		func(rv reflect.Value) ([]byte, error) {
+			return rv.Interface().(ik.EventTime).Bytes(), nil
+		},
+		func(rv reflect.Value, b []byte) error {
+			eventTime, err := ik.DecodeEventTime(b)
+			if err != nil {
+				return err
+			}
+			rv.Set(reflect.ValueOf(eventTime))
+			return nil
+		},
+	)
+	return &_codec
+}
+
+func newForwardInputFromListener(factory *ForwardInputFactory, logger ik.Logger, bind string, listener net.Listener, port ik.Port, options forwardInputOptions) *ForwardInput {
+	input := newForwardInputForBinds(factory, logger, []string{bind}, port, options)
+	input.listeners = []net.Listener{listener}
+	return input
+}
+
+func newForwardInputForBinds(factory *ForwardInputFactory, logger ik.Logger, binds []string, port ik.Port, options forwardInputOptions) *ForwardInput {
+	decodeTimeBuckets := options.decodeTimeBuckets
+	if len(decodeTimeBuckets) == 0 {
+		decodeTimeBuckets = ik.DefaultHistogramBounds
+	}
+	return &ForwardInput{
+		factory:      factory,
+		port:         port,
+		logger:       logger,
+		binds:        binds,
+		accepted:     make(chan acceptedConn),
+		stopChan:     make(chan struct{}),
+		codec:        newForwardCodec(),
+		options:      options,
+		clients:      make(map[uint64]*forwardClient),
+		clientsMtx:   sync.Mutex{},
+		entries:      0,
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+		entriesByTag: make(map[string]int64),
+		connections:  0,
+		rejected:     0,
+		ackedChunks:  newChunkCache(options.chunkCacheSize),
+		decodeTime:   ik.NewHistogram(decodeTimeBuckets),
+	}
+}
+
+// builds the input listening on the addresses without binding them,
+// which is done by Start.
+func newForwardInput(factory *ForwardInputFactory, logger ik.Logger, engine ik.Engine, binds []string, port ik.Port, options forwardInputOptions) (*ForwardInput, error) {
+	for _, bind := range binds {
+		_, _, err := net.SplitHostPort(bind)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newForwardInputForBinds(factory, logger, binds, port, options), nil
+}
+
+func (factory *ForwardInputFactory) Name() string {
+	return "forward"
+}
+
+// parses the comma-separated durations in ascending order into the upper
+// bounds of the buckets in seconds.
+func parseDurationBuckets(key string, value string) ([]float64, error) {
+	retval := make([]float64, 0)
+	for _, item := range strings.Split(value, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(item))
+		if err != nil || duration <= 0 {
+			return nil, errors.New(fmt.Sprintf("invalid %s: %s", key, strconv.Quote(value)))
+		}
+		if len(retval) > 0 && duration.Seconds() <= retval[len(retval)-1] {
+			return nil, errors.New(fmt.Sprintf("%s must be in ascending order", key))
+		}
+		retval = append(retval, duration.Seconds())
+	}
+	return retval, nil
+}
+
+func lookupTransportConfig(config *ik.ConfigElement) (map[string]string, bool) {
+	for _, elem := range config.Elems {
+		if elem.Name == "transport" {
+			return elem.Attrs, elem.Args == "tls"
+		}
+	}
+	transport, ok := config.Attrs["transport"]
+	if !ok {
+		return config.Attrs, false
+	}
+	return config.Attrs, transport == "tls"
+}
+
+func buildTLSConfig(attrs map[string]string) (*tls.Config, error) {
+	certPath, ok := attrs["cert"]
+	if !ok {
+		return nil, errors.New("required attribute `cert' is not specified")
+	}
+	keyPath, ok := attrs["key"]
+	if !ok {
+		return nil, errors.New("required attribute `key' is not specified")
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.NoClientCert,
+	}
+	caPath, ok := attrs["ca"]
+	if ok {
+		pem, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("no valid certificates found in %s", caPath))
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	verifyPeerStr, ok := attrs["verify_peer"]
+	if ok {
+		verifyPeer, err := strconv.ParseBool(verifyPeerStr)
+		if err != nil {
+			return nil, err
+		}
+		if verifyPeer {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
+
+// parses the comma-separated networks in CIDR notation, or the addresses,
+// which are taken as the networks of the single addresses.
+func parseNetworks(key string, value string) ([]*net.IPNet, error) {
+	retval := make([]*net.IPNet, 0)
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New(fmt.Sprintf("invalid %s: %s", key, strconv.Quote(s)))
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		retval = append(retval, network)
+	}
+	return retval, nil
+}
+
+func (factory *ForwardInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	// listens on every combination of the addresses and the ports
+	binds := make([]string, 0)
+	for _, listen := range strings.Split(config.AttrString("listen", ""), ",") {
+		for _, netPort := range strings.Split(config.AttrString("port", "24224"), ",") {
+			netPort = strings.TrimSpace(netPort)
+			_, err := strconv.Atoi(netPort)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid port: %s", strconv.Quote(netPort)))
+			}
+			binds = append(binds, net.JoinHostPort(strings.TrimSpace(listen), netPort))
+		}
+	}
+	var err error
+	options := forwardInputOptions{}
+	transportAttrs, useTLS := lookupTransportConfig(config)
+	if useTLS {
+		options.tlsConfig, err = buildTLSConfig(transportAttrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	options.id = config.AttrString("@id", "")
+	options.sharedKey = config.AttrString("shared_key", "")
+	options.format = config.AttrString("format", "msgpack")
+	switch options.format {
+	case "msgpack":
+	case "json":
+		// the nonce and the salt are binary, which JSON can't carry
+		if options.sharedKey != "" {
+			return nil, errors.New("shared_key is not supported with format json")
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Format `%s' is not supported", options.format))
+	}
+	options.userAuth, err = config.AttrBool("user_auth", false)
+	if err != nil {
+		return nil, err
+	}
+	if options.userAuth {
+		if options.sharedKey == "" {
+			return nil, errors.New("user_auth requires shared_key")
+		}
+		options.users = make(map[string]string)
+		for _, elem := range config.Elems {
+			if elem.Name != "user" {
+				continue
+			}
+			username, ok := elem.Attrs["username"]
+			if !ok {
+				return nil, errors.New("required attribute `username' is not specified in <user>")
+			}
+			password, ok := elem.Attrs["password"]
+			if !ok {
+				return nil, errors.New("required attribute `password' is not specified in <user>")
+			}
+			options.users[username] = password
+		}
+		if len(options.users) == 0 {
+			return nil, errors.New("user_auth requires at least one <user>")
+		}
+	}
+	options.handshakeTimeout, err = config.AttrDuration("handshake_timeout", 10*time.Second)
 	if err != nil {
-		logger.Warning("%s", err.Error())
 		return nil, err
 	}
-	return &ForwardInput{
-		factory:  factory,
-		port:     port,
-		logger:   logger,
-		bind:     bind,
-		listener: listener,
-		codec:    &_codec,
-		clients:  make(map[net.Conn]*forwardClient),
-		entries:  0,
-	}, nil
-}
-
-func (factory *ForwardInputFactory) Name() string {
-	return "forward"
-}
-
-func (factory *ForwardInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
-	listen, ok := config.Attrs["listen"]
+	var ok bool
+	options.selfHostname, ok = config.Attrs["self_hostname"]
 	if !ok {
-		listen = ""
+		options.selfHostname, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
 	}
-	netPort, ok := config.Attrs["port"]
-	if !ok {
-		netPort = "24224"
+	options.maxConnections, err = config.AttrInt("max_connections", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.maxMessageSize, err = config.AttrCapacity("max_message_size", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.shutdownTimeout, err = config.AttrDuration("shutdown_timeout", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.readTimeout, err = config.AttrDuration("read_timeout", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.keepRawBytes, err = config.AttrBool("keep_raw_bytes", false)
+	if err != nil {
+		return nil, err
+	}
+	options.keepAlive, err = config.AttrBool("keepalive", false)
+	if err != nil {
+		return nil, err
+	}
+	options.keepAlivePeriod, err = config.AttrDuration("keepalive_timeout", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.reusePort, err = config.AttrBool("reuse_port", false)
+	if err != nil {
+		return nil, err
+	}
+	options.emitBatchSize, err = config.AttrInt("emit_batch_size", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.emitBatchWait, err = config.AttrDuration("emit_batch_wait", 100*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	if options.emitBatchSize > 0 && options.emitBatchWait <= 0 {
+		return nil, errors.New("emit_batch_wait must be positive")
+	}
+	recordsPerSecond, err := config.AttrInt("records_per_second", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.recordsPerSecond = float64(recordsPerSecond)
+	switch throttleBy := config.AttrString("throttle_by", "connection"); throttleBy {
+	case "connection":
+	case "tag":
+		options.throttleByTag = true
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid throttle_by: %s", strconv.Quote(throttleBy)))
+	}
+	options.sourceAddressKey = config.AttrString("source_address_key", "")
+	options.sourceHostnameKey = config.AttrString("source_hostname_key", "")
+	options.overwriteSourceKeys, err = config.AttrBool("overwrite_source_keys", false)
+	if err != nil {
+		return nil, err
+	}
+	options.chunkCacheSize, err = config.AttrInt("chunk_cache_size", 1024)
+	if err != nil {
+		return nil, err
+	}
+	options.minBytesPerSec, err = config.AttrCapacity("min_bytes_per_sec", 0)
+	if err != nil {
+		return nil, err
+	}
+	options.slowClientWindow, err = config.AttrDuration("slow_client_window", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if options.minBytesPerSec > 0 && options.slowClientWindow < slowClientSamples*time.Millisecond {
+		return nil, errors.New("slow_client_window is too short")
+	}
+	for _, key := range []string{"allow", "deny"} {
+		value, ok := config.Attrs[key]
+		if !ok {
+			continue
+		}
+		networks, err := parseNetworks(key, value)
+		if err != nil {
+			return nil, err
+		}
+		if key == "allow" {
+			options.allow = networks
+		} else {
+			options.deny = networks
+		}
+	}
+	options.tag = config.AttrString("tag", "")
+	options.removeTagPrefix = config.AttrString("remove_tag_prefix", "")
+	options.addTagPrefix = config.AttrString("add_tag_prefix", "")
+	options.strictSizeCheck, err = config.AttrBool("strict_size_check", true)
+	if err != nil {
+		return nil, err
+	}
+	options.errorPolicy = config.AttrString("error_policy", "disconnect")
+	switch options.errorPolicy {
+	case "disconnect", "skip", "dead_letter":
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid error_policy: %s", strconv.Quote(options.errorPolicy)))
+	}
+	options.deadLetterTag = config.AttrString("dead_letter_tag", "ik.dead_letter")
+	websocket, err := config.AttrBool("websocket", false)
+	if err != nil {
+		return nil, err
+	}
+	if websocket {
+		options.websocketPath = config.AttrString("websocket_path", "/")
+		if !strings.HasPrefix(options.websocketPath, "/") {
+			return nil, errors.New("websocket_path must start with /")
+		}
+	}
+	switch evictionPolicy := config.AttrString("eviction_policy", "reject"); evictionPolicy {
+	case "reject":
+	case "evict_idle":
+		options.evictIdle = true
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid eviction_policy: %s", strconv.Quote(evictionPolicy)))
+	}
+	decodeTimeBuckets, ok := config.Attrs["decode_time_buckets"]
+	if ok {
+		options.decodeTimeBuckets, err = parseDurationBuckets("decode_time_buckets", decodeTimeBuckets)
+		if err != nil {
+			return nil, err
+		}
+	}
+	options.readBufferSize, err = config.AttrCapacity("read_buffer_size", 4096)
+	if err != nil {
+		return nil, err
+	}
+	if options.readBufferSize < 0 {
+		return nil, errors.New("read_buffer_size must not be negative")
+	}
+	input, err := newForwardInput(factory, engine.Logger(), engine, binds, engine.DefaultPort(), options)
+	if err != nil {
+		return nil, err
+	}
+	input.deadLetterPort = engine.DeadLetterPort()
+	return input, nil
+}
+
+func (factory *ForwardInputFactory) AcceptedAttributes() []string {
+	return []string{
+		"listen",
+		"port",
+		"format",
+		"transport",
+		"cert",
+		"key",
+		"ca",
+		"verify_peer",
+		"shared_key",
+		"self_hostname",
+		"user_auth",
+		"handshake_timeout",
+		"max_connections",
+		"max_message_size",
+		"shutdown_timeout",
+		"read_timeout",
+		"keep_raw_bytes",
+		"keepalive",
+		"keepalive_timeout",
+		"reuse_port",
+		"emit_batch_size",
+		"emit_batch_wait",
+		"records_per_second",
+		"throttle_by",
+		"source_address_key",
+		"source_hostname_key",
+		"overwrite_source_keys",
+		"chunk_cache_size",
+		"read_buffer_size",
+		"min_bytes_per_sec",
+		"slow_client_window",
+		"allow",
+		"deny",
+		"tag",
+		"remove_tag_prefix",
+		"add_tag_prefix",
+		"strict_size_check",
+		"error_policy",
+		"dead_letter_tag",
+		"eviction_policy",
+		"decode_time_buckets",
+		"websocket",
+		"websocket_path",
 	}
-	bind := listen + ":" + netPort
-	return newForwardInput(factory, engine.Logger(), engine, bind, engine.DefaultPort())
 }
 
 func (factory *ForwardInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
@@ -290,6 +2422,27 @@ func (factory *ForwardInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper)
 		Description: "Total number of entries received so far",
 		Fetcher:     &EntryCountTopic{},
 	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "entries_per_second",
+		DisplayName: "Entries per second",
+		Description: "Number of entries received per second over the last minute",
+		Fetcher:     &EntryRateTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "entries_by_tag",
+		DisplayName: "Entries by tag",
+		Description: "Number of entries received so far for each tag",
+		Fetcher:     &EntryCountByTagTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "bytes",
+		DisplayName: "Bytes received",
+		Description: "Total number of bytes received from the clients so far",
+		Fetcher:     &ReceivedBytesTopic{},
+	})
 	scorekeeper.AddTopic(ik.ScorekeeperTopic{
 		Plugin:      factory,
 		Name:        "connections",
@@ -297,6 +2450,64 @@ func (factory *ForwardInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper)
 		Description: "Number of connections currently handled",
 		Fetcher:     &ConnectionCountTopic{},
 	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "rejected_connections",
+		DisplayName: "Rejected connections",
+		Description: "Number of connections rejected due to max_connections",
+		Fetcher:     &RejectedConnectionCountTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "denied_connections",
+		DisplayName: "Denied connections",
+		Description: "Number of connections denied due to allow or deny",
+		Fetcher:     &DeniedConnectionCountTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "throttled",
+		DisplayName: "Throttled",
+		Description: "Number of times reading was paused due to records_per_second",
+		Fetcher:     &ThrottledCountTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "draining",
+		DisplayName: "Draining",
+		Description: "Whether new connections are no longer accepted",
+		Fetcher:     &DrainingTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "slow_clients",
+		DisplayName: "Slow clients",
+		Description: "Number of clients disconnected due to min_bytes_per_sec",
+		Fetcher:     &SlowClientCountTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "dropped_frames",
+		DisplayName: "Dropped frames",
+		Description: "Number of malformed messages dropped according to error_policy",
+		Fetcher:     &DroppedFrameCountTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "evicted_connections",
+		DisplayName: "Evicted connections",
+		Description: "Number of idle connections closed to make room for new ones due to max_connections",
+		Fetcher:     &EvictedConnectionCountTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "decode_seconds",
+		DisplayName: "Decode time",
+		Description: "Time taken to decode the records out of each message",
+		Fetcher: &ik.ScorekeeperHistogram{Histogram: func(input ik.PluginInstance) *ik.Histogram {
+			return input.(*ForwardInput).decodeTime
+		}},
+	})
 }
 
 func (topic *EntryCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
@@ -308,8 +2519,68 @@ func (topic *EntryCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error
 }
 
 func (topic *EntryCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *EntryCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	return atomic.LoadInt64(&input.entries), nil
+}
+
+func (topic *EntryRateTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *EntryRateTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *EntryRateTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	return input_.(*ForwardInput).EntryRate(), nil
+}
+
+func (topic *EntryCountByTagTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	tags, counts := input_.(*ForwardInput).entryCountsByTag()
+	chunks := make([]ik.MarkupChunk, 0, len(tags)*2)
+	for i, tag := range tags {
+		text := ": " + strconv.FormatInt(counts[i], 10)
+		if i < len(tags)-1 {
+			text += "\n"
+		}
+		chunks = append(chunks, ik.MarkupChunk{Attrs: ik.Embolden, Text: tag}, ik.MarkupChunk{Text: text})
+	}
+	return ik.Markup{chunks}, nil
+}
+
+func (topic *EntryCountByTagTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	tags, counts := input_.(*ForwardInput).entryCountsByTag()
+	lines := make([]string, len(tags))
+	for i, tag := range tags {
+		lines[i] = tag + ": " + strconv.FormatInt(counts[i], 10)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (topic *ReceivedBytesTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *ReceivedBytesTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	input := input_.(*ForwardInput)
+	return ik.FormatCapacity(atomic.LoadInt64(&input.bytes)), nil
+}
+
+func (topic *ReceivedBytesTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
 	input := input_.(*ForwardInput)
-	return strconv.FormatInt(input.entries, 10), nil
+	return atomic.LoadInt64(&input.bytes), nil
 }
 
 func (topic *ConnectionCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
@@ -321,8 +2592,133 @@ func (topic *ConnectionCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup,
 }
 
 func (topic *ConnectionCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *ConnectionCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	return int64(len(input.clients)), nil
+}
+
+func (topic *RejectedConnectionCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *RejectedConnectionCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *RejectedConnectionCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	return atomic.LoadInt64(&input.rejected), nil
+}
+
+func (topic *ThrottledCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *ThrottledCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *ThrottledCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	return atomic.LoadInt64(&input.throttled), nil
+}
+
+func (topic *DrainingTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *DrainingTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *DrainingTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	return atomic.LoadInt32(&input.draining) != 0, nil
+}
+
+func (topic *SlowClientCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *SlowClientCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *SlowClientCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	return atomic.LoadInt64(&input.slowClients), nil
+}
+
+func (topic *DeniedConnectionCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *DeniedConnectionCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *DeniedConnectionCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	return atomic.LoadInt64(&input.denied), nil
+}
+
+func (topic *DroppedFrameCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *DroppedFrameCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *DroppedFrameCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*ForwardInput)
+	return atomic.LoadInt64(&input.droppedFrames), nil
+}
+
+func (topic *EvictedConnectionCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *EvictedConnectionCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *EvictedConnectionCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
 	input := input_.(*ForwardInput)
-	return strconv.Itoa(len(input.clients)), nil // XXX: race
+	return atomic.LoadInt64(&input.evicted), nil
 }
 
 var _ = AddPlugin(&ForwardInputFactory{})
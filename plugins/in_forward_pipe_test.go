@@ -0,0 +1,235 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"github.com/ugorji/go/codec"
+	"net"
+	"testing"
+	"time"
+)
+
+// the other end of an in-memory connection to a forward client, through
+// which the tests send the messages and receive the acks as a forwarder
+// would.
+type testForwarder struct {
+	t    *testing.T
+	conn net.Conn
+	enc  *codec.Encoder
+	dec  *codec.Decoder
+	done chan struct{}
+}
+
+// an input with the options that emits to the returned port.
+func newTestForwardInput(options forwardInputOptions) (*ForwardInput, chanPort) {
+	port := make(chanPort, 100)
+	return &ForwardInput{
+		port:         port,
+		codec:        newForwardCodec(),
+		clients:      make(map[uint64]*forwardClient),
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+		options:      options,
+		decodeTime:   ik.NewHistogram(ik.DefaultHistogramBounds),
+	}, port
+}
+
+// connects a client of the input to a forwarder through a pipe, and starts
+// handling it.
+func newTestForwardClient(t *testing.T, input *ForwardInput) (*forwardClient, *testForwarder) {
+	conn, peer := net.Pipe()
+	c := newForwardClient(input, &testLogger{t}, conn, "pipe", input.codec)
+	forwarder := &testForwarder{
+		t:    t,
+		conn: peer,
+		enc:  codec.NewEncoder(peer, input.codec),
+		dec:  codec.NewDecoder(peer, input.codec),
+		done: make(chan struct{}),
+	}
+	go func() {
+		c.handle()
+		close(forwarder.done)
+	}()
+	return c, forwarder
+}
+
+// encodes the message, which is an array in one of the forward modes.
+func (forwarder *testForwarder) send(message ...interface{}) {
+	forwarder.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	err := forwarder.enc.Encode(message)
+	if err != nil {
+		forwarder.t.Log(err.Error())
+		forwarder.t.FailNow()
+	}
+}
+
+// sends the bytes as they are, e.g. a frame encoded beforehand.
+func (forwarder *testForwarder) sendBytes(b []byte) {
+	forwarder.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, err := forwarder.conn.Write(b)
+	if err != nil {
+		forwarder.t.Log(err.Error())
+		forwarder.t.FailNow()
+	}
+}
+
+// receives the next ack and returns the chunk id in it.
+func (forwarder *testForwarder) receiveAck() string {
+	forwarder.conn.SetReadDeadline(time.Now().Add(time.Second))
+	ack := map[string]interface{}{}
+	err := forwarder.dec.Decode(&ack)
+	if err != nil {
+		forwarder.t.Log(err.Error())
+		forwarder.t.FailNow()
+	}
+	chunk, _ := toBytes(ack["ack"])
+	return string(chunk)
+}
+
+// closes the connection and waits for the client to finish.
+func (forwarder *testForwarder) close() {
+	forwarder.conn.Close()
+	select {
+	case <-forwarder.done:
+	case <-time.After(time.Second):
+		forwarder.t.FailNow()
+	}
+}
+
+func TestForwardClient_handle_Pipe(t *testing.T) {
+	input, port := newTestForwardInput(forwardInputOptions{sourceAddressKey: "addr"})
+	_, forwarder := newTestForwardClient(t, input)
+	forwarder.send("tag", []interface{}{
+		[]interface{}{uint64(1), map[string]interface{}{"a": "b"}},
+		[]interface{}{uint64(2), map[string]interface{}{"a": "c"}},
+	}, map[string]interface{}{"chunk": "1"})
+	if forwarder.receiveAck() != "1" {
+		t.Fail()
+	}
+	forwarder.sendBytes(buildCompressedPackedForwardMessage(t, "other", 3))
+	forwarder.close()
+	if len(port) != 2 {
+		t.FailNow()
+	}
+	recordSets := <-port
+	if recordSets[0].Tag != "tag" || len(recordSets[0].Records) != 2 || recordSets[0].Records[1].Data["addr"] != "pipe" {
+		t.Log(recordSets)
+		t.Fail()
+	}
+	recordSets = <-port
+	if recordSets[0].Tag != "other" || len(recordSets[0].Records) != 3 {
+		t.Log(recordSets)
+		t.Fail()
+	}
+	if input.entries != 5 || len(input.clients) != 0 || input.decodeTime.Snapshot().Count != 2 {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_handle_ShortEntry(t *testing.T) {
+	input, port := newTestForwardInput(forwardInputOptions{errorPolicy: "skip"})
+	input.ackedChunks = newChunkCache(2)
+	_, forwarder := newTestForwardClient(t, input)
+	for i, entries := range [][]interface{}{
+		{[]interface{}{}},
+		{[]interface{}{uint64(1)}},
+		{[]interface{}{uint64(1), map[string]interface{}{"a": "b"}}},
+	} {
+		chunk := string('1' + rune(i))
+		forwarder.send("tag", entries, map[string]interface{}{"chunk": chunk})
+		if forwarder.receiveAck() != chunk {
+			t.Fail()
+		}
+	}
+	forwarder.close()
+	if input.droppedFrames != 2 || len(port) != 1 {
+		t.Log(input.droppedFrames)
+		t.Fail()
+	}
+}
+
+// answers the HELO with a PING, and returns the PONG.
+func (forwarder *testForwarder) ping(sharedKey string, username string, password string) []interface{} {
+	forwarder.conn.SetReadDeadline(time.Now().Add(time.Second))
+	helo := []interface{}{}
+	err := forwarder.dec.Decode(&helo)
+	if err != nil || len(helo) != 2 {
+		forwarder.t.FailNow()
+	}
+	heloOptions := helo[1].(map[string]interface{})
+	nonce, _ := toBytes(heloOptions["nonce"])
+	authSalt, _ := toBytes(heloOptions["auth"])
+	salt := []byte("salt")
+	forwarder.send(
+		"PING",
+		"client",
+		salt,
+		computeSharedKeyDigest(salt, []byte("client"), nonce, sharedKey),
+		username,
+		computePasswordDigest(authSalt, []byte(username), password),
+	)
+	forwarder.conn.SetReadDeadline(time.Now().Add(time.Second))
+	pong := []interface{}{}
+	err = forwarder.dec.Decode(&pong)
+	if err != nil || len(pong) != 5 {
+		forwarder.t.FailNow()
+	}
+	if pong[1] == true {
+		digest, _ := toBytes(pong[4])
+		if string(digest) != string(computeSharedKeyDigest(salt, []byte("server"), nonce, sharedKey)) {
+			forwarder.t.Fail()
+		}
+	}
+	return pong
+}
+
+func TestForwardClient_handle_Authenticate(t *testing.T) {
+	options := forwardInputOptions{
+		sharedKey:        "key",
+		selfHostname:     "server",
+		userAuth:         true,
+		users:            map[string]string{"user": "password"},
+		handshakeTimeout: time.Second,
+	}
+	for _, credentials := range [][3]string{
+		{"wrong", "user", "password"},
+		{"key", "user", "wrong"},
+		{"key", "nobody", "password"},
+		{"key", "user", "password"},
+	} {
+		input, port := newTestForwardInput(options)
+		_, forwarder := newTestForwardClient(t, input)
+		pong := forwarder.ping(credentials[0], credentials[1], credentials[2])
+		authenticated := credentials == [3]string{"key", "user", "password"}
+		hostname, _ := toBytes(pong[3])
+		if pong[1] != authenticated || string(hostname) != "server" {
+			t.Log(credentials, pong)
+			t.Fail()
+		}
+		if authenticated {
+			forwarder.send("tag", uint64(1), map[string]interface{}{"a": "b"})
+		}
+		forwarder.close()
+		// nothing is taken from the clients that failed to authenticate
+		if authenticated && (len(port) != 1 || input.entries != 1) {
+			t.Fail()
+		} else if !authenticated && (len(port) != 0 || input.entries != 0) {
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardClient_handle_HandshakeTimeout(t *testing.T) {
+	input, _ := newTestForwardInput(forwardInputOptions{
+		sharedKey:        "key",
+		selfHostname:     "server",
+		handshakeTimeout: 10 * time.Millisecond,
+	})
+	_, forwarder := newTestForwardClient(t, input)
+	// the client that never answers is disconnected
+	select {
+	case <-forwarder.done:
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+	forwarder.conn.Close()
+}
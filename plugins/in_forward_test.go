@@ -0,0 +1,1780 @@
+package plugins
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/iktest"
+	"github.com/ugorji/go/codec"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestForwardClientForBytes(b []byte) *forwardClient {
+	_codec := newForwardCodec()
+	input := &ForwardInput{
+		codec:        _codec,
+		clients:      make(map[uint64]*forwardClient),
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+	}
+	return &forwardClient{
+		input: input,
+		codec: _codec,
+		stream: &msgpackForwardStream{
+			codec: _codec,
+			dec:   codec.NewDecoderBytes(b, _codec),
+		},
+	}
+}
+
+func TestForwardClient_decodeEntries_EventTime(t *testing.T) {
+	b := []byte{
+		0x93,                // fixarray (3)
+		0xa3, 't', 'a', 'g', // "tag"
+		0xd7, 0x00, // fixext 8, type 0
+		0x54, 0x00, 0x00, 0x01, // seconds (1409286145)
+		0x07, 0x5b, 0xcd, 0x15, // nanoseconds (123456789)
+		0x81,                 // fixmap (1)
+		0xa1, 'k', 0xa1, 'v', // "k": "v"
+	}
+	c := newTestForwardClientForBytes(b)
+	recordSets, _, err := c.decodeEntries()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(recordSets) != 1 || len(recordSets[0].Records) != 1 {
+		t.FailNow()
+	}
+	if recordSets[0].Tag != "tag" {
+		t.Fail()
+	}
+	record := recordSets[0].Records[0]
+	if record.Timestamp != 1409286145 || record.Nanoseconds != 123456789 {
+		t.Logf("%d.%09d", record.Timestamp, record.Nanoseconds)
+		t.Fail()
+	}
+	if record.Data["k"] != "v" {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_decodeEntries_EventTimeInForwardMode(t *testing.T) {
+	b := []byte{
+		0x92,                // fixarray (2)
+		0xa3, 't', 'a', 'g', // "tag"
+		0x91,       // fixarray (1)
+		0x92,       // fixarray (2)
+		0xd7, 0x00, // fixext 8, type 0
+		0x54, 0x00, 0x00, 0x01,
+		0x07, 0x5b, 0xcd, 0x15,
+		0x80, // fixmap (0)
+	}
+	c := newTestForwardClientForBytes(b)
+	recordSets, _, err := c.decodeEntries()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(recordSets) != 1 || len(recordSets[0].Records) != 1 {
+		t.FailNow()
+	}
+	if recordSets[0].Records[0].Timestamp != 1409286145 {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_decodeEntries_FloatTimestampIsCoerced(t *testing.T) {
+	decode := func(timestamp interface{}) ik.TinyFluentRecord {
+		b := []byte{}
+		err := codec.NewEncoderBytes(&b, newForwardCodec()).Encode([]interface{}{
+			"tag",
+			timestamp,
+			map[string]interface{}{"k": "v", "nested": map[string]interface{}{"x": "y"}},
+		})
+		if err != nil {
+			t.FailNow()
+		}
+		recordSets, _, err := newTestForwardClientForBytes(b).decodeEntries()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if len(recordSets) != 1 || len(recordSets[0].Records) != 1 {
+			t.FailNow()
+		}
+		return recordSets[0].Records[0]
+	}
+	integer := decode(uint64(1409286145))
+	float := decode(float64(1409286145))
+	fractional := decode(float64(1409286145.25))
+	if fractional.Timestamp != 1409286145 || fractional.Nanoseconds != 250000000 {
+		t.Logf("%d.%09d", fractional.Timestamp, fractional.Nanoseconds)
+		t.Fail()
+	}
+	if !fractional.Time().Equal(time.Unix(1409286145, 250000000)) {
+		t.Fail()
+	}
+	if !reflect.DeepEqual(integer, float) {
+		t.Log(integer, float)
+		t.Fail()
+	}
+	if float.Data["k"] != "v" || float.Data["nested"].(map[string]interface{})["x"] != "y" {
+		t.Log(float.Data)
+		t.Fail()
+	}
+}
+
+func TestForwardClient_decodeEntries_ArrayIsCoerced(t *testing.T) {
+	b := []byte{
+		0x93,                // fixarray (3)
+		0xa3, 't', 'a', 'g', // "tag"
+		0xce, 0x54, 0x00, 0x01, 0x07, // uint32 (1409286407)
+		0x81,                     // fixmap (1)
+		0xa4, 't', 'a', 'g', 's', // "tags"
+		0x93,      // fixarray (3)
+		0xa1, 'a', // "a"
+		0xa1, 'b', // "b"
+		0x81, 0xa1, 'k', 0x91, 0xa1, 'v', // {"k": ["v"]}
+	}
+	recordSets, _, err := newTestForwardClientForBytes(b).decodeEntries()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	tags, ok := recordSets[0].Records[0].Data["tags"].([]interface{})
+	if !ok || len(tags) != 3 {
+		t.FailNow()
+	}
+	if tags[0] != "a" || tags[1] != "b" {
+		t.Log(tags)
+		t.Fail()
+	}
+	nested, ok := tags[2].(map[string]interface{})
+	if !ok || !reflect.DeepEqual(nested["k"], []interface{}{"v"}) {
+		t.Log(tags[2])
+		t.Fail()
+	}
+}
+
+func TestForwardClient_decodeEntries_KeepRawBytes(t *testing.T) {
+	b := []byte{
+		0x92,                // fixarray (2)
+		0xa3, 't', 'a', 'g', // "tag"
+		0x91,                         // fixarray (1)
+		0x92,                         // fixarray (2)
+		0xce, 0x54, 0x00, 0x01, 0x07, // uint32 (1409286407)
+		0x81,                 // fixmap (1)
+		0xa1, 'k', 0xa1, 'v', // "k": "v"
+	}
+	c := newTestForwardClientForBytes(b)
+	c.input.options.keepRawBytes = true
+	recordSets, _, err := c.decodeEntries()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(recordSets[0].Records[0].Data["k"], []byte("v")) {
+		t.Log(recordSets[0].Records[0].Data)
+		t.Fail()
+	}
+}
+
+func buildCompressedPackedForwardMessage(t *testing.T, tag string, n int) []byte {
+	_codec := newForwardCodec()
+	entries := []byte{}
+	enc := codec.NewEncoderBytes(&entries, _codec)
+	for i := 0; i < n; i += 1 {
+		err := enc.Encode([]interface{}{uint64(1409286145 + i), map[string]interface{}{"seq": i}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	compressed := bytes.Buffer{}
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(entries)
+	writer.Close()
+	retval := []byte{}
+	err := codec.NewEncoderBytes(&retval, _codec).Encode([]interface{}{
+		tag,
+		compressed.Bytes(),
+		map[string]interface{}{"compressed": "gzip"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	return retval
+}
+
+func buildPackedForwardMessageWithSize(t *testing.T, n int, size interface{}) []byte {
+	_codec := newForwardCodec()
+	entries := []byte{}
+	enc := codec.NewEncoderBytes(&entries, _codec)
+	for i := 0; i < n; i += 1 {
+		err := enc.Encode([]interface{}{uint64(1409286145 + i), map[string]interface{}{"seq": i}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	retval := []byte{}
+	err := codec.NewEncoderBytes(&retval, _codec).Encode([]interface{}{
+		"tag",
+		entries,
+		map[string]interface{}{"size": size},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	return retval
+}
+
+func TestForwardClient_decodeEntries_SizeOption(t *testing.T) {
+	cases := []struct {
+		n      int
+		size   interface{}
+		strict bool
+		ok     bool
+	}{
+		{3, 3, true, true},
+		{3, 4, true, false},
+		{3, 2, true, false},
+		{3, "3", true, false},
+		{3, 4, false, true},
+	}
+	for _, case_ := range cases {
+		c := newTestForwardClientForBytes(buildPackedForwardMessageWithSize(t, case_.n, case_.size))
+		c.input.options.strictSizeCheck = case_.strict
+		recordSets, options, err := c.decodeEntries()
+		if (err == nil) != case_.ok {
+			t.Logf("%+v: %v", case_, err)
+			t.Fail()
+			continue
+		}
+		if err == nil && len(recordSets[0].Records) != case_.n {
+			t.Fail()
+		}
+		if case_.strict && case_.ok && (!options.hasSize || options.size != uint64(case_.n)) {
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardClient_decodeEntries_SizeOption_JSON(t *testing.T) {
+	cases := []struct {
+		size interface{}
+		ok   bool
+	}{
+		{2, true},
+		{1.5, false},
+		{-2, false},
+	}
+	for _, case_ := range cases {
+		buf := &bytes.Buffer{}
+		err := (&jsonForwardStream{writer: buf}).Encode([]interface{}{
+			"tag",
+			[]interface{}{
+				[]interface{}{1409286145, map[string]interface{}{"a": "b"}},
+				[]interface{}{1409286146, map[string]interface{}{"a": "c"}},
+			},
+			map[string]interface{}{"size": case_.size},
+		})
+		if err != nil {
+			t.FailNow()
+		}
+		c := newTestForwardClientForBytes(nil)
+		c.input.options.strictSizeCheck = true
+		c.stream = &jsonForwardStream{reader: buf}
+		recordSets, options, err := c.decodeEntries()
+		if (err == nil) != case_.ok {
+			t.Logf("%+v: %v", case_, err)
+			t.Fail()
+			continue
+		}
+		if err == nil && (len(recordSets[0].Records) != 2 || !options.hasSize || options.size != 2) {
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardClient_decodeEntries_CompressedPackedForward(t *testing.T) {
+	c := newTestForwardClientForBytes(buildCompressedPackedForwardMessage(t, "tag", 3))
+	recordSets, _, err := c.decodeEntries()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(recordSets) != 1 || len(recordSets[0].Records) != 3 {
+		t.FailNow()
+	}
+	for i, record := range recordSets[0].Records {
+		if record.Timestamp != uint64(1409286145+i) {
+			t.Fail()
+		}
+		if record.Data["seq"] != uint64(i) {
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardClient_decodeEntries_CountsEntriesByTag(t *testing.T) {
+	c := newTestForwardClientForBytes(buildCompressedPackedForwardMessage(t, "noisy", 3))
+	input := c.input
+	_, _, err := c.decodeEntries()
+	if err != nil {
+		t.FailNow()
+	}
+	c = newTestForwardClientForBytes(buildCompressedPackedForwardMessage(t, "quiet", 1))
+	c.input = input
+	_, _, err = c.decodeEntries()
+	if err != nil {
+		t.FailNow()
+	}
+	text, _ := (&EntryCountByTagTopic{}).PlainText(input)
+	if text != "noisy: 3\nquiet: 1" {
+		t.Log(text)
+		t.Fail()
+	}
+	total, _ := (&EntryCountTopic{}).PlainText(input)
+	if total != "4" {
+		t.Log(total)
+		t.Fail()
+	}
+}
+
+func TestForwardClient_decodeEntries_CorruptCompressedPackedForward(t *testing.T) {
+	_codec := newForwardCodec()
+	entries := []byte{}
+	codec.NewEncoderBytes(&entries, _codec).Encode([]interface{}{uint64(1409286145), map[string]interface{}{}})
+	compressed := bytes.Buffer{}
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(entries)
+	writer.Close()
+	truncated := compressed.Bytes()[0 : compressed.Len()-6]
+	b := []byte{}
+	codec.NewEncoderBytes(&b, _codec).Encode([]interface{}{
+		"tag",
+		truncated,
+		map[string]interface{}{"compressed": "gzip"},
+	})
+	c := newTestForwardClientForBytes(b)
+	_, _, err := c.decodeEntries()
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_decodeEntries_CompressedPackedForwardLimit(t *testing.T) {
+	b := buildCompressedPackedForwardMessage(t, "tag", 1000)
+	for _, maxMessageSize := range []int64{int64(len(b)), 1024 * 1024} {
+		c := newTestForwardClientForBytes(b)
+		c.input.options.maxMessageSize = maxMessageSize
+		recordSets, _, err := c.decodeEntries()
+		if maxMessageSize == int64(len(b)) {
+			// the entries inflate beyond max_message_size
+			if err == nil || !strings.Contains(err.Error(), errMessageTooLarge.Error()) {
+				t.Log(err)
+				t.Fail()
+			}
+			continue
+		}
+		if err != nil || len(recordSets[0].Records) != 1000 {
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardInput_ConcurrentClients(t *testing.T) {
+	input := &ForwardInput{
+		codec:   newForwardCodec(),
+		clients: make(map[uint64]*forwardClient),
+	}
+	topic := &ConnectionCountTopic{}
+	done := make(chan bool)
+	for i := 0; i < 8; i += 1 {
+		go func() {
+			for j := 0; j < 100; j += 1 {
+				conn, peer := net.Pipe()
+				c := &forwardClient{input: input, conn: conn}
+				input.markCharged(c)
+				input.markDischarged(c)
+				conn.Close()
+				peer.Close()
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 8; {
+		select {
+		case <-done:
+			i += 1
+		default:
+			_, err := topic.PlainText(input)
+			if err != nil {
+				t.FailNow()
+			}
+		}
+	}
+	count, _ := topic.PlainText(input)
+	if count != "0" {
+		t.Fail()
+	}
+}
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (logger *testLogger) Critical(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testLogger) Error(format string, args ...interface{})   { logger.t.Logf(format, args...) }
+func (logger *testLogger) Warning(format string, args ...interface{}) { logger.t.Logf(format, args...) }
+func (logger *testLogger) Notice(format string, args ...interface{})  { logger.t.Logf(format, args...) }
+func (logger *testLogger) Info(format string, args ...interface{})    { logger.t.Logf(format, args...) }
+func (logger *testLogger) Debug(format string, args ...interface{})   { logger.t.Logf(format, args...) }
+
+func TestMsgpackFrameReader_ValidFrame(t *testing.T) {
+	b := buildCompressedPackedForwardMessage(t, "tag", 2)
+	r := &msgpackFrameReader{reader: bytes.NewReader(b), limit: 4096}
+	frame, err := r.readFrame()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !bytes.Equal(frame, b) {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_handle_OversizedMessage(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	input := &ForwardInput{
+		codec:        newForwardCodec(),
+		clients:      make(map[uint64]*forwardClient),
+		options:      forwardInputOptions{maxMessageSize: 1024},
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+	}
+	c := newForwardClient(input, &testLogger{t}, conn, conn.RemoteAddr().String(), input.codec)
+	go func() {
+		// array 32 claiming 4294967295 elements
+		peer.Write([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+	}()
+	if handleInner(c) {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_handle_TruncatedMessage(t *testing.T) {
+	for _, n := range []int{0, 1, 10} {
+		conn, peer := net.Pipe()
+		input := &ForwardInput{
+			codec:        newForwardCodec(),
+			clients:      make(map[uint64]*forwardClient),
+			options:      forwardInputOptions{maxMessageSize: 1024},
+			entriesByTag: make(map[string]int64),
+			entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+		}
+		warnings, errors := 0, 0
+		logger := &countingLogger{testLogger: testLogger{t}, warnings: &warnings, errors: &errors}
+		c := newForwardClient(input, logger, conn, conn.RemoteAddr().String(), input.codec)
+		b := buildCompressedPackedForwardMessage(t, "tag", 2)
+		go func() {
+			// closed in the middle of the message, or before it
+			peer.Write(b[:n])
+			peer.Close()
+		}()
+		if handleInner(c) {
+			t.Fail()
+		}
+		// a client going away is not an error
+		if errors != 0 || warnings != 0 {
+			t.Logf("%d: %d errors, %d warnings", n, errors, warnings)
+			t.Fail()
+		}
+		conn.Close()
+	}
+}
+
+func TestForwardClient_handle_MalformedMessage(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	input := &ForwardInput{
+		codec:        newForwardCodec(),
+		clients:      make(map[uint64]*forwardClient),
+		options:      forwardInputOptions{maxMessageSize: 1024},
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+	}
+	warnings, errors := 0, 0
+	logger := &countingLogger{testLogger: testLogger{t}, warnings: &warnings, errors: &errors}
+	c := newForwardClient(input, logger, conn, conn.RemoteAddr().String(), input.codec)
+	go func() {
+		// a map where an array is expected
+		peer.Write([]byte{0x81, 0xa1, 'a', 0xa1, 'b'})
+	}()
+	if handleInner(c) {
+		t.Fail()
+	}
+	if errors != 1 {
+		t.Fail()
+	}
+}
+
+// one end of a pair of pipes, which is not a net.Conn.
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func TestForwardClient_handle_ReadWriteCloser(t *testing.T) {
+	port := make(chanPort, 10)
+	input := &ForwardInput{
+		port:         port,
+		codec:        newForwardCodec(),
+		clients:      make(map[uint64]*forwardClient),
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+		options:      forwardInputOptions{sourceAddressKey: "addr", readTimeout: time.Second},
+	}
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	c := newForwardClient(input, &testLogger{t}, &pipeConn{Reader: serverReader, WriteCloser: serverWriter}, "pipe", input.codec)
+	if len(input.clients) != 1 || input.clients[c.id] != c {
+		t.FailNow()
+	}
+	done := make(chan struct{})
+	go func() {
+		c.handle()
+		close(done)
+	}()
+	err := codec.NewEncoder(clientWriter, input.codec).Encode([]interface{}{"tag", uint64(1), map[string]interface{}{"a": "b"}, map[string]interface{}{"chunk": "xyz"}})
+	if err != nil {
+		t.FailNow()
+	}
+	ack := map[string]interface{}{}
+	err = codec.NewDecoder(clientReader, input.codec).Decode(&ack)
+	if chunk, _ := toBytes(ack["ack"]); err != nil || string(chunk) != "xyz" {
+		t.Fail()
+	}
+	recordSets := <-port
+	if recordSets[0].Records[0].Data["addr"] != "pipe" {
+		t.Log(recordSets[0].Records[0].Data)
+		t.Fail()
+	}
+	clientWriter.Close()
+	<-done
+	if len(input.clients) != 0 {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_handle_ErrorPolicy(t *testing.T) {
+	for _, errorPolicy := range []string{"skip", "dead_letter"} {
+		port := make(chanPort, 10)
+		deadLetterPort := make(chanPort, 10)
+		input := &ForwardInput{
+			port:           port,
+			codec:          newForwardCodec(),
+			clients:        make(map[uint64]*forwardClient),
+			entriesByTag:   make(map[string]int64),
+			entriesMeter:   ik.NewRateMeter(time.Minute, 0, time.Now()),
+			ackedChunks:    newChunkCache(2),
+			options:        forwardInputOptions{errorPolicy: errorPolicy, deadLetterTag: "dead"},
+			deadLetterPort: deadLetterPort,
+		}
+		conn, peer := net.Pipe()
+		c := newForwardClient(input, &testLogger{t}, conn, conn.RemoteAddr().String(), input.codec)
+		done := make(chan struct{})
+		go func() {
+			c.handle()
+			close(done)
+		}()
+		buf := &bytes.Buffer{}
+		// a string where the record is expected
+		codec.NewEncoder(buf, input.codec).Encode([]interface{}{"tag", uint64(1), "a", map[string]interface{}{"chunk": "1"}})
+		malformed := buf.Bytes()
+		enc := codec.NewEncoder(peer, input.codec)
+		dec := codec.NewDecoder(peer, input.codec)
+		acks := make([]string, 0)
+		go peer.Write(malformed)
+		for _, message := range [][]interface{}{
+			nil,
+			{"tag", uint64(1), map[string]interface{}{"a": "b"}, map[string]interface{}{"chunk": "2"}},
+		} {
+			if message != nil {
+				go enc.Encode(message)
+			}
+			ack := map[string]interface{}{}
+			if dec.Decode(&ack) != nil {
+				t.FailNow()
+			}
+			chunk, _ := toBytes(ack["ack"])
+			acks = append(acks, string(chunk))
+		}
+		peer.Close()
+		<-done
+		// the connection survives the malformed message, which is acked
+		if !reflect.DeepEqual(acks, []string{"1", "2"}) || input.droppedFrames != 1 {
+			t.Log(errorPolicy, acks)
+			t.FailNow()
+		}
+		if len(port) != 1 || (<-port)[0].Tag != "tag" {
+			t.Fail()
+		}
+		if errorPolicy == "skip" {
+			if len(deadLetterPort) != 0 {
+				t.Fail()
+			}
+			continue
+		}
+		if len(deadLetterPort) != 1 {
+			t.FailNow()
+		}
+		recordSets := <-deadLetterPort
+		if recordSets[0].Tag != "dead" || recordSets[0].Records[0].Data[ik.DeadLetterErrorKey] == nil {
+			t.Fail()
+		}
+		message, _ := recordSets[0].Records[0].Data["message"].(string)
+		b, err := base64.StdEncoding.DecodeString(message)
+		if err != nil || !bytes.Equal(b, malformed) {
+			t.Log(message)
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardClient_CountsReceivedBytes(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	input := &ForwardInput{
+		codec:        newForwardCodec(),
+		clients:      make(map[uint64]*forwardClient),
+		options:      forwardInputOptions{maxMessageSize: 1024},
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+	}
+	c := newForwardClient(input, &testLogger{t}, conn, conn.RemoteAddr().String(), input.codec)
+	b := buildCompressedPackedForwardMessage(t, "tag", 2)
+	go func() {
+		peer.Write(b)
+	}()
+	recordSets, _, err := c.decodeEntries()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(recordSets[0].Records) != 2 {
+		t.Fail()
+	}
+	if c.bytes != int64(len(b)) || input.bytes != int64(len(b)) {
+		t.Logf("%d %d %d", c.bytes, input.bytes, len(b))
+		t.Fail()
+	}
+	input.bytes = 1536
+	text, _ := (&ReceivedBytesTopic{}).PlainText(input)
+	if text != "1.5 KiB" {
+		t.Log(text)
+		t.Fail()
+	}
+}
+
+// refuses the records for the given number of times
+type congestedPort struct {
+	testPort
+	refusals int
+}
+
+func (port *congestedPort) Emit(recordSets []ik.FluentRecordSet) error {
+	if port.refusals > 0 {
+		port.refusals -= 1
+		return &ik.BackpressureError{Pending: map[ik.Port][]ik.FluentRecordSet{port: recordSets}}
+	}
+	return port.testPort.Emit(recordSets)
+}
+
+func TestForwardClient_emit_RetriesOnBackpressure(t *testing.T) {
+	c := newTestForwardClientForBytes(nil)
+	c.logger = &testLogger{t}
+	port := &congestedPort{refusals: 3}
+	c.input.port = port
+	recordSets := []ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1, Data: map[string]interface{}{}}}}}
+	if !c.emit(recordSets) {
+		t.FailNow()
+	}
+	if port.refusals != 0 || len(port.recordSets) != 1 {
+		t.Fail()
+	}
+
+	// gives up on shutting down
+	port = &congestedPort{refusals: 1}
+	c.input.port = port
+	c.input.shuttingDown = 1
+	if c.emit(recordSets) {
+		t.Fail()
+	}
+}
+
+// refuses the records as the worker pool with the "drop" overflow action does
+type overflowingPort struct{}
+
+func (port *overflowingPort) Emit(recordSets []ik.FluentRecordSet) error {
+	return ik.ErrBufferOverflow
+}
+
+func TestForwardClient_emit_Overflow(t *testing.T) {
+	c := newTestForwardClientForBytes(nil)
+	c.logger = &testLogger{t}
+	c.input.port = &overflowingPort{}
+	recordSets := []ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1, Data: map[string]interface{}{}}}}}
+	// the chunk is not to be acked
+	if c.emit(recordSets) {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_decodeEntries_InjectsSource(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.FailNow()
+	}
+	defer listener.Close()
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.FailNow()
+	}
+	defer peer.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	b := []byte{
+		0x93,                // fixarray (3)
+		0xa3, 't', 'a', 'g', // fixstr "tag"
+		0x01,                     // timestamp
+		0x81,                     // fixmap (1)
+		0xa4, 'h', 'o', 's', 't', // fixstr "host"
+		0xa1, 'x', // fixstr "x"
+	}
+	for _, overwrite := range []bool{false, true} {
+		c := newTestForwardClientForBytes(b)
+		c.logger = &testLogger{t}
+		c.conn = conn
+		c.remoteAddr = conn.RemoteAddr().String()
+		c.input.options.sourceAddressKey = "addr"
+		c.input.options.sourceHostnameKey = "host"
+		c.input.options.overwriteSourceKeys = overwrite
+		recordSets, _, err := c.decodeEntries()
+		if err != nil {
+			t.FailNow()
+		}
+		data := recordSets[0].Records[0].Data
+		if data["addr"] != "127.0.0.1" {
+			t.Log(data)
+			t.Fail()
+		}
+		// the existing value is kept unless overwrite_source_keys is set
+		if overwrite {
+			if data["host"] == "x" || data["host"] == "" {
+				t.Log(data)
+				t.Fail()
+			}
+		} else if data["host"] != "x" {
+			t.Log(data)
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardInputOptions_rewriteTag(t *testing.T) {
+	cases := []struct {
+		options  forwardInputOptions
+		tag      string
+		expected string
+	}{
+		{forwardInputOptions{}, "app.access", "app.access"},
+		{forwardInputOptions{addTagPrefix: "dc1"}, "app.access", "dc1.app.access"},
+		{forwardInputOptions{removeTagPrefix: "app"}, "app.access", "access"},
+		// only the whole components are removed
+		{forwardInputOptions{removeTagPrefix: "ap"}, "app.access", "app.access"},
+		{forwardInputOptions{removeTagPrefix: "app"}, "app", "app"},
+		{forwardInputOptions{removeTagPrefix: "other"}, "app.access", "app.access"},
+		{forwardInputOptions{removeTagPrefix: "app", addTagPrefix: "dc1"}, "app.access", "dc1.access"},
+		{forwardInputOptions{removeTagPrefix: "dc1", addTagPrefix: "dc1"}, "dc1.app", "dc1.app"},
+		{forwardInputOptions{tag: "fixed"}, "app.access", "fixed"},
+		{forwardInputOptions{tag: "app.fixed", removeTagPrefix: "app", addTagPrefix: "dc1"}, "other", "dc1.fixed"},
+	}
+	for _, case_ := range cases {
+		tag := case_.options.rewriteTag(case_.tag)
+		if tag != case_.expected {
+			t.Logf("%+v %s: %s", case_.options, case_.tag, tag)
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardClient_decodeEntries_RewritesTag(t *testing.T) {
+	b := []byte{
+		0x93,                // fixarray (3)
+		0xa3, 't', 'a', 'g', // fixstr "tag"
+		0x01, // timestamp
+		0x80, // fixmap (0)
+	}
+	c := newTestForwardClientForBytes(b)
+	c.logger = &testLogger{t}
+	c.input.options.addTagPrefix = "dc1"
+	recordSets, _, err := c.decodeEntries()
+	if err != nil {
+		t.FailNow()
+	}
+	if recordSets[0].Tag != "dc1.tag" {
+		t.Log(recordSets[0].Tag)
+		t.Fail()
+	}
+	// counted under the rewritten tag
+	if c.input.entriesByTag["dc1.tag"] != 1 {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_KeepAlive(t *testing.T) {
+	input := &ForwardInput{
+		codec:   newForwardCodec(),
+		clients: make(map[uint64]*forwardClient),
+		options: forwardInputOptions{keepAlive: true, keepAlivePeriod: 30 * time.Second},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.FailNow()
+	}
+	defer listener.Close()
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.FailNow()
+	}
+	defer peer.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	warnings := 0
+	logger := &countingLogger{testLogger: testLogger{t}, warnings: &warnings}
+	newForwardClient(input, logger, conn, conn.RemoteAddr().String(), input.codec)
+	if warnings != 0 {
+		t.Fail()
+	}
+	// the connections other than TCP ones are warned about only once
+	for i := 0; i < 2; i += 1 {
+		conn, peer := net.Pipe()
+		newForwardClient(input, logger, conn, conn.RemoteAddr().String(), input.codec)
+		conn.Close()
+		peer.Close()
+	}
+	if warnings != 1 {
+		t.Log(warnings)
+		t.Fail()
+	}
+}
+
+// a testLogger that counts the warnings
+type countingLogger struct {
+	testLogger
+	warnings *int
+	errors   *int
+}
+
+func (logger *countingLogger) Warning(format string, args ...interface{}) {
+	*logger.warnings += 1
+	logger.testLogger.Warning(format, args...)
+}
+
+func (logger *countingLogger) Error(format string, args ...interface{}) {
+	if logger.errors != nil {
+		*logger.errors += 1
+	}
+	logger.testLogger.Error(format, args...)
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// a connection whose reads fail temporarily for the given times before
+// it gets closed
+type flakyConn struct {
+	net.Conn
+	failures int
+}
+
+func (conn *flakyConn) Read(p []byte) (int, error) {
+	if conn.failures > 0 {
+		conn.failures -= 1
+		return 0, temporaryError{}
+	}
+	return 0, io.EOF
+}
+
+func TestForwardClient_handle_TemporaryFailureBacksOff(t *testing.T) {
+	input := &ForwardInput{
+		codec:   newForwardCodec(),
+		clients: make(map[uint64]*forwardClient),
+	}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := newForwardClient(input, &testLogger{t}, &flakyConn{Conn: conn, failures: 3}, conn.RemoteAddr().String(), input.codec)
+	start := time.Now()
+	n := 0
+	for handleInner(c) {
+		n += 1
+	}
+	// waits 5ms, 10ms and then 20ms
+	if n != 3 || time.Since(start) < 35*time.Millisecond {
+		t.Log(n, time.Since(start))
+		t.Fail()
+	}
+	if c.temporaryFailureWait != 4*temporaryFailureInitialWait {
+		t.Fail()
+	}
+}
+
+func TestForwardInput_StartBindsAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.FailNow()
+	}
+	bind := listener.Addr().String()
+	// building the input doesn't bind the address
+	input, err := newForwardInput(nil, &testLogger{t}, nil, []string{bind}, nil, forwardInputOptions{})
+	if err != nil {
+		t.FailNow()
+	}
+	if input.Run() == nil || input.Start() == nil {
+		t.FailNow()
+	}
+	listener.Close()
+	// it can be started again once the address is freed
+	if input.Start() != nil {
+		t.FailNow()
+	}
+	if input.listeners[0].Addr().String() != bind || input.Stop() != nil {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_handle_JSONFormat(t *testing.T) {
+	input := &ForwardInput{
+		port:         &testPort{},
+		codec:        newForwardCodec(),
+		clients:      make(map[uint64]*forwardClient),
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+		options:      forwardInputOptions{format: "json", maxMessageSize: 1024},
+	}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := newForwardClient(input, &testLogger{t}, conn, conn.RemoteAddr().String(), input.codec)
+	done := make(chan bool)
+	go func() {
+		done <- handleInner(c)
+	}()
+	stream := &jsonForwardStream{reader: peer, writer: peer}
+	err := stream.Encode([]interface{}{
+		"tag",
+		[]interface{}{
+			[]interface{}{1409286145, map[string]interface{}{"a": "b"}},
+			[]interface{}{1409286146.5, map[string]interface{}{"a": 1}},
+		},
+		map[string]interface{}{"chunk": "xyz"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	// the ack is an object, which Decode doesn't take
+	header := make([]byte, 4)
+	io.ReadFull(peer, header)
+	payload := make([]byte, int(header[3]))
+	io.ReadFull(peer, payload)
+	if string(payload) != `{"ack":"xyz"}` || !<-done {
+		t.Log(string(payload))
+		t.Fail()
+	}
+	recordSets := input.port.(*testPort).recordSets
+	if len(recordSets) != 1 || recordSets[0].Tag != "tag" || len(recordSets[0].Records) != 2 {
+		t.FailNow()
+	}
+	record := recordSets[0].Records[1]
+	if record.Timestamp != 1409286146 || record.Nanoseconds != 500000000 || record.Data["a"] != float64(1) {
+		t.Log(record)
+		t.Fail()
+	}
+
+	// the length is checked against max_message_size
+	go func() {
+		done <- handleInner(c)
+	}()
+	peer.Write([]byte{0, 0, 4, 1})
+	if <-done {
+		t.Fail()
+	}
+}
+
+func TestJsonForwardStream_Decode_BogusLength(t *testing.T) {
+	// 4GiB declared without max_message_size, of which a few bytes follow
+	stream := &jsonForwardStream{reader: bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, '[', '1', ']'})}
+	v := []interface{}{}
+	err := stream.Decode(&v)
+	if err != io.ErrUnexpectedEOF {
+		t.Fail()
+	}
+	buf, err := appendFull(bytes.NewReader([]byte("abc")), nil, 1<<32)
+	if err != io.ErrUnexpectedEOF || cap(buf) > readGrowthSize {
+		t.Log(cap(buf))
+		t.Fail()
+	}
+}
+
+func TestForwardInput_MultipleBinds(t *testing.T) {
+	input, err := newForwardInput(nil, &testLogger{t}, nil, []string{"127.0.0.1:0", "127.0.0.1:0"}, &testPort{}, forwardInputOptions{})
+	if err != nil || input.Start() != nil || len(input.listeners) != 2 {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	// both accept the connections, which are counted together
+	for _, listener := range input.listeners {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.FailNow()
+		}
+		defer conn.Close()
+	}
+	topic := &ConnectionCountTopic{}
+	for i := 0; i < 100; i += 1 {
+		count, _ := topic.PlainText(input)
+		if count == "2" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	count, _ := topic.PlainText(input)
+	if count != "2" {
+		t.Log(count)
+		t.Fail()
+	}
+	input.Shutdown()
+	if <-done != nil {
+		t.Fail()
+	}
+	for _, listener := range input.listeners {
+		_, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			t.Fail()
+		}
+	}
+}
+
+func TestForwardInput_ListenerError(t *testing.T) {
+	input, err := newForwardInput(nil, &testLogger{t}, nil, []string{"127.0.0.1:0", "127.0.0.1:0"}, &testPort{}, forwardInputOptions{})
+	if err != nil || input.Start() != nil || len(input.listeners) != 2 {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	// the error on one of the listeners stops the input, closing the other
+	input.listeners[0].Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.FailNow()
+	}
+	_, err = net.Dial("tcp", input.listeners[1].Addr().String())
+	if err == nil {
+		t.Fail()
+	}
+	input.Shutdown()
+}
+
+// an engine that just provides the logger and the default port
+type testForwardEngine struct {
+	ik.Engine
+	logger ik.Logger
+}
+
+func (engine *testForwardEngine) Logger() ik.Logger       { return engine.logger }
+func (engine *testForwardEngine) DefaultPort() ik.Port    { return nil }
+func (engine *testForwardEngine) DeadLetterPort() ik.Port { return nil }
+
+func TestForwardInputFactory_New_FakeEngine(t *testing.T) {
+	engine := iktest.NewFakeEngine(&testLogger{t})
+	factory := &ForwardInputFactory{}
+	factory.BindScorekeeper(engine.Scorekeeper())
+	names := "," + strings.Join(engine.TopicNames(factory), ",") + ","
+	if !strings.Contains(names, ",connections,") || !strings.Contains(names, ",entries,") || !strings.Contains(names, ",dropped_frames,") {
+		t.Log(names)
+		t.Fail()
+	}
+	input, err := factory.New(engine, &ik.ConfigElement{Attrs: map[string]string{
+		"listen": "127.0.0.1",
+		"port":   "0",
+	}})
+	if err != nil || input.(*ForwardInput).Start() != nil {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		input.Shutdown()
+		<-done
+	}()
+	conn, err := net.Dial("tcp", input.(*ForwardInput).Addr().String())
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	_, err = conn.Write(buildCompressedPackedForwardMessage(t, "tag", 3))
+	if err != nil || !engine.Port.WaitForRecords(3, time.Second) {
+		t.FailNow()
+	}
+	records := engine.Port.Records()
+	if records[0].Tag != "tag" || fmt.Sprint(records[2].Data["seq"]) != "2" {
+		t.Log(records)
+		t.Fail()
+	}
+	count, _ := (&EntryCountTopic{}).PlainText(input)
+	if count != "3" {
+		t.Log(count)
+		t.Fail()
+	}
+}
+
+func TestForwardInputFactory_New_DecodeTimeBuckets(t *testing.T) {
+	engine := &testForwardEngine{logger: &testLogger{t}}
+	input, err := (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{
+		"decode_time_buckets": "500us, 1ms,1s",
+	}})
+	if err != nil {
+		t.FailNow()
+	}
+	if bounds := input.(*ForwardInput).decodeTime.Snapshot().Bounds; !reflect.DeepEqual(bounds, []float64{0.0005, 0.001, 1}) {
+		t.Log(bounds)
+		t.Fail()
+	}
+	for _, value := range []string{"1ms,1ms", "1s,1ms", "0s", "1ms,", "fast"} {
+		_, err = (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"decode_time_buckets": value}})
+		if err == nil {
+			t.Log(value)
+			t.Fail()
+		}
+	}
+	// the defaults unless given
+	input, err = (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{}})
+	if err != nil || len(input.(*ForwardInput).decodeTime.Snapshot().Bounds) != len(ik.DefaultHistogramBounds) {
+		t.Fail()
+	}
+}
+
+func TestForwardInputFactory_New_MultipleBinds(t *testing.T) {
+	engine := &testForwardEngine{logger: &testLogger{t}}
+	input, err := (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{
+		"listen": "127.0.0.1, ::1",
+		"port":   "24224,24225",
+	}})
+	if err != nil {
+		t.FailNow()
+	}
+	binds := input.(*ForwardInput).binds
+	if !reflect.DeepEqual(binds, []string{"127.0.0.1:24224", "127.0.0.1:24225", "[::1]:24224", "[::1]:24225"}) {
+		t.Log(binds)
+		t.Fail()
+	}
+	_, err = (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"port": "24224,prot"}})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+// passes the emitted record sets over the channel
+type chanPort chan []ik.FluentRecordSet
+
+func (port chanPort) Emit(recordSets []ik.FluentRecordSet) error {
+	port <- recordSets
+	return nil
+}
+
+func TestForwardClient_handle_EmitsInBatches(t *testing.T) {
+	port := make(chanPort, 10)
+	input := &ForwardInput{
+		port:         port,
+		codec:        newForwardCodec(),
+		clients:      make(map[uint64]*forwardClient),
+		entriesByTag: make(map[string]int64),
+		entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+		options:      forwardInputOptions{emitBatchSize: 4, emitBatchWait: 50 * time.Millisecond},
+	}
+	conn, peer := net.Pipe()
+	c := newForwardClient(input, &testLogger{t}, conn, conn.RemoteAddr().String(), input.codec)
+	done := make(chan struct{})
+	go func() {
+		c.handle()
+		close(done)
+	}()
+	acks := make(chan string, 10)
+	go func() {
+		dec := codec.NewDecoder(peer, input.codec)
+		for {
+			ack := map[string]interface{}{}
+			if dec.Decode(&ack) != nil {
+				return
+			}
+			chunk, _ := toBytes(ack["ack"])
+			acks <- string(chunk)
+		}
+	}()
+	enc := codec.NewEncoder(peer, input.codec)
+	send := func(tag string, chunk string) {
+		err := enc.Encode([]interface{}{tag, uint64(1), map[string]interface{}{"a": "b"}, map[string]interface{}{"chunk": chunk}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+
+	// a partial batch is emitted after emit_batch_wait
+	send("a", "1")
+	send("a", "2")
+	select {
+	case <-port:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+	recordSets := <-port
+	if len(recordSets) != 1 || len(recordSets[0].Records) != 2 || <-acks != "1" || <-acks != "2" {
+		t.FailNow()
+	}
+
+	// a full batch is emitted right away
+	for i := 0; i < 4; i += 1 {
+		send([]string{"a", "b"}[i/2], fmt.Sprintf("%d", i))
+	}
+	select {
+	case recordSets = <-port:
+	case <-time.After(40 * time.Millisecond):
+		t.FailNow()
+	}
+	if len(recordSets) != 2 || recordSets[1].Tag != "b" || len(recordSets[1].Records) != 2 {
+		t.FailNow()
+	}
+	for i := 0; i < 4; i += 1 {
+		<-acks
+	}
+
+	// what is left is emitted on close
+	input.options.emitBatchWait = time.Hour
+	send("c", "x")
+	peer.Close()
+	<-done
+	recordSets = <-port
+	if len(recordSets) != 1 || recordSets[0].Tag != "c" {
+		t.Fail()
+	}
+}
+
+func TestTokenBucket_take(t *testing.T) {
+	bucket := newTokenBucket(10)
+	now := bucket.last
+	// a second worth of tokens is available at first
+	if bucket.take(10, now) != 0 {
+		t.Fail()
+	}
+	if bucket.take(5, now) != 500*time.Millisecond {
+		t.Fail()
+	}
+	// refilled at the rate
+	if bucket.take(5, now.Add(time.Second)) != 0 {
+		t.Fail()
+	}
+	// but never more than a second worth
+	if bucket.take(15, now.Add(time.Hour)) != 500*time.Millisecond {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_throttle(t *testing.T) {
+	input := &ForwardInput{
+		options: forwardInputOptions{recordsPerSecond: 100, throttleByTag: true},
+	}
+	recordSets := []ik.FluentRecordSet{{Tag: "a", Records: make([]ik.TinyFluentRecord, 100)}}
+	c1 := &forwardClient{input: input}
+	c2 := &forwardClient{input: input}
+	start := time.Now()
+	c1.throttle(recordSets)
+	// the bucket for the tag is shared by the connections
+	c2.throttle(recordSets[0:1])
+	c2.throttle([]ik.FluentRecordSet{{Tag: "b", Records: make([]ik.TinyFluentRecord, 100)}})
+	elapsed := time.Since(start)
+	if elapsed < 900*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Log(elapsed)
+		t.Fail()
+	}
+	count, _ := (&ThrottledCountTopic{}).PlainText(input)
+	if count != "1" {
+		t.Log(count)
+		t.Fail()
+	}
+
+	// throttled by connection
+	input.options.throttleByTag = false
+	start = time.Now()
+	c1.throttle(recordSets)
+	c2.throttle(recordSets)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fail()
+	}
+}
+
+func TestForwardClient_handle_ResentChunk(t *testing.T) {
+	input, port := newTestForwardInput(forwardInputOptions{})
+	input.ackedChunks = newChunkCache(2)
+	// sends the chunks on a new connection and returns the acks
+	sendChunks := func(chunks ...string) []string {
+		_, forwarder := newTestForwardClient(t, input)
+		acks := make([]string, 0, len(chunks))
+		for _, chunk := range chunks {
+			forwarder.send("tag", uint64(1), map[string]interface{}{"a": "b"}, map[string]interface{}{"chunk": chunk})
+			acks = append(acks, forwarder.receiveAck())
+		}
+		forwarder.close()
+		return acks
+	}
+	acks := sendChunks("1", "1")
+	if !reflect.DeepEqual(acks, []string{"1", "1"}) || len(port) != 1 {
+		t.FailNow()
+	}
+	// resent after reconnecting
+	acks = sendChunks("1")
+	if !reflect.DeepEqual(acks, []string{"1"}) || len(port) != 1 || input.entries != 1 {
+		t.FailNow()
+	}
+	// emitted again once forgotten
+	sendChunks("2", "3", "1")
+	if len(port) != 4 {
+		t.Fail()
+	}
+}
+
+func TestChunkCache(t *testing.T) {
+	cache := newChunkCache(2)
+	cache.add("a")
+	cache.add("b")
+	cache.add("a")
+	cache.add("c")
+	if !cache.contains("a") || cache.contains("b") || !cache.contains("c") || cache.contains("") {
+		t.Fail()
+	}
+	// disabled
+	cache = newChunkCache(0)
+	cache.add("a")
+	if cache.contains("a") {
+		t.Fail()
+	}
+}
+
+func TestForwardInput_Addrs(t *testing.T) {
+	engine := &testForwardEngine{logger: &testLogger{t}}
+	input_, err := (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{
+		"listen": "127.0.0.1",
+		"port":   "0,0",
+	}})
+	if err != nil {
+		t.FailNow()
+	}
+	input := input_.(*ForwardInput)
+	if input.Addr() != nil || input.Start() != nil {
+		t.FailNow()
+	}
+	defer input.Stop()
+	// the ports assigned by the OS are told through ik.Listening
+	addrs := input_.(ik.Listening).Addrs()
+	if len(addrs) != 2 || addrs[0].String() == addrs[1].String() || input.Addr() != addrs[0] {
+		t.FailNow()
+	}
+	for _, addr := range addrs {
+		if addr.(*net.TCPAddr).Port == 0 {
+			t.FailNow()
+		}
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.FailNow()
+		}
+		conn.Close()
+	}
+}
+
+func TestForwardInput_Drain(t *testing.T) {
+	port := make(chanPort, 10)
+	input, err := newForwardInput(nil, &testLogger{t}, nil, []string{"127.0.0.1:0"}, port, forwardInputOptions{})
+	if err != nil || input.Start() != nil {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	addr := input.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	if input.Drain() != nil {
+		t.FailNow()
+	}
+	if draining, _ := (&DrainingTopic{}).PlainText(input); draining != "true" {
+		t.Fail()
+	}
+	// no longer accepts connections, ...
+	_, err = net.Dial("tcp", addr)
+	if err == nil {
+		t.Fail()
+	}
+	// ... but keeps handling the ones accepted
+	err = codec.NewEncoder(conn, input.codec).Encode([]interface{}{"tag", uint64(1), map[string]interface{}{"a": "b"}})
+	if err != nil {
+		t.FailNow()
+	}
+	select {
+	case <-port:
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	select {
+	case <-done:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+	if input.Shutdown() != nil || <-done != nil {
+		t.Fail()
+	}
+}
+
+// a connection that reads from the bytes, counting the reads
+type readCountingConn struct {
+	io.ReadWriteCloser
+	reader *bytes.Reader
+	reads  int
+}
+
+func (conn *readCountingConn) Read(p []byte) (int, error) {
+	conn.reads += 1
+	return conn.reader.Read(p)
+}
+
+func TestForwardClient_decodeEntries_ReadBuffer(t *testing.T) {
+	_codec := newForwardCodec()
+	b := []byte{}
+	enc := codec.NewEncoderBytes(&b, _codec)
+	for i := 0; i < 100; i += 1 {
+		err := enc.Encode([]interface{}{"tag", uint64(i), map[string]interface{}{"a": "b"}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	countReads := func(readBufferSize int64) int {
+		input := &ForwardInput{
+			codec:        _codec,
+			clients:      make(map[uint64]*forwardClient),
+			entriesByTag: make(map[string]int64),
+			entriesMeter: ik.NewRateMeter(time.Minute, 0, time.Now()),
+			options:      forwardInputOptions{readBufferSize: readBufferSize},
+		}
+		conn := &readCountingConn{reader: bytes.NewReader(b)}
+		c := newForwardClient(input, &testLogger{t}, conn, "test", _codec)
+		// none of the bytes read ahead are lost
+		for i := 0; i < 100; i += 1 {
+			recordSets, _, err := c.decodeEntries()
+			if err != nil || recordSets[0].Records[0].Timestamp != uint64(i) {
+				t.FailNow()
+			}
+		}
+		_, _, err := c.decodeEntries()
+		if err != io.EOF {
+			t.FailNow()
+		}
+		if c.bytes != int64(len(b)) {
+			t.Fail()
+		}
+		return conn.reads
+	}
+	unbuffered := countReads(0)
+	buffered := countReads(4096)
+	t.Logf("%d reads unbuffered, %d reads buffered", unbuffered, buffered)
+	if buffered > 2 || buffered >= unbuffered {
+		t.Fail()
+	}
+}
+
+func TestForwardInput_DisconnectsSlowClients(t *testing.T) {
+	port := make(chanPort, 10)
+	input, err := newForwardInput(nil, &testLogger{t}, nil, []string{"127.0.0.1:0"}, port, forwardInputOptions{
+		minBytesPerSec:   100,
+		slowClientWindow: 100 * time.Millisecond,
+	})
+	if err != nil || input.Start() != nil {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		input.Shutdown()
+		<-done
+	}()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", input.Addr().String())
+		if err != nil {
+			t.FailNow()
+		}
+		return conn
+	}
+	idle := dial()
+	defer idle.Close()
+	slow := dial()
+	defer slow.Close()
+	b := []byte{}
+	err = codec.NewEncoderBytes(&b, input.codec).Encode([]interface{}{"tag", uint64(1), map[string]interface{}{"a": "b"}})
+	if err != nil {
+		t.FailNow()
+	}
+	// sends the first byte of the message and stalls
+	slow.Write(b[:1])
+	slow.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = slow.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Log(err)
+		t.FailNow()
+	}
+	if count, _ := (&SlowClientCountTopic{}).PlainText(input); count != "1" {
+		t.Fail()
+	}
+	// the client idling between messages is left connected
+	_, err = idle.Write(b)
+	if err != nil {
+		t.FailNow()
+	}
+	select {
+	case <-port:
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+}
+
+func TestForwardInput_EvictsIdleClients(t *testing.T) {
+	port := make(chanPort, 10)
+	input, err := newForwardInput(nil, &testLogger{t}, nil, []string{"127.0.0.1:0"}, port, forwardInputOptions{
+		maxConnections: 3,
+		evictIdle:      true,
+	})
+	if err != nil || input.Start() != nil {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		input.Shutdown()
+		<-done
+	}()
+	b := []byte{}
+	err = codec.NewEncoderBytes(&b, input.codec).Encode([]interface{}{"tag", uint64(1), map[string]interface{}{"a": "b"}})
+	if err != nil {
+		t.FailNow()
+	}
+	// waits for the number of the connections to settle
+	waitForConnections := func(n int64) {
+		for i := 0; i < 100 && atomic.LoadInt64(&input.connections) != n; i += 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if atomic.LoadInt64(&input.connections) != n {
+			t.FailNow()
+		}
+	}
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", input.Addr().String())
+		if err != nil {
+			t.FailNow()
+		}
+		return conn
+	}
+	send := func(conn net.Conn) {
+		_, err := conn.Write(b)
+		if err != nil {
+			t.FailNow()
+		}
+		select {
+		case <-port:
+		case <-time.After(time.Second):
+			t.FailNow()
+		}
+	}
+	// tells if the connection has been closed by the input
+	isClosed := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}
+	active := dial()
+	defer active.Close()
+	waitForConnections(1)
+	idles := make([]net.Conn, 0)
+	for i := 0; i < 2; i += 1 {
+		idle := dial()
+		defer idle.Close()
+		waitForConnections(int64(i + 2))
+		idles = append(idles, idle)
+	}
+	// the connection made first is the most recently active one
+	send(active)
+	another := dial()
+	defer another.Close()
+	if !isClosed(idles[0]) {
+		t.FailNow()
+	}
+	waitForConnections(3)
+	if isClosed(idles[1]) || isClosed(active) {
+		t.FailNow()
+	}
+	send(another)
+	send(active)
+	if count, _ := (&EvictedConnectionCountTopic{}).PlainText(input); count != "1" {
+		t.Fail()
+	}
+	if count, _ := (&RejectedConnectionCountTopic{}).PlainText(input); count != "0" {
+		t.Fail()
+	}
+}
+
+func TestForwardInput_AllowDeny(t *testing.T) {
+	engine := &testForwardEngine{logger: &testLogger{t}}
+	newInput := func(attrs map[string]string) *ForwardInput {
+		attrs["listen"] = "127.0.0.1"
+		attrs["port"] = "0"
+		input, err := (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: attrs})
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		return input.(*ForwardInput)
+	}
+	// connects to the input and tells if the connection is not denied
+	connects := func(input *ForwardInput) bool {
+		if input.Start() != nil {
+			t.FailNow()
+		}
+		defer input.Shutdown()
+		conn, err := net.Dial("tcp", input.Addr().String())
+		if err != nil {
+			t.FailNow()
+		}
+		defer conn.Close()
+		if input.Run() != ik.Continue {
+			t.FailNow()
+		}
+		count, _ := (&DeniedConnectionCountTopic{}).PlainText(input)
+		return count == "0"
+	}
+	if !connects(newInput(map[string]string{"allow": "127.0.0.0/8, ::1", "deny": "192.0.2.0/24"})) {
+		t.Fail()
+	}
+	if !connects(newInput(map[string]string{"allow": ""})) {
+		t.Fail()
+	}
+	if connects(newInput(map[string]string{"allow": "192.0.2.0/24"})) {
+		t.Fail()
+	}
+	// deny takes precedence
+	input := newInput(map[string]string{"allow": "127.0.0.0/8", "deny": "127.0.0.1"})
+	if connects(input) {
+		t.Fail()
+	}
+	if count, _ := (&DeniedConnectionCountTopic{}).PlainText(input); count != "1" {
+		t.Fail()
+	}
+	_, err := (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"deny": "10.0.0.0/33"}})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestForwardInput_EntryRate(t *testing.T) {
+	input := newForwardInputForBinds(nil, &testLogger{t}, nil, nil, forwardInputOptions{})
+	input.countEntries([]ik.FluentRecordSet{{Tag: "tag", Records: make([]ik.TinyFluentRecord, 3)}})
+	input.countEntries([]ik.FluentRecordSet{{Tag: "tag", Records: make([]ik.TinyFluentRecord, 2)}})
+	if input.EntryRate() <= 0 {
+		t.Fail()
+	}
+	// fetching the topic leaves the count and the rate as they are
+	rate, _ := (&EntryRateTopic{}).Value(input)
+	count, _ := (&EntryCountTopic{}).PlainText(input)
+	if rate.(float64) <= 0 || count != "5" || input.EntryRate() <= 0 {
+		t.Fail()
+	}
+}
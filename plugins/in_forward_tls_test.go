@@ -0,0 +1,124 @@
+package plugins
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/iktest"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// writes a self-signed certificate for 127.0.0.1 and its key into the
+// directory.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.FailNow()
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.FailNow()
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.FailNow()
+	}
+	certPath := path.Join(dir, "cert.pem")
+	keyPath := path.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.FailNow()
+	}
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.FailNow()
+	}
+	return certPath, keyPath
+}
+
+func TestForwardInputFactory_New_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_forward_tls")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCertificate(t, dir)
+	engine := iktest.NewFakeEngine(&testLogger{t})
+	input, err := (&ForwardInputFactory{}).New(engine, &ik.ConfigElement{
+		Attrs: map[string]string{"listen": "127.0.0.1", "port": "0"},
+		Elems: []*ik.ConfigElement{{
+			Name:  "transport",
+			Args:  "tls",
+			Attrs: map[string]string{"cert": certPath, "key": keyPath},
+		}},
+	})
+	if err != nil || input.(*ForwardInput).Start() != nil {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		input.Shutdown()
+		<-done
+	}()
+	addr := input.(*ForwardInput).Addr().String()
+	// the client that doesn't speak TLS fails the handshake, which doesn't
+	// stop the others from being accepted
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.FailNow()
+	}
+	conn.Write(buildCompressedPackedForwardMessage(t, "plain", 1))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	conn.Close()
+	if err == nil {
+		t.Fail()
+	}
+	pool := x509.NewCertPool()
+	certPem, _ := ioutil.ReadFile(certPath)
+	pool.AppendCertsFromPEM(certPem)
+	tlsConn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer tlsConn.Close()
+	_, err = tlsConn.Write(buildCompressedPackedForwardMessage(t, "tls", 3))
+	if err != nil || !engine.Port.WaitForRecords(3, time.Second) {
+		t.FailNow()
+	}
+	for _, record := range engine.Port.Records() {
+		if record.Tag != "tls" {
+			t.Fail()
+		}
+	}
+}
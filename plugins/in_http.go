@@ -0,0 +1,217 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	httpInputReadHeaderTimeout = 10 * time.Second
+	httpInputReadTimeout       = 60 * time.Second
+)
+
+type HttpInput struct {
+	factory       *HttpInputFactory
+	port          ik.Port
+	logger        ik.Logger
+	bind          string
+	bodySizeLimit int64
+	listener      net.Listener
+	server        *http.Server
+	requests      int64
+}
+
+type HttpInputFactory struct {
+}
+
+type RequestCountTopic struct{}
+
+func decodeHttpRecords(v interface{}) ([]ik.TinyFluentRecord, error) {
+	timestamp := uint64(time.Now().Unix())
+	switch v_ := v.(type) {
+	case map[string]interface{}:
+		return []ik.TinyFluentRecord{{Timestamp: timestamp, Data: v_}}, nil
+	case []interface{}:
+		retval := make([]ik.TinyFluentRecord, 0, len(v_))
+		for _, elem := range v_ {
+			data, ok := elem.(map[string]interface{})
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("record must be an object (got %T)", elem))
+			}
+			retval = append(retval, ik.TinyFluentRecord{Timestamp: timestamp, Data: data})
+		}
+		return retval, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("payload must be an object or an array (got %T)", v))
+	}
+}
+
+func (input *HttpInput) readPayload(request *http.Request) ([]byte, error) {
+	contentType := request.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		err := request.ParseForm()
+		if err != nil {
+			return nil, err
+		}
+		payload, ok := request.PostForm["json"]
+		if !ok || len(payload) == 0 {
+			return nil, errors.New("`json' parameter is not specified")
+		}
+		return []byte(payload[0]), nil
+	}
+	return ioutil.ReadAll(request.Body)
+}
+
+func (input *HttpInput) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	atomic.AddInt64(&input.requests, 1)
+	if request.Method != "POST" {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tag := strings.Replace(strings.Trim(request.URL.Path, "/"), "/", ".", -1)
+	if tag == "" {
+		http.Error(writer, "Tag is not specified", http.StatusBadRequest)
+		return
+	}
+	if input.bodySizeLimit > 0 {
+		request.Body = http.MaxBytesReader(writer, request.Body, input.bodySizeLimit)
+	}
+	payload, err := input.readPayload(request)
+	if err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var v interface{}
+	err = json.Unmarshal(payload, &v)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := decodeHttpRecords(v)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = input.port.Emit([]ik.FluentRecordSet{{Tag: tag, Records: records}})
+	if err != nil {
+		input.logger.Error("%s", err.Error())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (input *HttpInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *HttpInput) Port() ik.Port {
+	return input.port
+}
+
+func (input *HttpInput) Run() error {
+	err := input.server.Serve(input.listener)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+	}
+	return err
+}
+
+func (input *HttpInput) Shutdown() error {
+	return input.listener.Close()
+}
+
+func (input *HttpInput) Dispose() {
+	input.Shutdown()
+}
+
+func newHttpInput(factory *HttpInputFactory, logger ik.Logger, bind string, bodySizeLimit int64, port ik.Port) (*HttpInput, error) {
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		logger.Warning("%s", err.Error())
+		return nil, err
+	}
+	input := &HttpInput{
+		factory:       factory,
+		port:          port,
+		logger:        logger,
+		bind:          bind,
+		bodySizeLimit: bodySizeLimit,
+		listener:      listener,
+	}
+	// the clients that trickle the requests in would otherwise hold the
+	// connections forever
+	input.server = &http.Server{
+		Handler:           input,
+		ReadHeaderTimeout: httpInputReadHeaderTimeout,
+		ReadTimeout:       httpInputReadTimeout,
+	}
+	return input, nil
+}
+
+func (factory *HttpInputFactory) Name() string {
+	return "http"
+}
+
+func (factory *HttpInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	listen, ok := config.Attrs["bind"]
+	if !ok {
+		listen = ""
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "9880"
+	}
+	bind := listen + ":" + netPort
+	bodySizeLimit := int64(32 * 1024 * 1024) // 32MB
+	bodySizeLimitStr, ok := config.Attrs["body_size_limit"]
+	if ok {
+		var err error
+		bodySizeLimit, err = ik.ParseCapacityString(bodySizeLimitStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newHttpInput(factory, engine.Logger(), bind, bodySizeLimit, engine.DefaultPort())
+}
+
+func (factory *HttpInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "requests",
+		DisplayName: "Total number of requests",
+		Description: "Total number of requests received so far",
+		Fetcher:     &RequestCountTopic{},
+	})
+}
+
+func (topic *RequestCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(input_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *RequestCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
+	return ik.PlainTextOfScoreValue(topic, input_)
+}
+
+func (topic *RequestCountTopic) Value(input_ ik.PluginInstance) (interface{}, error) {
+	input := input_.(*HttpInput)
+	return atomic.LoadInt64(&input.requests), nil
+}
+
+var _ = AddPlugin(&HttpInputFactory{})
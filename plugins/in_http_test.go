@@ -0,0 +1,95 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testPort struct {
+	recordSets []ik.FluentRecordSet
+}
+
+func (port *testPort) Emit(recordSets []ik.FluentRecordSet) error {
+	port.recordSets = append(port.recordSets, recordSets...)
+	return nil
+}
+
+func TestHttpInput_ServeHTTP_JSON(t *testing.T) {
+	port := &testPort{}
+	input := &HttpInput{port: port, logger: &testLogger{t}}
+	request, _ := http.NewRequest("POST", "/foo/bar", strings.NewReader(`[{"a":"b"},{"c":"d"}]`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.FailNow()
+	}
+	if len(port.recordSets) != 1 || len(port.recordSets[0].Records) != 2 {
+		t.FailNow()
+	}
+	if port.recordSets[0].Tag != "foo.bar" {
+		t.Fail()
+	}
+	if port.recordSets[0].Records[1].Data["c"] != "d" {
+		t.Fail()
+	}
+}
+
+func TestHttpInput_ServeHTTP_Form(t *testing.T) {
+	port := &testPort{}
+	input := &HttpInput{port: port, logger: &testLogger{t}}
+	form := url.Values{"json": {`{"a":"b"}`}}
+	request, _ := http.NewRequest("POST", "/tag", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.FailNow()
+	}
+	if len(port.recordSets) != 1 || port.recordSets[0].Records[0].Data["a"] != "b" {
+		t.Fail()
+	}
+}
+
+func TestHttpInput_ServeHTTP_Malformed(t *testing.T) {
+	port := &testPort{}
+	input := &HttpInput{port: port, logger: &testLogger{t}}
+	request, _ := http.NewRequest("POST", "/tag", strings.NewReader(`{"a":`))
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fail()
+	}
+	if len(port.recordSets) != 0 {
+		t.Fail()
+	}
+}
+
+func TestHttpInput_ServeHTTP_BodySizeLimit(t *testing.T) {
+	port := &testPort{}
+	input := &HttpInput{port: port, logger: &testLogger{t}, bodySizeLimit: 16}
+	for _, contentType := range []string{"application/json", "application/x-www-form-urlencoded"} {
+		request, _ := http.NewRequest("POST", "/tag", strings.NewReader(`{"a":"`+strings.Repeat("b", 16)+`"}`))
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		input.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Log(contentType, recorder.Code)
+			t.Fail()
+		}
+	}
+	request, _ := http.NewRequest("POST", "/tag", strings.NewReader(`{"a":"b"}`))
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || len(port.recordSets) != 1 {
+		t.Fail()
+	}
+	value, err := (&RequestCountTopic{}).Value(input)
+	if err != nil || value.(int64) != 3 {
+		t.Fail()
+	}
+}
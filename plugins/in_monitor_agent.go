@@ -0,0 +1,140 @@
+package plugins
+
+import (
+	"encoding/json"
+	"github.com/moriyoshi/ik"
+	"net"
+	"net/http"
+)
+
+type MonitorAgentInput struct {
+	factory  *MonitorAgentInputFactory
+	engine   ik.Engine
+	logger   ik.Logger
+	bind     string
+	listener net.Listener
+	server   *http.Server
+}
+
+type MonitorAgentInputFactory struct {
+}
+
+type monitorAgentTopic struct {
+	PluginId int    `json:"plugin_id"`
+	Plugin   string `json:"plugin"`
+	// the id given with @id, if any
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	// the name namespaced by the plugin and the id, e.g.
+	// "forward[main].entries"
+	QualifiedName string `json:"qualified_name"`
+	DisplayName   string `json:"display_name"`
+	Description   string `json:"description"`
+	// the number or the flag as it is if the topic provides one, or the
+	// text otherwise
+	Value interface{} `json:"value"`
+	Error string      `json:"error,omitempty"`
+}
+
+// fetches the values of the topics on every request so that they are live.
+func (input *MonitorAgentInput) collect() []monitorAgentTopic {
+	retval := make([]monitorAgentTopic, 0)
+	scorekeeper := input.engine.Scorekeeper()
+	for i, pluginInstance := range input.engine.PluginInstances() {
+		plugin := pluginInstance.Factory()
+		for _, topic := range scorekeeper.GetTopics(plugin) {
+			entry := monitorAgentTopic{
+				PluginId:      i + 1,
+				Plugin:        plugin.Name(),
+				Id:            ik.PluginInstanceId(pluginInstance),
+				Name:          topic.Name,
+				QualifiedName: ik.QualifiedTopicName(pluginInstance, topic),
+				DisplayName:   topic.DisplayName,
+				Description:   topic.Description,
+			}
+			value, err := ik.FetchScoreValue(topic.Fetcher, pluginInstance)
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Value = value
+			}
+			retval = append(retval, entry)
+		}
+	}
+	return retval
+}
+
+func (input *MonitorAgentInput) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/api/plugins.json" {
+		http.NotFound(writer, request)
+		return
+	}
+	body, err := json.Marshal(map[string]interface{}{"topics": input.collect()})
+	if err != nil {
+		input.logger.Error("%s", err.Error())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(body)
+}
+
+func (input *MonitorAgentInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *MonitorAgentInput) Port() ik.Port {
+	return nil
+}
+
+func (input *MonitorAgentInput) Run() error {
+	err := input.server.Serve(input.listener)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+	}
+	return err
+}
+
+func (input *MonitorAgentInput) Shutdown() error {
+	return input.listener.Close()
+}
+
+func newMonitorAgentInput(factory *MonitorAgentInputFactory, logger ik.Logger, engine ik.Engine, bind string) (*MonitorAgentInput, error) {
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		logger.Warning("%s", err.Error())
+		return nil, err
+	}
+	input := &MonitorAgentInput{
+		factory:  factory,
+		engine:   engine,
+		logger:   logger,
+		bind:     bind,
+		listener: listener,
+	}
+	input.server = &http.Server{Handler: input}
+	return input, nil
+}
+
+func (factory *MonitorAgentInputFactory) Name() string {
+	return "monitor_agent"
+}
+
+func (factory *MonitorAgentInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	listen, ok := config.Attrs["bind"]
+	if !ok {
+		listen = ""
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "24220"
+	}
+	bind := listen + ":" + netPort
+	return newMonitorAgentInput(factory, engine.Logger(), engine, bind)
+}
+
+func (factory *MonitorAgentInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&MonitorAgentInputFactory{})
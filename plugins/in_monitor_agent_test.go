@@ -0,0 +1,78 @@
+package plugins
+
+import (
+	"encoding/json"
+	"github.com/moriyoshi/ik"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMonitorAgentInput_ServeHTTP(t *testing.T) {
+	engine := newTestEngineWithHttpInputs(t, 3)
+	input := &MonitorAgentInput{engine: engine, logger: &testLogger{t}}
+	fetch := func() []monitorAgentTopic {
+		recorder := httptest.NewRecorder()
+		input.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/plugins.json", nil))
+		if recorder.Code != 200 || recorder.Header().Get("Content-Type") != "application/json" {
+			t.FailNow()
+		}
+		v := struct {
+			Topics []monitorAgentTopic `json:"topics"`
+		}{}
+		err := json.Unmarshal(recorder.Body.Bytes(), &v)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		return v.Topics
+	}
+	topics := fetch()
+	expected := monitorAgentTopic{
+		PluginId:      1,
+		Plugin:        "http",
+		Name:          "requests",
+		QualifiedName: "http.requests",
+		DisplayName:   "Total number of requests",
+		Description:   "Total number of requests received so far",
+		Value:         float64(3),
+	}
+	if len(topics) != 1 || topics[0] != expected {
+		t.Log(topics)
+		t.FailNow()
+	}
+	// the values are fetched on every request
+	atomic.AddInt64(&engine.pluginInstances[0].(*HttpInput).requests, 1)
+	if fetch()[0].Value != float64(4) {
+		t.Fail()
+	}
+}
+
+func TestMonitorAgentInput_ServeHTTP_TypedValues(t *testing.T) {
+	factory := &ForwardInputFactory{}
+	scorekeeper := ik.NewScorekeeper(&testLogger{t})
+	factory.BindScorekeeper(scorekeeper)
+	forwardInput := newForwardInputForBinds(factory, &testLogger{t}, []string{"127.0.0.1:0"}, nil, forwardInputOptions{})
+	forwardInput.entries = 3
+	input := &MonitorAgentInput{
+		engine: &testEngine{scorekeeper: scorekeeper, pluginInstances: []ik.PluginInstance{forwardInput}},
+		logger: &testLogger{t},
+	}
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/plugins.json", nil))
+	v := struct {
+		Topics []map[string]interface{} `json:"topics"`
+	}{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &v)
+	if err != nil {
+		t.FailNow()
+	}
+	values := map[string]interface{}{}
+	for _, topic := range v.Topics {
+		values[topic["name"].(string)] = topic["value"]
+	}
+	if values["entries"] != float64(3) || values["draining"] != false || values["bytes"] != float64(0) {
+		t.Log(values)
+		t.Fail()
+	}
+}
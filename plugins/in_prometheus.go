@@ -0,0 +1,223 @@
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var prometheusInvalidCharRegExp = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+type PrometheusInput struct {
+	factory     *PrometheusInputFactory
+	engine      ik.Engine
+	logger      ik.Logger
+	bind        string
+	metricsPath string
+	listener    net.Listener
+	server      *http.Server
+}
+
+type PrometheusInputFactory struct {
+}
+
+type prometheusSample struct {
+	// the id of the instance, or its number if not given
+	instance  string
+	value     float64
+	histogram *ik.HistogramSnapshot
+}
+
+type prometheusMetric struct {
+	help string
+	// "gauge" or "histogram"
+	kind    string
+	samples []prometheusSample
+}
+
+func prometheusMetricName(plugin ik.Plugin, topic ik.ScorekeeperTopic) string {
+	return "ik_" + prometheusInvalidCharRegExp.ReplaceAllString(plugin.Name()+"_"+topic.Name, "_")
+}
+
+// converts the value of a topic to a sample.  the flags are 1 or 0, and
+// the text is taken if it is a number.
+func prometheusValue(value interface{}) (float64, bool) {
+	switch value_ := value.(type) {
+	case int64:
+		return float64(value_), true
+	case float64:
+		return value_, true
+	case bool:
+		if value_ {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		retval, err := strconv.ParseFloat(strings.TrimSpace(value_), 64)
+		return retval, err == nil
+	}
+	return 0, false
+}
+
+// collects the topics whose value is numeric for every plugin instance.
+// the instances without @id are numbered in the same way as the HTML
+// scoreboard does.
+func (input *PrometheusInput) collect() map[string]*prometheusMetric {
+	retval := make(map[string]*prometheusMetric)
+	scorekeeper := input.engine.Scorekeeper()
+	for i, pluginInstance := range input.engine.PluginInstances() {
+		plugin := pluginInstance.Factory()
+		for _, topic := range scorekeeper.GetTopics(plugin) {
+			value_, err := ik.FetchScoreValue(topic.Fetcher, pluginInstance)
+			if err != nil {
+				input.logger.Error("%s", err.Error())
+				continue
+			}
+			sample := prometheusSample{instance: ik.PluginInstanceId(pluginInstance)}
+			if sample.instance == "" {
+				sample.instance = strconv.Itoa(i + 1)
+			}
+			kind := "gauge"
+			histogram, ok := value_.(ik.HistogramSnapshot)
+			if ok {
+				sample.histogram = &histogram
+				kind = "histogram"
+			} else {
+				sample.value, ok = prometheusValue(value_)
+				if !ok {
+					continue
+				}
+			}
+			name := prometheusMetricName(plugin, topic)
+			metric, ok := retval[name]
+			if !ok {
+				metric = &prometheusMetric{help: topic.Description, kind: kind}
+				retval[name] = metric
+			}
+			metric.samples = append(metric.samples, sample)
+		}
+	}
+	return retval
+}
+
+// renders the cumulative counts of the buckets, the sum and the count.
+func renderPrometheusHistogram(buf *bytes.Buffer, name string, instance string, histogram *ik.HistogramSnapshot) {
+	cumulative := int64(0)
+	for i, bound := range histogram.Bounds {
+		cumulative += histogram.Counts[i]
+		fmt.Fprintf(buf, "%s_bucket{instance_id=%s,le=\"%s\"} %d\n", name, prometheusLabelValue(instance), strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket{instance_id=%s,le=\"+Inf\"} %d\n", name, prometheusLabelValue(instance), histogram.Count)
+	fmt.Fprintf(buf, "%s_sum{instance_id=%s} %s\n", name, prometheusLabelValue(instance), strconv.FormatFloat(histogram.Sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count{instance_id=%s} %d\n", name, prometheusLabelValue(instance), histogram.Count)
+}
+
+// quotes the value of a label, escaping the backslashes, the double quotes
+// and the newlines.
+func prometheusLabelValue(value string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value) + "\""
+}
+
+// renders the metrics in the Prometheus text exposition format.
+func renderPrometheusMetrics(metrics map[string]*prometheusMetric) []byte {
+	names := make([]string, 0, len(metrics))
+	for name, _ := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		metric := metrics[name]
+		help := strings.Replace(strings.Replace(metric.help, "\\", "\\\\", -1), "\n", "\\n", -1)
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, metric.kind)
+		for _, sample := range metric.samples {
+			if sample.histogram != nil {
+				renderPrometheusHistogram(buf, name, sample.instance, sample.histogram)
+				continue
+			}
+			fmt.Fprintf(buf, "%s{instance_id=%s} %s\n", name, prometheusLabelValue(sample.instance), strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+	}
+	return buf.Bytes()
+}
+
+func (input *PrometheusInput) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != input.metricsPath {
+		http.NotFound(writer, request)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(renderPrometheusMetrics(input.collect()))
+}
+
+func (input *PrometheusInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *PrometheusInput) Port() ik.Port {
+	return nil
+}
+
+func (input *PrometheusInput) Run() error {
+	err := input.server.Serve(input.listener)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+	}
+	return err
+}
+
+func (input *PrometheusInput) Shutdown() error {
+	return input.listener.Close()
+}
+
+func newPrometheusInput(factory *PrometheusInputFactory, logger ik.Logger, engine ik.Engine, bind string, metricsPath string) (*PrometheusInput, error) {
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		logger.Warning("%s", err.Error())
+		return nil, err
+	}
+	input := &PrometheusInput{
+		factory:     factory,
+		engine:      engine,
+		logger:      logger,
+		bind:        bind,
+		metricsPath: metricsPath,
+		listener:    listener,
+	}
+	input.server = &http.Server{Handler: input}
+	return input, nil
+}
+
+func (factory *PrometheusInputFactory) Name() string {
+	return "prometheus"
+}
+
+func (factory *PrometheusInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	listen, ok := config.Attrs["bind"]
+	if !ok {
+		listen = ""
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "24231"
+	}
+	metricsPath, ok := config.Attrs["metrics_path"]
+	if !ok {
+		metricsPath = "/metrics"
+	}
+	bind := listen + ":" + netPort
+	return newPrometheusInput(factory, engine.Logger(), engine, bind, metricsPath)
+}
+
+func (factory *PrometheusInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&PrometheusInputFactory{})
@@ -0,0 +1,146 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// an engine that only knows about the plugin instances and the scorekeeper
+type testEngine struct {
+	ik.Engine
+	scorekeeper     *ik.Scorekeeper
+	pluginInstances []ik.PluginInstance
+}
+
+func (engine *testEngine) Scorekeeper() *ik.Scorekeeper {
+	return engine.scorekeeper
+}
+
+func (engine *testEngine) PluginInstances() []ik.PluginInstance {
+	return engine.pluginInstances
+}
+
+func newTestEngineWithHttpInputs(t *testing.T, requests ...int64) *testEngine {
+	factory := &HttpInputFactory{}
+	scorekeeper := ik.NewScorekeeper(&testLogger{t})
+	factory.BindScorekeeper(scorekeeper)
+	engine := &testEngine{scorekeeper: scorekeeper}
+	for _, n := range requests {
+		engine.pluginInstances = append(engine.pluginInstances, &HttpInput{factory: factory, requests: n})
+	}
+	return engine
+}
+
+func TestPrometheusInput_ServeHTTP(t *testing.T) {
+	input := &PrometheusInput{
+		engine:      newTestEngineWithHttpInputs(t, 3, 5),
+		logger:      &testLogger{t},
+		metricsPath: "/metrics",
+	}
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != 200 {
+		t.FailNow()
+	}
+	expected := "# HELP ik_http_requests Total number of requests received so far\n" +
+		"# TYPE ik_http_requests gauge\n" +
+		"ik_http_requests{instance_id=\"1\"} 3\n" +
+		"ik_http_requests{instance_id=\"2\"} 5\n"
+	if recorder.Body.String() != expected {
+		t.Log(recorder.Body.String())
+		t.Fail()
+	}
+	recorder = httptest.NewRecorder()
+	input.ServeHTTP(recorder, httptest.NewRequest("GET", "/other", nil))
+	if recorder.Code != 404 {
+		t.Fail()
+	}
+}
+
+func TestPrometheusInput_TypedValues(t *testing.T) {
+	factory := &ForwardInputFactory{}
+	scorekeeper := ik.NewScorekeeper(&testLogger{t})
+	factory.BindScorekeeper(scorekeeper)
+	forwardInput := newForwardInputForBinds(factory, &testLogger{t}, []string{"127.0.0.1:0"}, nil, forwardInputOptions{})
+	forwardInput.bytes = 2048
+	forwardInput.draining = 1
+	input := &PrometheusInput{
+		engine:      &testEngine{scorekeeper: scorekeeper, pluginInstances: []ik.PluginInstance{forwardInput}},
+		logger:      &testLogger{t},
+		metricsPath: "/metrics",
+	}
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	// neither is parsed from the text, which is "2.0 KiB" and "true"
+	for _, expected := range []string{
+		"ik_forward_bytes{instance_id=\"1\"} 2048\n",
+		"ik_forward_draining{instance_id=\"1\"} 1\n",
+		"ik_forward_connections{instance_id=\"1\"} 0\n",
+		"# TYPE ik_forward_decode_seconds histogram\n",
+		"ik_forward_decode_seconds_count{instance_id=\"1\"} 0\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Log(body)
+			t.Fail()
+		}
+	}
+	// the text is not a number
+	if strings.Contains(body, "ik_forward_entries_by_tag") {
+		t.Fail()
+	}
+}
+
+func TestRenderPrometheusMetrics_Histogram(t *testing.T) {
+	histogram := ik.NewHistogram([]float64{0.001, 0.01})
+	histogram.Observe(0.0005)
+	histogram.Observe(0.005)
+	histogram.Observe(0.5)
+	snapshot := histogram.Snapshot()
+	body := string(renderPrometheusMetrics(map[string]*prometheusMetric{
+		"ik_forward_decode_seconds": {
+			help:    "Decode time",
+			kind:    "histogram",
+			samples: []prometheusSample{{instance: "1", histogram: &snapshot}},
+		},
+	}))
+	expected := "# HELP ik_forward_decode_seconds Decode time\n" +
+		"# TYPE ik_forward_decode_seconds histogram\n" +
+		"ik_forward_decode_seconds_bucket{instance_id=\"1\",le=\"0.001\"} 1\n" +
+		"ik_forward_decode_seconds_bucket{instance_id=\"1\",le=\"0.01\"} 2\n" +
+		"ik_forward_decode_seconds_bucket{instance_id=\"1\",le=\"+Inf\"} 3\n" +
+		"ik_forward_decode_seconds_sum{instance_id=\"1\"} 0.5055\n" +
+		"ik_forward_decode_seconds_count{instance_id=\"1\"} 3\n"
+	if body != expected {
+		t.Log(body)
+		t.Fail()
+	}
+}
+
+func TestPrometheusInput_InstanceId(t *testing.T) {
+	factory := &ForwardInputFactory{}
+	scorekeeper := ik.NewScorekeeper(&testLogger{t})
+	factory.BindScorekeeper(scorekeeper)
+	main := newForwardInputForBinds(factory, &testLogger{t}, []string{"127.0.0.1:0"}, nil, forwardInputOptions{id: "main"})
+	main.entries = 3
+	other := newForwardInputForBinds(factory, &testLogger{t}, []string{"127.0.0.1:0"}, nil, forwardInputOptions{})
+	other.entries = 5
+	input := &PrometheusInput{
+		engine:      &testEngine{scorekeeper: scorekeeper, pluginInstances: []ik.PluginInstance{main, other}},
+		logger:      &testLogger{t},
+		metricsPath: "/metrics",
+	}
+	recorder := httptest.NewRecorder()
+	input.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	// numbered unless given an id
+	if !strings.Contains(body, "ik_forward_entries{instance_id=\"main\"} 3\n") || !strings.Contains(body, "ik_forward_entries{instance_id=\"2\"} 5\n") {
+		t.Log(body)
+		t.Fail()
+	}
+	if prometheusLabelValue("a\"b\\c\nd") != "\"a\\\"b\\\\c\\nd\"" {
+		t.Fail()
+	}
+}
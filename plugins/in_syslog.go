@@ -0,0 +1,358 @@
+package plugins
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var syslogFacilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverityNames = []string{
+	"emerg", "alert", "crit", "err", "warn", "notice", "info", "debug",
+}
+
+type syslogMessageParser func(line string, now time.Time) (ik.FluentRecord, error)
+
+type SyslogInput struct {
+	factory    *SyslogInputFactory
+	port       ik.Port
+	logger     ik.Logger
+	bind       string
+	tagPrefix  string
+	parser     syslogMessageParser
+	pump       *ik.RecordPump
+	conn       net.PacketConn
+	listener   net.Listener
+	buffer     []byte
+	clients    map[net.Conn]struct{}
+	clientsMtx sync.Mutex
+}
+
+type SyslogInputFactory struct {
+}
+
+func parseSyslogPriority(line string) (string, string, string, error) {
+	if len(line) < 3 || line[0] != '<' {
+		return "", "", "", errors.New("missing priority")
+	}
+	e := strings.IndexByte(line, '>')
+	if e < 2 || e > 4 {
+		return "", "", "", errors.New("malformed priority")
+	}
+	pri := 0
+	for _, c := range []byte(line[1:e]) {
+		if c < '0' || c > '9' {
+			return "", "", "", errors.New("malformed priority")
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	facility := pri / 8
+	if facility >= len(syslogFacilityNames) {
+		return "", "", "", errors.New(fmt.Sprintf("invalid priority: %d", pri))
+	}
+	return syslogFacilityNames[facility], syslogSeverityNames[pri%8], line[e+1:], nil
+}
+
+// splits "program[pid]" into its parts
+func splitSyslogProgram(tag string) (string, string) {
+	s := strings.IndexByte(tag, '[')
+	if s >= 0 && strings.HasSuffix(tag, "]") {
+		return tag[0:s], tag[s+1 : len(tag)-1]
+	}
+	return tag, ""
+}
+
+func parseRFC3164(line string, now time.Time) (ik.FluentRecord, error) {
+	facility, severity, rest, err := parseSyslogPriority(line)
+	if err != nil {
+		return ik.FluentRecord{}, err
+	}
+	data := map[string]interface{}{
+		"facility": facility,
+		"severity": severity,
+	}
+	timestamp := now
+	if len(rest) >= 16 && rest[15] == ' ' {
+		t, err := time.ParseInLocation(time.Stamp, rest[0:15], now.Location())
+		if err == nil {
+			timestamp = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+			// a message logged in december and received in january
+			if timestamp.After(now.AddDate(0, 1, 0)) {
+				timestamp = timestamp.AddDate(-1, 0, 0)
+			}
+			rest = rest[16:]
+			fields := strings.SplitN(rest, " ", 2)
+			data["hostname"] = fields[0]
+			if len(fields) > 1 {
+				rest = fields[1]
+			} else {
+				rest = ""
+			}
+		}
+	}
+	c := strings.Index(rest, ": ")
+	if c > 0 && strings.IndexByte(rest[0:c], ' ') < 0 {
+		program, pid := splitSyslogProgram(rest[0:c])
+		data["program"] = program
+		if pid != "" {
+			data["pid"] = pid
+		}
+		rest = rest[c+2:]
+	}
+	data["message"] = rest
+	return ik.FluentRecord{
+		Tag:       facility + "." + severity,
+		Timestamp: uint64(timestamp.Unix()),
+		Data:      data,
+	}, nil
+}
+
+// skips the STRUCTURED-DATA part of an RFC5424 message
+func skipStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, "-") {
+		return s[1:], nil
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		i += 1
+		for {
+			if i >= len(s) {
+				return "", errors.New("unterminated structured data")
+			}
+			if s[i] == '\\' {
+				i += 2
+				continue
+			}
+			if s[i] == '"' {
+				i += 1
+				for i < len(s) && s[i] != '"' {
+					if s[i] == '\\' {
+						i += 1
+					}
+					i += 1
+				}
+			} else if s[i] == ']' {
+				i += 1
+				break
+			}
+			i += 1
+		}
+	}
+	if i == 0 {
+		return "", errors.New("malformed structured data")
+	}
+	return s[i:], nil
+}
+
+func parseRFC5424(line string, now time.Time) (ik.FluentRecord, error) {
+	facility, severity, rest, err := parseSyslogPriority(line)
+	if err != nil {
+		return ik.FluentRecord{}, err
+	}
+	fields := strings.SplitN(rest, " ", 7)
+	if len(fields) < 7 || fields[0] != "1" {
+		return ik.FluentRecord{}, errors.New("malformed RFC5424 header")
+	}
+	data := map[string]interface{}{
+		"facility": facility,
+		"severity": severity,
+	}
+	timestamp := now
+	if fields[1] != "-" {
+		timestamp, err = time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return ik.FluentRecord{}, err
+		}
+	}
+	if fields[2] != "-" {
+		data["hostname"] = fields[2]
+	}
+	if fields[3] != "-" {
+		data["program"] = fields[3]
+	}
+	if fields[4] != "-" {
+		data["pid"] = fields[4]
+	}
+	if fields[5] != "-" {
+		data["msgid"] = fields[5]
+	}
+	rest, err = skipStructuredData(fields[6])
+	if err != nil {
+		return ik.FluentRecord{}, err
+	}
+	data["message"] = strings.TrimPrefix(rest, " ")
+	return ik.FluentRecord{
+		Tag:       facility + "." + severity,
+		Timestamp: uint64(timestamp.Unix()),
+		Data:      data,
+	}, nil
+}
+
+func (input *SyslogInput) feed(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return
+	}
+	record, err := input.parser(line, time.Now())
+	if err != nil {
+		input.logger.Error("Unparsed line: %s (%s)", line, err.Error())
+		return
+	}
+	record.Tag = input.tagPrefix + "." + record.Tag
+	input.pump.EmitOne(record)
+}
+
+func (input *SyslogInput) handleStream(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		input.feed(line)
+		if err != nil {
+			if err != io.EOF {
+				input.logger.Error("%s", err.Error())
+			}
+			break
+		}
+	}
+	conn.Close()
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	delete(input.clients, conn)
+}
+
+func (input *SyslogInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *SyslogInput) Port() ik.Port {
+	return input.port
+}
+
+func (input *SyslogInput) Run() error {
+	if input.listener != nil {
+		conn, err := input.listener.Accept()
+		if err != nil {
+			input.logger.Warning("%s", err.Error())
+			return err
+		}
+		input.clientsMtx.Lock()
+		input.clients[conn] = struct{}{}
+		input.clientsMtx.Unlock()
+		go input.handleStream(conn)
+		return ik.Continue
+	}
+	n, _, err := input.conn.ReadFrom(input.buffer)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+		return err
+	}
+	input.feed(string(input.buffer[0:n]))
+	return ik.Continue
+}
+
+func (input *SyslogInput) Shutdown() error {
+	input.pump.Shutdown()
+	if input.listener != nil {
+		func() {
+			input.clientsMtx.Lock()
+			defer input.clientsMtx.Unlock()
+			for conn, _ := range input.clients {
+				conn.Close()
+			}
+		}()
+		return input.listener.Close()
+	}
+	return input.conn.Close()
+}
+
+func (input *SyslogInput) Dispose() {
+	input.Shutdown()
+}
+
+func newSyslogInput(factory *SyslogInputFactory, logger ik.Logger, engine ik.Engine, protocolType string, bind string, tagPrefix string, parser syslogMessageParser, port ik.Port) (*SyslogInput, error) {
+	input := &SyslogInput{
+		factory:    factory,
+		port:       port,
+		logger:     logger,
+		bind:       bind,
+		tagPrefix:  tagPrefix,
+		parser:     parser,
+		pump:       ik.NewRecordPump(port, DefaultBacklogSize),
+		clients:    make(map[net.Conn]struct{}),
+		clientsMtx: sync.Mutex{},
+	}
+	var err error
+	switch protocolType {
+	case "udp":
+		input.conn, err = net.ListenPacket("udp", bind)
+		input.buffer = make([]byte, 65536)
+	case "tcp":
+		input.listener, err = net.Listen("tcp", bind)
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported protocol_type: %s", protocolType))
+	}
+	if err != nil {
+		logger.Warning("%s", err.Error())
+		return nil, err
+	}
+	err = engine.Spawn(input.pump)
+	if err != nil {
+		input.Shutdown()
+		return nil, err
+	}
+	return input, nil
+}
+
+func (factory *SyslogInputFactory) Name() string {
+	return "syslog"
+}
+
+func (factory *SyslogInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	listen, ok := config.Attrs["listen"]
+	if !ok {
+		listen = ""
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "5140"
+	}
+	bind := listen + ":" + netPort
+	protocolType, ok := config.Attrs["protocol_type"]
+	if !ok {
+		protocolType = "udp"
+	}
+	tagPrefix, ok := config.Attrs["tag_prefix"]
+	if !ok {
+		tagPrefix = "syslog"
+	}
+	parserName, ok := config.Attrs["parser"]
+	if !ok {
+		parserName = "rfc3164"
+	}
+	var parser syslogMessageParser
+	switch parserName {
+	case "rfc3164":
+		parser = parseRFC3164
+	case "rfc5424":
+		parser = parseRFC5424
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported parser: %s", parserName))
+	}
+	return newSyslogInput(factory, engine.Logger(), engine, protocolType, bind, tagPrefix, parser, engine.DefaultPort())
+}
+
+func (factory *SyslogInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&SyslogInputFactory{})
@@ -0,0 +1,90 @@
+package plugins
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRFC3164(t *testing.T) {
+	now := time.Date(2014, time.August, 29, 12, 0, 0, 0, time.UTC)
+	record, err := parseRFC3164("<34>Aug 29 04:22:25 mymachine su[123]: 'su root' failed", now)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if record.Tag != "auth.crit" {
+		t.Log(record.Tag)
+		t.Fail()
+	}
+	if record.Timestamp != uint64(time.Date(2014, time.August, 29, 4, 22, 25, 0, time.UTC).Unix()) {
+		t.Fail()
+	}
+	if record.Data["hostname"] != "mymachine" || record.Data["program"] != "su" || record.Data["pid"] != "123" {
+		t.Fail()
+	}
+	if record.Data["message"] != "'su root' failed" {
+		t.Fail()
+	}
+}
+
+func TestParseRFC3164_NoHeader(t *testing.T) {
+	now := time.Date(2014, time.August, 29, 12, 0, 0, 0, time.UTC)
+	record, err := parseRFC3164("<13>hello world", now)
+	if err != nil {
+		t.FailNow()
+	}
+	if record.Tag != "user.notice" || record.Timestamp != uint64(now.Unix()) {
+		t.Fail()
+	}
+	if record.Data["message"] != "hello world" {
+		t.Fail()
+	}
+}
+
+func TestParseRFC3164_InvalidPriority(t *testing.T) {
+	now := time.Date(2014, time.August, 29, 12, 0, 0, 0, time.UTC)
+	for _, line := range []string{"<-1>hello", "<-9>hello", "<+1>hello", "<192>hello", "<1a>hello"} {
+		_, err := parseRFC3164(line, now)
+		if err == nil {
+			t.Log(line)
+			t.Fail()
+		}
+	}
+	record, err := parseRFC3164("<191>hello", now)
+	if err != nil || record.Tag != "local7.debug" {
+		t.Fail()
+	}
+}
+
+func TestParseRFC5424(t *testing.T) {
+	line := `<165>1 2014-08-29T04:22:25.003Z mymachine evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App]"] An application event`
+	record, err := parseRFC5424(line, time.Now())
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if record.Tag != "local4.notice" {
+		t.Log(record.Tag)
+		t.Fail()
+	}
+	if record.Timestamp != uint64(time.Date(2014, time.August, 29, 4, 22, 25, 0, time.UTC).Unix()) {
+		t.Fail()
+	}
+	if record.Data["hostname"] != "mymachine" || record.Data["program"] != "evntslog" || record.Data["msgid"] != "ID47" {
+		t.Fail()
+	}
+	if _, ok := record.Data["pid"]; ok {
+		t.Fail()
+	}
+	if record.Data["message"] != "An application event" {
+		t.Log(record.Data["message"])
+		t.Fail()
+	}
+}
+
+func TestParseRFC5424_Malformed(t *testing.T) {
+	_, err := parseRFC5424("<165>2 - - - - - -", time.Now())
+	if err == nil {
+		t.Fail()
+	}
+}
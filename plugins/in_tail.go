@@ -980,6 +980,9 @@ func (input *TailInput) openTailWatcher(path string) (*TailWatcher, error) {
 	}
 	lineParser, err := input.lineParserFactory.New(func(record ik.FluentRecord) error {
 		record.Tag = input.tagPrefix
+		if record.Timestamp == 0 {
+			record.Timestamp = uint64(time.Now().Unix())
+		}
 		input.pump.EmitOne(record)
 		return nil
 	})
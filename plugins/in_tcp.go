@@ -0,0 +1,228 @@
+package plugins
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the default of max_line_size
+const defaultTcpMaxLineSize = 1024 * 1024
+
+type tcpClient struct {
+	input      *TcpInput
+	logger     ik.Logger
+	conn       net.Conn
+	scanner    *bufio.Scanner
+	lineParser ik.LineParser
+}
+
+type TcpInput struct {
+	factory           *TcpInputFactory
+	port              ik.Port
+	logger            ik.Logger
+	bind              string
+	tag               string
+	maxLineSize       int
+	listener          net.Listener
+	lineParserFactory ik.LineParserFactory
+	pump              *ik.RecordPump
+	clients           map[net.Conn]*tcpClient
+	clientsMtx        sync.Mutex
+}
+
+type TcpInputFactory struct {
+}
+
+// the connection is closed once a line exceeds max_line_size, as the rest
+// of the line can't be told from the next one.
+func (c *tcpClient) handle() {
+	for c.scanner.Scan() {
+		line := c.scanner.Text()
+		if len(line) > 0 {
+			err := c.lineParser.Feed(line)
+			if err != nil {
+				c.logger.Error("%s", err.Error())
+			}
+		}
+	}
+	err := c.scanner.Err()
+	if err == nil {
+		c.logger.Info("Client %s closed the connection", c.conn.RemoteAddr().String())
+	} else if err == bufio.ErrTooLong {
+		c.logger.Error("Closing the connection from %s which sent a line longer than %d bytes", c.conn.RemoteAddr().String(), c.input.maxLineSize)
+	} else {
+		c.logger.Error("%s", err.Error())
+	}
+	err = c.conn.Close()
+	if err != nil {
+		c.logger.Warning("%s", err.Error())
+	}
+	c.input.markDischarged(c)
+}
+
+func newTcpClient(input *TcpInput, logger ik.Logger, conn net.Conn) (*tcpClient, error) {
+	lineParser, err := input.lineParserFactory.New(func(record ik.FluentRecord) error {
+		record.Tag = input.tag
+		if record.Timestamp == 0 {
+			record.Timestamp = uint64(time.Now().Unix())
+		}
+		input.pump.EmitOne(record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	bufferSize := 4096
+	if bufferSize > input.maxLineSize {
+		bufferSize = input.maxLineSize
+	}
+	scanner.Buffer(make([]byte, 0, bufferSize), input.maxLineSize)
+	c := &tcpClient{
+		input:      input,
+		logger:     logger,
+		conn:       conn,
+		scanner:    scanner,
+		lineParser: lineParser,
+	}
+	input.markCharged(c)
+	return c, nil
+}
+
+func (input *TcpInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *TcpInput) Port() ik.Port {
+	return input.port
+}
+
+func (input *TcpInput) Run() error {
+	conn, err := input.listener.Accept()
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+		return err
+	}
+	c, err := newTcpClient(input, input.logger, conn)
+	if err != nil {
+		input.logger.Error("%s", err.Error())
+		conn.Close()
+		return ik.Continue
+	}
+	go c.handle()
+	return ik.Continue
+}
+
+func (input *TcpInput) Shutdown() error {
+	func() {
+		input.clientsMtx.Lock()
+		defer input.clientsMtx.Unlock()
+		for conn, _ := range input.clients {
+			err := conn.Close()
+			if err != nil {
+				input.logger.Warning("Error during closing connection: %s", err.Error())
+			}
+		}
+	}()
+	input.pump.Shutdown()
+	return input.listener.Close()
+}
+
+func (input *TcpInput) Dispose() {
+	input.Shutdown()
+}
+
+func (input *TcpInput) markCharged(c *tcpClient) {
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	input.clients[c.conn] = c
+}
+
+func (input *TcpInput) markDischarged(c *tcpClient) {
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	delete(input.clients, c.conn)
+}
+
+func newTcpInput(factory *TcpInputFactory, logger ik.Logger, engine ik.Engine, bind string, tag string, lineParserFactory ik.LineParserFactory, maxLineSize int, port ik.Port) (*TcpInput, error) {
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		logger.Warning("%s", err.Error())
+		return nil, err
+	}
+	pump := ik.NewRecordPump(port, DefaultBacklogSize)
+	err = engine.Spawn(pump)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &TcpInput{
+		factory:           factory,
+		port:              port,
+		logger:            logger,
+		bind:              bind,
+		tag:               tag,
+		maxLineSize:       maxLineSize,
+		listener:          listener,
+		lineParserFactory: lineParserFactory,
+		pump:              pump,
+		clients:           make(map[net.Conn]*tcpClient),
+		clientsMtx:        sync.Mutex{},
+	}, nil
+}
+
+func (factory *TcpInputFactory) Name() string {
+	return "tcp"
+}
+
+func (factory *TcpInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	listen, ok := config.Attrs["listen"]
+	if !ok {
+		listen = ""
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "5170"
+	}
+	bind := listen + ":" + netPort
+	tag, ok := config.Attrs["tag"]
+	if !ok {
+		return nil, errors.New("required attribute `tag' is not specified")
+	}
+	format, ok := config.Attrs["format"]
+	if !ok {
+		format = "json"
+	}
+	maxLineSize := defaultTcpMaxLineSize
+	maxLineSizeStr, ok := config.Attrs["max_line_size"]
+	if ok {
+		_maxLineSize, err := ik.ParseCapacityString(maxLineSizeStr)
+		if err != nil {
+			return nil, err
+		}
+		if _maxLineSize <= 0 {
+			return nil, errors.New(fmt.Sprintf("invalid max_line_size: %s", strconv.Quote(maxLineSizeStr)))
+		}
+		maxLineSize = int(_maxLineSize)
+	}
+	lineParserFactoryFactory := engine.LineParserPluginRegistry().LookupLineParserFactoryFactory(format)
+	if lineParserFactoryFactory == nil {
+		return nil, errors.New(fmt.Sprintf("Format `%s' is not supported", format))
+	}
+	lineParserFactory, err := lineParserFactoryFactory(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	return newTcpInput(factory, engine.Logger(), engine, bind, tag, lineParserFactory, maxLineSize, engine.DefaultPort())
+}
+
+func (factory *TcpInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&TcpInputFactory{})
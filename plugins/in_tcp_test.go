@@ -0,0 +1,84 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runs the spawnees on their own goroutines, and hands back what Run()
+// returned.
+type spawningEngine struct {
+	ik.Engine
+	done chan error
+}
+
+func (engine *spawningEngine) Spawn(spawnee ik.Spawnee) error {
+	go func() {
+		engine.done <- spawnee.Run()
+	}()
+	return nil
+}
+
+func newSpawningEngine() *spawningEngine {
+	return &spawningEngine{done: make(chan error, 1)}
+}
+
+// receives the "message" fields of n records with the tag.
+func receiveMessages(t *testing.T, port chanPort, tag string, n int) []string {
+	messages := []string{}
+	timeout := time.After(5 * time.Second)
+	for len(messages) < n {
+		select {
+		case recordSets := <-port:
+			for _, recordSet := range recordSets {
+				if recordSet.Tag != tag {
+					t.Fail()
+				}
+				for _, record := range recordSet.Records {
+					messages = append(messages, record.Data["message"].(string))
+					if record.Timestamp == 0 {
+						t.Fail()
+					}
+				}
+			}
+		case <-timeout:
+			t.Log(messages)
+			t.FailNow()
+		}
+	}
+	return messages
+}
+
+func TestTcpInput_Run(t *testing.T) {
+	port := make(chanPort, 10)
+	engine := newSpawningEngine()
+	input, err := newTcpInput(&TcpInputFactory{}, &testLogger{t}, engine, "127.0.0.1:0", "tcp.test", &testLineParserFactory{}, 8, port)
+	if err != nil {
+		t.FailNow()
+	}
+	defer input.Shutdown()
+	go input.Run()
+	conn, err := net.Dial("tcp", input.listener.Addr().String())
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("a\r\nb\n\n" + strings.Repeat("c", 9) + "\nd\n"))
+	if err != nil {
+		t.FailNow()
+	}
+	// the connection is closed at the line longer than max_line_size
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil || err.(net.Error).Timeout() {
+		t.Fail()
+	}
+	messages := receiveMessages(t, port, "tcp.test", 2)
+	if strings.Join(messages, ",") != "a,b" {
+		t.Log(messages)
+		t.Fail()
+	}
+}
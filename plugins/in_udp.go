@@ -0,0 +1,145 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type UdpInput struct {
+	factory    *UdpInputFactory
+	port       ik.Port
+	logger     ik.Logger
+	bind       string
+	tag        string
+	conn       net.PacketConn
+	lineParser ik.LineParser
+	pump       *ik.RecordPump
+	buffer     []byte
+}
+
+type UdpInputFactory struct {
+}
+
+func (input *UdpInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *UdpInput) Port() ik.Port {
+	return input.port
+}
+
+func (input *UdpInput) Run() error {
+	n, addr, err := input.conn.ReadFrom(input.buffer)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+		return err
+	}
+	line := strings.TrimRight(string(input.buffer[0:n]), "\r\n")
+	if len(line) > 0 {
+		err = input.lineParser.Feed(line)
+		if err != nil {
+			input.logger.Error("Failed to parse datagram from %s: %s", addr.String(), err.Error())
+		}
+	}
+	return ik.Continue
+}
+
+func (input *UdpInput) Shutdown() error {
+	input.pump.Shutdown()
+	return input.conn.Close()
+}
+
+func (input *UdpInput) Dispose() {
+	input.Shutdown()
+}
+
+func newUdpInput(factory *UdpInputFactory, logger ik.Logger, engine ik.Engine, bind string, tag string, lineParserFactory ik.LineParserFactory, messageLengthLimit int, port ik.Port) (*UdpInput, error) {
+	conn, err := net.ListenPacket("udp", bind)
+	if err != nil {
+		logger.Warning("%s", err.Error())
+		return nil, err
+	}
+	input := &UdpInput{
+		factory: factory,
+		port:    port,
+		logger:  logger,
+		bind:    bind,
+		tag:     tag,
+		conn:    conn,
+		pump:    ik.NewRecordPump(port, DefaultBacklogSize),
+		buffer:  make([]byte, messageLengthLimit),
+	}
+	input.lineParser, err = lineParserFactory.New(func(record ik.FluentRecord) error {
+		record.Tag = input.tag
+		if record.Timestamp == 0 {
+			record.Timestamp = uint64(time.Now().Unix())
+		}
+		input.pump.EmitOne(record)
+		return nil
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = engine.Spawn(input.pump)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return input, nil
+}
+
+func (factory *UdpInputFactory) Name() string {
+	return "udp"
+}
+
+func (factory *UdpInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	listen, ok := config.Attrs["listen"]
+	if !ok {
+		listen = ""
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "5160"
+	}
+	bind := listen + ":" + netPort
+	tag, ok := config.Attrs["tag"]
+	if !ok {
+		return nil, errors.New("required attribute `tag' is not specified")
+	}
+	format, ok := config.Attrs["format"]
+	if !ok {
+		format = "json"
+	}
+	messageLengthLimit := 4096
+	messageLengthLimitStr, ok := config.Attrs["message_length_limit"]
+	if ok {
+		_messageLengthLimit, err := ik.ParseCapacityString(messageLengthLimitStr)
+		if err != nil {
+			return nil, err
+		}
+		if _messageLengthLimit <= 0 {
+			return nil, errors.New(fmt.Sprintf("invalid message_length_limit: %s", strconv.Quote(messageLengthLimitStr)))
+		}
+		messageLengthLimit = int(_messageLengthLimit)
+	}
+	lineParserFactoryFactory := engine.LineParserPluginRegistry().LookupLineParserFactoryFactory(format)
+	if lineParserFactoryFactory == nil {
+		return nil, errors.New(fmt.Sprintf("Format `%s' is not supported", format))
+	}
+	lineParserFactory, err := lineParserFactoryFactory(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	return newUdpInput(factory, engine.Logger(), engine, bind, tag, lineParserFactory, messageLengthLimit, engine.DefaultPort())
+}
+
+func (factory *UdpInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&UdpInputFactory{})
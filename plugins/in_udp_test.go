@@ -0,0 +1,38 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestUdpInput_Run(t *testing.T) {
+	port := make(chanPort, 10)
+	engine := newSpawningEngine()
+	input, err := newUdpInput(&UdpInputFactory{}, &testLogger{t}, engine, "127.0.0.1:0", "udp.test", &testLineParserFactory{}, 8, port)
+	if err != nil {
+		t.FailNow()
+	}
+	defer input.Shutdown()
+	conn, err := net.Dial("udp", input.conn.LocalAddr().String())
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	// the datagram longer than message_length_limit is truncated
+	for _, datagram := range []string{"a\r\n", "\n", strings.Repeat("b", 9)} {
+		_, err = conn.Write([]byte(datagram))
+		if err != nil {
+			t.FailNow()
+		}
+		if input.Run() != ik.Continue {
+			t.FailNow()
+		}
+	}
+	messages := receiveMessages(t, port, "udp.test", 2)
+	if strings.Join(messages, ",") != "a,"+strings.Repeat("b", 8) {
+		t.Log(messages)
+		t.Fail()
+	}
+}
@@ -0,0 +1,126 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"net"
+	"os"
+	"strconv"
+)
+
+// UnixInput speaks the forward protocol over a Unix domain socket.
+type UnixInput struct {
+	*ForwardInput
+	factory    *UnixInputFactory
+	path       string
+	permission os.FileMode
+}
+
+type UnixInputFactory struct {
+}
+
+func (input *UnixInput) Factory() ik.Plugin {
+	return input.factory
+}
+
+func (input *UnixInput) Shutdown() error {
+	bound := len(input.listeners) > 0
+	err := input.ForwardInput.Shutdown()
+	if !bound {
+		// the path may belong to someone else unless bound by the input
+		return err
+	}
+	err_ := os.Remove(input.path)
+	if err_ != nil && !os.IsNotExist(err_) {
+		input.logger.Warning("Failed to remove %s: %s", input.path, err_.Error())
+	}
+	return err
+}
+
+func (input *UnixInput) Dispose() {
+	input.Shutdown()
+}
+
+// removes the socket left by the previous run, which nobody accepts on
+// any longer.  neither a file which isn't a socket nor a socket still in
+// use by another process is removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New(fmt.Sprintf("%s exists and is not a socket", path))
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return errors.New(fmt.Sprintf("%s is in use", path))
+	}
+	return os.Remove(path)
+}
+
+// Removes the stale socket, binds the path and sets the permission of
+// the socket.  It does nothing if already bound.
+func (input *UnixInput) Start() error {
+	if len(input.listeners) > 0 {
+		return nil
+	}
+	err := removeStaleSocket(input.path)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+		return err
+	}
+	listener, err := net.Listen("unix", input.path)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+		return err
+	}
+	err = os.Chmod(input.path, input.permission)
+	if err != nil {
+		input.logger.Warning("%s", err.Error())
+		listener.Close()
+		return err
+	}
+	input.listeners = []net.Listener{listener}
+	return nil
+}
+
+func newUnixInput(factory *UnixInputFactory, logger ik.Logger, path string, permission os.FileMode, port ik.Port) *UnixInput {
+	return &UnixInput{
+		ForwardInput: newForwardInputForBinds(nil, logger, []string{path}, port, forwardInputOptions{}),
+		factory:      factory,
+		path:         path,
+		permission:   permission,
+	}
+}
+
+func (factory *UnixInputFactory) Name() string {
+	return "unix"
+}
+
+func (factory *UnixInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
+	path, ok := config.Attrs["path"]
+	if !ok {
+		path = "/var/run/ik/ik.sock"
+	}
+	permission := os.FileMode(0644)
+	permissionStr, ok := config.Attrs["permission"]
+	if ok {
+		_permission, err := strconv.ParseUint(permissionStr, 8, 32)
+		if err != nil {
+			return nil, err
+		}
+		permission = os.FileMode(_permission)
+	}
+	return newUnixInput(factory, engine.Logger(), path, permission, engine.DefaultPort()), nil
+}
+
+func (factory *UnixInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&UnixInputFactory{})
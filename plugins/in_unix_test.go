@@ -0,0 +1,93 @@
+package plugins
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestUnixInput_StaleSocketAndShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_unix")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	sockPath := path.Join(dir, "ik.sock")
+	// the socket of a previous run which nobody listens on any longer
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: sockPath, Net: "unix"})
+	if err != nil {
+		t.FailNow()
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+	input := newUnixInput(&UnixInputFactory{}, &testLogger{t}, sockPath, 0600, nil)
+	// nothing is done to the path until started
+	_, err = os.Stat(sockPath)
+	if err != nil {
+		t.FailNow()
+	}
+	err = input.Start()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	info, err := os.Stat(sockPath)
+	if err != nil {
+		t.FailNow()
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Fail()
+	}
+	input.Shutdown()
+	_, err = os.Stat(sockPath)
+	if !os.IsNotExist(err) {
+		t.Fail()
+	}
+}
+
+func TestUnixInput_KeepsFileAndLiveSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_unix")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "file")
+	err = ioutil.WriteFile(filePath, []byte("data"), 0644)
+	if err != nil {
+		t.FailNow()
+	}
+	err = newUnixInput(&UnixInputFactory{}, &testLogger{t}, filePath, 0600, nil).Start()
+	if err == nil {
+		t.Fail()
+	}
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil || string(b) != "data" {
+		t.Fail()
+	}
+	// the socket of another instance still running
+	sockPath := path.Join(dir, "ik.sock")
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.FailNow()
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	err = newUnixInput(&UnixInputFactory{}, &testLogger{t}, sockPath, 0600, nil).Start()
+	if err == nil {
+		t.Fail()
+	}
+	info, err := os.Stat(sockPath)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,174 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"sync"
+)
+
+const (
+	copyModeNoCopy = iota
+	copyModeShallow
+	copyModeDeep
+)
+
+type CopyOutput struct {
+	factory      *CopyOutputFactory
+	engine       ik.Engine
+	logger       ik.Logger
+	stores       []ik.Output
+	copyMode     int
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
+}
+
+type CopyOutputFactory struct {
+}
+
+func deepCopyValue(v interface{}) interface{} {
+	switch v_ := v.(type) {
+	case map[string]interface{}:
+		retval := make(map[string]interface{}, len(v_))
+		for k, e := range v_ {
+			retval[k] = deepCopyValue(e)
+		}
+		return retval
+	case []interface{}:
+		retval := make([]interface{}, len(v_))
+		for i, e := range v_ {
+			retval[i] = deepCopyValue(e)
+		}
+		return retval
+	case []byte:
+		retval := make([]byte, len(v_))
+		copy(retval, v_)
+		return retval
+	}
+	return v
+}
+
+// copies the record sets so that a store modifying the records does
+// not affect what the other stores see.  no_copy hands the same ones over.
+func copyRecordSets(recordSets []ik.FluentRecordSet, copyMode int) []ik.FluentRecordSet {
+	if copyMode == copyModeNoCopy {
+		return recordSets
+	}
+	retval := make([]ik.FluentRecordSet, len(recordSets))
+	for i, recordSet := range recordSets {
+		records := make([]ik.TinyFluentRecord, len(recordSet.Records))
+		for j, record := range recordSet.Records {
+			records[j] = record
+			if copyMode == copyModeDeep {
+				records[j].Data = deepCopyValue(record.Data).(map[string]interface{})
+			} else {
+				data := make(map[string]interface{}, len(record.Data))
+				for k, v := range record.Data {
+					data[k] = v
+				}
+				records[j].Data = data
+			}
+		}
+		retval[i] = ik.FluentRecordSet{Tag: recordSet.Tag, Records: records}
+	}
+	return retval
+}
+
+// delivers the records to every store.  a store failing doesn't keep
+// the rest from receiving them.
+func (output *CopyOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	var err error
+	var backpressureErr *ik.BackpressureError
+	for i, store := range output.stores {
+		recordSets_ := recordSets
+		// the last store can take the original ones
+		if i < len(output.stores)-1 {
+			recordSets_ = copyRecordSets(recordSets, output.copyMode)
+		}
+		err_ := store.Emit(recordSets_)
+		if err_ != nil {
+			backpressureErr_, ok := err_.(*ik.BackpressureError)
+			if !ok {
+				output.logger.Error("%s", err_.Error())
+				if err == nil {
+					err = err_
+				}
+				continue
+			}
+			if backpressureErr == nil {
+				backpressureErr = &ik.BackpressureError{Pending: make(map[ik.Port][]ik.FluentRecordSet)}
+			}
+			backpressureErr.Merge(backpressureErr_)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if backpressureErr != nil {
+		return backpressureErr
+	}
+	return nil
+}
+
+func (output *CopyOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *CopyOutput) Run() error {
+	<-output.shutdownChan
+	terminateStores(output.engine, output.stores)
+	return nil
+}
+
+func (output *CopyOutput) Shutdown() error {
+	output.shutdownOnce.Do(func() {
+		close(output.shutdownChan)
+	})
+	return nil
+}
+
+func (output *CopyOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newCopyOutput(factory *CopyOutputFactory, engine ik.Engine, stores []ik.Output, copyMode int) *CopyOutput {
+	return &CopyOutput{
+		factory:      factory,
+		engine:       engine,
+		logger:       engine.Logger(),
+		stores:       stores,
+		copyMode:     copyMode,
+		shutdownChan: make(chan struct{}),
+	}
+}
+
+func (factory *CopyOutputFactory) Name() string {
+	return "copy"
+}
+
+func (factory *CopyOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	copyMode := copyModeNoCopy
+	copyModeStr, ok := config.Attrs["copy_mode"]
+	if ok {
+		switch copyModeStr {
+		case "no_copy":
+			copyMode = copyModeNoCopy
+		case "shallow":
+			copyMode = copyModeShallow
+		case "deep":
+			copyMode = copyModeDeep
+		default:
+			return nil, errors.New(fmt.Sprintf("unsupported copy_mode: %s", copyModeStr))
+		}
+	}
+	stores, _, err := newStores(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	return newCopyOutput(factory, engine, stores, copyMode), nil
+}
+
+func (factory *CopyOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&CopyOutputFactory{})
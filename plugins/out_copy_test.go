@@ -0,0 +1,110 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+)
+
+type testOutput struct {
+	testPort
+}
+
+func (output *testOutput) Factory() ik.Plugin { return nil }
+func (output *testOutput) Run() error         { return nil }
+func (output *testOutput) Shutdown() error    { return nil }
+
+// rewrites the records it receives in place
+type mutatingOutput struct {
+	testOutput
+}
+
+func (output *mutatingOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	for _, recordSet := range recordSets {
+		for _, record := range recordSet.Records {
+			record.Data["k"] = "mutated"
+			record.Data["nested"].(map[string]interface{})["k"] = "mutated"
+		}
+	}
+	return output.testOutput.Emit(recordSets)
+}
+
+func newTestCopyRecordSets() []ik.FluentRecordSet {
+	return []ik.FluentRecordSet{
+		{
+			Tag: "tag",
+			Records: []ik.TinyFluentRecord{
+				{Timestamp: 1409286145, Data: map[string]interface{}{"k": "v", "nested": map[string]interface{}{"k": "v"}}},
+				{Timestamp: 1409286146, Data: map[string]interface{}{"k": "v", "nested": map[string]interface{}{"k": "v"}}},
+			},
+		},
+	}
+}
+
+func TestCopyOutput_Emit(t *testing.T) {
+	stores := []*testOutput{{}, {}, {}}
+	output := &CopyOutput{logger: &testLogger{t}, copyMode: copyModeShallow}
+	for _, store := range stores {
+		output.stores = append(output.stores, store)
+	}
+	err := output.Emit(newTestCopyRecordSets())
+	if err != nil {
+		t.FailNow()
+	}
+	for _, store := range stores {
+		if len(store.recordSets) != 1 || store.recordSets[0].Tag != "tag" || len(store.recordSets[0].Records) != 2 {
+			t.FailNow()
+		}
+		if store.recordSets[0].Records[1].Timestamp != 1409286146 || store.recordSets[0].Records[1].Data["k"] != "v" {
+			t.Fail()
+		}
+	}
+}
+
+func TestCopyOutput_Emit_DeepCopyIsolatesMutations(t *testing.T) {
+	mutating := &mutatingOutput{}
+	store := &testOutput{}
+	output := &CopyOutput{logger: &testLogger{t}, stores: []ik.Output{mutating, store}, copyMode: copyModeDeep}
+	recordSets := newTestCopyRecordSets()
+	err := output.Emit(recordSets)
+	if err != nil {
+		t.FailNow()
+	}
+	for _, record := range store.recordSets[0].Records {
+		if record.Data["k"] != "v" || record.Data["nested"].(map[string]interface{})["k"] != "v" {
+			t.Log(record.Data)
+			t.Fail()
+		}
+	}
+	// the first store got a copy, so the original is intact as well
+	if recordSets[0].Records[0].Data["k"] != "v" {
+		t.Fail()
+	}
+}
+
+func TestCopyOutput_Emit_NoCopySharesRecords(t *testing.T) {
+	mutating := &mutatingOutput{}
+	store := &testOutput{}
+	output := &CopyOutput{logger: &testLogger{t}, stores: []ik.Output{mutating, store}, copyMode: copyModeNoCopy}
+	output.Emit(newTestCopyRecordSets())
+	if store.recordSets[0].Records[0].Data["k"] != "mutated" {
+		t.Fail()
+	}
+}
+
+func TestCopyOutput_Emit_Backpressure(t *testing.T) {
+	congested := &congestedPort{refusals: 1}
+	store := &testOutput{}
+	output := &CopyOutput{logger: &testLogger{t}, stores: []ik.Output{&struct {
+		testOutput
+		*congestedPort
+	}{testOutput{}, congested}, store}}
+	err := output.Emit(newTestCopyRecordSets())
+	backpressureErr, ok := err.(*ik.BackpressureError)
+	if !ok || len(backpressureErr.Pending[congested]) != 1 {
+		t.FailNow()
+	}
+	// the congested store doesn't keep the other from receiving them
+	if len(store.recordSets) != 1 {
+		t.Fail()
+	}
+}
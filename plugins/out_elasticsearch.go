@@ -0,0 +1,218 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	strftime "github.com/jehiah/go-strftime"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type ElasticsearchOutput struct {
+	factory   *ElasticsearchOutputFactory
+	logger    ik.Logger
+	client    *http.Client
+	bulkURL   string
+	indexName string
+	typeName  string
+	buffer    ik.RecordBuffer
+	durable   bool
+	sender    *retryingSender
+	cancel    chan bool
+	closeOnce sync.Once
+}
+
+type ElasticsearchOutputFactory struct {
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool                                 `json:"errors"`
+	Items  []map[string]elasticsearchBulkResult `json:"items"`
+}
+
+type elasticsearchBulkResult struct {
+	Status int         `json:"status"`
+	Error  interface{} `json:"error"`
+}
+
+// encodes the records for the _bulk API.  the index name is formatted
+// with the time of each record, so a batch may span multiple indices.
+func (output *ElasticsearchOutput) encodeBulk(records []ik.FluentRecord) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, record := range records {
+		timestamp := time.Unix(int64(record.Timestamp), int64(record.Nanoseconds)).UTC()
+		action := map[string]interface{}{"_index": strftime.Format(output.indexName, timestamp)}
+		if output.typeName != "" {
+			action["_type"] = output.typeName
+		}
+		err := enc.Encode(map[string]interface{}{"index": action})
+		if err != nil {
+			return nil, err
+		}
+		data := make(map[string]interface{}, len(record.Data)+1)
+		for k, v := range record.Data {
+			data[k] = v
+		}
+		if _, ok := data["@timestamp"]; !ok {
+			data["@timestamp"] = timestamp.Format(time.RFC3339Nano)
+		}
+		err = enc.Encode(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// the server errors and the connection errors are retried, while the
+// request rejected by the server is not.
+func (output *ElasticsearchOutput) post(payload []byte) error {
+	response, err := output.client.Post(output.bulkURL, "application/x-ndjson", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 500 {
+		return errors.New(fmt.Sprintf("%s returned %s", output.bulkURL, response.Status))
+	} else if response.StatusCode >= 300 {
+		return &nonRetryableError{errors.New(fmt.Sprintf("%s returned %s: %s", output.bulkURL, response.Status, string(body)))}
+	}
+	result := elasticsearchBulkResponse{}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return &nonRetryableError{err}
+	}
+	if result.Errors {
+		failed := 0
+		var firstError interface{}
+		for _, item := range result.Items {
+			for _, itemResult := range item {
+				if itemResult.Status >= 300 {
+					if failed == 0 {
+						firstError = itemResult.Error
+					}
+					failed += 1
+				}
+			}
+		}
+		output.logger.Error("%d of %d records were rejected: %v", failed, len(result.Items), firstError)
+	}
+	return nil
+}
+
+func (output *ElasticsearchOutput) flushRecords(records []ik.FluentRecord) error {
+	payload, err := output.encodeBulk(records)
+	if err != nil {
+		output.logger.Error("%s", err.Error())
+		return err
+	}
+	return output.sender.enqueue([]encodedChunk{{records: records, payload: payload}}, output.durable)
+}
+
+func (output *ElasticsearchOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return appendToBuffer(output.logger, output.buffer, output, recordSets)
+}
+
+func (output *ElasticsearchOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *ElasticsearchOutput) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (output *ElasticsearchOutput) Shutdown() error {
+	output.closeOnce.Do(func() {
+		close(output.cancel)
+		output.buffer.Close()
+	})
+	return output.sender.flush()
+}
+
+func (output *ElasticsearchOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newElasticsearchOutput(factory *ElasticsearchOutputFactory, logger ik.Logger, bulkURL string, indexName string, typeName string, requestTimeout time.Duration, bufferOptions bufferOptions, retry *ik.RetryManager) (*ElasticsearchOutput, error) {
+	retval := &ElasticsearchOutput{
+		factory:   factory,
+		logger:    logger,
+		client:    &http.Client{Timeout: requestTimeout},
+		bulkURL:   bulkURL,
+		indexName: indexName,
+		typeName:  typeName,
+		cancel:    make(chan bool),
+	}
+	retval.sender = &retryingSender{
+		logger: logger,
+		retry:  retry,
+		send:   retval.post,
+	}
+	buffer, durable, err := bufferOptions.newBuffer(retval.flushRecords)
+	if err != nil {
+		return nil, err
+	}
+	retval.buffer = buffer
+	retval.durable = durable
+	return retval, nil
+}
+
+func (factory *ElasticsearchOutputFactory) Name() string {
+	return "elasticsearch"
+}
+
+func (factory *ElasticsearchOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	scheme, ok := config.Attrs["scheme"]
+	if !ok {
+		scheme = "http"
+	}
+	host, ok := config.Attrs["host"]
+	if !ok {
+		host = "localhost"
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "9200"
+	}
+	indexName, ok := config.Attrs["index_name"]
+	if !ok {
+		indexName = "fluentd"
+	}
+	typeName, _ := config.Attrs["type_name"]
+	requestTimeout, err := config.AttrDuration("request_timeout", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	bufferOptions, err := parseBufferOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := parseRetryManager(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	bulkURL := scheme + "://" + host + ":" + netPort + "/_bulk"
+	output, err := newElasticsearchOutput(factory, engine.Logger(), bulkURL, indexName, typeName, requestTimeout, bufferOptions, retry)
+	if err != nil {
+		return nil, err
+	}
+	output.sender.deadLetterPort = engine.DeadLetterPort()
+	output.sender.run(bufferOptions.flushInterval, output.cancel)
+	return output, nil
+}
+
+func (factory *ElasticsearchOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&ElasticsearchOutputFactory{})
@@ -0,0 +1,113 @@
+package plugins
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/moriyoshi/ik"
+	mrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestElasticsearchOutput(t *testing.T, url string) *ElasticsearchOutput {
+	retry := ik.NewRetryManager(time.Second, time.Minute, 2., 3, mrand.NewSource(0))
+	output, err := newElasticsearchOutput(&ElasticsearchOutputFactory{}, &testLogger{t}, url+"/_bulk", "logstash-%Y.%m.%d", "", time.Second, bufferOptions{chunkLimitSize: 1024 * 1024, flushInterval: time.Hour}, retry)
+	if err != nil {
+		t.FailNow()
+	}
+	return output
+}
+
+func TestElasticsearchOutput_flushRecords(t *testing.T) {
+	var lines []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Fail()
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			line := map[string]interface{}{}
+			if json.Unmarshal(scanner.Bytes(), &line) != nil {
+				t.Fail()
+			}
+			lines = append(lines, line)
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+	output := newTestElasticsearchOutput(t, server.URL)
+	defer output.Shutdown()
+	err := output.flushRecords([]ik.FluentRecord{
+		{Tag: "a", Timestamp: 1409286145, Nanoseconds: 500000000, Data: map[string]interface{}{"message": "foo"}},
+		{Tag: "a", Timestamp: 1409372545, Data: map[string]interface{}{"message": "bar", "@timestamp": "given"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	if len(lines) != 4 {
+		t.Log(lines)
+		t.FailNow()
+	}
+	action := lines[0]["index"].(map[string]interface{})
+	if action["_index"] != "logstash-2014.08.29" {
+		t.Log(action)
+		t.Fail()
+	}
+	if _, ok := action["_type"]; ok {
+		t.Fail()
+	}
+	if lines[1]["message"] != "foo" || lines[1]["@timestamp"] != "2014-08-29T04:22:25.5Z" {
+		t.Log(lines[1])
+		t.Fail()
+	}
+	if lines[2]["index"].(map[string]interface{})["_index"] != "logstash-2014.08.30" {
+		t.Fail()
+	}
+	if lines[3]["@timestamp"] != "given" {
+		t.Fail()
+	}
+}
+
+func TestElasticsearchOutput_flushRecords_RetriesOnServerError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+	output := newTestElasticsearchOutput(t, server.URL)
+	defer output.Shutdown()
+	err := output.flushRecords([]ik.FluentRecord{{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{}}})
+	if err == nil || len(output.sender.pending) != 1 {
+		t.FailNow()
+	}
+	// still backing off
+	if output.sender.flush() == nil || requests != 1 {
+		t.FailNow()
+	}
+	output.sender.nextRetry = time.Time{}
+	if output.sender.flush() != nil || requests != 2 || len(output.sender.pending) != 0 {
+		t.Fail()
+	}
+}
+
+func TestElasticsearchOutput_flushRecords_DropsRejectedRequest(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	output := newTestElasticsearchOutput(t, server.URL)
+	defer output.Shutdown()
+	err := output.flushRecords([]ik.FluentRecord{{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{}}})
+	if err != nil || requests != 1 || len(output.sender.pending) != 0 {
+		t.Fail()
+	}
+}
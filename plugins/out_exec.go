@@ -0,0 +1,392 @@
+package plugins
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"github.com/ugorji/go/codec"
+	"io"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	execFormatJSON = iota
+	execFormatTSV
+	execFormatMsgpack
+)
+
+// how long to wait before restarting a child that exited, and for the
+// children to exit after their stdin is closed on shutdown.
+const execRestartInterval = time.Second
+const execExitTimeout = 5 * time.Second
+
+// runs the command by the shell in a process group of its own, so that
+// killing it doesn't leave the processes it spawned behind.
+func newShellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+type execChild struct {
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	stderrDone chan struct{}
+	mtx        sync.Mutex
+}
+
+type ExecOutput struct {
+	factory      *ExecOutputFactory
+	logger       ik.Logger
+	command      string
+	format       int
+	keys         []string
+	codec        *codec.MsgpackHandle
+	children     []*execChild
+	nextChild    int
+	liveChildren int64
+	mtx          sync.Mutex
+	wg           sync.WaitGroup
+	buffer       ik.RecordBuffer
+	durable      bool
+	sender       *retryingSender
+	cancel       chan bool
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+type ExecOutputFactory struct {
+}
+
+type ExecChildCountTopic struct{}
+
+func (output *ExecOutput) encode(records []ik.FluentRecord) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	switch output.format {
+	case execFormatJSON:
+		enc := json.NewEncoder(buf)
+		for _, record := range records {
+			err := enc.Encode(record.Data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case execFormatTSV:
+		values := make([]string, len(output.keys))
+		for _, record := range records {
+			for i, key := range output.keys {
+				v, ok := record.Data[key]
+				if ok && v != nil {
+					values[i] = fmt.Sprint(v)
+				} else {
+					values[i] = ""
+				}
+			}
+			buf.WriteString(strings.Join(values, "\t"))
+			buf.WriteByte('\n')
+		}
+	case execFormatMsgpack:
+		enc := codec.NewEncoder(buf, output.codec)
+		for _, record := range records {
+			err := enc.Encode(record.Data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writes the payload to the running children in turn.
+func (output *ExecOutput) write(payload []byte) error {
+	output.mtx.Lock()
+	var child *execChild
+	for i := 0; i < len(output.children); i += 1 {
+		child = output.children[output.nextChild]
+		output.nextChild = (output.nextChild + 1) % len(output.children)
+		if child != nil {
+			break
+		}
+	}
+	output.mtx.Unlock()
+	if child == nil {
+		return errors.New("no child process is running")
+	}
+	child.mtx.Lock()
+	defer child.mtx.Unlock()
+	_, err := child.stdin.Write(payload)
+	return err
+}
+
+func (output *ExecOutput) startChild() (*execChild, error) {
+	cmd := newShellCommand(output.command)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	child := &execChild{cmd: cmd, stdin: stdin, stderrDone: make(chan struct{})}
+	go func() {
+		defer close(child.stderrDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			output.logger.Warning("%s[%d]: %s", output.command, cmd.Process.Pid, scanner.Text())
+		}
+	}()
+	return child, nil
+}
+
+// keeps a child running in the slot, restarting it whenever it exits
+// until the output is shut down.
+func (output *ExecOutput) supervise(slot int) {
+	defer output.wg.Done()
+	for {
+		child, err := output.startChild()
+		if err != nil {
+			output.logger.Error("Failed to start %s: %s", output.command, err.Error())
+		} else {
+			output.mtx.Lock()
+			output.children[slot] = child
+			select {
+			case <-output.cancel:
+				// started while shutting down
+				child.stdin.Close()
+			default:
+			}
+			output.mtx.Unlock()
+			atomic.AddInt64(&output.liveChildren, 1)
+			// the stderr must be read up before Wait() closes it
+			<-child.stderrDone
+			err = child.cmd.Wait()
+			atomic.AddInt64(&output.liveChildren, -1)
+			output.mtx.Lock()
+			output.children[slot] = nil
+			output.mtx.Unlock()
+			select {
+			case <-output.cancel:
+				return
+			default:
+			}
+			if err != nil {
+				output.logger.Error("%s[%d] exited: %s", output.command, child.cmd.Process.Pid, err.Error())
+			} else {
+				output.logger.Error("%s[%d] exited unexpectedly", output.command, child.cmd.Process.Pid)
+			}
+		}
+		select {
+		case <-output.cancel:
+			return
+		case <-time.After(execRestartInterval):
+		}
+	}
+}
+
+// closes the stdin of the children, and kills the ones that don't exit
+// in time.
+func (output *ExecOutput) stopChildren() {
+	output.mtx.Lock()
+	for _, child := range output.children {
+		if child != nil {
+			child.mtx.Lock()
+			child.stdin.Close()
+			child.mtx.Unlock()
+		}
+	}
+	output.mtx.Unlock()
+	exited := make(chan struct{})
+	go func() {
+		output.wg.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(execExitTimeout):
+		output.mtx.Lock()
+		for _, child := range output.children {
+			if child != nil {
+				output.logger.Warning("killing %s[%d]", output.command, child.cmd.Process.Pid)
+				killProcessGroup(child.cmd)
+			}
+		}
+		output.mtx.Unlock()
+		<-exited
+	}
+}
+
+func (output *ExecOutput) flushRecords(records []ik.FluentRecord) error {
+	payload, err := output.encode(records)
+	if err != nil {
+		output.logger.Error("%s", err.Error())
+		return err
+	}
+	return output.sender.enqueue([]encodedChunk{{records: records, payload: payload}}, output.durable)
+}
+
+func (output *ExecOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return appendToBuffer(output.logger, output.buffer, output, recordSets)
+}
+
+func (output *ExecOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *ExecOutput) Run() error {
+	<-output.done
+	return nil
+}
+
+func (output *ExecOutput) Shutdown() error {
+	var err error
+	output.closeOnce.Do(func() {
+		output.buffer.Close()
+		err = output.sender.flush()
+		close(output.cancel)
+		output.stopChildren()
+		close(output.done)
+	})
+	return err
+}
+
+func (output *ExecOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newExecOutput(factory *ExecOutputFactory, logger ik.Logger, command string, format int, keys []string, numChildren int, bufferOptions bufferOptions, retry *ik.RetryManager) (*ExecOutput, error) {
+	_codec := codec.MsgpackHandle{}
+	_codec.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	_codec.RawToString = false
+	retval := &ExecOutput{
+		factory:  factory,
+		logger:   logger,
+		command:  command,
+		format:   format,
+		keys:     keys,
+		codec:    &_codec,
+		children: make([]*execChild, numChildren),
+		cancel:   make(chan bool),
+		done:     make(chan struct{}),
+	}
+	retval.sender = &retryingSender{
+		logger: logger,
+		retry:  retry,
+		send:   retval.write,
+	}
+	buffer, durable, err := bufferOptions.newBuffer(retval.flushRecords)
+	if err != nil {
+		return nil, err
+	}
+	retval.buffer = buffer
+	retval.durable = durable
+	for i := 0; i < numChildren; i += 1 {
+		retval.wg.Add(1)
+		go retval.supervise(i)
+	}
+	return retval, nil
+}
+
+func (factory *ExecOutputFactory) Name() string {
+	return "exec"
+}
+
+func (factory *ExecOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	command, ok := config.Attrs["command"]
+	if !ok {
+		return nil, errors.New("'command' parameter is required")
+	}
+	format := execFormatJSON
+	formatStr, ok := config.Attrs["format"]
+	if ok {
+		switch formatStr {
+		case "json":
+		case "tsv":
+			format = execFormatTSV
+		case "msgpack":
+			format = execFormatMsgpack
+		default:
+			return nil, errors.New("unknown format: " + formatStr)
+		}
+	}
+	var keys []string
+	if format == execFormatTSV {
+		keysStr, ok := config.Attrs["keys"]
+		if !ok {
+			return nil, errors.New("'keys' parameter is required for tsv format")
+		}
+		for _, key := range strings.Split(keysStr, ",") {
+			keys = append(keys, strings.TrimSpace(key))
+		}
+	}
+	numChildren := 1
+	numChildrenStr, ok := config.Attrs["num_children"]
+	if ok {
+		var err error
+		numChildren, err = strconv.Atoi(numChildrenStr)
+		if err != nil {
+			return nil, err
+		}
+		if numChildren < 1 {
+			return nil, errors.New("num_children must be at least 1")
+		}
+	}
+	bufferOptions, err := parseBufferOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := parseRetryManager(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	output, err := newExecOutput(factory, engine.Logger(), command, format, keys, numChildren, bufferOptions, retry)
+	if err != nil {
+		return nil, err
+	}
+	output.sender.deadLetterPort = engine.DeadLetterPort()
+	output.sender.run(bufferOptions.flushInterval, output.cancel)
+	return output, nil
+}
+
+func (factory *ExecOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "children",
+		DisplayName: "Children",
+		Description: "Number of child processes currently running",
+		Fetcher:     &ExecChildCountTopic{},
+	})
+}
+
+func (topic *ExecChildCountTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *ExecChildCountTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*ExecOutput)
+	return strconv.FormatInt(atomic.LoadInt64(&output.liveChildren), 10), nil
+}
+
+var _ = AddPlugin(&ExecOutputFactory{})
@@ -0,0 +1,120 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	mrand "math/rand"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestExecOutput(t *testing.T, command string, format int, keys []string, numChildren int) *ExecOutput {
+	retry := ik.NewRetryManager(time.Second, time.Minute, 2., 3, mrand.NewSource(0))
+	output, err := newExecOutput(&ExecOutputFactory{}, &testLogger{t}, command, format, keys, numChildren, bufferOptions{chunkLimitSize: 1024 * 1024, flushInterval: time.Hour}, retry)
+	if err != nil {
+		t.FailNow()
+	}
+	return output
+}
+
+// waits for the children to be started
+func waitForExecChildren(output *ExecOutput, n int64) bool {
+	for i := 0; i < 300; i += 1 {
+		text, _ := (&ExecChildCountTopic{}).PlainText(output)
+		if text == strconv.FormatInt(n, 10) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestExecOutput_encode(t *testing.T) {
+	records := []ik.FluentRecord{
+		{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"k": "v1", "n": 1}},
+		{Tag: "a", Timestamp: 1409286146, Data: map[string]interface{}{"k": "v2"}},
+	}
+	output := &ExecOutput{format: execFormatJSON}
+	payload, err := output.encode(records)
+	if err != nil || string(payload) != "{\"k\":\"v1\",\"n\":1}\n{\"k\":\"v2\"}\n" {
+		t.Log(string(payload))
+		t.Fail()
+	}
+	output = &ExecOutput{format: execFormatTSV, keys: []string{"n", "k"}}
+	payload, err = output.encode(records)
+	if err != nil || string(payload) != "1\tv1\n\tv2\n" {
+		t.Log(string(payload))
+		t.Fail()
+	}
+}
+
+func TestExecOutput_flushRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_exec")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	// each child writes to its own file
+	output := newTestExecOutput(t, "cat > "+dir+"/$$", execFormatTSV, []string{"k"}, 2)
+	if !waitForExecChildren(output, 2) {
+		t.FailNow()
+	}
+	for _, v := range []string{"v1", "v2", "v3"} {
+		err := output.flushRecords([]ik.FluentRecord{{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"k": v}}})
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	output.Shutdown()
+	text, _ := (&ExecChildCountTopic{}).PlainText(output)
+	if text != "0" {
+		t.Fail()
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.FailNow()
+	}
+	lines := []string{}
+	for _, file := range files {
+		content, _ := ioutil.ReadFile(path.Join(dir, file.Name()))
+		lines = append(lines, strings.Split(strings.TrimSpace(string(content)), "\n")...)
+	}
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "v1,v2,v3" {
+		t.Log(lines)
+		t.Fail()
+	}
+}
+
+func TestExecOutput_RestartsChild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_exec")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	// the child exits after reading a line
+	output := newTestExecOutput(t, "head -n 1 >> "+dir+"/out", execFormatTSV, []string{"k"}, 1)
+	defer output.Shutdown()
+	if !waitForExecChildren(output, 1) {
+		t.FailNow()
+	}
+	output.flushRecords([]ik.FluentRecord{{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"k": "v1"}}})
+	if !waitForExecChildren(output, 0) || !waitForExecChildren(output, 1) {
+		t.FailNow()
+	}
+	output.sender.nextRetry = time.Time{}
+	output.flushRecords([]ik.FluentRecord{{Tag: "a", Timestamp: 1409286146, Data: map[string]interface{}{"k": "v2"}}})
+	for i := 0; i < 300; i += 1 {
+		content, _ := ioutil.ReadFile(path.Join(dir, "out"))
+		if string(content) == "v1\nv2\n" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fail()
+}
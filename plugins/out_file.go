@@ -2,11 +2,11 @@ package plugins
 
 import (
 	"compress/gzip"
-	"encoding/json"
 	"errors"
 	"fmt"
 	strftime "github.com/jehiah/go-strftime"
 	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/formatters"
 	jnl "github.com/moriyoshi/ik/journal"
 	"io"
 	"math/rand"
@@ -14,6 +14,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,12 +28,16 @@ type FileOutput struct {
 	compressionFormat int
 	journalGroup      ik.JournalGroup
 	slicer            *ik.Slicer
-	timeFormat        string
+	formatter         ik.Formatter
 	timeSliceFormat   string
 	location          *time.Location
 	c                 chan []ik.FluentRecordSet
 	cancel            chan bool
 	disableDraining   bool
+	append            bool
+	flushInterval     time.Duration
+	flushTicker       *time.Ticker
+	flushMtx          sync.Mutex
 }
 
 type FileOutputPacker struct {
@@ -48,33 +53,7 @@ const (
 )
 
 func (packer *FileOutputPacker) Pack(record ik.FluentRecord) ([]byte, error) {
-	formattedData, err := packer.output.formatData(record.Data)
-	if err != nil {
-		return nil, err
-	}
-	return ([]byte)(fmt.Sprintf(
-		"%s\t%s\t%s\n",
-		packer.output.formatTime(record.Timestamp),
-		record.Tag,
-		formattedData,
-	)), nil
-}
-
-func (output *FileOutput) formatTime(timestamp uint64) string {
-	timestamp_ := time.Unix(int64(timestamp), 0)
-	if output.timeFormat == "" {
-		return timestamp_.Format(time.RFC3339)
-	} else {
-		return strftime.Format(output.timeFormat, timestamp_)
-	}
-}
-
-func (output *FileOutput) formatData(data map[string]interface{}) (string, error) {
-	b, err := json.Marshal(data)
-	if err != nil {
-		return "", err
-	}
-	return string(b), nil // XXX: byte => rune
+	return packer.output.formatter.Format(record)
 }
 
 func (output *FileOutput) Emit(recordSets []ik.FluentRecordSet) error {
@@ -86,7 +65,31 @@ func (output *FileOutput) Factory() ik.Plugin {
 	return output.factory
 }
 
+// flushes the journals of the time slices that have already passed, which
+// would otherwise be left unflushed until a record for a new slice arrives.
+func (output *FileOutput) flushPastSlices() {
+	currentKey := strftime.Format(output.timeSliceFormat, time.Now())
+	for _, key := range output.journalGroup.GetJournalKeys() {
+		if key == currentKey {
+			continue
+		}
+		journal := output.journalGroup.GetJournal(key)
+		err := journal.Flush(func(chunk ik.JournalChunk) error {
+			defer chunk.Dispose()
+			chunk.TakeOwnership()
+			return output.flush(key, chunk)
+		})
+		if err != nil {
+			output.logger.Error("Failed to flush %s: %s", key, err.Error())
+		}
+	}
+}
+
 func (output *FileOutput) Run() error {
+	var tick <-chan time.Time
+	if output.flushTicker != nil {
+		tick = output.flushTicker.C
+	}
 	select {
 	case <-output.cancel:
 		return nil
@@ -95,11 +98,16 @@ func (output *FileOutput) Run() error {
 		if err != nil {
 			return err
 		}
+	case <-tick:
+		output.flushPastSlices()
 	}
 	return ik.Continue
 }
 
 func (output *FileOutput) Shutdown() error {
+	if output.flushTicker != nil {
+		output.flushTicker.Stop()
+	}
 	output.cancel <- true
 	return output.journalGroup.Dispose()
 }
@@ -144,17 +152,29 @@ func (output *FileOutput) flush(key string, chunk ik.JournalChunk) error {
 	if output.compressionFormat == compressionGzip {
 		suffix = ".gz"
 	}
-	outPath, err := buildNextPathName(
-		key,
-		output.pathPrefix,
-		output.pathSuffix,
-		suffix,
-	)
+	output.flushMtx.Lock()
+	defer output.flushMtx.Unlock()
+	var outPath string
+	var err error
+	flags := os.O_CREATE | os.O_WRONLY
+	if output.append {
+		outPath = output.pathPrefix + key + output.pathSuffix + suffix
+		err = os.MkdirAll(path.Dir(outPath), os.FileMode(os.ModePerm))
+		flags |= os.O_APPEND
+	} else {
+		outPath, err = buildNextPathName(
+			key,
+			output.pathPrefix,
+			output.pathSuffix,
+			suffix,
+		)
+		flags |= os.O_EXCL
+	}
 	if err != nil {
 		return err
 	}
 	var writer io.WriteCloser
-	writer, err = os.OpenFile(outPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, output.permission)
+	writer, err = os.OpenFile(outPath, flags, output.permission)
 	if err != nil {
 		return err
 	}
@@ -207,7 +227,7 @@ func (output *FileOutput) attachListeners(journal ik.Journal) {
 	})
 }
 
-func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand.Source, pathPrefix string, pathSuffix string, timeFormat string, compressionFormat int, symlinkPath string, permission os.FileMode, bufferChunkLimit int64, timeSliceFormat string, disableDraining bool) (*FileOutput, error) {
+func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand.Source, pathPrefix string, pathSuffix string, formatter ik.Formatter, compressionFormat int, symlinkPath string, permission os.FileMode, bufferChunkLimit int64, timeSliceFormat string, disableDraining bool, append_ bool, flushInterval time.Duration) (*FileOutput, error) {
 	if timeSliceFormat == "" {
 		timeSliceFormat = "%Y%m%d"
 	}
@@ -227,12 +247,17 @@ func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand
 		symlinkPath:       symlinkPath,
 		permission:        permission,
 		compressionFormat: compressionFormat,
-		timeFormat:        timeFormat,
+		formatter:         formatter,
 		timeSliceFormat:   timeSliceFormat,
 		location:          time.UTC,
 		c:                 make(chan []ik.FluentRecordSet, 100 /* FIXME */),
 		cancel:            make(chan bool),
 		disableDraining:   disableDraining,
+		append:            append_,
+		flushInterval:     flushInterval,
+	}
+	if flushInterval > 0 {
+		retval.flushTicker = time.NewTicker(flushInterval)
 	}
 	journalGroup, err := journalGroupFactory.GetJournalGroup(pathPrefix, retval)
 	if err != nil {
@@ -286,7 +311,6 @@ func (factory *FileOutputFactory) Name() string {
 func (factory *FileOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
 	pathPrefix := ""
 	pathSuffix := ""
-	timeFormat := ""
 	compressionFormat := compressionNone
 	symlinkPath := ""
 	permission := 0666
@@ -298,7 +322,10 @@ func (factory *FileOutputFactory) New(engine ik.Engine, config *ik.ConfigElement
 	if !ok {
 		return nil, errors.New("'path' parameter is required on file output")
 	}
-	timeFormat, _ = config.Attrs["time_format"]
+	formatter, err := formatters.New(config, "out_file")
+	if err != nil {
+		return nil, err
+	}
 	compressionFormatStr, ok := config.Attrs["compress"]
 	if ok {
 		if compressionFormatStr == "gz" || compressionFormatStr == "gzip" {
@@ -344,19 +371,31 @@ func (factory *FileOutputFactory) New(engine ik.Engine, config *ik.ConfigElement
 		}
 	}
 
+	append_, err := config.AttrBool("append", false)
+	if err != nil {
+		return nil, err
+	}
+
+	flushInterval, err := config.AttrDuration("flush_interval", 0)
+	if err != nil {
+		return nil, err
+	}
+
 	return newFileOutput(
 		factory,
 		engine.Logger(),
 		engine.RandSource(),
 		pathPrefix,
 		pathSuffix,
-		timeFormat,
+		formatter,
 		compressionFormat,
 		symlinkPath,
 		os.FileMode(permission),
 		bufferChunkLimit,
 		timeSliceFormat,
 		disableDraining,
+		append_,
+		flushInterval,
 	)
 }
 
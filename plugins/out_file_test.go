@@ -0,0 +1,122 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/iktest"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestFileOutput(t *testing.T, dir string, attrs map[string]string) *FileOutput {
+	attrs["path"] = path.Join(dir, "out")
+	attrs["time_slice_format"] = "%Y"
+	attrs["time_format"] = "%Y"
+	output, err := (&FileOutputFactory{}).New(iktest.NewFakeEngine(&testLogger{t}), &ik.ConfigElement{Attrs: attrs})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	return output.(*FileOutput)
+}
+
+// runs the output until shut down.
+func runFileOutput(output *FileOutput) chan error {
+	done := make(chan error, 1)
+	go func() {
+		for {
+			err := output.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// waits for the file to have the content.
+func waitForFileContent(path_ string, expected string) (string, bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, _ := ioutil.ReadFile(path_)
+		if string(b) == expected || time.Now().After(deadline) {
+			return string(b), string(b) == expected
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var testFileOutputRecordSets = []ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145, Data: map[string]interface{}{"a": "b"}}}}}
+
+func TestFileOutput_AppendsPastSliceOnTick(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_file")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	// the slice file written before
+	slicePath := path.Join(dir, "out.2014.log")
+	err = ioutil.WriteFile(slicePath, []byte("old\n"), 0644)
+	if err != nil {
+		t.FailNow()
+	}
+	output := newTestFileOutput(t, dir, map[string]string{"append": "true", "flush_interval": "10ms"})
+	done := runFileOutput(output)
+	// the slice of 2014 has passed, so it is flushed on the next tick
+	// without waiting for a record of another slice
+	output.Emit(testFileOutputRecordSets)
+	content, ok := waitForFileContent(slicePath, "old\n2014\ttag\t{\"a\":\"b\"}\n")
+	if !ok {
+		t.Logf("%q", content)
+		t.Fail()
+	}
+	output.Shutdown()
+	<-done
+}
+
+func TestFileOutput_NewSliceFileWithoutAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_file")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(path.Join(dir, "out.2014_0.log"), []byte("old\n"), 0644)
+	if err != nil {
+		t.FailNow()
+	}
+	output := newTestFileOutput(t, dir, map[string]string{"flush_interval": "10ms"})
+	done := runFileOutput(output)
+	output.Emit(testFileOutputRecordSets)
+	content, ok := waitForFileContent(path.Join(dir, "out.2014_1.log"), "2014\ttag\t{\"a\":\"b\"}\n")
+	if !ok {
+		t.Logf("%q", content)
+		t.Fail()
+	}
+	output.Shutdown()
+	<-done
+}
+
+func TestFileOutputFactory_New_Attributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_file")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	// the number of seconds as the buffered outputs take
+	output := newTestFileOutput(t, dir, map[string]string{"flush_interval": "60", "append": "true"})
+	if output.flushInterval != 60*time.Second || !output.append {
+		t.Fail()
+	}
+	output.flushTicker.Stop()
+	output.journalGroup.Dispose()
+	_, err = (&FileOutputFactory{}).New(iktest.NewFakeEngine(&testLogger{t}), &ik.ConfigElement{Attrs: map[string]string{
+		"path":   path.Join(dir, "out"),
+		"append": "maybe",
+	}})
+	if err == nil {
+		t.Fail()
+	}
+}
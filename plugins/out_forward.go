@@ -2,95 +2,315 @@ package plugins
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/moriyoshi/ik"
 	"github.com/ugorji/go/codec"
+	"math"
 	"net"
-	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// the number of heartbeat intervals kept to estimate the distribution
+const heartbeatHistorySize = 100
+
+type forwardServer struct {
+	bind          string
+	weight        int
+	currentWeight int
+	available     bool
+	lastHeartbeat time.Time
+	intervals     []float64
+	mtx           sync.Mutex
+}
+
+type ForwardServerStatusTopic struct{}
+
 type ForwardOutput struct {
-	factory *ForwardOutputFactory
-	logger  ik.Logger
-	codec   *codec.MsgpackHandle
-	bind    string
-	enc     *codec.Encoder
-	conn    net.Conn
-	buffer  bytes.Buffer
+	factory            *ForwardOutputFactory
+	logger             ik.Logger
+	codec              *codec.MsgpackHandle
+	servers            []*forwardServer
+	requireAckResponse bool
+	ackResponseTimeout time.Duration
+	buffer             ik.RecordBuffer
+	durable            bool
+	sender             *retryingSender
+	cancel             chan bool
+	closeOnce          sync.Once
+	heartbeatInterval  time.Duration
+	hardTimeout        time.Duration
+	phiThreshold       float64
 }
 
-func (output *ForwardOutput) encodeEntry(tag string, record ik.TinyFluentRecord) error {
-	v := []interface{}{tag, record.Timestamp, record.Data}
-	if output.enc == nil {
-		output.enc = codec.NewEncoder(&output.buffer, output.codec)
-	}
-	err := output.enc.Encode(v)
+func newChunkId() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return err
+	return base64.StdEncoding.EncodeToString(b), nil
 }
 
-func (output *ForwardOutput) encodeRecordSet(recordSet ik.FluentRecordSet) error {
-	v := []interface{}{recordSet.Tag, recordSet.Records}
-	if output.enc == nil {
-		output.enc = codec.NewEncoder(&output.buffer, output.codec)
+// encodes a record set in the forward mode, which is what ikb sends in
+// the bulk mode.  the chunk option is attached if ack is required.
+func (output *ForwardOutput) encodeRecordSet(recordSet ik.FluentRecordSet) (encodedChunk, error) {
+	retval := encodedChunk{}
+	v := []interface{}{recordSet.Tag, encodeForwardEntries(recordSet.Records)}
+	if output.requireAckResponse {
+		var err error
+		retval.id, err = newChunkId()
+		if err != nil {
+			return retval, err
+		}
+		v = append(v, map[string]interface{}{"chunk": retval.id})
 	}
-	err := output.enc.Encode(v)
+	buffer := bytes.Buffer{}
+	err := codec.NewEncoder(&buffer, output.codec).Encode(v)
 	if err != nil {
-		return err
+		return retval, err
 	}
-	return err
+	retval.payload = buffer.Bytes()
+	return retval, nil
 }
 
-func (output *ForwardOutput) flush() error {
-	if output.conn == nil {
-		conn, err := net.Dial("tcp", output.bind)
-		if err != nil {
-			output.logger.Error("%#v", err.Error())
-			return err
-		} else {
-			output.conn = conn
+// builds the [time, record] entries of the forward mode.  the time is an
+// EventTime if the record has the nanoseconds, and the seconds otherwise
+// so that the receivers not knowing EventTime can read it.
+func encodeForwardEntries(records []ik.TinyFluentRecord) []interface{} {
+	entries := make([]interface{}, len(records))
+	for i, record := range records {
+		var timestamp interface{} = record.Timestamp
+		if record.Nanoseconds != 0 {
+			timestamp = ik.EventTime{Seconds: uint32(record.Timestamp), Nanoseconds: record.Nanoseconds}
 		}
+		entries[i] = []interface{}{timestamp, record.Data}
 	}
-	n, err := output.buffer.WriteTo(output.conn)
-	if err != nil {
-		output.logger.Error("Write failed. size: %d, buf size: %d, error: %#v", n, output.buffer.Len(), err.Error())
-		output.conn = nil
-		return err
+	return entries
+}
+
+// picks the next server by smooth weighted round-robin.  servers with
+// zero weight are never chosen.
+func (output *ForwardOutput) nextServer() *forwardServer {
+	total := 0
+	var retval *forwardServer
+	for _, server := range output.servers {
+		if server.weight <= 0 || !server.isAvailable() {
+			continue
+		}
+		total += server.weight
+		server.currentWeight += server.weight
+		if retval == nil || server.currentWeight > retval.currentWeight {
+			retval = server
+		}
 	}
-	if n > 0 {
-		output.logger.Notice("Forwarded: %d bytes (left: %d bytes)\n", n, output.buffer.Len())
+	if retval != nil {
+		retval.currentWeight -= total
+	}
+	return retval
+}
+
+func (server *forwardServer) isAvailable() bool {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	return server.available
+}
+
+func (server *forwardServer) onHeartbeat(now time.Time) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	if !server.lastHeartbeat.IsZero() {
+		server.intervals = append(server.intervals, now.Sub(server.lastHeartbeat).Seconds())
+		if len(server.intervals) > heartbeatHistorySize {
+			server.intervals = server.intervals[len(server.intervals)-heartbeatHistorySize:]
+		}
+	}
+	server.lastHeartbeat = now
+	server.available = true
+}
+
+// computes the suspicion level of the phi accrual failure detector, assuming
+// the heartbeat intervals are normally distributed.
+func (server *forwardServer) phi(now time.Time) float64 {
+	if len(server.intervals) == 0 {
+		return 0
+	}
+	mean := 0.
+	for _, interval := range server.intervals {
+		mean += interval
+	}
+	mean /= float64(len(server.intervals))
+	variance := 0.
+	for _, interval := range server.intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	variance /= float64(len(server.intervals))
+	// keep the deviation from being zero for perfectly regular heartbeats
+	stddev := math.Max(math.Sqrt(variance), mean/4)
+	elapsed := now.Sub(server.lastHeartbeat).Seconds()
+	pLater := 0.5 * math.Erfc((elapsed-mean)/(stddev*math.Sqrt2))
+	if pLater <= 0 {
+		return math.Inf(1)
+	}
+	return -math.Log10(pLater)
+}
+
+// marks the server down once either phi_threshold or hard_timeout is exceeded.
+func (server *forwardServer) evaluate(now time.Time, hardTimeout time.Duration, phiThreshold float64) bool {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	if server.available {
+		if now.Sub(server.lastHeartbeat) > hardTimeout || server.phi(now) > phiThreshold {
+			server.available = false
+			return true
+		}
+	}
+	return false
+}
+
+func (output *ForwardOutput) heartbeat() {
+	for _, server := range output.servers {
+		conn, err := net.DialTimeout("tcp", server.bind, output.heartbeatInterval)
+		now := time.Now()
+		if err == nil {
+			conn.Close()
+			if !server.isAvailable() {
+				output.logger.Notice("Server %s is back online", server.bind)
+			}
+			server.onHeartbeat(now)
+		} else if server.evaluate(now, output.hardTimeout, output.phiThreshold) {
+			output.logger.Warning("Server %s is marked down: %s", server.bind, err.Error())
+		}
 	}
-	output.conn.Close()
-	output.conn = nil
-	return nil
 }
 
-func (output *ForwardOutput) run_flush(flush_interval int) {
-	ticker := time.NewTicker(time.Duration(flush_interval) * time.Second)
+func (output *ForwardOutput) run_heartbeat() {
+	ticker := time.NewTicker(output.heartbeatInterval)
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
+			case <-output.cancel:
+				return
 			case <-ticker.C:
-				output.flush()
+				output.heartbeat()
 			}
 		}
 	}()
 }
 
-func (output *ForwardOutput) Emit(recordSet []ik.FluentRecordSet) error {
-	for _, recordSet := range recordSet {
-		err := output.encodeRecordSet(recordSet)
+func (output *ForwardOutput) waitForAck(conn net.Conn, chunk encodedChunk) error {
+	err := conn.SetReadDeadline(time.Now().Add(output.ackResponseTimeout))
+	if err != nil {
+		return err
+	}
+	response := map[string]interface{}{}
+	err = codec.NewDecoder(conn, output.codec).Decode(&response)
+	if err != nil {
+		return err
+	}
+	ack, ok := toBytes(response["ack"])
+	if !ok || string(ack) != chunk.id {
+		return errors.New(fmt.Sprintf("unexpected ack response: %v", response))
+	}
+	return nil
+}
+
+// sends the chunks to a single server, and returns how many of them were
+// sent successfully.
+func (output *ForwardOutput) send(server *forwardServer, chunks []encodedChunk) (int, error) {
+	conn, err := net.Dial("tcp", server.bind)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	for i, chunk := range chunks {
+		_, err := conn.Write(chunk.payload)
+		if err != nil {
+			return i, err
+		}
+		if output.requireAckResponse {
+			err = output.waitForAck(conn, chunk)
+			if err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(chunks), nil
+}
+
+// tries the servers in turn until all the chunks are sent, and returns how
+// many of them were sent.
+func (output *ForwardOutput) sendChunks(chunks []encodedChunk) (int, error) {
+	var err error
+	sent := 0
+	for i := 0; i < len(output.servers) && sent < len(chunks); i += 1 {
+		server := output.nextServer()
+		if server == nil {
+			err = errors.New("no server is available")
+			break
+		}
+		var n int
+		n, err = output.send(server, chunks[sent:])
+		sent += n
+		if err != nil {
+			output.logger.Error("Failed to forward records to %s: %s", server.bind, err.Error())
+			continue
+		}
+		output.logger.Notice("Forwarded: %d chunks to %s", n, server.bind)
+	}
+	if sent < len(chunks) && err == nil {
+		err = errors.New("could not forward all the records")
+	}
+	return sent, err
+}
+
+// groups the buffered records by tag, and sends them as chunks.
+func (output *ForwardOutput) flushRecords(records []ik.FluentRecord) error {
+	recordSets := []ik.FluentRecordSet{}
+	groups := [][]ik.FluentRecord{}
+	indices := map[string]int{}
+	for _, record := range records {
+		i, ok := indices[record.Tag]
+		if !ok {
+			i = len(recordSets)
+			indices[record.Tag] = i
+			recordSets = append(recordSets, ik.FluentRecordSet{Tag: record.Tag})
+			groups = append(groups, nil)
+		}
+		recordSets[i].Records = append(recordSets[i].Records, ik.TinyFluentRecord{
+			Timestamp:   record.Timestamp,
+			Data:        record.Data,
+			Nanoseconds: record.Nanoseconds,
+		})
+		groups[i] = append(groups[i], record)
+	}
+	chunks := make([]encodedChunk, 0, len(recordSets))
+	for i, recordSet := range recordSets {
+		chunk, err := output.encodeRecordSet(recordSet)
 		if err != nil {
 			output.logger.Error("%#v", err)
 			return err
 		}
+		chunk.records = groups[i]
+		chunks = append(chunks, chunk)
 	}
-	return nil
+	return output.sender.enqueue(chunks, output.durable)
+}
+
+// The records that don't fit in the buffer are handed back to the caller
+// as a BackpressureError, and so are all of them while the chunks kept for
+// retrying have reached total_limit_size.
+func (output *ForwardOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	if output.sender.isFull() {
+		return &ik.BackpressureError{Pending: map[ik.Port][]ik.FluentRecordSet{output: recordSets}}
+	}
+	return appendToBuffer(output.logger, output.buffer, output, recordSets)
 }
 
 func (output *ForwardOutput) Factory() ik.Plugin {
@@ -103,53 +323,188 @@ func (output *ForwardOutput) Run() error {
 }
 
 func (output *ForwardOutput) Shutdown() error {
-	return nil
+	var err error
+	output.closeOnce.Do(func() {
+		close(output.cancel)
+		output.buffer.Close()
+		err = output.sender.flush()
+	})
+	return err
 }
 
 type ForwardOutputFactory struct {
 }
 
-func newForwardOutput(factory *ForwardOutputFactory, logger ik.Logger, bind string) (*ForwardOutput, error) {
-	_codec := codec.MsgpackHandle{}
-	_codec.MapType = reflect.TypeOf(map[string]interface{}(nil))
-	_codec.RawToString = false
-	_codec.StructToArray = true
-	return &ForwardOutput{
-		factory: factory,
-		logger:  logger,
-		codec:   &_codec,
-		bind:    bind,
-	}, nil
+func newForwardOutput(factory *ForwardOutputFactory, logger ik.Logger, servers []*forwardServer, requireAckResponse bool, ackResponseTimeout time.Duration, bufferOptions bufferOptions, retry *ik.RetryManager) (*ForwardOutput, error) {
+	now := time.Now()
+	for _, server := range servers {
+		server.available = true
+		server.lastHeartbeat = now
+	}
+	retval := &ForwardOutput{
+		factory:            factory,
+		logger:             logger,
+		codec:              newForwardCodec(),
+		servers:            servers,
+		requireAckResponse: requireAckResponse,
+		ackResponseTimeout: ackResponseTimeout,
+		cancel:             make(chan bool),
+	}
+	retval.sender = &retryingSender{
+		logger:    logger,
+		retry:     retry,
+		sendBatch: retval.sendChunks,
+	}
+	buffer, durable, err := bufferOptions.newBuffer(retval.flushRecords)
+	if err != nil {
+		return nil, err
+	}
+	retval.buffer = buffer
+	retval.durable = durable
+	if !durable {
+		retval.sender.pendingLimit = bufferOptions.totalLimitSize
+	}
+	return retval, nil
 }
 
 func (factory *ForwardOutputFactory) Name() string {
 	return "forward"
 }
 
-func (factory *ForwardOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
-	host, ok := config.Attrs["host"]
+func newForwardServer(attrs map[string]string) (*forwardServer, error) {
+	host, ok := attrs["host"]
 	if !ok {
 		host = "localhost"
 	}
-	netPort, ok := config.Attrs["port"]
+	netPort, ok := attrs["port"]
 	if !ok {
 		netPort = "24224"
 	}
-	flush_interval_str, ok := config.Attrs["flush_interval"]
-	if !ok {
-		flush_interval_str = "60"
+	weight := 60
+	weightStr, ok := attrs["weight"]
+	if ok {
+		var err error
+		weight, err = strconv.Atoi(weightStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &forwardServer{
+		bind:   host + ":" + netPort,
+		weight: weight,
+	}, nil
+}
+
+func (factory *ForwardOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	servers := []*forwardServer{}
+	for _, elem := range config.Elems {
+		if elem.Name == "server" {
+			server, err := newForwardServer(elem.Attrs)
+			if err != nil {
+				return nil, err
+			}
+			servers = append(servers, server)
+		}
 	}
-	flush_interval, err := strconv.Atoi(flush_interval_str)
+	if len(servers) == 0 {
+		server, err := newForwardServer(config.Attrs)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	bufferOptions, err := parseBufferOptions(config)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Failed to parse flush_interval_str: #v", err))
+		return nil, err
+	}
+	requireAckResponse := false
+	requireAckResponseStr, ok := config.Attrs["require_ack_response"]
+	if ok {
+		requireAckResponse, err = strconv.ParseBool(requireAckResponseStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ackResponseTimeout := 190 * time.Second
+	ackResponseTimeoutStr, ok := config.Attrs["ack_response_timeout"]
+	if ok {
+		ackResponseTimeout, err = time.ParseDuration(ackResponseTimeoutStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	heartbeatInterval := time.Second
+	heartbeatIntervalStr, ok := config.Attrs["heartbeat_interval"]
+	if ok {
+		heartbeatInterval, err = time.ParseDuration(heartbeatIntervalStr)
+		if err != nil {
+			return nil, err
+		}
 	}
-	bind := host + ":" + netPort
-	output, err := newForwardOutput(factory, engine.Logger(), bind)
-	output.run_flush(flush_interval)
-	return output, err
+	hardTimeout := 60 * time.Second
+	hardTimeoutStr, ok := config.Attrs["hard_timeout"]
+	if ok {
+		hardTimeout, err = time.ParseDuration(hardTimeoutStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	phiThreshold := 16.
+	phiThresholdStr, ok := config.Attrs["phi_threshold"]
+	if ok {
+		phiThreshold, err = strconv.ParseFloat(phiThresholdStr, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry, err := parseRetryManager(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	output, err := newForwardOutput(factory, engine.Logger(), servers, requireAckResponse, ackResponseTimeout, bufferOptions, retry)
+	if err != nil {
+		return nil, err
+	}
+	output.heartbeatInterval = heartbeatInterval
+	output.hardTimeout = hardTimeout
+	output.phiThreshold = phiThreshold
+	output.sender.deadLetterPort = engine.DeadLetterPort()
+	output.sender.run(bufferOptions.flushInterval, output.cancel)
+	if heartbeatInterval > 0 {
+		output.run_heartbeat()
+	}
+	return output, nil
 }
 
 func (factory *ForwardOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "servers",
+		DisplayName: "Servers",
+		Description: "Availability of each server determined by heartbeats",
+		Fetcher:     &ForwardServerStatusTopic{},
+	})
+}
+
+func (topic *ForwardServerStatusTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *ForwardServerStatusTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*ForwardOutput)
+	statuses := make([]string, 0, len(output.servers))
+	for _, server := range output.servers {
+		status := "down"
+		if server.isAvailable() {
+			status = "up"
+		}
+		statuses = append(statuses, server.bind+" "+status)
+	}
+	return strings.Join(statuses, ", "), nil
 }
 
 var _ = AddPlugin(&ForwardOutputFactory{})
@@ -0,0 +1,335 @@
+package plugins
+
+import (
+	"bytes"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestForwardOutput_nextServer_Weighted(t *testing.T) {
+	servers := []*forwardServer{
+		{bind: "a", weight: 2, available: true},
+		{bind: "b", weight: 1, available: true},
+		{bind: "c", weight: 0, available: true},
+		{bind: "d", weight: 5, available: false},
+	}
+	output := &ForwardOutput{servers: servers}
+	counts := map[string]int{}
+	for i := 0; i < 9; i += 1 {
+		counts[output.nextServer().bind] += 1
+	}
+	if counts["a"] != 6 || counts["b"] != 3 || counts["c"] != 0 || counts["d"] != 0 {
+		t.Log(counts)
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_encodeRecordSet(t *testing.T) {
+	output := &ForwardOutput{codec: newForwardCodec()}
+	chunk, err := output.encodeRecordSet(ik.FluentRecordSet{
+		Tag: "tag",
+		Records: []ik.TinyFluentRecord{
+			{Timestamp: 1, Data: map[string]interface{}{}},
+			{Timestamp: 1, Data: map[string]interface{}{}, Nanoseconds: 5},
+		},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	// ["tag", [[1, {}], [EventTime(1, 5), {}]]]
+	expected := []byte{
+		0x92, 0xa3, 't', 'a', 'g', 0x92,
+		0x92, 0x01, 0x80,
+		0x92, 0xd7, 0x00, 0, 0, 0, 1, 0, 0, 0, 5, 0x80,
+	}
+	if !bytes.Equal(chunk.payload, expected) {
+		t.Logf("%x", chunk.payload)
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_flush_RequireAckResponse(t *testing.T) {
+	port := &testPort{}
+	input, err := newForwardInput(&ForwardInputFactory{}, &testLogger{t}, nil, []string{"127.0.0.1:0"}, port, forwardInputOptions{shutdownTimeout: time.Second})
+	if err != nil || input.Start() != nil {
+		t.FailNow()
+	}
+	done := make(chan bool)
+	go func() {
+		for input.Run() == ik.Continue {
+		}
+		done <- true
+	}()
+	defer func() {
+		input.Shutdown()
+		<-done
+	}()
+	output, _ := newForwardOutput(
+		&ForwardOutputFactory{},
+		&testLogger{t},
+		[]*forwardServer{
+			// nobody listens on the first one
+			{bind: "127.0.0.1:1", weight: 1},
+			{bind: input.listeners[0].Addr().String(), weight: 1},
+		},
+		true,
+		5*time.Second,
+		bufferOptions{overflowAction: ik.OverflowActionDrop},
+		ik.NewRetryManager(0, 0, 2, -1, rand.NewSource(0)),
+	)
+	err = output.Emit([]ik.FluentRecordSet{
+		{
+			Tag: "tag",
+			Records: []ik.TinyFluentRecord{
+				{Timestamp: 1409286145, Data: map[string]interface{}{"k": "v"}},
+			},
+		},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	err = output.buffer.Flush()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(output.sender.pending) != 0 {
+		t.Fail()
+	}
+	if len(port.recordSets) != 1 || port.recordSets[0].Tag != "tag" {
+		t.FailNow()
+	}
+	if port.recordSets[0].Records[0].Timestamp != 1409286145 {
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_flush_KeepsPendingOnFailure(t *testing.T) {
+	output, _ := newForwardOutput(
+		&ForwardOutputFactory{},
+		&testLogger{t},
+		[]*forwardServer{{bind: "127.0.0.1:1", weight: 1}},
+		false,
+		time.Second,
+		bufferOptions{overflowAction: ik.OverflowActionDrop},
+		ik.NewRetryManager(0, 0, 2, -1, rand.NewSource(0)),
+	)
+	output.Emit([]ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145}}}})
+	if output.buffer.Flush() == nil {
+		t.Fail()
+	}
+	if len(output.sender.pending) != 1 {
+		t.Fail()
+	}
+}
+
+func TestForwardServer_evaluate(t *testing.T) {
+	start := time.Unix(1409286145, 0)
+	server := &forwardServer{bind: "a", weight: 1}
+	for i := 0; i <= 10; i += 1 {
+		server.onHeartbeat(start.Add(time.Duration(i) * time.Second))
+	}
+	last := start.Add(10 * time.Second)
+	if server.evaluate(last.Add(time.Second), time.Minute, 16) {
+		t.Fail()
+	}
+	if !server.evaluate(last.Add(30*time.Second), time.Minute, 16) {
+		t.Fail()
+	}
+	if server.isAvailable() {
+		t.Fail()
+	}
+	server.onHeartbeat(last.Add(31 * time.Second))
+	if !server.isAvailable() {
+		t.Fail()
+	}
+}
+
+func TestForwardServer_evaluate_HardTimeout(t *testing.T) {
+	now := time.Unix(1409286145, 0)
+	server := &forwardServer{bind: "a", weight: 1}
+	server.onHeartbeat(now)
+	if server.evaluate(now.Add(time.Second), 2*time.Second, 16) {
+		t.Fail()
+	}
+	if !server.evaluate(now.Add(3*time.Second), 2*time.Second, 16) {
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_FileBuffer_KeepsChunksOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_forward")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	output, err := newForwardOutput(
+		&ForwardOutputFactory{},
+		&testLogger{t},
+		[]*forwardServer{{bind: "127.0.0.1:1", weight: 1}},
+		false,
+		time.Second,
+		bufferOptions{bufferPath: dir, overflowAction: ik.OverflowActionDrop},
+		ik.NewRetryManager(0, 0, 2, -1, rand.NewSource(0)),
+	)
+	if err != nil {
+		t.FailNow()
+	}
+	output.Emit([]ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145}}}})
+	if output.buffer.Flush() == nil {
+		t.Fail()
+	}
+	if len(output.sender.pending) != 0 {
+		t.Fail()
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_Emit_BackpressureOnOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_forward")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	output, err := newForwardOutput(
+		&ForwardOutputFactory{},
+		&testLogger{t},
+		[]*forwardServer{{bind: "127.0.0.1:1", weight: 1}},
+		false,
+		time.Second,
+		bufferOptions{bufferPath: dir, totalLimitSize: 64, overflowAction: ik.OverflowActionDrop},
+		ik.NewRetryManager(0, 0, 2, -1, rand.NewSource(0)),
+	)
+	if err != nil {
+		t.FailNow()
+	}
+	defer output.buffer.Close()
+	records := make([]ik.TinyFluentRecord, 8)
+	for i := range records {
+		records[i] = ik.TinyFluentRecord{Timestamp: uint64(1409286145 + i), Data: map[string]interface{}{"k": "v"}}
+	}
+	err = output.Emit([]ik.FluentRecordSet{{Tag: "a", Records: records}, {Tag: "b", Records: records[0:1]}})
+	backpressureErr, ok := err.(*ik.BackpressureError)
+	if !ok {
+		t.FailNow()
+	}
+	pending := backpressureErr.Pending[output]
+	if len(pending) != 2 || pending[0].Tag != "a" || pending[1].Tag != "b" {
+		t.FailNow()
+	}
+	if len(pending[0].Records) == 0 || len(pending[0].Records) == len(records) {
+		t.Log(len(pending[0].Records))
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_flush_Backoff(t *testing.T) {
+	output, _ := newForwardOutput(
+		&ForwardOutputFactory{},
+		&testLogger{t},
+		[]*forwardServer{{bind: "127.0.0.1:1", weight: 1}},
+		false,
+		time.Second,
+		bufferOptions{overflowAction: ik.OverflowActionDrop},
+		ik.NewRetryManager(time.Hour, 0, 2, 1, rand.NewSource(0)),
+	)
+	deadLetterPort := make(chanPort, 1)
+	output.sender.deadLetterPort = deadLetterPort
+	output.Emit([]ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145, Data: map[string]interface{}{"k": "v"}}}}})
+	if output.buffer.Flush() == nil || output.sender.nextRetry.IsZero() {
+		t.FailNow()
+	}
+	// still backing off
+	if output.sender.flush() == nil || output.sender.retry.Steps() != 1 {
+		t.Fail()
+	}
+	// retry_limit exceeded; the chunks are given up
+	output.sender.nextRetry = time.Time{}
+	output.sender.flush()
+	if len(output.sender.pending) != 0 || output.sender.retry.Steps() != 0 {
+		t.Fail()
+	}
+	// and emitted to the dead letter port
+	if len(deadLetterPort) != 1 {
+		t.FailNow()
+	}
+	recordSets := <-deadLetterPort
+	record := recordSets[0].Records[0]
+	if recordSets[0].Tag != "tag" || record.Data["k"] != "v" || record.Data[ik.DeadLetterErrorKey] == nil {
+		t.Log(recordSets)
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_Emit_BackpressureOnPendingLimit(t *testing.T) {
+	output, _ := newForwardOutput(
+		&ForwardOutputFactory{},
+		&testLogger{t},
+		[]*forwardServer{{bind: "127.0.0.1:1", weight: 1}},
+		false,
+		time.Second,
+		bufferOptions{totalLimitSize: 16, overflowAction: ik.OverflowActionDrop},
+		ik.NewRetryManager(time.Hour, 0, 2, -1, rand.NewSource(0)),
+	)
+	recordSets := []ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145, Data: map[string]interface{}{"k": "v"}}}}}
+	if output.Emit(recordSets) != nil {
+		t.FailNow()
+	}
+	if output.buffer.Flush() == nil || len(output.sender.pending) != 1 {
+		t.FailNow()
+	}
+	err := output.Emit(recordSets)
+	backpressureErr, ok := err.(*ik.BackpressureError)
+	if !ok {
+		t.FailNow()
+	}
+	pending := backpressureErr.Pending[output]
+	if len(pending) != 1 || pending[0].Tag != "tag" {
+		t.Fail()
+	}
+	// Shutdown() may be called more than once
+	output.Shutdown()
+	output.Shutdown()
+}
+
+func TestForwardOutput_flush_GivesUpOnlyExhaustedChunks(t *testing.T) {
+	output, _ := newForwardOutput(
+		&ForwardOutputFactory{},
+		&testLogger{t},
+		[]*forwardServer{{bind: "127.0.0.1:1", weight: 1}},
+		false,
+		time.Second,
+		bufferOptions{overflowAction: ik.OverflowActionDrop},
+		ik.NewRetryManager(time.Hour, 0, 2, 1, rand.NewSource(0)),
+	)
+	deadLetterPort := make(chanPort, 2)
+	output.sender.deadLetterPort = deadLetterPort
+	output.Emit([]ik.FluentRecordSet{{Tag: "a", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145}}}})
+	output.buffer.Flush()
+	// queued just before the retry that exceeds retry_limit
+	output.sender.nextRetry = time.Time{}
+	output.Emit([]ik.FluentRecordSet{{Tag: "b", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145}}}})
+	output.buffer.Flush()
+	if len(deadLetterPort) != 1 || (<-deadLetterPort)[0].Tag != "a" {
+		t.FailNow()
+	}
+	// the chunk tried once is kept and backed off for
+	if len(output.sender.pending) != 1 || output.sender.pending[0].failures != 1 {
+		t.FailNow()
+	}
+	if output.sender.nextRetry.IsZero() || output.sender.retry.Steps() != 1 {
+		t.Fail()
+	}
+	output.sender.nextRetry = time.Time{}
+	output.sender.flush()
+	if len(output.sender.pending) != 0 || len(deadLetterPort) != 1 || (<-deadLetterPort)[0].Tag != "b" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,258 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type HttpOutput struct {
+	factory     *HttpOutputFactory
+	engine      ik.Engine
+	logger      ik.Logger
+	client      *http.Client
+	endpointURL string
+	ndjson      bool
+	contentType string
+	headers     map[string]string
+	username    string
+	password    string
+	secondary   ik.Output
+	buffer      ik.RecordBuffer
+	durable     bool
+	sender      *retryingSender
+	cancel      chan bool
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+type HttpOutputFactory struct {
+}
+
+// encodes the records either as a JSON array or as newline-delimited JSON.
+func (output *HttpOutput) encode(records []ik.FluentRecord) ([]byte, error) {
+	if !output.ndjson {
+		data := make([]map[string]interface{}, len(records))
+		for i, record := range records {
+			data[i] = record.Data
+		}
+		return json.Marshal(data)
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, record := range records {
+		err := enc.Encode(record.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (output *HttpOutput) post(payload []byte) error {
+	request, err := http.NewRequest("POST", output.endpointURL, bytes.NewReader(payload))
+	if err != nil {
+		return &nonRetryableError{err}
+	}
+	request.Header.Set("Content-Type", output.contentType)
+	for name, value := range output.headers {
+		request.Header.Set(name, value)
+	}
+	if output.username != "" {
+		request.SetBasicAuth(output.username, output.password)
+	}
+	response, err := output.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("%s returned %s", output.endpointURL, response.Status))
+	}
+	return nil
+}
+
+// hands the records which could not be delivered to the <secondary> output,
+// or to the dead letter port if there is none.
+func (output *HttpOutput) emitToSecondary(chunks []encodedChunk, reason string) {
+	if output.secondary == nil {
+		output.sender.emitDeadLetters(chunks, reason)
+		return
+	}
+	err := output.secondary.Emit(recordSetsOfChunks(chunks))
+	if err != nil {
+		output.logger.Error("Failed to emit to the secondary output: %s", err.Error())
+	}
+}
+
+func (output *HttpOutput) flushRecords(records []ik.FluentRecord) error {
+	payload, err := output.encode(records)
+	if err != nil {
+		output.logger.Error("%s", err.Error())
+		return err
+	}
+	return output.sender.enqueue([]encodedChunk{{records: records, payload: payload}}, output.durable)
+}
+
+func (output *HttpOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return appendToBuffer(output.logger, output.buffer, output, recordSets)
+}
+
+func (output *HttpOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+// the secondary output is terminated once the records left in the buffer
+// have been flushed.
+func (output *HttpOutput) Run() error {
+	<-output.done
+	if output.secondary != nil {
+		terminateStores(output.engine, []ik.Output{output.secondary})
+	}
+	return nil
+}
+
+func (output *HttpOutput) Shutdown() error {
+	var err error
+	output.closeOnce.Do(func() {
+		close(output.cancel)
+		output.buffer.Close()
+		err = output.sender.flush()
+		close(output.done)
+	})
+	return err
+}
+
+func (output *HttpOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newHttpOutput(factory *HttpOutputFactory, engine ik.Engine, logger ik.Logger, endpointURL string, ndjson bool, contentType string, headers map[string]string, username string, password string, requestTimeout time.Duration, bufferOptions bufferOptions, retry *ik.RetryManager) (*HttpOutput, error) {
+	retval := &HttpOutput{
+		factory:     factory,
+		engine:      engine,
+		logger:      logger,
+		client:      &http.Client{Timeout: requestTimeout},
+		endpointURL: endpointURL,
+		ndjson:      ndjson,
+		contentType: contentType,
+		headers:     headers,
+		username:    username,
+		password:    password,
+		cancel:      make(chan bool),
+		done:        make(chan struct{}),
+	}
+	retval.sender = &retryingSender{
+		logger: logger,
+		retry:  retry,
+		send:   retval.post,
+		giveUp: retval.emitToSecondary,
+	}
+	buffer, durable, err := bufferOptions.newBuffer(retval.flushRecords)
+	if err != nil {
+		return nil, err
+	}
+	retval.buffer = buffer
+	retval.durable = durable
+	return retval, nil
+}
+
+func newSecondary(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	for _, elem := range config.Elems {
+		if elem.Name != "secondary" {
+			continue
+		}
+		type_ := elem.Attrs["type"]
+		factory := lookupOutputFactory(type_)
+		if factory == nil {
+			return nil, errors.New("Could not find output factory: " + type_)
+		}
+		secondary, err := factory.New(engine, elem)
+		if err != nil {
+			return nil, err
+		}
+		err = engine.Launch(secondary)
+		if err != nil {
+			return nil, err
+		}
+		return secondary, nil
+	}
+	return nil, nil
+}
+
+func (factory *HttpOutputFactory) Name() string {
+	return "http"
+}
+
+func (factory *HttpOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	endpointURL, ok := config.Attrs["endpoint_url"]
+	if !ok {
+		return nil, errors.New("'endpoint_url' parameter is required")
+	}
+	ndjson := false
+	format, ok := config.Attrs["format"]
+	if ok {
+		switch format {
+		case "json":
+		case "ndjson":
+			ndjson = true
+		default:
+			return nil, errors.New("unknown format: " + format)
+		}
+	}
+	contentType, ok := config.Attrs["content_type"]
+	if !ok {
+		if ndjson {
+			contentType = "application/x-ndjson"
+		} else {
+			contentType = "application/json"
+		}
+	}
+	headers := make(map[string]string)
+	for _, elem := range config.Elems {
+		if elem.Name == "headers" {
+			for name, value := range elem.Attrs {
+				headers[name] = value
+			}
+		}
+	}
+	username, _ := config.Attrs["username"]
+	password, _ := config.Attrs["password"]
+	requestTimeout, err := config.AttrDuration("request_timeout", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	bufferOptions, err := parseBufferOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := parseRetryManager(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	output, err := newHttpOutput(factory, engine, engine.Logger(), endpointURL, ndjson, contentType, headers, username, password, requestTimeout, bufferOptions, retry)
+	if err != nil {
+		return nil, err
+	}
+	output.secondary, err = newSecondary(engine, config)
+	if err != nil {
+		output.buffer.Close()
+		return nil, err
+	}
+	output.sender.deadLetterPort = engine.DeadLetterPort()
+	output.sender.run(bufferOptions.flushInterval, output.cancel)
+	return output, nil
+}
+
+func (factory *HttpOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&HttpOutputFactory{})
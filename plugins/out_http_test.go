@@ -0,0 +1,120 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestHttpOutput(t *testing.T, url string, ndjson bool, contentType string, retryLimit int) *HttpOutput {
+	retry := ik.NewRetryManager(time.Second, time.Minute, 2., retryLimit, mrand.NewSource(0))
+	output, err := newHttpOutput(&HttpOutputFactory{}, nil, &testLogger{t}, url, ndjson, contentType, map[string]string{"X-Foo": "bar"}, "user", "secret", time.Second, bufferOptions{chunkLimitSize: 1024 * 1024, flushInterval: time.Hour}, retry)
+	if err != nil {
+		t.FailNow()
+	}
+	return output
+}
+
+func TestHttpOutput_flushRecords(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+	records := []ik.FluentRecord{
+		{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"k": "v1"}},
+		{Tag: "a", Timestamp: 1409286146, Data: map[string]interface{}{"k": "v2"}},
+	}
+	output := newTestHttpOutput(t, server.URL+"/hook", false, "application/json", 3)
+	defer output.Shutdown()
+	if output.flushRecords(records) != nil {
+		t.FailNow()
+	}
+	output_ := newTestHttpOutput(t, server.URL+"/hook", true, "application/x-ndjson", 3)
+	defer output_.Shutdown()
+	if output_.flushRecords(records) != nil {
+		t.FailNow()
+	}
+	if len(requests) != 2 {
+		t.FailNow()
+	}
+	if bodies[0] != `[{"k":"v1"},{"k":"v2"}]` || bodies[1] != "{\"k\":\"v1\"}\n{\"k\":\"v2\"}\n" {
+		t.Log(bodies)
+		t.Fail()
+	}
+	for _, r := range requests {
+		username, password, ok := r.BasicAuth()
+		if r.URL.Path != "/hook" || r.Header.Get("X-Foo") != "bar" || !ok || username != "user" || password != "secret" {
+			t.Fail()
+		}
+	}
+	if requests[0].Header.Get("Content-Type") != "application/json" || requests[1].Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fail()
+	}
+}
+
+func TestHttpOutput_flushRecords_GivesUpToSecondary(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	secondary := &testOutput{}
+	output := newTestHttpOutput(t, server.URL, false, "application/json", 2)
+	output.secondary = secondary
+	defer output.Shutdown()
+	err := output.flushRecords([]ik.FluentRecord{
+		{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"k": "v1"}},
+		{Tag: "a", Timestamp: 1409286146, Data: map[string]interface{}{"k": "v2"}},
+		{Tag: "b", Timestamp: 1409286147, Data: map[string]interface{}{"k": "v3"}},
+	})
+	if err == nil || len(secondary.recordSets) != 0 {
+		t.FailNow()
+	}
+	for i := 0; i < 2; i += 1 {
+		output.sender.nextRetry = time.Time{}
+		output.sender.flush()
+	}
+	if requests != 3 || len(output.sender.pending) != 0 {
+		t.Log(requests)
+		t.FailNow()
+	}
+	if len(secondary.recordSets) != 2 || secondary.recordSets[0].Tag != "a" || len(secondary.recordSets[0].Records) != 2 || secondary.recordSets[1].Tag != "b" || secondary.recordSets[1].Records[0].Data["k"] != "v3" {
+		t.Log(secondary.recordSets)
+		t.Fail()
+	}
+}
+
+func TestHttpOutput_flushRecords_GivesUpToDeadLetterPort(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	deadLetterPort := make(chanPort, 1)
+	output := newTestHttpOutput(t, server.URL, false, "application/json", 0)
+	output.sender.deadLetterPort = deadLetterPort
+	defer output.Shutdown()
+	data := map[string]interface{}{"k": "v1"}
+	output.flushRecords([]ik.FluentRecord{{Tag: "a", Timestamp: 1409286145, Data: data}})
+	if len(deadLetterPort) != 1 {
+		t.FailNow()
+	}
+	recordSets := <-deadLetterPort
+	record := recordSets[0].Records[0]
+	if recordSets[0].Tag != "a" || record.Data["k"] != "v1" || record.Data[ik.DeadLetterErrorKey] == nil {
+		t.Log(recordSets)
+		t.Fail()
+	}
+	// the record given is left intact
+	if _, ok := data[ik.DeadLetterErrorKey]; ok {
+		t.Fail()
+	}
+}
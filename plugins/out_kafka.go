@@ -0,0 +1,428 @@
+package plugins
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/formatters"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type KafkaOutput struct {
+	factory      *KafkaOutputFactory
+	logger       ik.Logger
+	brokers      []string
+	config       *sarama.Config
+	producer     sarama.SyncProducer
+	producerMtx  sync.Mutex
+	topic        string
+	topicKey     string
+	partitionKey string
+	formatter    ik.Formatter
+	buffer       ik.RecordBuffer
+	durable      bool
+	sender       *retryingSender
+	cancel       chan bool
+	closeOnce    sync.Once
+	// the number of the messages delivered and those failed to be
+	// delivered, including the ones retried afterwards
+	produced int64
+	failed   int64
+}
+
+type KafkaOutputFactory struct {
+}
+
+type ProducedMessageCountTopic struct{}
+
+type FailedMessageCountTopic struct{}
+
+// the topic of the record is taken from the field named by topic_key,
+// falling back to topic, and then to the tag.
+func (output *KafkaOutput) topicFor(record ik.FluentRecord) string {
+	if output.topicKey != "" {
+		topic, ok := record.Data[output.topicKey].(string)
+		if ok && topic != "" {
+			return topic
+		}
+	}
+	if output.topic != "" {
+		return output.topic
+	}
+	return record.Tag
+}
+
+func (output *KafkaOutput) message(record ik.FluentRecord) (*sarama.ProducerMessage, error) {
+	value, err := output.formatter.Format(record)
+	if err != nil {
+		return nil, err
+	}
+	retval := &sarama.ProducerMessage{
+		Topic: output.topicFor(record),
+		Value: sarama.ByteEncoder(value),
+	}
+	if output.partitionKey != "" {
+		key, ok := record.Data[output.partitionKey]
+		if ok {
+			retval.Key = sarama.StringEncoder(fmt.Sprint(key))
+		}
+	}
+	return retval, nil
+}
+
+// the length of a missing field in the payload
+const kafkaMissingField = 0xffffffff
+
+func appendKafkaField(b []byte, field []byte, present bool) []byte {
+	n := make([]byte, 4)
+	if !present {
+		binary.BigEndian.PutUint32(n, kafkaMissingField)
+		return append(b, n...)
+	}
+	binary.BigEndian.PutUint32(n, uint32(len(field)))
+	return append(append(b, n...), field...)
+}
+
+// encodes the messages into a payload, in which the topic, the key and
+// the value of each message are prefixed with their lengths.
+func encodeKafkaMessages(messages []*sarama.ProducerMessage) ([]byte, error) {
+	retval := []byte{}
+	for _, message := range messages {
+		retval = appendKafkaField(retval, []byte(message.Topic), true)
+		var key []byte
+		if message.Key != nil {
+			var err error
+			key, err = message.Key.Encode()
+			if err != nil {
+				return nil, err
+			}
+		}
+		retval = appendKafkaField(retval, key, message.Key != nil)
+		value, err := message.Value.Encode()
+		if err != nil {
+			return nil, err
+		}
+		retval = appendKafkaField(retval, value, true)
+	}
+	return retval, nil
+}
+
+func decodeKafkaMessages(payload []byte) ([]*sarama.ProducerMessage, error) {
+	retval := []*sarama.ProducerMessage{}
+	field := func() ([]byte, bool, error) {
+		if len(payload) < 4 {
+			return nil, false, errors.New("Failed to decode the field length")
+		}
+		n := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		if n == kafkaMissingField {
+			return nil, false, nil
+		}
+		if uint64(n) > uint64(len(payload)) {
+			return nil, false, errors.New(fmt.Sprintf("Invalid field length: %d", n))
+		}
+		b := payload[0:n]
+		payload = payload[n:]
+		return b, true, nil
+	}
+	for len(payload) > 0 {
+		topic, ok, err := field()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("Failed to decode the topic")
+		}
+		message := &sarama.ProducerMessage{Topic: string(topic)}
+		key, ok, err := field()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			message.Key = sarama.ByteEncoder(key)
+		}
+		value, ok, err := field()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("Failed to decode the value")
+		}
+		message.Value = sarama.ByteEncoder(value)
+		retval = append(retval, message)
+	}
+	return retval, nil
+}
+
+// tells if producing the message again would not help.
+func isKafkaErrorPermanent(err error) bool {
+	switch err {
+	case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessage, sarama.ErrInvalidTopic:
+		return true
+	}
+	return false
+}
+
+// connects to the brokers on the first use, so that the output can be
+// configured while they are down.
+func (output *KafkaOutput) getProducer() (sarama.SyncProducer, error) {
+	output.producerMtx.Lock()
+	defer output.producerMtx.Unlock()
+	if output.producer == nil {
+		producer, err := sarama.NewSyncProducer(output.brokers, output.config)
+		if err != nil {
+			return nil, err
+		}
+		output.producer = producer
+	}
+	return output.producer, nil
+}
+
+func (output *KafkaOutput) closeProducer() {
+	output.producerMtx.Lock()
+	defer output.producerMtx.Unlock()
+	if output.producer != nil {
+		output.producer.Close()
+		output.producer = nil
+	}
+}
+
+// the messages that failed to be delivered are retried, except for those
+// rejected for good, which are dropped.
+func (output *KafkaOutput) produce(payload []byte) error {
+	messages, err := decodeKafkaMessages(payload)
+	if err != nil {
+		return &nonRetryableError{err}
+	}
+	producer, err := output.getProducer()
+	if err != nil {
+		return err
+	}
+	err = producer.SendMessages(messages)
+	if err == nil {
+		atomic.AddInt64(&output.produced, int64(len(messages)))
+		return nil
+	}
+	producerErrors, ok := err.(sarama.ProducerErrors)
+	if !ok {
+		atomic.AddInt64(&output.failed, int64(len(messages)))
+		// reconnects on the next attempt
+		output.closeProducer()
+		return err
+	}
+	atomic.AddInt64(&output.produced, int64(len(messages)-len(producerErrors)))
+	atomic.AddInt64(&output.failed, int64(len(producerErrors)))
+	rest := []*sarama.ProducerMessage{}
+	for _, producerError := range producerErrors {
+		if isKafkaErrorPermanent(producerError.Err) {
+			output.logger.Error("Dropped a message to %s: %s", producerError.Msg.Topic, producerError.Err.Error())
+		} else {
+			rest = append(rest, producerError.Msg)
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	restPayload, err := encodeKafkaMessages(rest)
+	if err != nil {
+		return &nonRetryableError{err}
+	}
+	return &partialSendError{err: producerErrors, rest: restPayload}
+}
+
+func (output *KafkaOutput) flushRecords(records []ik.FluentRecord) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(records))
+	for _, record := range records {
+		message, err := output.message(record)
+		if err != nil {
+			output.logger.Error("Failed to format a record of %s: %s", record.Tag, err.Error())
+			continue
+		}
+		messages = append(messages, message)
+	}
+	payload, err := encodeKafkaMessages(messages)
+	if err != nil {
+		output.logger.Error("%s", err.Error())
+		return err
+	}
+	return output.sender.enqueue([]encodedChunk{{records: records, payload: payload}}, output.durable)
+}
+
+func (output *KafkaOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return appendToBuffer(output.logger, output.buffer, output, recordSets)
+}
+
+func (output *KafkaOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *KafkaOutput) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (output *KafkaOutput) Shutdown() error {
+	output.closeOnce.Do(func() {
+		close(output.cancel)
+		output.buffer.Close()
+	})
+	err := output.sender.flush()
+	output.closeProducer()
+	return err
+}
+
+func (output *KafkaOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newKafkaOutput(factory *KafkaOutputFactory, logger ik.Logger, brokers []string, config *sarama.Config, topic string, topicKey string, partitionKey string, formatter ik.Formatter, bufferOptions bufferOptions, retry *ik.RetryManager) (*KafkaOutput, error) {
+	retval := &KafkaOutput{
+		factory:      factory,
+		logger:       logger,
+		brokers:      brokers,
+		config:       config,
+		topic:        topic,
+		topicKey:     topicKey,
+		partitionKey: partitionKey,
+		formatter:    formatter,
+		cancel:       make(chan bool),
+	}
+	retval.sender = &retryingSender{
+		logger: logger,
+		retry:  retry,
+		send:   retval.produce,
+	}
+	buffer, durable, err := bufferOptions.newBuffer(retval.flushRecords)
+	if err != nil {
+		return nil, err
+	}
+	retval.buffer = buffer
+	retval.durable = durable
+	return retval, nil
+}
+
+// accepts "all" or -1, 1 and 0 like the acks setting of the Java client.
+func parseRequiredAcks(s string) (sarama.RequiredAcks, error) {
+	switch s {
+	case "all", "-1":
+		return sarama.WaitForAll, nil
+	case "1":
+		return sarama.WaitForLocal, nil
+	case "0":
+		return sarama.NoResponse, nil
+	}
+	return 0, errors.New(fmt.Sprintf("invalid required_acks: %s", strconv.Quote(s)))
+}
+
+func (factory *KafkaOutputFactory) Name() string {
+	return "kafka"
+}
+
+func (factory *KafkaOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	brokersStr, ok := config.Attrs["brokers"]
+	if !ok {
+		brokersStr = "localhost:9092"
+	}
+	brokers := []string{}
+	for _, broker := range strings.Split(brokersStr, ",") {
+		broker = strings.TrimSpace(broker)
+		if broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, errors.New("'brokers' parameter must not be empty")
+	}
+	topic, _ := config.Attrs["topic"]
+	topicKey, _ := config.Attrs["topic_key"]
+	partitionKey, _ := config.Attrs["partition_key"]
+	requiredAcksStr, ok := config.Attrs["required_acks"]
+	if !ok {
+		requiredAcksStr = "1"
+	}
+	requiredAcks, err := parseRequiredAcks(requiredAcksStr)
+	if err != nil {
+		return nil, err
+	}
+	ackTimeout, err := config.AttrDuration("ack_timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	formatter, err := formatters.New(config, "json")
+	if err != nil {
+		return nil, err
+	}
+	bufferOptions, err := parseBufferOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := parseRetryManager(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	producerConfig := sarama.NewConfig()
+	producerConfig.ClientID = "ik"
+	producerConfig.Producer.RequiredAcks = requiredAcks
+	producerConfig.Producer.Timeout = ackTimeout
+	producerConfig.Producer.Partitioner = sarama.NewHashPartitioner
+	// needed by the sync producer
+	producerConfig.Producer.Return.Successes = true
+	output, err := newKafkaOutput(factory, engine.Logger(), brokers, producerConfig, topic, topicKey, partitionKey, formatter, bufferOptions, retry)
+	if err != nil {
+		return nil, err
+	}
+	output.sender.deadLetterPort = engine.DeadLetterPort()
+	output.sender.run(bufferOptions.flushInterval, output.cancel)
+	return output, nil
+}
+
+func (factory *KafkaOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "produced_messages",
+		DisplayName: "Produced messages",
+		Description: "Number of messages delivered to the brokers",
+		Fetcher:     &ProducedMessageCountTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "failed_messages",
+		DisplayName: "Failed messages",
+		Description: "Number of messages failed to be delivered, including those retried",
+		Fetcher:     &FailedMessageCountTopic{},
+	})
+}
+
+func (topic *ProducedMessageCountTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *ProducedMessageCountTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*KafkaOutput)
+	return strconv.FormatInt(atomic.LoadInt64(&output.produced), 10), nil
+}
+
+func (topic *FailedMessageCountTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *FailedMessageCountTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*KafkaOutput)
+	return strconv.FormatInt(atomic.LoadInt64(&output.failed), 10), nil
+}
+
+var _ = AddPlugin(&KafkaOutputFactory{})
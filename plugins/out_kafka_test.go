@@ -0,0 +1,188 @@
+package plugins
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/formatters"
+	mrand "math/rand"
+	"testing"
+	"time"
+)
+
+// fails the messages whose values are in failures with the errors, and
+// records the values of the others
+type testKafkaProducer struct {
+	failures map[string]error
+	err      error
+	produced []string
+	closed   bool
+}
+
+func (producer *testKafkaProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return 0, 0, producer.SendMessages([]*sarama.ProducerMessage{msg})
+}
+
+func (producer *testKafkaProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	if producer.err != nil {
+		return producer.err
+	}
+	var errs sarama.ProducerErrors
+	for _, msg := range msgs {
+		value, _ := msg.Value.Encode()
+		err, ok := producer.failures[string(value)]
+		if ok {
+			errs = append(errs, &sarama.ProducerError{Msg: msg, Err: err})
+		} else {
+			producer.produced = append(producer.produced, string(value))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (producer *testKafkaProducer) Close() error {
+	producer.closed = true
+	return nil
+}
+
+// formats the records as their message fields
+type testMessageFormatter struct{}
+
+func (formatter *testMessageFormatter) Format(record ik.FluentRecord) ([]byte, error) {
+	return []byte(record.Data["message"].(string)), nil
+}
+
+func newTestKafkaOutput(t *testing.T, producer *testKafkaProducer) *KafkaOutput {
+	retry := ik.NewRetryManager(time.Second, time.Minute, 2., 3, mrand.NewSource(0))
+	output, err := newKafkaOutput(&KafkaOutputFactory{}, &testLogger{t}, []string{"localhost:9092"}, sarama.NewConfig(), "", "topic", "id", &testMessageFormatter{}, bufferOptions{chunkLimitSize: 1024 * 1024, flushInterval: time.Hour}, retry)
+	if err != nil {
+		t.FailNow()
+	}
+	output.producer = producer
+	return output
+}
+
+func TestKafkaOutput_message(t *testing.T) {
+	output := newTestKafkaOutput(t, &testKafkaProducer{})
+	defer output.Shutdown()
+	message, err := output.message(ik.FluentRecord{Tag: "app.access", Data: map[string]interface{}{"message": "foo", "id": 42}})
+	if err != nil {
+		t.FailNow()
+	}
+	key, _ := message.Key.Encode()
+	if message.Topic != "app.access" || string(key) != "42" {
+		t.Log(message)
+		t.Fail()
+	}
+	message, _ = output.message(ik.FluentRecord{Tag: "app.access", Data: map[string]interface{}{"message": "foo", "topic": "other"}})
+	if message.Topic != "other" || message.Key != nil {
+		t.Fail()
+	}
+	output.topic = "static"
+	message, _ = output.message(ik.FluentRecord{Tag: "app.access", Data: map[string]interface{}{"message": "foo"}})
+	if message.Topic != "static" {
+		t.Fail()
+	}
+}
+
+func TestKafkaMessages_RoundTrip(t *testing.T) {
+	messages := []*sarama.ProducerMessage{
+		{Topic: "a", Key: sarama.StringEncoder("k"), Value: sarama.ByteEncoder("v")},
+		{Topic: "b", Value: sarama.ByteEncoder("")},
+	}
+	payload, err := encodeKafkaMessages(messages)
+	if err != nil {
+		t.FailNow()
+	}
+	decoded, err := decodeKafkaMessages(payload)
+	if err != nil || len(decoded) != 2 {
+		t.FailNow()
+	}
+	key, _ := decoded[0].Key.Encode()
+	value, _ := decoded[0].Value.Encode()
+	if decoded[0].Topic != "a" || string(key) != "k" || string(value) != "v" {
+		t.Fail()
+	}
+	if decoded[1].Topic != "b" || decoded[1].Key != nil {
+		t.Fail()
+	}
+	_, err = decodeKafkaMessages(payload[0 : len(payload)-1])
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestKafkaOutput_flushRecords_PartialFailure(t *testing.T) {
+	producer := &testKafkaProducer{failures: map[string]error{
+		"retried": sarama.ErrNotEnoughReplicas,
+		"dropped": sarama.ErrMessageSizeTooLarge,
+	}}
+	output := newTestKafkaOutput(t, producer)
+	defer output.Shutdown()
+	records := []ik.FluentRecord{}
+	for _, message := range []string{"foo", "retried", "dropped", "bar"} {
+		records = append(records, ik.FluentRecord{Tag: "a", Data: map[string]interface{}{"message": message}})
+	}
+	if output.flushRecords(records) == nil {
+		t.Fail()
+	}
+	if len(producer.produced) != 2 {
+		t.Fail()
+	}
+	// only the message failed for the time being is retried
+	delete(producer.failures, "retried")
+	output.sender.nextRetry = time.Time{}
+	err := output.sender.flush()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(producer.produced) != 3 || producer.produced[2] != "retried" {
+		t.Log(producer.produced)
+		t.Fail()
+	}
+	produced, _ := (&ProducedMessageCountTopic{}).PlainText(output)
+	failed, _ := (&FailedMessageCountTopic{}).PlainText(output)
+	if produced != "3" || failed != "2" {
+		t.Log(produced, failed)
+		t.Fail()
+	}
+}
+
+func TestKafkaOutput_flushRecords_ConnectionFailure(t *testing.T) {
+	producer := &testKafkaProducer{err: errors.New("broken pipe")}
+	output := newTestKafkaOutput(t, producer)
+	defer output.Shutdown()
+	if output.flushRecords([]ik.FluentRecord{{Tag: "a", Data: map[string]interface{}{"message": "foo"}}}) == nil {
+		t.Fail()
+	}
+	// the producer is made anew on the next attempt
+	if !producer.closed || output.producer != nil {
+		t.Fail()
+	}
+	if len(output.sender.pending) != 1 {
+		t.Fail()
+	}
+}
+
+func TestKafkaOutputFactory_New(t *testing.T) {
+	engine := &testRetryEngine{testForwardEngine{logger: &testLogger{t}}}
+	_, err := (&KafkaOutputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"required_acks": "2"}})
+	if err == nil {
+		t.Fail()
+	}
+	output, err := (&KafkaOutputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"brokers": "a:9092, b:9092", "required_acks": "all", "format": "msgpack"}})
+	if err != nil {
+		t.FailNow()
+	}
+	defer output.(*KafkaOutput).Shutdown()
+	if len(output.(*KafkaOutput).brokers) != 2 || output.(*KafkaOutput).config.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Fail()
+	}
+	if _, ok := output.(*KafkaOutput).formatter.(*formatters.MsgpackFormatter); !ok {
+		t.Fail()
+	}
+}
@@ -0,0 +1,306 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	strftime "github.com/jehiah/go-strftime"
+	"github.com/moriyoshi/ik"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the largest document MongoDB accepts
+const mongoMaxDocumentSize = 16 * 1024 * 1024
+
+type MongoOutput struct {
+	factory        *MongoOutputFactory
+	logger         ik.Logger
+	dialInfo       *mgo.DialInfo
+	session        *mgo.Session
+	sessionMtx     sync.Mutex
+	database       string
+	collectionName string
+	timeKey        string
+	buffer         ik.RecordBuffer
+	durable        bool
+	sender         *retryingSender
+	cancel         chan bool
+	closeOnce      sync.Once
+	// the number of the documents dropped for exceeding the size limit
+	oversized int64
+}
+
+type MongoOutputFactory struct {
+}
+
+type OversizedDocumentCountTopic struct{}
+
+// the document stored for the record, whose timestamp is put under
+// time_key as a BSON date.
+func (output *MongoOutput) document(record ik.FluentRecord) bson.M {
+	document := make(bson.M, len(record.Data)+1)
+	for k, v := range record.Data {
+		document[k] = v
+	}
+	document[output.timeKey] = time.Unix(int64(record.Timestamp), int64(record.Nanoseconds)).UTC()
+	return document
+}
+
+// encodes the records into a chunk for each collection.  the collection
+// name is formatted with the time of each record, so a batch may span
+// multiple collections.  the payload of a chunk is the NUL-terminated
+// name of the collection followed by the BSON documents.  the documents
+// exceeding the size limit are dropped, as they would never be accepted.
+func (output *MongoOutput) encodeChunks(records []ik.FluentRecord) ([]encodedChunk, error) {
+	retval := []encodedChunk{}
+	indices := make(map[string]int)
+	for _, record := range records {
+		timestamp := time.Unix(int64(record.Timestamp), int64(record.Nanoseconds)).UTC()
+		collectionName := strftime.Format(output.collectionName, timestamp)
+		document, err := bson.Marshal(output.document(record))
+		if err != nil {
+			return nil, err
+		}
+		if len(document) > mongoMaxDocumentSize {
+			atomic.AddInt64(&output.oversized, 1)
+			output.logger.Error("Dropped a record of %s as the document is %d bytes, exceeding the limit of %d bytes", record.Tag, len(document), mongoMaxDocumentSize)
+			continue
+		}
+		i, ok := indices[collectionName]
+		if !ok {
+			i = len(retval)
+			indices[collectionName] = i
+			retval = append(retval, encodedChunk{payload: append([]byte(collectionName), 0)})
+		}
+		retval[i].records = append(retval[i].records, record)
+		retval[i].payload = append(retval[i].payload, document...)
+	}
+	return retval, nil
+}
+
+// splits the payload made by encodeChunks into the collection name and
+// the documents.
+func decodeMongoPayload(payload []byte) (string, []interface{}, error) {
+	i := bytes.IndexByte(payload, 0)
+	if i < 0 {
+		return "", nil, errors.New("Failed to decode the collection name")
+	}
+	collectionName := string(payload[0:i])
+	documents := []interface{}{}
+	for rest := payload[i+1:]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return "", nil, errors.New("Failed to decode the document length")
+		}
+		n := int(binary.LittleEndian.Uint32(rest))
+		if n < 5 || n > len(rest) {
+			return "", nil, errors.New(fmt.Sprintf("Invalid document length: %d", n))
+		}
+		documents = append(documents, bson.Raw{Kind: 0x03, Data: rest[0:n]})
+		rest = rest[n:]
+	}
+	return collectionName, documents, nil
+}
+
+// connects to the server on the first use, so that the output can be
+// configured while the server is down.
+func (output *MongoOutput) getSession() (*mgo.Session, error) {
+	output.sessionMtx.Lock()
+	defer output.sessionMtx.Unlock()
+	if output.session == nil {
+		session, err := mgo.DialWithInfo(output.dialInfo)
+		if err != nil {
+			return nil, err
+		}
+		output.session = session
+	}
+	return output.session, nil
+}
+
+// the connection errors are retried on a refreshed session, while the
+// documents rejected by the server are not.
+func (output *MongoOutput) insert(payload []byte) error {
+	collectionName, documents, err := decodeMongoPayload(payload)
+	if err != nil {
+		return &nonRetryableError{err}
+	}
+	session, err := output.getSession()
+	if err != nil {
+		return err
+	}
+	bulk := session.DB(output.database).C(collectionName).Bulk()
+	bulk.Unordered()
+	bulk.Insert(documents...)
+	_, err = bulk.Run()
+	if err == nil {
+		return nil
+	}
+	switch err_ := err.(type) {
+	case *mgo.BulkError:
+		// the rest of the documents have been inserted
+		cases := err_.Cases()
+		output.logger.Error("%d of %d records were rejected by %s: %s", len(cases), len(documents), collectionName, err_.Error())
+		return nil
+	case *mgo.LastError:
+		return &nonRetryableError{err}
+	}
+	// reconnects on the next attempt
+	session.Refresh()
+	return err
+}
+
+func (output *MongoOutput) flushRecords(records []ik.FluentRecord) error {
+	chunks, err := output.encodeChunks(records)
+	if err != nil {
+		output.logger.Error("%s", err.Error())
+		return err
+	}
+	return output.sender.enqueue(chunks, output.durable)
+}
+
+func (output *MongoOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return appendToBuffer(output.logger, output.buffer, output, recordSets)
+}
+
+func (output *MongoOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *MongoOutput) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (output *MongoOutput) Shutdown() error {
+	output.closeOnce.Do(func() {
+		close(output.cancel)
+		output.buffer.Close()
+	})
+	err := output.sender.flush()
+	output.sessionMtx.Lock()
+	defer output.sessionMtx.Unlock()
+	if output.session != nil {
+		output.session.Close()
+		output.session = nil
+	}
+	return err
+}
+
+func (output *MongoOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newMongoOutput(factory *MongoOutputFactory, logger ik.Logger, dialInfo *mgo.DialInfo, collectionName string, timeKey string, bufferOptions bufferOptions, retry *ik.RetryManager) (*MongoOutput, error) {
+	retval := &MongoOutput{
+		factory:        factory,
+		logger:         logger,
+		dialInfo:       dialInfo,
+		database:       dialInfo.Database,
+		collectionName: collectionName,
+		timeKey:        timeKey,
+		cancel:         make(chan bool),
+	}
+	retval.sender = &retryingSender{
+		logger: logger,
+		retry:  retry,
+		send:   retval.insert,
+	}
+	buffer, durable, err := bufferOptions.newBuffer(retval.flushRecords)
+	if err != nil {
+		return nil, err
+	}
+	retval.buffer = buffer
+	retval.durable = durable
+	return retval, nil
+}
+
+func (factory *MongoOutputFactory) Name() string {
+	return "mongo"
+}
+
+func (factory *MongoOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	host, ok := config.Attrs["host"]
+	if !ok {
+		host = "localhost"
+	}
+	netPort, ok := config.Attrs["port"]
+	if !ok {
+		netPort = "27017"
+	}
+	database, ok := config.Attrs["database"]
+	if !ok {
+		return nil, errors.New("'database' parameter is required for mongo output")
+	}
+	collectionName, ok := config.Attrs["collection"]
+	if !ok {
+		return nil, errors.New("'collection' parameter is required for mongo output")
+	}
+	timeKey, ok := config.Attrs["time_key"]
+	if !ok {
+		timeKey = "time"
+	}
+	timeout, err := config.AttrDuration("timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := config.Attrs["user"]
+	password, _ := config.Attrs["password"]
+	if user == "" && password != "" {
+		return nil, errors.New("'user' parameter is required if 'password' is given")
+	}
+	authDatabase, _ := config.Attrs["auth_database"]
+	bufferOptions, err := parseBufferOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := parseRetryManager(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	dialInfo := &mgo.DialInfo{
+		Addrs:    []string{net.JoinHostPort(host, netPort)},
+		Timeout:  timeout,
+		Database: database,
+		Source:   authDatabase,
+		Username: user,
+		Password: password,
+	}
+	output, err := newMongoOutput(factory, engine.Logger(), dialInfo, collectionName, timeKey, bufferOptions, retry)
+	if err != nil {
+		return nil, err
+	}
+	output.sender.deadLetterPort = engine.DeadLetterPort()
+	output.sender.run(bufferOptions.flushInterval, output.cancel)
+	return output, nil
+}
+
+func (factory *MongoOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "oversized_documents",
+		DisplayName: "Oversized documents",
+		Description: "Number of records dropped as their documents exceed 16MB",
+		Fetcher:     &OversizedDocumentCountTopic{},
+	})
+}
+
+func (topic *OversizedDocumentCountTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *OversizedDocumentCountTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*MongoOutput)
+	return strconv.FormatInt(atomic.LoadInt64(&output.oversized), 10), nil
+}
+
+var _ = AddPlugin(&MongoOutputFactory{})
@@ -0,0 +1,127 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"gopkg.in/mgo.v2"
+	mrand "math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMongoOutput(t *testing.T) *MongoOutput {
+	retry := ik.NewRetryManager(time.Second, time.Minute, 2., 3, mrand.NewSource(0))
+	dialInfo := &mgo.DialInfo{Addrs: []string{"127.0.0.1:27017"}, Database: "test"}
+	output, err := newMongoOutput(&MongoOutputFactory{}, &testLogger{t}, dialInfo, "logs_%Y%m%d", "time", bufferOptions{chunkLimitSize: 1024 * 1024, flushInterval: time.Hour}, retry)
+	if err != nil {
+		t.FailNow()
+	}
+	return output
+}
+
+func TestMongoOutput_document(t *testing.T) {
+	output := newTestMongoOutput(t)
+	defer output.Shutdown()
+	data := map[string]interface{}{"message": "foo", "time": "given"}
+	document := output.document(ik.FluentRecord{Tag: "a", Timestamp: 1409286145, Nanoseconds: 500000000, Data: data})
+	if document["message"] != "foo" {
+		t.Fail()
+	}
+	timestamp, ok := document["time"].(time.Time)
+	if !ok || !timestamp.Equal(time.Unix(1409286145, 500000000)) {
+		t.Log(document)
+		t.Fail()
+	}
+	// the record is left as is
+	if data["time"] != "given" {
+		t.Fail()
+	}
+}
+
+func TestMongoOutput_encodeChunks(t *testing.T) {
+	output := newTestMongoOutput(t)
+	defer output.Shutdown()
+	chunks, err := output.encodeChunks([]ik.FluentRecord{
+		{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"message": "foo"}},
+		{Tag: "a", Timestamp: 1409372545, Data: map[string]interface{}{"message": "bar"}},
+		{Tag: "a", Timestamp: 1409286146, Data: map[string]interface{}{"message": "baz"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	// a collection for each day
+	if len(chunks) != 2 || len(chunks[0].records) != 2 || len(chunks[1].records) != 1 {
+		t.FailNow()
+	}
+	for i, expected := range []string{"logs_20140829", "logs_20140830"} {
+		collectionName, documents, err := decodeMongoPayload(chunks[i].payload)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if collectionName != expected || len(documents) != len(chunks[i].records) {
+			t.Fail()
+		}
+	}
+}
+
+func TestMongoOutput_encodeChunks_Oversized(t *testing.T) {
+	output := newTestMongoOutput(t)
+	defer output.Shutdown()
+	chunks, err := output.encodeChunks([]ik.FluentRecord{
+		{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"message": strings.Repeat("x", mongoMaxDocumentSize)}},
+		{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"message": "foo"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	if len(chunks) != 1 || len(chunks[0].records) != 1 || chunks[0].records[0].Data["message"] != "foo" {
+		t.Fail()
+	}
+	count, _ := (&OversizedDocumentCountTopic{}).PlainText(output)
+	if count != "1" {
+		t.Fail()
+	}
+}
+
+func TestDecodeMongoPayload_Malformed(t *testing.T) {
+	for _, payload := range [][]byte{
+		[]byte("logs"),
+		[]byte("logs\x00\x05\x00"),
+		[]byte("logs\x00\x10\x00\x00\x00\x00"),
+	} {
+		_, _, err := decodeMongoPayload(payload)
+		if err == nil {
+			t.Fail()
+		}
+	}
+}
+
+// an engine that can configure the retries
+type testRetryEngine struct {
+	testForwardEngine
+}
+
+func (engine *testRetryEngine) RandSource() mrand.Source { return mrand.NewSource(0) }
+
+func TestMongoOutputFactory_New(t *testing.T) {
+	engine := &testRetryEngine{testForwardEngine{logger: &testLogger{t}}}
+	_, err := (&MongoOutputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"collection": "logs"}})
+	if err == nil {
+		t.Fail()
+	}
+	_, err = (&MongoOutputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"database": "test", "collection": "logs", "password": "secret"}})
+	if err == nil {
+		t.Fail()
+	}
+	// doesn't connect until the records are flushed
+	output, err := (&MongoOutputFactory{}).New(engine, &ik.ConfigElement{Attrs: map[string]string{"database": "test", "collection": "logs", "user": "ik", "password": "secret", "auth_database": "admin"}})
+	if err != nil {
+		t.FailNow()
+	}
+	dialInfo := output.(*MongoOutput).dialInfo
+	if dialInfo.Addrs[0] != "localhost:27017" || dialInfo.Source != "admin" || dialInfo.Username != "ik" {
+		t.Fail()
+	}
+	output.(*MongoOutput).Shutdown()
+}
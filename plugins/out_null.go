@@ -0,0 +1,92 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// NullOutput discards the records it receives, only counting them.
+type NullOutput struct {
+	factory      *NullOutputFactory
+	logger       ik.Logger
+	discarded    int64
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
+}
+
+type NullOutputFactory struct {
+}
+
+type DiscardedRecordCountTopic struct{}
+
+func (output *NullOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	n := 0
+	for _, recordSet := range recordSets {
+		n += len(recordSet.Records)
+	}
+	atomic.AddInt64(&output.discarded, int64(n))
+	return nil
+}
+
+func (output *NullOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *NullOutput) Run() error {
+	<-output.shutdownChan
+	return nil
+}
+
+func (output *NullOutput) Shutdown() error {
+	output.shutdownOnce.Do(func() {
+		close(output.shutdownChan)
+	})
+	return nil
+}
+
+func (output *NullOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newNullOutput(factory *NullOutputFactory, logger ik.Logger) *NullOutput {
+	return &NullOutput{
+		factory:      factory,
+		logger:       logger,
+		shutdownChan: make(chan struct{}),
+	}
+}
+
+func (factory *NullOutputFactory) Name() string {
+	return "null"
+}
+
+func (factory *NullOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	return newNullOutput(factory, engine.Logger()), nil
+}
+
+func (factory *NullOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "discarded",
+		DisplayName: "Discarded records",
+		Description: "Total number of records discarded so far",
+		Fetcher:     &DiscardedRecordCountTopic{},
+	})
+}
+
+func (topic *DiscardedRecordCountTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *DiscardedRecordCountTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*NullOutput)
+	return strconv.FormatInt(atomic.LoadInt64(&output.discarded), 10), nil
+}
+
+var _ = AddPlugin(&NullOutputFactory{})
@@ -0,0 +1,30 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+)
+
+func TestNullOutput(t *testing.T) {
+	output := newNullOutput(&NullOutputFactory{}, &testLogger{t})
+	done := make(chan error)
+	go func() {
+		done <- output.Run()
+	}()
+	err := output.Emit([]ik.FluentRecordSet{
+		{Tag: "a", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145}, {Timestamp: 1409286146}}},
+		{Tag: "b", Records: []ik.TinyFluentRecord{{Timestamp: 1409286147}}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	text, err := (&DiscardedRecordCountTopic{}).PlainText(output)
+	if err != nil || text != "3" {
+		t.Fail()
+	}
+	output.Shutdown()
+	output.Dispose()
+	if <-done != nil {
+		t.Fail()
+	}
+}
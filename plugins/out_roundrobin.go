@@ -0,0 +1,133 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strconv"
+	"sync"
+)
+
+type roundRobinStore struct {
+	output        ik.Output
+	weight        int
+	currentWeight int
+}
+
+type RoundRobinOutput struct {
+	factory      *RoundRobinOutputFactory
+	engine       ik.Engine
+	logger       ik.Logger
+	stores       []*roundRobinStore
+	mtx          sync.Mutex
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
+}
+
+type RoundRobinOutputFactory struct {
+}
+
+// picks the next store by smooth weighted round-robin, in the same way
+// as out_forward picks the servers.
+func (output *RoundRobinOutput) nextStore() *roundRobinStore {
+	output.mtx.Lock()
+	defer output.mtx.Unlock()
+	total := 0
+	var retval *roundRobinStore
+	for _, store := range output.stores {
+		if store.weight <= 0 {
+			continue
+		}
+		total += store.weight
+		store.currentWeight += store.weight
+		if retval == nil || store.currentWeight > retval.currentWeight {
+			retval = store
+		}
+	}
+	if retval != nil {
+		retval.currentWeight -= total
+	}
+	return retval
+}
+
+// hands the whole batch over to a single store.
+func (output *RoundRobinOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	store := output.nextStore()
+	if store == nil {
+		return errors.New("no store is available")
+	}
+	return store.output.Emit(recordSets)
+}
+
+func (output *RoundRobinOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *RoundRobinOutput) Run() error {
+	<-output.shutdownChan
+	stores := make([]ik.Output, len(output.stores))
+	for i, store := range output.stores {
+		stores[i] = store.output
+	}
+	terminateStores(output.engine, stores)
+	return nil
+}
+
+func (output *RoundRobinOutput) Shutdown() error {
+	output.shutdownOnce.Do(func() {
+		close(output.shutdownChan)
+	})
+	return nil
+}
+
+func (output *RoundRobinOutput) Dispose() {
+	output.Shutdown()
+}
+
+func newRoundRobinOutput(factory *RoundRobinOutputFactory, engine ik.Engine, stores []*roundRobinStore) *RoundRobinOutput {
+	return &RoundRobinOutput{
+		factory:      factory,
+		engine:       engine,
+		logger:       engine.Logger(),
+		stores:       stores,
+		shutdownChan: make(chan struct{}),
+	}
+}
+
+func (factory *RoundRobinOutputFactory) Name() string {
+	return "roundrobin"
+}
+
+func (factory *RoundRobinOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	outputs, elems, err := newStores(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	stores := make([]*roundRobinStore, len(outputs))
+	total := 0
+	for i, elem := range elems {
+		weight := 1
+		weightStr, ok := elem.Attrs["weight"]
+		if ok {
+			weight, err = strconv.Atoi(weightStr)
+			if err != nil {
+				terminateStores(engine, outputs)
+				return nil, errors.New(fmt.Sprintf("Failed to parse weight: %s", err.Error()))
+			}
+		}
+		stores[i] = &roundRobinStore{output: outputs[i], weight: weight}
+		if weight > 0 {
+			total += weight
+		}
+	}
+	if total == 0 {
+		terminateStores(engine, outputs)
+		return nil, errors.New("at least one <store> must have a positive weight")
+	}
+	return newRoundRobinOutput(factory, engine, stores), nil
+}
+
+func (factory *RoundRobinOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&RoundRobinOutputFactory{})
@@ -0,0 +1,50 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"sync"
+	"testing"
+)
+
+// counts the batches it receives from multiple goroutines
+type countingOutput struct {
+	testOutput
+	batches int
+	mtx     sync.Mutex
+}
+
+func (output *countingOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	output.mtx.Lock()
+	defer output.mtx.Unlock()
+	output.batches += 1
+	return nil
+}
+
+func TestRoundRobinOutput_Emit_Weighted(t *testing.T) {
+	outputs := []*countingOutput{{}, {}, {}, {}}
+	weights := []int{3, 1, 0, 2}
+	output := &RoundRobinOutput{logger: &testLogger{t}}
+	for i, o := range outputs {
+		output.stores = append(output.stores, &roundRobinStore{output: o, weight: weights[i]})
+	}
+	recordSets := []ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145}}}}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 60; j += 1 {
+				err := output.Emit(recordSets)
+				if err != nil {
+					t.Fail()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// 600 batches in total, distributed by the weights 3:1:0:2
+	if outputs[0].batches != 300 || outputs[1].batches != 100 || outputs[2].batches != 0 || outputs[3].batches != 200 {
+		t.Log(outputs[0].batches, outputs[1].batches, outputs[2].batches, outputs[3].batches)
+		t.Fail()
+	}
+}
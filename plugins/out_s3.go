@@ -0,0 +1,288 @@
+package plugins
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	strftime "github.com/jehiah/go-strftime"
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/formatters"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the object key format used unless s3_object_key_format is given
+const defaultS3ObjectKeyFormat = "%{path}%Y/%m/%d/%H/%{chunk_id}.%{file_extension}"
+
+// the subset of the S3 client used by S3Output
+type s3Putter interface {
+	PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+}
+
+type S3Output struct {
+	factory           *S3OutputFactory
+	logger            ik.Logger
+	client            s3Putter
+	bucket            string
+	objectKeyFormat   string
+	formatter         ik.Formatter
+	compressionFormat int
+	buffer            ik.RecordBuffer
+	sender            *retryingSender
+	cancel            chan bool
+	closeOnce         sync.Once
+	// the number of the objects uploaded so far
+	uploaded int64
+}
+
+type S3OutputFactory struct {
+}
+
+type UploadedObjectCountTopic struct{}
+
+// expands %{path} and %{file_extension} of the object key format, leaving
+// %{chunk_id} to be filled once the content is known.
+func expandS3ObjectKeyFormat(format string, path string, fileExtension string) string {
+	format = strings.Replace(format, "%{path}", path, -1)
+	return strings.Replace(format, "%{file_extension}", fileExtension, -1)
+}
+
+// the key of the object for the record, with the chunk id to be filled
+// in place of the NUL character.
+func (output *S3Output) keyTemplate(record ik.FluentRecord) string {
+	timestamp := time.Unix(int64(record.Timestamp), int64(record.Nanoseconds)).UTC()
+	format := strings.Replace(output.objectKeyFormat, "%{chunk_id}", "\x00", -1)
+	return strftime.Format(format, timestamp)
+}
+
+// encodes the records into a chunk for each object.  the records are
+// sliced by the time formatted into the object key, and the chunk id is
+// derived from the content, so that the chunk retried from the buffer is
+// uploaded to the same key.  the payload of a chunk is the NUL-terminated
+// key followed by the content of the object.
+func (output *S3Output) encodeChunks(records []ik.FluentRecord) ([]encodedChunk, error) {
+	templates := []string{}
+	slices := make(map[string][]ik.FluentRecord)
+	for _, record := range records {
+		template := output.keyTemplate(record)
+		_, ok := slices[template]
+		if !ok {
+			templates = append(templates, template)
+		}
+		slices[template] = append(slices[template], record)
+	}
+	retval := make([]encodedChunk, 0, len(templates))
+	for _, template := range templates {
+		content := &bytes.Buffer{}
+		var writer io.Writer = content
+		var gzipWriter *gzip.Writer
+		if output.compressionFormat == compressionGzip {
+			gzipWriter = gzip.NewWriter(content)
+			writer = gzipWriter
+		}
+		for _, record := range slices[template] {
+			b, err := output.formatter.Format(record)
+			if err != nil {
+				output.logger.Error("Failed to format a record of %s: %s", record.Tag, err.Error())
+				continue
+			}
+			_, err = writer.Write(b)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if gzipWriter != nil {
+			err := gzipWriter.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		hash := sha1.Sum(content.Bytes())
+		key := strings.Replace(template, "\x00", hex.EncodeToString(hash[:]), -1)
+		payload := make([]byte, 0, len(key)+1+content.Len())
+		payload = append(append(append(payload, key...), 0), content.Bytes()...)
+		retval = append(retval, encodedChunk{records: slices[template], payload: payload})
+	}
+	return retval, nil
+}
+
+func (output *S3Output) upload(payload []byte) error {
+	i := bytes.IndexByte(payload, 0)
+	if i < 0 {
+		return &nonRetryableError{errors.New("Failed to decode the object key")}
+	}
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(output.bucket),
+		Key:    aws.String(string(payload[0:i])),
+		Body:   bytes.NewReader(payload[i+1:]),
+	}
+	if output.compressionFormat == compressionGzip {
+		input.ContentType = aws.String("application/x-gzip")
+	} else {
+		input.ContentType = aws.String("text/plain")
+	}
+	_, err := output.client.PutObject(input)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&output.uploaded, 1)
+	return nil
+}
+
+// the chunks that failed to be uploaded are left to the file buffer, which
+// hands the same records again on the next flush.
+func (output *S3Output) flushRecords(records []ik.FluentRecord) error {
+	chunks, err := output.encodeChunks(records)
+	if err != nil {
+		output.logger.Error("%s", err.Error())
+		return err
+	}
+	return output.sender.enqueue(chunks, true)
+}
+
+func (output *S3Output) Emit(recordSets []ik.FluentRecordSet) error {
+	return appendToBuffer(output.logger, output.buffer, output, recordSets)
+}
+
+func (output *S3Output) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *S3Output) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (output *S3Output) Shutdown() error {
+	output.closeOnce.Do(func() {
+		close(output.cancel)
+		output.buffer.Close()
+	})
+	return nil
+}
+
+func (output *S3Output) Dispose() {
+	output.Shutdown()
+}
+
+func newS3Output(factory *S3OutputFactory, logger ik.Logger, client s3Putter, bucket string, objectKeyFormat string, formatter ik.Formatter, compressionFormat int, bufferOptions bufferOptions, retry *ik.RetryManager) (*S3Output, error) {
+	retval := &S3Output{
+		factory:           factory,
+		logger:            logger,
+		client:            client,
+		bucket:            bucket,
+		objectKeyFormat:   objectKeyFormat,
+		formatter:         formatter,
+		compressionFormat: compressionFormat,
+		cancel:            make(chan bool),
+	}
+	retval.sender = &retryingSender{
+		logger: logger,
+		retry:  retry,
+		send:   retval.upload,
+	}
+	buffer, _, err := bufferOptions.newBuffer(retval.flushRecords)
+	if err != nil {
+		return nil, err
+	}
+	retval.buffer = buffer
+	return retval, nil
+}
+
+func (factory *S3OutputFactory) Name() string {
+	return "s3"
+}
+
+func (factory *S3OutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	bucket, ok := config.Attrs["bucket"]
+	if !ok {
+		return nil, errors.New("'bucket' parameter is required for s3 output")
+	}
+	path, _ := config.Attrs["path"]
+	compressionFormat := compressionNone
+	fileExtension := "log"
+	compressionFormatStr, ok := config.Attrs["compress"]
+	if ok {
+		if compressionFormatStr == "gz" || compressionFormatStr == "gzip" {
+			compressionFormat = compressionGzip
+			fileExtension = "gz"
+		} else {
+			return nil, errors.New("unknown compression format: " + compressionFormatStr)
+		}
+	}
+	objectKeyFormat, ok := config.Attrs["s3_object_key_format"]
+	if !ok {
+		objectKeyFormat = defaultS3ObjectKeyFormat
+	}
+	if !strings.Contains(objectKeyFormat, "%{chunk_id}") {
+		// the chunks of the same time slice would overwrite each other
+		return nil, errors.New("s3_object_key_format must contain %{chunk_id}")
+	}
+	objectKeyFormat = expandS3ObjectKeyFormat(objectKeyFormat, path, fileExtension)
+	formatter, err := formatters.New(config, "out_file")
+	if err != nil {
+		return nil, err
+	}
+	bufferOptions, err := parseBufferOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	if bufferOptions.bufferPath == "" {
+		return nil, errors.New("s3 output requires 'buffer_type file' and 'buffer_path'")
+	}
+	retry, err := parseRetryManager(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	// the credentials are taken from the environment variables, the
+	// shared credentials file or the instance role
+	awsConfig := aws.NewConfig()
+	region, ok := config.Attrs["region"]
+	if ok {
+		awsConfig = awsConfig.WithRegion(region)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	output, err := newS3Output(factory, engine.Logger(), s3.New(sess), bucket, objectKeyFormat, formatter, compressionFormat, bufferOptions, retry)
+	if err != nil {
+		return nil, err
+	}
+	output.sender.deadLetterPort = engine.DeadLetterPort()
+	return output, nil
+}
+
+func (factory *S3OutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "uploaded_objects",
+		DisplayName: "Uploaded objects",
+		Description: "Number of objects uploaded to the bucket",
+		Fetcher:     &UploadedObjectCountTopic{},
+	})
+}
+
+func (topic *UploadedObjectCountTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{[]ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *UploadedObjectCountTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*S3Output)
+	return strconv.FormatInt(atomic.LoadInt64(&output.uploaded), 10), nil
+}
+
+var _ = AddPlugin(&S3OutputFactory{})
@@ -0,0 +1,139 @@
+package plugins
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	mrand "math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keeps the uploaded objects, failing while err is set
+type testS3Putter struct {
+	objects map[string][]byte
+	err     error
+}
+
+func (putter *testS3Putter) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if putter.err != nil {
+		return nil, putter.err
+	}
+	b, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	putter.objects[aws.StringValue(input.Key)] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func newTestS3Output(t *testing.T, putter *testS3Putter, compressionFormat int) (*S3Output, string) {
+	bufferPath, err := ioutil.TempDir("", "ik-s3")
+	if err != nil {
+		t.FailNow()
+	}
+	retry := ik.NewRetryManager(time.Second, time.Minute, 2., 3, mrand.NewSource(0))
+	objectKeyFormat := expandS3ObjectKeyFormat(defaultS3ObjectKeyFormat, "logs/", "log")
+	output, err := newS3Output(&S3OutputFactory{}, &testLogger{t}, putter, "bucket", objectKeyFormat, &testMessageFormatter{}, compressionFormat, bufferOptions{chunkLimitSize: 1024 * 1024, flushInterval: time.Hour, bufferPath: bufferPath}, retry)
+	if err != nil {
+		t.FailNow()
+	}
+	return output, bufferPath
+}
+
+func TestS3Output_flushRecords(t *testing.T) {
+	putter := &testS3Putter{objects: make(map[string][]byte)}
+	output, bufferPath := newTestS3Output(t, putter, compressionNone)
+	defer os.RemoveAll(bufferPath)
+	defer output.Shutdown()
+	err := output.flushRecords([]ik.FluentRecord{
+		{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"message": "foo\n"}},
+		{Tag: "a", Timestamp: 1409289745, Data: map[string]interface{}{"message": "bar\n"}},
+		{Tag: "a", Timestamp: 1409286146, Data: map[string]interface{}{"message": "baz\n"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	// sliced by the hour
+	if len(putter.objects) != 2 {
+		t.Log(putter.objects)
+		t.FailNow()
+	}
+	for key, content := range putter.objects {
+		var expected string
+		if strings.HasPrefix(key, "logs/2014/08/29/04/") {
+			expected = "foo\nbaz\n"
+		} else if strings.HasPrefix(key, "logs/2014/08/29/05/") {
+			expected = "bar\n"
+		} else {
+			t.Log(key)
+			t.Fail()
+			continue
+		}
+		if !strings.HasSuffix(key, ".log") || string(content) != expected {
+			t.Log(key, string(content))
+			t.Fail()
+		}
+	}
+	count, _ := (&UploadedObjectCountTopic{}).PlainText(output)
+	if count != "2" {
+		t.Fail()
+	}
+}
+
+func TestS3Output_flushRecords_Retry(t *testing.T) {
+	putter := &testS3Putter{objects: make(map[string][]byte), err: errors.New("unavailable")}
+	output, bufferPath := newTestS3Output(t, putter, compressionGzip)
+	defer os.RemoveAll(bufferPath)
+	defer output.Shutdown()
+	records := []ik.FluentRecord{{Tag: "a", Timestamp: 1409286145, Data: map[string]interface{}{"message": "foo\n"}}}
+	// the failure is returned to the buffer, which keeps the chunk
+	if output.flushRecords(records) == nil {
+		t.Fail()
+	}
+	putter.err = nil
+	output.sender.nextRetry = time.Time{}
+	for i := 0; i < 2; i += 1 {
+		err := output.flushRecords(records)
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	// the same records are uploaded to the same key
+	if len(putter.objects) != 1 {
+		t.Log(putter.objects)
+		t.FailNow()
+	}
+	for _, content := range putter.objects {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			t.FailNow()
+		}
+		b, _ := ioutil.ReadAll(reader)
+		if string(b) != "foo\n" {
+			t.Fail()
+		}
+	}
+}
+
+func TestS3OutputFactory_New(t *testing.T) {
+	engine := &testRetryEngine{testForwardEngine{logger: &testLogger{t}}}
+	for _, attrs := range []map[string]string{
+		{"buffer_type": "file", "buffer_path": "/tmp/ik-s3"},
+		{"bucket": "bucket"},
+		{"bucket": "bucket", "buffer_type": "file", "buffer_path": "/tmp/ik-s3", "s3_object_key_format": "%Y%m%d.log"},
+		{"bucket": "bucket", "buffer_type": "file", "buffer_path": "/tmp/ik-s3", "compress": "bzip2"},
+	} {
+		_, err := (&S3OutputFactory{}).New(engine, &ik.ConfigElement{Attrs: attrs})
+		if err == nil {
+			t.Log(attrs)
+			t.Fail()
+		}
+	}
+}
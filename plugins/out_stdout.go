@@ -1,21 +1,42 @@
 package plugins
 
 import (
-	"fmt"
 	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/formatters"
+	"io"
 	"os"
+	"sync"
 	"time"
 )
 
 type StdoutOutput struct {
-	factory *StdoutOutputFactory
-	logger  ik.Logger
+	factory   *StdoutOutputFactory
+	logger    ik.Logger
+	writer    io.Writer
+	closer    io.Closer
+	formatter ik.Formatter
+	mtx       sync.Mutex
 }
 
 func (output *StdoutOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	output.mtx.Lock()
+	defer output.mtx.Unlock()
 	for _, recordSet := range recordSets {
 		for _, record := range recordSet.Records {
-			fmt.Fprintf(os.Stdout, "%d %s: %s\n", record.Timestamp, recordSet.Tag, record.Data)
+			b, err := output.formatter.Format(ik.FluentRecord{
+				Tag:         recordSet.Tag,
+				Timestamp:   record.Timestamp,
+				Data:        record.Data,
+				Nanoseconds: record.Nanoseconds,
+			})
+			if err != nil {
+				output.logger.Error("%s", err.Error())
+				continue
+			}
+			_, err = output.writer.Write(b)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -31,6 +52,13 @@ func (output *StdoutOutput) Run() error {
 }
 
 func (output *StdoutOutput) Shutdown() error {
+	if output.closer != nil {
+		output.mtx.Lock()
+		defer output.mtx.Unlock()
+		err := output.closer.Close()
+		output.closer = nil
+		return err
+	}
 	return nil
 }
 
@@ -41,19 +69,44 @@ func (output *StdoutOutput) Dispose() {
 type StdoutOutputFactory struct {
 }
 
-func newStdoutOutput(factory *StdoutOutputFactory, logger ik.Logger) (*StdoutOutput, error) {
-	return &StdoutOutput{
-		factory: factory,
-		logger:  logger,
-	}, nil
+func newStdoutOutput(factory *StdoutOutputFactory, logger ik.Logger, outputPath string, formatter ik.Formatter) (*StdoutOutput, error) {
+	retval := &StdoutOutput{
+		factory:   factory,
+		logger:    logger,
+		writer:    os.Stdout,
+		formatter: formatter,
+	}
+	if outputPath != "" {
+		f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		retval.writer = f
+		retval.closer = f
+	}
+	return retval, nil
 }
 
 func (factory *StdoutOutputFactory) Name() string {
 	return "stdout"
 }
 
-func (factory *StdoutOutputFactory) New(engine ik.Engine, _ *ik.ConfigElement) (ik.Output, error) {
-	return newStdoutOutput(factory, engine.Logger())
+func (factory *StdoutOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	outputPath, _ := config.Attrs["output_path"]
+	formatter, err := formatters.New(config, "out_file")
+	if err != nil {
+		return nil, err
+	}
+	// json and ltsv have been written after the time and the tag, which
+	// output_time false and output_tag false leave out
+	format := config.AttrString("format", "out_file")
+	if format == "json" || format == "ltsv" {
+		formatter, err = formatters.NewOutFileFormatter(config, formatter, "\t")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newStdoutOutput(factory, engine.Logger(), outputPath, formatter)
 }
 
 func (factory *StdoutOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
@@ -0,0 +1,46 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStdoutOutputFactory_New_Format(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out_stdout")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	engine := &testForwardEngine{logger: &testLogger{t}}
+	recordSets := []ik.FluentRecordSet{{Tag: "tag", Records: []ik.TinyFluentRecord{{Timestamp: 1409286145, Data: map[string]interface{}{"a": "b"}}}}}
+	for i, testCase := range []struct {
+		attrs    map[string]string
+		expected string
+	}{
+		{map[string]string{}, "2014\ttag\t{\"a\":\"b\"}\n"},
+		// the time and the tag are written in front of json and ltsv too
+		{map[string]string{"format": "json"}, "2014\ttag\t{\"a\":\"b\"}\n"},
+		{map[string]string{"format": "ltsv"}, "2014\ttag\ta:b\n"},
+		{map[string]string{"format": "json", "output_time": "false", "output_tag": "false"}, "{\"a\":\"b\"}\n"},
+		{map[string]string{"format": "csv", "fields": "a"}, "b\n"},
+	} {
+		outputPath := path.Join(dir, string('a'+rune(i)))
+		testCase.attrs["output_path"] = outputPath
+		testCase.attrs["time_format"] = "%Y"
+		output, err := (&StdoutOutputFactory{}).New(engine, &ik.ConfigElement{Attrs: testCase.attrs})
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		err = output.Emit(recordSets)
+		output.Shutdown()
+		b, _ := ioutil.ReadFile(outputPath)
+		if err != nil || string(b) != testCase.expected {
+			t.Logf("expected %q, got %q", testCase.expected, string(b))
+			t.Fail()
+		}
+	}
+}
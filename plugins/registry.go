@@ -12,3 +12,13 @@ func AddPlugin(plugin ik.Plugin) bool {
 func GetPlugins() []ik.Plugin {
 	return _plugins
 }
+
+func lookupOutputFactory(name string) ik.OutputFactory {
+	for _, plugin := range _plugins {
+		factory, ok := plugin.(ik.OutputFactory)
+		if ok && factory.Name() == name {
+			return factory
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package plugins
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// listens on the address with SO_REUSEADDR and SO_REUSEPORT set on the
+// socket, so that the address can be bound again right after the previous
+// listener is closed, and can be shared with other processes.
+func listenReusingPort(network string, address string) (net.Listener, error) {
+	listenConfig := net.ListenConfig{
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			var err error
+			controlErr := conn.Control(func(fd uintptr) {
+				err = setReusePort(fd)
+			})
+			if controlErr != nil {
+				return controlErr
+			}
+			return err
+		},
+	}
+	return listenConfig.Listen(context.Background(), network, address)
+}
@@ -0,0 +1,16 @@
+package plugins
+
+import (
+	"syscall"
+)
+
+// SO_REUSEPORT is not defined by the syscall package on Linux.
+const soReusePort = 0xf
+
+func setReusePort(fd uintptr) error {
+	err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	if err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
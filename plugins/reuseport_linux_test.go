@@ -0,0 +1,48 @@
+package plugins
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewForwardInput_ReusePort(t *testing.T) {
+	logger := &testLogger{t}
+	options := forwardInputOptions{reusePort: true}
+	input, err := newForwardInput(nil, logger, nil, []string{"127.0.0.1:0"}, nil, options)
+	if err != nil || input.Start() != nil {
+		t.FailNow()
+	}
+	bind := input.listeners[0].Addr().String()
+	// leave a connection in TIME_WAIT on the server side
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.FailNow()
+	}
+	serverConn, err := input.listeners[0].Accept()
+	if err != nil {
+		t.FailNow()
+	}
+	serverConn.Close()
+	conn.Close()
+	// the port can be shared
+	another, err := newForwardInput(nil, logger, nil, []string{bind}, nil, options)
+	if err == nil {
+		err = another.Start()
+	}
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	another.Stop()
+	// and rebound at once
+	input.Stop()
+	input, err = newForwardInput(nil, logger, nil, []string{bind}, nil, options)
+	if err == nil {
+		err = input.Start()
+	}
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	input.Stop()
+}
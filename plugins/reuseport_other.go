@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package plugins
+
+import (
+	"errors"
+)
+
+func setReusePort(fd uintptr) error {
+	return errors.New("reuse_port is not supported on this platform")
+}
@@ -0,0 +1,51 @@
+package plugins
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+)
+
+// creates and launches the outputs described by the <store> elements,
+// which the outputs like copy and roundrobin deliver the records to.
+func newStores(engine ik.Engine, config *ik.ConfigElement) ([]ik.Output, []*ik.ConfigElement, error) {
+	stores := make([]ik.Output, 0)
+	elems := make([]*ik.ConfigElement, 0)
+	for _, elem := range config.Elems {
+		if elem.Name != "store" {
+			continue
+		}
+		type_ := elem.Attrs["type"]
+		factory := lookupOutputFactory(type_)
+		if factory == nil {
+			terminateStores(engine, stores)
+			return nil, nil, errors.New("Could not find output factory: " + type_)
+		}
+		store, err := factory.New(engine, elem)
+		if err != nil {
+			terminateStores(engine, stores)
+			return nil, nil, err
+		}
+		err = engine.Launch(store)
+		if err != nil {
+			terminateStores(engine, stores)
+			return nil, nil, err
+		}
+		stores = append(stores, store)
+		elems = append(elems, elem)
+	}
+	if len(stores) == 0 {
+		return nil, nil, errors.New("no <store> is specified")
+	}
+	return stores, elems, nil
+}
+
+// must not be called from Shutdown(), since terminating a plugin instance
+// waits for the spawner that is calling it.
+func terminateStores(engine ik.Engine, stores []ik.Output) {
+	for _, store := range stores {
+		err := engine.Terminate(store)
+		if err != nil {
+			engine.Logger().Error("%s", err.Error())
+		}
+	}
+}
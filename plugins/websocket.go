@@ -0,0 +1,289 @@
+package plugins
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// the GUID the handshake appends to the key, as defined in RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC11B65"
+
+const (
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xa
+)
+
+// a connection upgraded to WebSocket.  the payloads of the data frames read
+// off it make up a stream of bytes, regardless of how the client splits them
+// into frames and messages, and what is written to it is sent in a binary
+// frame.  the pings are answered while reading.  the connection is closed
+// without a close frame unless the client sent one, as it may be closed
+// while the client is not reading, e.g. on eviction.
+type websocketConn struct {
+	net.Conn
+	reader *bufio.Reader
+	// the bytes left in the payload of the data frame being read, and the
+	// mask applied to them
+	remaining int64
+	mask      [4]byte
+	maskPos   int
+	closed    bool
+	writeMtx  sync.Mutex
+}
+
+func newWebSocketConn(conn net.Conn, reader *bufio.Reader) *websocketConn {
+	return &websocketConn{Conn: conn, reader: reader}
+}
+
+// reads the header of the next frame and returns the opcode.  the frames
+// from the clients must be masked.
+func (conn *websocketConn) readFrameHeader() (byte, int64, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(conn.reader, header)
+	if err != nil {
+		return 0, 0, err
+	}
+	opcode := header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		return 0, 0, errors.New("received an unmasked WebSocket frame")
+	}
+	n := int64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		b := make([]byte, 2)
+		_, err = io.ReadFull(conn.reader, b)
+		n = int64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		_, err = io.ReadFull(conn.reader, b)
+		n = int64(binary.BigEndian.Uint64(b))
+		if n < 0 {
+			err = errors.New("invalid WebSocket frame length")
+		}
+	}
+	if err == nil {
+		_, err = io.ReadFull(conn.reader, conn.mask[:])
+		conn.maskPos = 0
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return opcode, n, err
+}
+
+func (conn *websocketConn) unmask(p []byte) {
+	for i := range p {
+		p[i] ^= conn.mask[conn.maskPos&3]
+		conn.maskPos += 1
+	}
+}
+
+// handles a control frame, whose payload is at most 125 bytes.  returns
+// io.EOF on a close frame.
+func (conn *websocketConn) handleControlFrame(opcode byte, n int64) error {
+	if n > 125 {
+		return errors.New("WebSocket control frame too long")
+	}
+	payload := make([]byte, n)
+	_, err := io.ReadFull(conn.reader, payload)
+	if err != nil {
+		return err
+	}
+	conn.unmask(payload)
+	switch opcode {
+	case websocketOpClose:
+		// echoes the status code back
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		conn.writeFrame(websocketOpClose, payload)
+		conn.closed = true
+		return io.EOF
+	case websocketOpPing:
+		return conn.writeFrame(websocketOpPong, payload)
+	}
+	return nil
+}
+
+func (conn *websocketConn) Read(p []byte) (int, error) {
+	if conn.closed {
+		return 0, io.EOF
+	}
+	for conn.remaining == 0 {
+		opcode, n, err := conn.readFrameHeader()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case websocketOpContinuation, websocketOpText, websocketOpBinary:
+			conn.remaining = n
+		case websocketOpClose, websocketOpPing, websocketOpPong:
+			err = conn.handleControlFrame(opcode, n)
+			if err != nil {
+				return 0, err
+			}
+		default:
+			return 0, errors.New(fmt.Sprintf("unknown WebSocket opcode: 0x%x", opcode))
+		}
+	}
+	if int64(len(p)) > conn.remaining {
+		p = p[:conn.remaining]
+	}
+	n, err := conn.reader.Read(p)
+	conn.unmask(p[:n])
+	conn.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (conn *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n < 65536:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	conn.writeMtx.Lock()
+	defer conn.writeMtx.Unlock()
+	_, err := conn.Conn.Write(append(header, payload...))
+	return err
+}
+
+func (conn *websocketConn) Write(p []byte) (int, error) {
+	err := conn.writeFrame(websocketOpBinary, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// accepts the connections upgraded to WebSocket on the path, which are
+// served over HTTP on the listener.
+type websocketListener struct {
+	listener  net.Listener
+	path      string
+	logger    ik.Logger
+	server    *http.Server
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newWebSocketListener(listener net.Listener, path string, logger ik.Logger) *websocketListener {
+	retval := &websocketListener{
+		listener: listener,
+		path:     path,
+		logger:   logger,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	retval.server = &http.Server{Handler: retval}
+	go func() {
+		err := retval.server.Serve(listener)
+		if err != http.ErrServerClosed {
+			logger.Error("%s", err.Error())
+		}
+		retval.Close()
+	}()
+	return retval
+}
+
+func headerContainsToken(header http.Header, key string, token string) bool {
+	for _, value := range header[key] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrades the request to WebSocket and hands the connection to Accept.
+func (listener *websocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != listener.path {
+		http.NotFound(w, r)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "WebSocket upgrade expected", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket is not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		listener.logger.Error("%s", err.Error())
+		return
+	}
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n"))
+	if err != nil {
+		listener.logger.Warning("WebSocket handshake with %s failed: %s", conn.RemoteAddr().String(), err.Error())
+		conn.Close()
+		return
+	}
+	select {
+	case listener.conns <- newWebSocketConn(conn, rw.Reader):
+	case <-listener.closed:
+		conn.Close()
+	}
+}
+
+func (listener *websocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, &net.OpError{Op: "accept", Net: "websocket", Addr: listener.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// stops accepting the connections.  those upgraded already are left open.
+func (listener *websocketListener) Close() error {
+	var retval error
+	listener.closeOnce.Do(func() {
+		close(listener.closed)
+		retval = listener.server.Close()
+	})
+	return retval
+}
+
+func (listener *websocketListener) Addr() net.Addr {
+	return listener.listener.Addr()
+}
@@ -0,0 +1,157 @@
+package plugins
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/moriyoshi/ik"
+	"github.com/ugorji/go/codec"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// a WebSocket client just enough for the tests
+type testWebSocketClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestWebSocket(t *testing.T, addr string, path string) *testWebSocketClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.FailNow()
+	}
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	if err != nil {
+		t.FailNow()
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(digest[:]) {
+		t.Log(response)
+		t.FailNow()
+	}
+	return &testWebSocketClient{conn: conn, reader: reader}
+}
+
+// sends a masked frame, which is final unless more is set.
+func (client *testWebSocketClient) send(opcode byte, payload []byte, more bool) error {
+	header := []byte{opcode, 0x80 | 126, 0, 0}
+	if !more {
+		header[0] |= 0x80
+	}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	masked := make([]byte, len(payload))
+	for i, c := range payload {
+		masked[i] = c ^ mask[i%4]
+	}
+	_, err := client.conn.Write(append(append(header, mask...), masked...))
+	return err
+}
+
+// receives an unmasked frame.
+func (client *testWebSocketClient) receive() (byte, []byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(client.reader, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, header[1]&0x7f)
+	_, err = io.ReadFull(client.reader, payload)
+	return header[0] & 0x0f, payload, err
+}
+
+func TestForwardInput_WebSocket(t *testing.T) {
+	port := make(chanPort, 10)
+	input, err := newForwardInput(nil, &testLogger{t}, nil, []string{"127.0.0.1:0"}, port, forwardInputOptions{
+		websocketPath: "/forward",
+	})
+	if err != nil || input.Start() != nil {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() {
+		for {
+			err := input.Run()
+			if err != ik.Continue {
+				done <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		input.Shutdown()
+		<-done
+	}()
+
+	// not upgraded on the other paths
+	response, err := http.Get("http://" + input.Addr().String() + "/")
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.FailNow()
+	}
+
+	client := dialTestWebSocket(t, input.Addr().String(), "/forward")
+	defer client.conn.Close()
+	b := []byte{}
+	err = codec.NewEncoderBytes(&b, input.codec).Encode([]interface{}{
+		"tag",
+		[]interface{}{
+			[]interface{}{uint64(1409286145), map[string]interface{}{"a": "b"}},
+			[]interface{}{uint64(1409286146), map[string]interface{}{"a": "c"}},
+		},
+		map[string]interface{}{"chunk": "xyz"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	// the message is split into frames with a ping in between
+	if client.send(websocketOpBinary, b[:5], true) != nil || client.send(websocketOpPing, []byte("ping"), false) != nil || client.send(websocketOpContinuation, b[5:], false) != nil {
+		t.FailNow()
+	}
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	opcode, payload, err := client.receive()
+	if err != nil || opcode != websocketOpPong || string(payload) != "ping" {
+		t.FailNow()
+	}
+	opcode, payload, err = client.receive()
+	if err != nil || opcode != websocketOpBinary {
+		t.FailNow()
+	}
+	ack := map[string]interface{}{}
+	err = codec.NewDecoderBytes(payload, input.codec).Decode(&ack)
+	if chunk, _ := toBytes(ack["ack"]); err != nil || string(chunk) != "xyz" {
+		t.Fail()
+	}
+	select {
+	case recordSets := <-port:
+		if len(recordSets) != 1 || recordSets[0].Tag != "tag" || len(recordSets[0].Records) != 2 {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	// the close frame is echoed
+	if client.send(websocketOpClose, []byte{0x03, 0xe8}, false) != nil {
+		t.FailNow()
+	}
+	opcode, payload, err = client.receive()
+	if err != nil || opcode != websocketOpClose || string(payload) != "\x03\xe8" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,74 @@
+package ik
+
+import (
+	"sync"
+	"time"
+)
+
+// the number of the samples a RateMeter keeps over its window.
+const rateMeterResolution = 60
+
+type rateSample struct {
+	value int64
+	at    time.Time
+}
+
+// RateMeter tells how fast a cumulative counter has increased over a fixed
+// window, from the samples recorded as the counter increases.  Reading the
+// rate doesn't change the meter, so that any number of readers get the
+// same rate.
+type RateMeter struct {
+	window  time.Duration
+	since   rateSample
+	samples []rateSample
+	mtx     sync.Mutex
+}
+
+// Record takes the value the counter has increased to.  The samples less
+// than window/60 apart are merged into one.
+func (meter *RateMeter) Record(value int64, now time.Time) {
+	meter.mtx.Lock()
+	defer meter.mtx.Unlock()
+	n := len(meter.samples)
+	if n > 0 && now.Sub(meter.samples[n-1].at) < meter.window/rateMeterResolution {
+		meter.samples[n-1].value = value
+	} else {
+		meter.samples = append(meter.samples, rateSample{value, now})
+	}
+	// only the last of the samples that fell out of the window is needed
+	i := 0
+	for i+1 < len(meter.samples) && !meter.samples[i+1].at.After(now.Add(-meter.window)) {
+		i += 1
+	}
+	if i > 0 {
+		meter.since = meter.samples[i-1]
+		meter.samples = append([]rateSample{}, meter.samples[i:]...)
+	}
+}
+
+// Rate returns the increase per second of the counter to the value over
+// the window ending now, or since the meter was created if it is shorter.
+func (meter *RateMeter) Rate(value int64, now time.Time) float64 {
+	meter.mtx.Lock()
+	defer meter.mtx.Unlock()
+	start := now.Add(-meter.window)
+	base := meter.since
+	if start.Before(base.at) {
+		start = base.at
+	}
+	for _, sample := range meter.samples {
+		if sample.at.After(start) {
+			break
+		}
+		base = sample
+	}
+	elapsed := now.Sub(start)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(value-base.value) / elapsed.Seconds()
+}
+
+func NewRateMeter(window time.Duration, value int64, now time.Time) *RateMeter {
+	return &RateMeter{window: window, since: rateSample{value, now}}
+}
@@ -0,0 +1,30 @@
+package ik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	now := time.Unix(1409286145, 0)
+	meter := NewRateMeter(10*time.Second, 10, now)
+	meter.Record(30, now.Add(2*time.Second))
+	// since the meter was created, as it is younger than the window
+	if meter.Rate(30, now.Add(2*time.Second)) != 10 {
+		t.Fail()
+	}
+	// reading doesn't change the rate
+	if meter.Rate(30, now.Add(2*time.Second)) != 10 {
+		t.Fail()
+	}
+	meter.Record(70, now.Add(12*time.Second))
+	// over the last 10 seconds, from the sample taken at 2 seconds
+	if meter.Rate(70, now.Add(12*time.Second)) != 4 {
+		t.Fail()
+	}
+	// the samples older than the window are dropped but the last of them
+	meter.Record(90, now.Add(30*time.Second))
+	if len(meter.samples) != 2 || meter.Rate(90, now.Add(40*time.Second)) != 0 {
+		t.Fail()
+	}
+}
@@ -43,8 +43,9 @@ func (pump *RecordPump) Run() error {
 				buffer[record.Tag] = recordSet
 			}
 			recordSet.Records = append(recordSet.Records, TinyFluentRecord{
-				Timestamp: record.Timestamp,
-				Data:      record.Data,
+				Timestamp:   record.Timestamp,
+				Data:        record.Data,
+				Nanoseconds: record.Nanoseconds,
 			})
 			break
 		case <-pump.heartbeat.C:
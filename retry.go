@@ -0,0 +1,58 @@
+package ik
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// the fraction of the wait that is randomized so that the clients that
+// failed at once will not retry all at once.
+const RetryRandomizationWidth = 0.125
+
+// RetryManager computes the exponentially growing wait between retries.
+// It is not goroutine-safe.
+type RetryManager struct {
+	RetryWait                   time.Duration
+	MaxRetryWait                time.Duration
+	RetryExponentialBackoffBase float64
+	// the number of retries allowed; negative means unlimited
+	RetryLimit int
+	rand       *rand.Rand
+	steps      int
+}
+
+// Returns the duration to wait before the next retry, and whether to give
+// up retrying because retry_limit is exceeded.
+func (manager *RetryManager) NextWait() (time.Duration, bool) {
+	if manager.RetryLimit >= 0 && manager.steps >= manager.RetryLimit {
+		return 0, true
+	}
+	wait := float64(manager.RetryWait) * math.Pow(manager.RetryExponentialBackoffBase, float64(manager.steps))
+	wait *= 1 + RetryRandomizationWidth*(manager.rand.Float64()*2-1)
+	if manager.MaxRetryWait > 0 && wait > float64(manager.MaxRetryWait) {
+		wait = float64(manager.MaxRetryWait)
+	}
+	manager.steps += 1
+	return time.Duration(wait), false
+}
+
+// Returns the number of retries made since the last Reset().
+func (manager *RetryManager) Steps() int {
+	return manager.steps
+}
+
+func (manager *RetryManager) Reset() {
+	manager.steps = 0
+}
+
+func NewRetryManager(retryWait time.Duration, maxRetryWait time.Duration, retryExponentialBackoffBase float64, retryLimit int, randSource rand.Source) *RetryManager {
+	return &RetryManager{
+		RetryWait:                   retryWait,
+		MaxRetryWait:                maxRetryWait,
+		RetryExponentialBackoffBase: retryExponentialBackoffBase,
+		RetryLimit:                  retryLimit,
+		rand:                        rand.New(randSource),
+		steps:                       0,
+	}
+}
@@ -0,0 +1,53 @@
+package ik
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRetryManager_Backoff(t *testing.T) {
+	manager := NewRetryManager(time.Second, 10*time.Second, 2, 6, rand.NewSource(0))
+	expected := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, base := range expected {
+		wait, giveUp := manager.NextWait()
+		if giveUp {
+			t.FailNow()
+		}
+		base *= time.Second
+		if base == 10*time.Second {
+			if wait > base || float64(wait) < float64(base)*(1-RetryRandomizationWidth) {
+				t.Logf("%d: %s", i, wait.String())
+				t.Fail()
+			}
+		} else if float64(wait) < float64(base)*(1-RetryRandomizationWidth) || float64(wait) > float64(base)*(1+RetryRandomizationWidth) {
+			t.Logf("%d: %s", i, wait.String())
+			t.Fail()
+		}
+	}
+	_, giveUp := manager.NextWait()
+	if !giveUp {
+		t.Fail()
+	}
+	manager.Reset()
+	_, giveUp = manager.NextWait()
+	if giveUp || manager.Steps() != 1 {
+		t.Fail()
+	}
+}
+
+func TestRetryManager_Jitter(t *testing.T) {
+	manager := NewRetryManager(time.Second, 0, 2, -1, rand.NewSource(0))
+	waits := map[time.Duration]bool{}
+	for i := 0; i < 10; i += 1 {
+		wait, giveUp := manager.NextWait()
+		if giveUp {
+			t.FailNow()
+		}
+		waits[wait] = true
+		manager.Reset()
+	}
+	if len(waits) < 2 {
+		t.Fail()
+	}
+}
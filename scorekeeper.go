@@ -3,6 +3,7 @@ package ik
 import (
 	"errors"
 	"fmt"
+	"strconv"
 )
 
 type Scorekeeper struct {
@@ -34,14 +35,23 @@ func (sk *Scorekeeper) GetTopics(plugin Plugin) []ScorekeeperTopic {
 	return topics
 }
 
-func (sk *Scorekeeper) AddTopic(topic ScorekeeperTopic) {
+// AddTopic registers the topic of the plugin.  The topic is not
+// registered if the plugin has one with the same name already, which is
+// logged and returned as an error.
+func (sk *Scorekeeper) AddTopic(topic ScorekeeperTopic) error {
 	sk.logger.Info("AddTopic: plugin=%s, name=%s", topic.Plugin.Name(), topic.Name)
 	entries, ok := sk.topics[topic.Plugin]
 	if !ok {
 		entries = make(map[string]ScorekeeperTopic)
 		sk.topics[topic.Plugin] = entries
 	}
+	if _, ok := entries[topic.Name]; ok {
+		err := errors.New(fmt.Sprintf("duplicate topic: plugin=%s, name=%s", topic.Plugin.Name(), topic.Name))
+		sk.logger.Error("%s", err.Error())
+		return err
+	}
 	entries[topic.Name] = topic
+	return nil
 }
 
 func (sk *Scorekeeper) Fetch(plugin Plugin, name string) (ScoreValueFetcher, error) {
@@ -60,6 +70,62 @@ func (sk *Scorekeeper) Fetch(plugin Plugin, name string) (ScoreValueFetcher, err
 
 func (sk *Scorekeeper) Dispose() {}
 
+// PluginInstanceId returns the id of the plugin instance given with `@id',
+// or the empty string if it has none.
+func PluginInstanceId(pluginInstance PluginInstance) string {
+	identified, ok := pluginInstance.(IdentifiedPluginInstance)
+	if !ok {
+		return ""
+	}
+	return identified.Id()
+}
+
+// QualifiedTopicName returns the name of the topic namespaced by the
+// plugin and the id of the instance, e.g. "forward[main].entries", or
+// "forward.entries" for an instance without an id.
+func QualifiedTopicName(pluginInstance PluginInstance, topic ScorekeeperTopic) string {
+	retval := topic.Plugin.Name()
+	if id := PluginInstanceId(pluginInstance); id != "" {
+		retval += "[" + id + "]"
+	}
+	return retval + "." + topic.Name
+}
+
+// FetchScoreValue returns the typed value of the topic if the fetcher
+// provides one, or the plain text otherwise.
+func FetchScoreValue(fetcher ScoreValueFetcher, pluginInstance PluginInstance) (interface{}, error) {
+	typed, ok := fetcher.(TypedScoreValueFetcher)
+	if ok {
+		return typed.Value(pluginInstance)
+	}
+	return fetcher.PlainText(pluginInstance)
+}
+
+// FormatScoreValue formats a typed value of a topic for display.
+func FormatScoreValue(value interface{}) string {
+	switch value_ := value.(type) {
+	case int64:
+		return strconv.FormatInt(value_, 10)
+	case float64:
+		return strconv.FormatFloat(value_, 'f', 2, 64)
+	case bool:
+		return strconv.FormatBool(value_)
+	case string:
+		return value_
+	}
+	return fmt.Sprint(value)
+}
+
+// PlainTextOfScoreValue formats the typed value of the topic, for the
+// fetchers whose plain text is derived from it.
+func PlainTextOfScoreValue(fetcher TypedScoreValueFetcher, pluginInstance PluginInstance) (string, error) {
+	value, err := fetcher.Value(pluginInstance)
+	if err != nil {
+		return "", err
+	}
+	return FormatScoreValue(value), nil
+}
+
 func NewScorekeeper(logger Logger) *Scorekeeper {
 	return &Scorekeeper{
 		logger: logger,
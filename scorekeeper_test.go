@@ -0,0 +1,54 @@
+package ik
+
+import (
+	"testing"
+)
+
+type testScorekeeperPlugin struct {
+	// keeps the plugins distinct, which pointers to empty structs may not be
+	_ int
+}
+
+func (*testScorekeeperPlugin) Name() string                 { return "test" }
+func (*testScorekeeperPlugin) BindScorekeeper(*Scorekeeper) {}
+
+type testScorekeeperInstance struct {
+	id string
+}
+
+func (*testScorekeeperInstance) Run() error          { return nil }
+func (*testScorekeeperInstance) Shutdown() error     { return nil }
+func (*testScorekeeperInstance) Factory() Plugin     { return &testScorekeeperPlugin{} }
+func (instance *testScorekeeperInstance) Id() string { return instance.id }
+
+func TestScorekeeper_AddTopic_Duplicate(t *testing.T) {
+	plugin := &testScorekeeperPlugin{}
+	sk := NewScorekeeper(testConfigLogger{})
+	if sk.AddTopic(ScorekeeperTopic{Plugin: plugin, Name: "entries", DisplayName: "first"}) != nil {
+		t.FailNow()
+	}
+	if sk.AddTopic(ScorekeeperTopic{Plugin: plugin, Name: "entries", DisplayName: "second"}) == nil {
+		t.Fail()
+	}
+	// the first one is kept
+	topics := sk.GetTopics(plugin)
+	if len(topics) != 1 || topics[0].DisplayName != "first" {
+		t.Fail()
+	}
+	// the names are per plugin
+	if sk.AddTopic(ScorekeeperTopic{Plugin: &testScorekeeperPlugin{}, Name: "entries"}) != nil {
+		t.Fail()
+	}
+}
+
+func TestQualifiedTopicName(t *testing.T) {
+	topic := ScorekeeperTopic{Plugin: &testScorekeeperPlugin{}, Name: "entries"}
+	if name := QualifiedTopicName(&testScorekeeperInstance{id: "main"}, topic); name != "test[main].entries" {
+		t.Log(name)
+		t.Fail()
+	}
+	if name := QualifiedTopicName(&testScorekeeperInstance{}, topic); name != "test.entries" {
+		t.Log(name)
+		t.Fail()
+	}
+}
@@ -44,6 +44,7 @@ func (slicer *Slicer) Emit(recordSets []FluentRecordSet) error {
 				tag,
 				record.Timestamp,
 				record.Data,
+				record.Nanoseconds,
 			}
 			key := slicer.keyGetter(fullRecord)
 			data, err := slicer.packer.Pack(fullRecord)
@@ -160,6 +160,10 @@ func (spawner *Spawner) spawn(spawnee Spawnee, retval chan dispatchReturnValue)
 			exitStatus = Continue
 			for exitStatus == Continue {
 				exitStatus = descriptor.spawnee.Run()
+				// stop asking for more once the shutdown is requested
+				if exitStatus == Continue && spawner.isShutdownRequested(descriptor) {
+					exitStatus = nil
+				}
 			}
 		}()
 		func() {
@@ -200,12 +204,22 @@ func (spawner *Spawner) spawn(spawnee Spawnee, retval chan dispatchReturnValue)
 	}()
 }
 
+func (spawner *Spawner) isShutdownRequested(descriptor *spawneeDescriptor) bool {
+	spawner.mtx.Lock()
+	defer spawner.mtx.Unlock()
+	return descriptor.shutdownRequested
+}
+
 func (spawner *Spawner) kill(spawnee Spawnee, retval chan dispatchReturnValue) {
 	spawner.mtx.Lock()
 	descriptor, ok := spawner.m[spawnee]
-	spawner.mtx.Unlock()
-	if ok && descriptor.exitStatus != Continue {
+	// a spawnee being shut down already is not asked to shut down again
+	running := ok && descriptor.exitStatus == Continue && !descriptor.shutdownRequested
+	if running {
 		descriptor.shutdownRequested = true
+	}
+	spawner.mtx.Unlock()
+	if running {
 		err := spawnee.Shutdown()
 		retval <- dispatchReturnValue{true, nil, err, nil}
 	} else {
@@ -307,43 +321,30 @@ func (spawner *Spawner) GetSpawneeStatuses() ([]SpawneeStatus, error) {
 }
 
 func (spawner *Spawner) Poll(spawnee Spawnee) error {
+	spawner.mtx.Lock()
+	defer spawner.mtx.Unlock()
 	descriptor, ok := spawner.m[spawnee]
 	if !ok {
 		return NotFound
 	}
-	if func() bool {
-		spawner.mtx.Lock()
-		defer spawner.mtx.Unlock()
-		if descriptor.exitStatus != Continue {
-			return true
-		}
-		return false
-	}() {
-		return nil
+	for descriptor.exitStatus == Continue {
+		spawner.cond.Wait()
 	}
-	defer descriptor.mtx.Unlock()
-	descriptor.mtx.Lock()
-	descriptor.cond.Wait()
 	return nil
 }
 
+// waits for all the spawnees to stop.  the status of each is checked, as
+// the events may come one after another faster than they are seen.
 func (spawner *Spawner) PollMultiple(spawnees []Spawnee) error {
-	spawnees_ := make(map[Spawnee]bool)
+	spawner.mtx.Lock()
+	defer spawner.mtx.Unlock()
 	for _, spawnee := range spawnees {
-		spawnees_[spawnee] = true
-	}
-	count := len(spawnees_)
-	for count > 0 {
-		spawner.mtx.Lock()
-		spawner.cond.Wait()
-		lastEvent := spawner.lastEvent
-		spawner.mtx.Unlock()
-		if lastEvent.t == Stopped {
-			spawnee := lastEvent.spawnee
-			if alive, ok := spawnees_[spawnee]; alive && ok {
-				spawnees_[spawnee] = false
-				count -= 1
-			}
+		descriptor, ok := spawner.m[spawnee]
+		if !ok {
+			continue
+		}
+		for descriptor.exitStatus == Continue {
+			spawner.cond.Wait()
 		}
 	}
 	return nil
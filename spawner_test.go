@@ -57,6 +57,64 @@ func TestSpawner_Spawn(t *testing.T) {
 	}
 }
 
+func TestSpawner_Kill(t *testing.T) {
+	spawner := NewSpawner()
+	f := &Foo{"", make(chan string)}
+	spawner.Spawn(f)
+	killed, err := spawner.Kill(f)
+	if !killed || err != nil {
+		t.FailNow()
+	}
+	spawner.Poll(f)
+	err = spawner.GetStatus(f)
+	if err == Continue || err.Error() != "ok" {
+		t.Fail()
+	}
+	killed, _ = spawner.Kill(f)
+	if killed {
+		t.Fail()
+	}
+}
+
+func TestSpawner_Kill_Twice(t *testing.T) {
+	spawner := NewSpawner()
+	// Bar keeps running after Shutdown until it receives something
+	f := &Bar{make(chan interface{})}
+	spawner.Spawn(f)
+	killed, _ := spawner.Kill(f)
+	if !killed {
+		t.FailNow()
+	}
+	killed, _ = spawner.Kill(f)
+	if killed {
+		t.Fail()
+	}
+	f.c <- "stop"
+	spawner.Poll(f)
+}
+
+// keeps returning Continue, like the outputs that flush periodically
+type Qux struct{}
+
+func (qux *Qux) Run() error {
+	return Continue
+}
+
+func (qux *Qux) Shutdown() error {
+	return nil
+}
+
+func TestSpawner_Kill_Continue(t *testing.T) {
+	spawner := NewSpawner()
+	f := &Qux{}
+	spawner.Spawn(f)
+	spawner.Kill(f)
+	spawner.Poll(f)
+	if spawner.GetStatus(f) != nil {
+		t.Fail()
+	}
+}
+
 func TestSpawner_Panic1(t *testing.T) {
 	spawner := NewSpawner()
 	f := &Bar{make(chan interface{})}
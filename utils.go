@@ -50,6 +50,21 @@ func ParseCapacityString(s string) (int64, error) {
 	return multiply * i, nil
 }
 
+// Formats the number of bytes with a binary prefix, e.g. "1.5 KiB".
+func FormatCapacity(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + " bytes"
+	}
+	value := float64(n)
+	for _, suffix := range []string{"KiB", "MiB", "GiB", "TiB", "PiB"} {
+		value /= 1024
+		if value < 1024 {
+			return strconv.FormatFloat(value, 'f', 1, 64) + " " + suffix
+		}
+	}
+	return strconv.FormatFloat(value/1024, 'f', 1, 64) + " EiB"
+}
+
 func NewRandSourceWithTimestampSeed() rand.Source {
 	return rand.NewSource(time.Now().UnixNano())
 }
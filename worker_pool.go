@@ -0,0 +1,282 @@
+package ik
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultWorkerQueueLimit = 65536
+
+type workerPoolItem struct {
+	port       Port
+	recordSets []FluentRecordSet
+}
+
+// WorkerPool hands the records emitted by the inputs to a fixed number of
+// worker goroutines, which run the filters and the outputs on behalf of
+// the inputs so that decoding the input doesn't wait for them.
+//
+// The record sets are assigned to the workers by the hash of the tag, so
+// the records with the same tag are processed in the order they were
+// emitted.  Those with different tags may be processed out of order, even
+// if they came through the same connection.
+//
+// The number of records queued or being processed is bounded by the
+// limit.  When it is reached, the inputs are either blocked until the
+// workers catch up or get ErrBufferOverflow with the records dropped,
+// depending on the overflow action.
+type WorkerPool struct {
+	logger         Logger
+	queues         [][]workerPoolItem
+	limit          int64
+	overflowAction int
+	queued         int64
+	dropped        int64
+	closed         bool
+	mtx            sync.Mutex
+	cond           *sync.Cond
+	shutdownChan   chan struct{}
+	shutdownOnce   sync.Once
+	wg             sync.WaitGroup
+}
+
+type workerPoolPort struct {
+	pool *WorkerPool
+	port Port
+}
+
+type WorkerPoolPlugin struct{}
+
+type WorkerPoolQueueDepthTopic struct{}
+
+type WorkerPoolDroppedRecordCountTopic struct{}
+
+var workerPoolPlugin = &WorkerPoolPlugin{}
+
+func (port *workerPoolPort) Emit(recordSets []FluentRecordSet) error {
+	return port.pool.dispatch(port.port, recordSets)
+}
+
+// Returns the port that emits the records to the given port through the
+// workers.
+func (pool *WorkerPool) Port(port Port) Port {
+	return &workerPoolPort{pool: pool, port: port}
+}
+
+func (pool *WorkerPool) isShuttingDown() bool {
+	select {
+	case <-pool.shutdownChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// queues the batch unless the limit is exceeded.  a batch larger than the
+// limit itself is let through when the queues are empty.  the lock must be
+// held by the caller.
+func (pool *WorkerPool) enqueue(i int, item workerPoolItem) error {
+	n := int64(countRecords(item.recordSets))
+	for pool.limit > 0 && pool.queued > 0 && pool.queued+n > pool.limit && !pool.closed {
+		if pool.overflowAction != OverflowActionBlock {
+			pool.dropped += n
+			return ErrBufferOverflow
+		}
+		pool.cond.Wait()
+	}
+	if pool.closed {
+		// the workers are gone; let the caller do it
+		pool.mtx.Unlock()
+		defer pool.mtx.Lock()
+		return item.port.Emit(item.recordSets)
+	}
+	pool.queues[i] = append(pool.queues[i], item)
+	pool.queued += n
+	pool.cond.Broadcast()
+	return nil
+}
+
+func (pool *WorkerPool) dispatch(port Port, recordSets []FluentRecordSet) error {
+	batches := make([][]FluentRecordSet, len(pool.queues))
+	for _, recordSet := range recordSets {
+		hash := fnv.New32a()
+		hash.Write([]byte(recordSet.Tag))
+		i := hash.Sum32() % uint32(len(pool.queues))
+		batches[i] = append(batches[i], recordSet)
+	}
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	var retval error
+	for i, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		err := pool.enqueue(i, workerPoolItem{port: port, recordSets: batch})
+		if err != nil {
+			retval = err
+		}
+	}
+	return retval
+}
+
+func countRecords(recordSets []FluentRecordSet) int {
+	n := 0
+	for _, recordSet := range recordSets {
+		n += len(recordSet.Records)
+	}
+	return n
+}
+
+// emits the records, retrying the ones refused by the congested outputs
+// until they are accepted, which in turn blocks the inputs once the limit
+// is reached.
+func (pool *WorkerPool) process(item workerPoolItem) {
+	err := item.port.Emit(item.recordSets)
+	wait := 10 * time.Millisecond
+	for err != nil {
+		backpressureErr, ok := err.(*BackpressureError)
+		if !ok {
+			pool.logger.Error("%s", err.Error())
+			return
+		}
+		if pool.isShuttingDown() {
+			pool.logger.Error("Shutting down; %s", err.Error())
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+		if wait > time.Second {
+			wait = time.Second
+		}
+		err = backpressureErr.Retry()
+	}
+}
+
+// runs until the pool is shut down and the queue is drained.  the records
+// are counted as queued until they are processed.
+func (pool *WorkerPool) work(i int) {
+	defer pool.wg.Done()
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	for {
+		for len(pool.queues[i]) == 0 && !pool.closed {
+			pool.cond.Wait()
+		}
+		if len(pool.queues[i]) == 0 {
+			return
+		}
+		item := pool.queues[i][0]
+		pool.queues[i][0] = workerPoolItem{}
+		pool.queues[i] = pool.queues[i][1:]
+		pool.mtx.Unlock()
+		pool.process(item)
+		pool.mtx.Lock()
+		pool.queued -= int64(countRecords(item.recordSets))
+		pool.cond.Broadcast()
+	}
+}
+
+func (pool *WorkerPool) Factory() Plugin {
+	return workerPoolPlugin
+}
+
+func (pool *WorkerPool) Run() error {
+	<-pool.shutdownChan
+	pool.wg.Wait()
+	return nil
+}
+
+func (pool *WorkerPool) Shutdown() error {
+	pool.shutdownOnce.Do(func() {
+		close(pool.shutdownChan)
+		pool.mtx.Lock()
+		defer pool.mtx.Unlock()
+		pool.closed = true
+		pool.cond.Broadcast()
+	})
+	return nil
+}
+
+// Returns the number of records waiting for the workers or being processed.
+func (pool *WorkerPool) QueuedCount() int64 {
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	return pool.queued
+}
+
+// Returns the number of records dropped as the limit was reached.
+func (pool *WorkerPool) DroppedCount() int64 {
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	return pool.dropped
+}
+
+func (*WorkerPoolPlugin) Name() string {
+	return "workers"
+}
+
+func (plugin *WorkerPoolPlugin) BindScorekeeper(scorekeeper *Scorekeeper) {
+	scorekeeper.AddTopic(ScorekeeperTopic{
+		Plugin:      plugin,
+		Name:        "queue_depth",
+		DisplayName: "Queue depth",
+		Description: "Number of records waiting for the workers",
+		Fetcher:     &WorkerPoolQueueDepthTopic{},
+	})
+	scorekeeper.AddTopic(ScorekeeperTopic{
+		Plugin:      plugin,
+		Name:        "dropped",
+		DisplayName: "Dropped records",
+		Description: "Number of records dropped as the queue was full",
+		Fetcher:     &WorkerPoolDroppedRecordCountTopic{},
+	})
+}
+
+func (topic *WorkerPoolQueueDepthTopic) Markup(pool_ PluginInstance) (Markup, error) {
+	text, err := topic.PlainText(pool_)
+	if err != nil {
+		return Markup{}, err
+	}
+	return Markup{[]MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *WorkerPoolQueueDepthTopic) PlainText(pool_ PluginInstance) (string, error) {
+	pool := pool_.(*WorkerPool)
+	return strconv.FormatInt(pool.QueuedCount(), 10), nil
+}
+
+func (topic *WorkerPoolDroppedRecordCountTopic) Markup(pool_ PluginInstance) (Markup, error) {
+	text, err := topic.PlainText(pool_)
+	if err != nil {
+		return Markup{}, err
+	}
+	return Markup{[]MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *WorkerPoolDroppedRecordCountTopic) PlainText(pool_ PluginInstance) (string, error) {
+	pool := pool_.(*WorkerPool)
+	return strconv.FormatInt(pool.DroppedCount(), 10), nil
+}
+
+// Creates a pool of the given number of workers, which holds up to limit
+// records (unlimited if zero).  It needs to be launched by the engine.
+func NewWorkerPool(logger Logger, scorekeeper *Scorekeeper, workers int, limit int64, overflowAction int) *WorkerPool {
+	pool := &WorkerPool{
+		logger:         logger,
+		queues:         make([][]workerPoolItem, workers),
+		limit:          limit,
+		overflowAction: overflowAction,
+		shutdownChan:   make(chan struct{}),
+	}
+	pool.cond = sync.NewCond(&pool.mtx)
+	for i := range pool.queues {
+		pool.wg.Add(1)
+		go pool.work(i)
+	}
+	if scorekeeper != nil {
+		workerPoolPlugin.BindScorekeeper(scorekeeper)
+	}
+	return pool
+}
@@ -0,0 +1,190 @@
+package ik
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a testPort that can be emitted to by multiple workers
+type syncPort struct {
+	testPort
+	mtx sync.Mutex
+}
+
+func (port *syncPort) Emit(recordSets []FluentRecordSet) error {
+	port.mtx.Lock()
+	defer port.mtx.Unlock()
+	return port.testPort.Emit(recordSets)
+}
+
+func TestWorkerPool_OrderingPerTag(t *testing.T) {
+	pool := NewWorkerPool(testConfigLogger{}, nil, 4, 16, OverflowActionBlock)
+	done := make(chan error)
+	go func() {
+		done <- pool.Run()
+	}()
+	port := &syncPort{}
+	poolPort := pool.Port(port)
+	for i := 0; i < 100; i += 1 {
+		recordSets := make([]FluentRecordSet, 0)
+		for j := 0; j < 8; j += 1 {
+			recordSets = append(recordSets, FluentRecordSet{fmt.Sprintf("tag.%d", j), []TinyFluentRecord{{Timestamp: uint64(i)}}})
+		}
+		err := poolPort.Emit(recordSets)
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	pool.Shutdown()
+	if <-done != nil {
+		t.FailNow()
+	}
+	if pool.QueuedCount() != 0 || len(port.recordSets) != 800 {
+		t.Log(pool.QueuedCount(), len(port.recordSets))
+		t.FailNow()
+	}
+	next := make(map[string]uint64)
+	for _, recordSet := range port.recordSets {
+		if recordSet.Records[0].Timestamp != next[recordSet.Tag] {
+			t.Log(recordSet.Tag, recordSet.Records[0].Timestamp)
+			t.FailNow()
+		}
+		next[recordSet.Tag] += 1
+	}
+}
+
+func TestWorkerPool_RetriesOnBackpressure(t *testing.T) {
+	pool := NewWorkerPool(testConfigLogger{}, nil, 1, 0, OverflowActionBlock)
+	done := make(chan error)
+	go func() {
+		done <- pool.Run()
+	}()
+	port := &congestedPort{refusals: 3}
+	err := pool.Port(port).Emit([]FluentRecordSet{
+		{"a", []TinyFluentRecord{{Timestamp: 1}}},
+		{"a", []TinyFluentRecord{{Timestamp: 2}}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	// the refused record set is retried until it is accepted
+	for i := 0; i < 100 && pool.QueuedCount() > 0; i += 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	pool.Shutdown()
+	<-done
+	if len(port.recordSets) != 2 || port.recordSets[1].Records[0].Timestamp != 2 {
+		t.Fail()
+	}
+}
+
+func TestWorkerPool_EmitAfterShutdown(t *testing.T) {
+	pool := NewWorkerPool(testConfigLogger{}, nil, 2, 1, OverflowActionBlock)
+	pool.Shutdown()
+	pool.Shutdown()
+	if pool.Run() != nil {
+		t.FailNow()
+	}
+	port := &testPort{}
+	err := pool.Port(port).Emit([]FluentRecordSet{{"a", []TinyFluentRecord{{Timestamp: 1}}}})
+	if err != nil || len(port.recordSets) != 1 {
+		t.Fail()
+	}
+}
+
+// takes a while for each batch, and records the largest number of the
+// records held by the pool it has seen
+type slowPort struct {
+	syncPort
+	pool      *WorkerPool
+	maxQueued int64
+}
+
+func (port *slowPort) Emit(recordSets []FluentRecordSet) error {
+	time.Sleep(time.Millisecond)
+	queued := port.pool.QueuedCount()
+	port.mtx.Lock()
+	if queued > port.maxQueued {
+		port.maxQueued = queued
+	}
+	port.mtx.Unlock()
+	return port.syncPort.Emit(recordSets)
+}
+
+// floods the pool from multiple goroutines, each emitting 10 records at
+// a time.  returns the number of Emit calls that failed.
+func floodWorkerPool(pool *WorkerPool, port Port) int64 {
+	failures := int64(0)
+	mtx := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i += 1 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j += 1 {
+				records := make([]TinyFluentRecord, 10)
+				for k := range records {
+					records[k] = TinyFluentRecord{Timestamp: uint64(j), Data: map[string]interface{}{"payload": make([]byte, 1024)}}
+				}
+				err := port.Emit([]FluentRecordSet{{fmt.Sprintf("tag.%d", i), records}})
+				if err != nil {
+					if err != ErrBufferOverflow {
+						panic(err)
+					}
+					mtx.Lock()
+					failures += 1
+					mtx.Unlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	return failures
+}
+
+func TestWorkerPool_Flood_Block(t *testing.T) {
+	pool := NewWorkerPool(testConfigLogger{}, nil, 2, 100, OverflowActionBlock)
+	done := make(chan error)
+	go func() {
+		done <- pool.Run()
+	}()
+	port := &slowPort{pool: pool}
+	if floodWorkerPool(pool, pool.Port(port)) != 0 {
+		t.FailNow()
+	}
+	pool.Shutdown()
+	<-done
+	// every record is delivered while the pool never holds more than the limit
+	if countRecords(port.recordSets) != 4000 || pool.DroppedCount() != 0 {
+		t.Fail()
+	}
+	if port.maxQueued > 100 {
+		t.Log(port.maxQueued)
+		t.Fail()
+	}
+}
+
+func TestWorkerPool_Flood_Drop(t *testing.T) {
+	pool := NewWorkerPool(testConfigLogger{}, nil, 2, 100, OverflowActionDrop)
+	done := make(chan error)
+	go func() {
+		done <- pool.Run()
+	}()
+	port := &slowPort{pool: pool}
+	failures := floodWorkerPool(pool, pool.Port(port))
+	pool.Shutdown()
+	<-done
+	if failures == 0 || pool.DroppedCount() != failures*10 {
+		t.Log(failures, pool.DroppedCount())
+		t.Fail()
+	}
+	if int64(countRecords(port.recordSets))+pool.DroppedCount() != 4000 {
+		t.Fail()
+	}
+	if port.maxQueued > 100 {
+		t.Log(port.maxQueued)
+		t.Fail()
+	}
+}